@@ -0,0 +1,406 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/db"
+	"ebs/src/middlewares"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func adminHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	admin := g.Group("/admin", middlewares.RequireRole(types.ROLE_ADMIN))
+	admin.
+		GET("/audit", func(ctx *gin.Context) {
+			var filters types.AuditTrailQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			listAuditTrail(ctx, &filters)
+		}).
+		GET("/dead-letters", func(ctx *gin.Context) {
+			var filters types.DeadLettersQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if filters.Page == 0 {
+				filters.Page = 1
+			}
+			if filters.Limit == 0 {
+				filters.Limit = 20
+			}
+			deadLetters := make([]models.DeadLetter, 0)
+			var count int64
+			conds := &models.DeadLetter{Topic: filters.Topic, Status: filters.Status}
+			db := db.GetDb()
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.
+					Model(&models.DeadLetter{}).
+					Where(conds).
+					Count(&count).
+					Error; err != nil {
+					return err
+				}
+				return tx.
+					Where(conds).
+					Order("created_at desc").
+					Offset((filters.Page - 1) * filters.Limit).
+					Limit(filters.Limit).
+					Find(&deadLetters).
+					Error
+			})
+			if err != nil {
+				log.Printf("Error retrieving dead letters: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  deadLetters,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		GET("/dead-letters/:id", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var deadLetter models.DeadLetter
+			db := db.GetDb()
+			if err := db.
+				Where(&models.DeadLetter{ID: id}).
+				First(&deadLetter).
+				Error; err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": deadLetter})
+		}).
+		POST("/dead-letters/:id/replay", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			deadLetter, err := common.ReplayDeadLetter(id, ctx.GetUint("id"))
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "pending dead letter not found"})
+				return
+			}
+			if err != nil {
+				log.Printf("Error replaying dead letter [%s]: %s\n", id, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": deadLetter})
+		}).
+		POST("/dead-letters/:id/discard", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			deadLetter, err := common.DiscardDeadLetter(id, ctx.GetUint("id"))
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "pending dead letter not found"})
+				return
+			}
+			if err != nil {
+				log.Printf("Error discarding dead letter [%s]: %s\n", id, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": deadLetter})
+		}).
+		GET("/stripe-events", func(ctx *gin.Context) {
+			var filters types.StripeEventsQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if filters.Page == 0 {
+				filters.Page = 1
+			}
+			if filters.Limit == 0 {
+				filters.Limit = 20
+			}
+			stripeEvents := make([]models.StripeEvent, 0)
+			var count int64
+			conds := &models.StripeEvent{Type: filters.Type, Status: filters.Status}
+			db := db.GetDb()
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.
+					Model(&models.StripeEvent{}).
+					Where(conds).
+					Count(&count).
+					Error; err != nil {
+					return err
+				}
+				return tx.
+					Select("id", "event_id", "type", "account", "status", "error", "attempts", "processed_at", "created_at", "updated_at").
+					Where(conds).
+					Order("created_at desc").
+					Offset((filters.Page - 1) * filters.Limit).
+					Limit(filters.Limit).
+					Find(&stripeEvents).
+					Error
+			})
+			if err != nil {
+				log.Printf("Error retrieving stripe events: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  stripeEvents,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		GET("/stripe-events/:id", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var stripeEvent models.StripeEvent
+			db := db.GetDb()
+			if err := db.
+				Where(&models.StripeEvent{ID: id}).
+				First(&stripeEvent).
+				Error; err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "stripe event not found"})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": stripeEvent})
+		}).
+		POST("/stripe-events/:id/replay", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			stripeEvent, err := common.ReplayStripeEvent(id)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "stripe event not found"})
+				return
+			}
+			if errors.Is(err, common.ErrStripeEventNotReplayable) {
+				ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				log.Printf("Error replaying stripe event [%s]: %s\n", id, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusAccepted, gin.H{"data": stripeEvent})
+		}).
+		GET("/payment-discrepancies", func(ctx *gin.Context) {
+			var filters types.PaymentDiscrepanciesQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if filters.Page == 0 {
+				filters.Page = 1
+			}
+			if filters.Limit == 0 {
+				filters.Limit = 20
+			}
+			discrepancies := make([]models.PaymentDiscrepancy, 0)
+			summary := make([]types.DiscrepancySummary, 0)
+			var count int64
+			conds := &models.PaymentDiscrepancy{
+				OrganizationID: filters.OrganizationID,
+				Kind:           filters.Kind,
+				Status:         filters.Status,
+			}
+			db := db.GetDb()
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.
+					Model(&models.PaymentDiscrepancy{}).
+					Where(conds).
+					Count(&count).
+					Error; err != nil {
+					return err
+				}
+				if err := tx.
+					Model(&models.PaymentDiscrepancy{}).
+					Select("kind", "status", "COUNT(*) AS count").
+					Where(conds).
+					Group("kind").
+					Group("status").
+					Scan(&summary).
+					Error; err != nil {
+					return err
+				}
+				return tx.
+					Where(conds).
+					Order("created_at desc").
+					Offset((filters.Page - 1) * filters.Limit).
+					Limit(filters.Limit).
+					Find(&discrepancies).
+					Error
+			})
+			if err != nil {
+				log.Printf("Error retrieving payment discrepancies: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":    discrepancies,
+				"summary": summary,
+				"count":   count,
+				"page":    filters.Page,
+				"limit":   filters.Limit,
+			})
+		}).
+		POST("/payment-discrepancies/:id/resolve", func(ctx *gin.Context) {
+			id, err := uuid.Parse(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			discrepancy, err := common.ResolvePaymentDiscrepancy(id, ctx.GetUint("id"))
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "open discrepancy not found"})
+				return
+			}
+			if err != nil {
+				log.Printf("Error resolving payment discrepancy [%s]: %s\n", id, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_DISCREPANCY_RESOLVE, discrepancy.OrganizationID, discrepancy.ID,
+				types.JSONB{"status": types.DISCREPANCY_OPEN},
+				types.JSONB{"status": types.DISCREPANCY_RESOLVED})
+			ctx.JSON(http.StatusOK, gin.H{"data": discrepancy})
+		}).
+		POST("/reconciliation", func(ctx *gin.Context) {
+			since := time.Now().Add(-common.ReconciliationWindow)
+			go func() {
+				if err := common.ReconcilePayments(since); err != nil {
+					log.Printf("[Reconciliation] Error reconciling payments: %s\n", err.Error())
+				}
+			}()
+			ctx.JSON(http.StatusAccepted, gin.H{"since": since})
+		}).
+		GET("/mail-logs", func(ctx *gin.Context) {
+			var filters types.MailLogQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			listMailLogs(ctx, 0, &filters)
+		}).
+		GET("/email-suppressions", func(ctx *gin.Context) {
+			var filters types.EmailSuppressionQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			suppressions, count, err := common.ListEmailSuppressions(&filters)
+			if err != nil {
+				log.Printf("Error retrieving email suppressions: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  suppressions,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		POST("/email-suppressions", func(ctx *gin.Context) {
+			var body types.CreateEmailSuppressionRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			suppression, err := common.AddEmailSuppression(body)
+			if err != nil {
+				log.Printf("Error suppressing email: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": suppression})
+		}).
+		DELETE("/email-suppressions/:id", func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			err := common.RemoveEmailSuppression(params.ID)
+			if errors.Is(err, common.ErrEmailSuppressionNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				log.Printf("Error removing email suppression [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		}).
+		GET("/rating-reports", func(ctx *gin.Context) {
+			var filters types.RatingReportQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			reports, count, err := common.ListRatingReports(&filters)
+			if err != nil {
+				log.Printf("Error retrieving rating reports: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  reports,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		POST("/ratings/:id/moderate", func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.ModerateRatingRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			before, after, err := common.ModerateRating(params.ID, body.Status, ctx.GetUint("id"))
+			if errors.Is(err, common.ErrRatingNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				log.Printf("Error moderating rating [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_RATING_MODERATE, after.OrganizationID, after.ID,
+				types.JSONB{"status": before.Status},
+				types.JSONB{"status": after.Status})
+			ctx.JSON(http.StatusOK, gin.H{"data": after})
+		})
+	return g
+}
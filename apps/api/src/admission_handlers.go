@@ -1,7 +1,9 @@
 package main
 
 import (
+	"ebs/src/common"
 	"ebs/src/db"
+	"ebs/src/middlewares"
 	"ebs/src/models"
 	"ebs/src/types"
 	"ebs/src/utils"
@@ -14,10 +16,11 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+var errAdmissionForbidden = errors.New("not allowed to admit reservations for this event")
+
 func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 	g.
 		GET("/admissions", func(ctx *gin.Context) {
@@ -51,7 +54,6 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			resIdKey := rawData["reservationId"].(float64)
 			reservationId := uint(resIdKey)
 
-			tenantId, _ := uuid.Parse(ctx.GetString("tenant_id"))
 			userId := ctx.GetUint("id")
 			db := db.GetDb()
 			err = db.Transaction(func(tx *gorm.DB) error {
@@ -69,10 +71,14 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				if err != nil {
 					return err
 				}
-				if reservation.Booking.Event.Status == types.EVENT_COMPLETED {
+				event := reservation.Booking.Event
+				if !middlewares.CheckEventPermission(ctx, event.OrganizerID, event.ID, types.PERM_ADMISSIONS_CREATE) {
+					return errAdmissionForbidden
+				}
+				if event.Status == types.EVENT_COMPLETED {
 					return errors.New("ticket admissions are no longer accepted")
 				}
-				if reservation.Booking.Event.Status != types.EVENT_ADMISSION {
+				if event.Status != types.EVENT_ADMISSION {
 					return errors.New("ticket admissions are not accepted")
 				}
 				admission := models.Admission{
@@ -80,7 +86,7 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					Type:          "single",
 					Status:        "completed",
 					By:            userId,
-					TenantID:      &tenantId,
+					TenantID:      reservation.TenantID,
 				}
 				err = tx.
 					Where(models.Admission{ReservationID: reservationId}).
@@ -103,8 +109,23 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					Error; err != nil {
 					return err
 				}
-				return nil
+				if err := models.EmitWebhookEvent(tx, event.OrganizerID, types.WEBHOOK_ADMISSION_CREATED, types.JSONB{
+					"id":            admission.ID,
+					"reservationId": reservationId,
+					"ticketId":      reservation.TicketID,
+					"eventId":       event.ID,
+					"bookingId":     reservation.BookingID,
+				}); err != nil {
+					return err
+				}
+				return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_ADMISSION_CREATE, event.OrganizerID, admission.ID,
+					types.JSONB{"reservation_id": reservationId, "reservation_status": reservation.Status},
+					types.JSONB{"reservation_id": reservationId, "reservation_status": types.RESERVATION_COMPLETED}))
 			})
+			if errors.Is(err, errAdmissionForbidden) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
 			if err != nil {
 				err := fmt.Errorf("error on Ticket admission: %s", err.Error())
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -119,43 +140,42 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			orgId := ctx.GetUint("org")
-			var res models.Reservation
 			var adm models.Admission
-			db := db.GetDb()
-			if err := db.
-				Where(&models.Admission{ID: params.ID}).
-				Preload("Reservation").
-				Preload("Reservation.Booking").
-				Preload("Reservation.Ticket").
-				First(&adm).
-				Error; err != nil {
-				log.Printf("Error retrieving Admission [%d]: %s\n", params.ID, err.Error())
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					ctx.Status(http.StatusNotFound)
-					return
+			var evt models.Event
+			// Admissions belong to the tenants of the attendees, the organization lets its own be read
+			db := db.GetDb().WithContext(db.WithOrganization(ctx.Request.Context(), orgId))
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.
+					Where(&models.Admission{ID: params.ID}).
+					Preload("Reservation").
+					Preload("Reservation.Booking").
+					Preload("Reservation.Ticket").
+					First(&adm).
+					Error; err != nil {
+					return fmt.Errorf("error retrieving Admission [%d]: %w", params.ID, err)
 				}
-				ctx.Status(http.StatusNotFound)
-				return
-			}
-			if err := db.
-				Where(&models.Reservation{ID: adm.ReservationID}).
-				First(&res).
-				Error; err != nil {
-				log.Printf("Error retrieving Reservation for Admission [%d]: %s\n", params.ID, err.Error())
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					ctx.Status(http.StatusNotFound)
-					return
+				var res models.Reservation
+				if err := tx.
+					Where(&models.Reservation{ID: adm.ReservationID}).
+					First(&res).
+					Error; err != nil {
+					return fmt.Errorf("error retrieving Reservation for Admission [%d]: %w", params.ID, err)
 				}
-				ctx.Status(http.StatusBadRequest)
-				return
-			}
-			var ticket models.Ticket
-			if err := db.
-				Where(&models.Ticket{ID: res.TicketID}).
-				Preload("Event", "organizer_id = ?", orgId).
-				First(&ticket).
-				Error; err != nil {
-				log.Printf("Error retrieving Ticket for Admission [%d]: %s\n", params.ID, err.Error())
+				var ticket models.Ticket
+				if err := tx.
+					Where(&models.Ticket{ID: res.TicketID}).
+					Preload("Event", "organizer_id = ?", orgId).
+					First(&ticket).
+					Error; err != nil {
+					return fmt.Errorf("error retrieving Ticket for Admission [%d]: %w", params.ID, err)
+				}
+				if err := tx.Where(&models.Event{ID: ticket.EventID, OrganizerID: orgId}).First(&evt).Error; err != nil {
+					return fmt.Errorf("error retrieving Event for Admission [%d]: %w", params.ID, err)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Println(err.Error())
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					ctx.Status(http.StatusNotFound)
 					return
@@ -163,14 +183,8 @@ func admissionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
-			var evt models.Event
-			if err := db.Where(&models.Event{ID: ticket.EventID, OrganizerID: orgId}).First(&evt).Error; err != nil {
-				log.Printf("Error retrieving Event for Admission [%d]: %s\n", params.ID, err.Error())
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					ctx.Status(http.StatusNotFound)
-					return
-				}
-				ctx.Status(http.StatusBadRequest)
+			if !middlewares.CheckEventPermission(ctx, evt.OrganizerID, evt.ID, types.PERM_ADMISSIONS_READ) {
+				ctx.Status(http.StatusNotFound)
 				return
 			}
 
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func apiKeyHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	orgParam := middlewares.OrganizationParam("orgId")
+	g.
+		GET("/users/me/api-keys", func(ctx *gin.Context) {
+			keys, err := common.ListPersonalAPIKeys(ctx.GetUint("id"))
+			if err != nil {
+				log.Printf("Error retrieving API keys: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": keys})
+		}).
+		POST("/users/me/api-keys", func(ctx *gin.Context) {
+			var body types.CreateAPIKeyRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			key, secret, err := common.CreatePersonalAPIKey(ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error creating API key: %s\n", err.Error())
+				ctx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": key, "key": secret})
+		}).
+		DELETE("/users/me/api-keys/:keyId", func(ctx *gin.Context) {
+			keyId, err := uuid.Parse(ctx.Param("keyId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.RevokePersonalAPIKey(ctx.GetUint("id"), keyId); err != nil {
+				log.Printf("Error revoking API key [%s]: %s\n", keyId, err.Error())
+				ctx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		})
+	keys.
+		GET("/organizations/:orgId/api-keys", middlewares.RequireSession, middlewares.RequirePermission(types.PERM_API_KEYS_MANAGE, orgParam), func(ctx *gin.Context) {
+			keys, err := common.ListOrganizationAPIKeys(ctx.GetUint("org_id"))
+			if err != nil {
+				log.Printf("Error retrieving API keys of Organization [%d]: %s\n", ctx.GetUint("org_id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": keys})
+		}).
+		POST("/organizations/:orgId/api-keys", middlewares.RequireSession, middlewares.RequirePermission(types.PERM_API_KEYS_MANAGE, orgParam), func(ctx *gin.Context) {
+			var body types.CreateAPIKeyRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			key, secret, err := common.CreateOrganizationAPIKey(orgId, ctx.GetUint("id"), orgRole(ctx), body)
+			if err != nil {
+				log.Printf("Error creating API key for Organization [%d]: %s\n", orgId, err.Error())
+				ctx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_API_KEY_CREATE, orgId, key.ID, nil,
+				types.JSONB{"name": key.Name, "prefix": key.Prefix, "scopes": key.Scopes, "expiresAt": key.ExpiresAt})
+			ctx.JSON(http.StatusCreated, gin.H{"data": key, "key": secret})
+		}).
+		DELETE("/organizations/:orgId/api-keys/:keyId", middlewares.RequireSession, middlewares.RequirePermission(types.PERM_API_KEYS_MANAGE, orgParam), func(ctx *gin.Context) {
+			keyId, err := uuid.Parse(ctx.Param("keyId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			if err := common.RevokeOrganizationAPIKey(orgId, keyId, ctx.GetUint("id")); err != nil {
+				log.Printf("Error revoking API key [%s]: %s\n", keyId, err.Error())
+				ctx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_API_KEY_REVOKE, orgId, keyId,
+				types.JSONB{"revoked": false},
+				types.JSONB{"revoked": true})
+			ctx.Status(http.StatusNoContent)
+		})
+	return g
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrAPIKeyScopeDenied):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
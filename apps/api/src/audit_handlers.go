@@ -0,0 +1,82 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/db"
+	"ebs/src/middlewares"
+	"ebs/src/models"
+	"ebs/src/types"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func auditHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	keys.
+		GET("/organizations/:orgId/audit", middlewares.RequirePermission(types.PERM_AUDIT_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
+			var filters types.AuditTrailQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			filters.OrganizationID = ctx.GetUint("org_id")
+			listAuditTrail(ctx, &filters)
+		})
+	return g
+}
+
+func listAuditTrail(ctx *gin.Context, filters *types.AuditTrailQueryFilters) {
+	entries, count, err := common.ListAuditTrail(filters)
+	if err != nil {
+		log.Printf("Error retrieving audit trail: %s\n", err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  entries,
+		"count": count,
+		"page":  filters.Page,
+		"limit": filters.Limit,
+	})
+}
+
+// newTrailLog returns an audit trail entry for a change made by the authenticated actor of the request
+func newTrailLog(ctx *gin.Context, action types.AuditAction, orgId uint, resourceId any, before types.JSONB, after types.JSONB) *models.TrailLog {
+	group, _, _ := strings.Cut(string(action), ".")
+	entry := &models.TrailLog{
+		Type:           action,
+		Initiator:      types.AUDIT_BY_USER,
+		Group:          group,
+		OrganizationID: orgId,
+		ResourceID:     fmt.Sprint(resourceId),
+		Before:         before,
+		After:          after,
+		RequestID:      ctx.GetString("request_id"),
+	}
+	if _, ok := ctx.Get("staff_event_id"); ok {
+		entry.Initiator = types.AUDIT_BY_STAFF
+	}
+	if _, ok := ctx.Get("api_key_id"); ok {
+		entry.Initiator = types.AUDIT_BY_API_KEY
+	}
+	if actorId := ctx.GetUint("id"); actorId > 0 {
+		entry.ActorID = &actorId
+	}
+	if tenantId, err := uuid.Parse(ctx.GetString("tenant_id")); err == nil {
+		entry.TenantID = &tenantId
+	}
+	return entry
+}
+
+// recordAudit records a change that has already been committed in the audit trail
+func recordAudit(ctx *gin.Context, action types.AuditAction, orgId uint, resourceId any, before types.JSONB, after types.JSONB) {
+	entry := newTrailLog(ctx, action, orgId, resourceId, before, after)
+	if err := common.RecordAudit(db.GetDb(), entry); err != nil {
+		log.Printf("Error recording audit entry [%s] for %s: %s\n", action, entry.ResourceID, err.Error())
+	}
+}
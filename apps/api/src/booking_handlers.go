@@ -1,6 +1,7 @@
 package main
 
 import (
+	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/models"
 	"ebs/src/types"
@@ -73,17 +74,19 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			bookingId := uint(atoi)
 			var booking models.Booking
-			db := db.GetDb()
-			err = db.
-				Model(&models.Booking{}).
-				Where(&models.Booking{ID: bookingId}).
-				Preload("Event").
-				Preload("Tickets").
-				Preload("Reservations").
-				Preload("Reservations.Ticket").
-				Limit(100).
-				First(&booking).
-				Error
+			db := db.GetDb().WithContext(ctx.Request.Context())
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return tx.
+					Model(&models.Booking{}).
+					Where(&models.Booking{ID: bookingId}).
+					Preload("Event").
+					Preload("Tickets").
+					Preload("Reservations").
+					Preload("Reservations.Ticket").
+					Limit(100).
+					First(&booking).
+					Error
+			})
 			if err != nil {
 				log.Print(err.Error())
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -98,7 +101,7 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err = db.Transaction(func(tx *gorm.DB) error {
 				var booking models.Booking
 				err := tx.
@@ -122,6 +125,9 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				if err != nil {
 					return err
 				}
+				if err := common.EmitReservationsCanceled(tx, params.ID); err != nil {
+					return err
+				}
 				err = tx.
 					Model(&models.Reservation{}).
 					Where(&models.Reservation{BookingID: params.ID}).
@@ -138,7 +144,18 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				if err != nil {
 					return err
 				}
-				return nil
+				var orgId uint
+				if err := tx.
+					Model(&models.Event{}).
+					Select("organizer_id").
+					Where("id = ?", booking.EventID).
+					Take(&orgId).
+					Error; err != nil {
+					return err
+				}
+				return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_BOOKING_CANCEL, orgId, params.ID,
+					types.JSONB{"status": booking.Status},
+					types.JSONB{"status": types.BOOKING_CANCELED}))
 			})
 			if err != nil {
 				log.Printf("Could not complete request: %s\n", err.Error())
@@ -154,10 +171,18 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err := db.Transaction(func(tx *gorm.DB) error {
 				switch body.Type {
 				case "transaction":
+					var txn models.Transaction
+					if err := tx.
+						Select("id", "status").
+						Where("id = ?", body.TxnID).
+						First(&txn).
+						Error; err != nil {
+						return err
+					}
 					if err := tx.
 						Model(&models.Transaction{}).
 						Where("id = ?", body.TxnID).
@@ -184,6 +209,9 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 						return err
 					}
 					// ids := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(bIds)), ","), "[]")
+					if err := common.EmitReservationsCanceled(tx, bIds...); err != nil {
+						return err
+					}
 					if err := tx.
 						Model(&models.Reservation{}).
 						Where("booking_id IN (?)", bIds).
@@ -192,6 +220,22 @@ func bookingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 						log.Printf("Could not update Reservation for Booking %v: %s\n", bIds, err.Error())
 						return err
 					}
+					var orgId uint
+					if err := tx.
+						Model(&models.Booking{}).
+						Select("events.organizer_id").
+						Joins("JOIN events ON events.id = bookings.event_id").
+						Where(&models.Booking{TransactionID: &txnId}).
+						Limit(1).
+						Scan(&orgId).
+						Error; err != nil {
+						return err
+					}
+					if err := common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_TRANSACTION_CANCEL, orgId, body.TxnID,
+						types.JSONB{"status": txn.Status, "bookings": bIds},
+						types.JSONB{"status": types.TRANSACTION_CANCELED, "bookings": bIds})); err != nil {
+						return err
+					}
 				case "reservation":
 					return errors.New("updating status for individual Booking is not allowed")
 				default:
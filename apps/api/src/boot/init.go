@@ -5,6 +5,7 @@ import (
 	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/lib"
+	"ebs/src/lib/broker"
 	"ebs/src/models"
 	"ebs/src/types"
 	"ebs/src/utils"
@@ -42,21 +43,47 @@ func InitDb() *gorm.DB {
 		&models.Permission{},
 		&models.RolePermission{},
 		&models.Rating{},
+		&models.RatingReport{},
+		&models.Survey{},
+		&models.SurveyResponse{},
 		&models.Notification{},
+		&models.NotificationRecipient{},
+		&models.NotificationPreference{},
+		&models.EmailBranding{},
+		&models.Broadcast{},
+		&models.BroadcastRecipient{},
+		&models.MailLog{},
+		&models.EmailSuppression{},
 		&models.Credential{},
 		&models.Token{},
 		&models.Account{},
+		&models.DeadLetter{},
+		&models.OutboxMessage{},
+		&models.StripeEvent{},
+		&models.PaymentDiscrepancy{},
+		&models.StaffCredential{},
+		&models.TrailLog{},
+		&models.SigningKey{},
+		&models.TOTPCredential{},
+		&models.RecoveryCode{},
+		&models.APIKey{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
 	)
 	if err != nil {
 		log.Fatalf("error migration: %s", err.Error())
 	}
+	if err = db.Transaction(models.SeedDefaultRoles); err != nil {
+		log.Printf("Error seeding default roles: %s\n", err.Error())
+	}
+	// Tenant scoped transactions call set_tenant, the API must not serve requests without it
+	if err = models.EnableRowLevelSecurity(db); err != nil {
+		log.Fatalf("error enabling row level security: %s", err.Error())
+	}
+	if err = models.ProtectTrailLogs(db); err != nil {
+		log.Printf("Error protecting audit trail: %s\n", err.Error())
+	}
 	if err = db.Exec(`
-	CREATE OR REPLACE FUNCTION set_tenant(tenant_id text) RETURNS void AS $$
-	BEGIN
-		PERFORM set_config('app.current_tenant', tenant_id, false);
-	END;
-	$$ LANGUAGE plpgsql;
-
 	CREATE OR REPLACE FUNCTION daily_transactions(org_id INT)
 	RETURNS TABLE (
 		txn_date TEXT,
@@ -86,7 +113,7 @@ func InitDb() *gorm.DB {
 	END;
 	$$ LANGUAGE plpgsql;
 	`).Error; err != nil {
-		log.Printf("Error creating FUNCTION set_tenant: %s\n", err.Error())
+		log.Printf("Error creating FUNCTION daily_transactions: %s\n", err.Error())
 	}
 
 	return db
@@ -98,67 +125,112 @@ func InitBroker() {
 	go RecoverQueuedJobs()
 	go UpdateExpiredJobs()
 	go StatusUpdateExpiredBookings()
-	if apiEnv == "test" || apiEnv == "production" {
-		go func() {
-			InitTopics()
-			InitQueues()
-
+	b := broker.GetBroker()
+	lib.NewTaskPublisher(func(clientId string, topic string, p *types.JSONB) error {
+		return b.Publish(topic, p)
+	})
+	go func() {
+		if apiEnv == string(types.Test) || apiEnv == string(types.Production) {
 			lib.S3ListObjects()
-			common.SQSConsumers()
-			common.SNSSubscribes()
-		}()
-	} else {
-		emailQueue := os.Getenv("EMAIL_QUEUE")
-		lib.KafkaCreateTopics(
-			utils.WithSuffix("Retry"),
-			utils.WithSuffix("EventsToOpen"),
-			utils.WithSuffix("EventsToClose"),
-			utils.WithSuffix("EventsToComplete"),
-			utils.WithSuffix("PendingTransactions"),
-			utils.WithSuffix("PaymentTransactionUpdates"),
-			utils.WithSuffix(emailQueue),
-		)
-		var retryConsumer types.Handler = common.KafkaRetryConsumer
-		go lib.KafkaConsumer("retry", utils.WithSuffix("Retry"), &retryConsumer)
-
-		var eventsToOpenConsumer types.Handler = common.KafkaEventsToOpenConsumer
-		go lib.KafkaConsumer("events", utils.WithSuffix("EventsToOpen"), &eventsToOpenConsumer)
-
-		var eventsToCloseConsumer types.Handler = common.KafkaEventsToCloseConsumer
-		go lib.KafkaConsumer("events", utils.WithSuffix("EventsToClose"), &eventsToCloseConsumer)
-
-		var eventsToCompleteConsumer types.Handler = common.KafkaEventsToCompleteConsumer
-		go lib.KafkaConsumer("events", utils.WithSuffix("EventsToComplete"), &eventsToCompleteConsumer)
-
-		var emailsToSendConsumer types.Handler = common.KafkaEmailsToSendConsumer
-		go lib.KafkaConsumer("emails", utils.WithSuffix(emailQueue), &emailsToSendConsumer)
+		}
+		if err := b.CreateTopics(common.Topics()...); err != nil {
+			log.Printf("[%s] Error creating topics: %s\n", b.Name(), err.Error())
+		}
+		if err := common.RegisterConsumers(b); err != nil {
+			log.Printf("[%s] Error registering consumers: %s\n", b.Name(), err.Error())
+		}
+		InitOutboxRelay()
+		InitWebhookDelivery()
+		InitPaymentReconciliation()
+	}()
+}
 
-		var pendingTxnConsumer types.Handler = common.KafkaPendingTransactionsConsumer
-		go lib.KafkaConsumer("transactions", utils.WithSuffix("PendingTransactions"), &pendingTxnConsumer)
+// InitOutboxRelay periodically publishes pending outbox messages to the broker
+func InitOutboxRelay() {
+	sched, err := lib.GetScheduler()
+	if err != nil {
+		log.Println("An error has occurred. Check logs for info")
+		return
+	}
+	_, err = sched.NewJob(
+		gocron.DurationJob(common.OutboxPollInterval),
+		gocron.NewTask(func() {
+			if err := common.RelayOutbox(); err != nil {
+				log.Printf("[Outbox] Error relaying messages: %s\n", err.Error())
+			}
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Printf("Error scheduling outbox relay: %s\n", err.Error())
+	}
+}
 
-		var kafkaPaymentTransactionUpdatesConsumer types.Handler = common.KafkaPaymentTransactionUpdatesConsumer
-		go lib.KafkaConsumer("payments", utils.WithSuffix("PaymentTransactionUpdates"), &kafkaPaymentTransactionUpdatesConsumer)
+// InitWebhookDelivery periodically sends the organization webhook deliveries that are due
+func InitWebhookDelivery() {
+	sched, err := lib.GetScheduler()
+	if err != nil {
+		log.Println("An error has occurred. Check logs for info")
+		return
+	}
+	_, err = sched.NewJob(
+		gocron.DurationJob(common.WebhookPollInterval),
+		gocron.NewTask(func() {
+			if err := common.DeliverWebhooks(); err != nil {
+				log.Printf("[Webhooks] Error delivering webhooks: %s\n", err.Error())
+			}
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Printf("Error scheduling webhook delivery: %s\n", err.Error())
 	}
 }
 
-func InitQueues() {
-	emailQueue := os.Getenv("EMAIL_QUEUE")
-	lib.SQSCreateQueue(utils.WithSuffix(emailQueue))
-	lib.SQSCreateQueue(utils.WithSuffix("EventsToOpen"))
-	lib.SQSCreateQueue(utils.WithSuffix("EventsToClose"))
-	lib.SQSCreateQueue(utils.WithSuffix("EventsToComplete"))
-	lib.SQSCreateQueue(utils.WithSuffix("PendingReservations"))
-	lib.SQSCreateQueue(utils.WithSuffix("PendingTransactions"))
-	lib.SQSCreateQueue(utils.WithSuffix("PaymentsProcessing"))
-	lib.SQSCreateQueue(utils.WithSuffix("ExpiredBookings"))
-	lib.SQSCreateQueue(utils.WithSuffix("PaymentTransactionUpdates"))
-	lib.SQSCreateQueue(utils.WithSuffix("DLQ"))
+// InitSigningKeys loads the access token signing keys, generating one when there is none,
+// and schedules their rotation every SigningKeyRotation
+func InitSigningKeys() {
+	if err := utils.RotateSigningKeys(utils.SigningKeyRotation); err != nil {
+		log.Fatalf("Error loading signing keys: %s\n", err.Error())
+	}
+	sched, err := lib.GetScheduler()
+	if err != nil {
+		log.Println("An error has occurred. Check logs for info")
+		return
+	}
+	_, err = sched.NewJob(
+		gocron.DurationJob(utils.SigningKeyReload),
+		gocron.NewTask(func() {
+			if err := utils.RotateSigningKeys(utils.SigningKeyRotation); err != nil {
+				log.Printf("[SigningKeys] Error rotating signing keys: %s\n", err.Error())
+			}
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Printf("Error scheduling signing key rotation: %s\n", err.Error())
+	}
 }
-func InitTopics() {
-	lib.SNSCreateTopic(utils.WithSuffix("EventsToOpen"))
-	lib.SNSCreateTopic(utils.WithSuffix("EventsToClose"))
-	lib.SNSCreateTopic(utils.WithSuffix("EventsToComplete"))
-	lib.SNSCreateTopic(utils.WithSuffix("PendingTransactions"))
+
+// InitPaymentReconciliation reconciles the payments of the past ReconciliationWindow with Stripe every night
+func InitPaymentReconciliation() {
+	sched, err := lib.GetScheduler()
+	if err != nil {
+		log.Println("An error has occurred. Check logs for info")
+		return
+	}
+	_, err = sched.NewJob(
+		gocron.DailyJob(1, gocron.NewAtTimes(gocron.NewAtTime(3, 0, 0))),
+		gocron.NewTask(func() {
+			if err := common.ReconcilePayments(time.Now().Add(-common.ReconciliationWindow)); err != nil {
+				log.Printf("[Reconciliation] Error reconciling payments: %s\n", err.Error())
+			}
+		}),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Printf("Error scheduling payment reconciliation: %s\n", err.Error())
+	}
 }
 
 func InitScheduler() {
@@ -225,7 +297,7 @@ func RecoverQueuedJobs() error {
 		jobDef := gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(jobTask.RunsAt))
 		jt := gocron.NewTask(func() {
 			log.Println("Running scheduled task")
-			err := lib.KafkaProduceMessage(jobTask.Payload["producerClientId"].(string), jobTask.Payload["topic"].(string), &jobTask.Payload)
+			err := broker.Publish(jobTask.Payload["topic"].(string), &jobTask.Payload)
 			if err != nil {
 				log.Printf("Error on producting message: %s\n", err.Error())
 				return
@@ -266,7 +338,7 @@ func UpdateExpiredJobs() {
 func StatusUpdateExpiredBookings() {
 	db := db.GetDb()
 	err := db.Transaction(func(tx *gorm.DB) error {
-		var eventIDs []uint
+		var events []models.Event
 		if err := tx.
 			Model(&models.Event{}).
 			Where("status IN (?)", []string{string(types.EVENT_TICKETS_NOTIFY)}).
@@ -275,18 +347,27 @@ func StatusUpdateExpiredBookings() {
 				Or("opens_at < ?", time.Now()).
 				Or("deadline < ?", time.Now()),
 			).
-			Select("id").
-			Pluck("id", &eventIDs).
+			Select("id", "organizer_id", "status").
+			Find(&events).
 			Error; err != nil {
 			return err
 		}
-		log.Printf("[CONSUMER]: Found %d Events overdue\n", len(eventIDs))
+		log.Printf("[CONSUMER]: Found %d Events overdue\n", len(events))
+		eventIDs := make([]uint, len(events))
+		for i, event := range events {
+			eventIDs[i] = event.ID
+		}
 		if err := tx.Model(&models.Event{}).
 			Where("id IN (?)", eventIDs).
 			Update("status", types.EVENT_EXPIRED).
 			Error; err != nil {
 			return err
 		}
+		for _, event := range events {
+			if err := models.EmitEventStatusChanged(tx, event.OrganizerID, event.ID, event.Status, types.EVENT_EXPIRED); err != nil {
+				return err
+			}
+		}
 		var bids []uint
 		if err := tx.
 			Model(&models.Booking{}).
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func broadcastHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canSend := middlewares.RequirePermission(types.PERM_BROADCASTS_SEND, middlewares.EventOrganization("id"))
+	keys.
+		POST("/events/:id/broadcasts", canSend, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.CreateBroadcastRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			broadcast, err := common.CreateBroadcast(orgId, params.ID, ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error creating broadcast for Event [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_BROADCAST_SEND, orgId, broadcast.ID, nil, types.JSONB{
+				"eventId":    params.ID,
+				"subject":    broadcast.Subject,
+				"tiers":      broadcast.Tiers,
+				"admission":  broadcast.Admission,
+				"recipients": broadcast.Recipients,
+			})
+			ctx.JSON(http.StatusAccepted, gin.H{"data": broadcast})
+		}).
+		GET("/events/:id/broadcasts", canSend, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var filters types.BroadcastQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			broadcasts, count, err := common.ListBroadcasts(ctx.GetUint("org_id"), params.ID, &filters)
+			if err != nil {
+				log.Printf("Error retrieving broadcasts of Event [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  broadcasts,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		GET("/events/:id/broadcasts/:broadcastId", canSend, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			broadcastId, err := uuid.Parse(ctx.Param("broadcastId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			broadcast, err := common.GetBroadcast(ctx.GetUint("org_id"), params.ID, broadcastId)
+			if err != nil {
+				log.Printf("Error retrieving broadcast [%s]: %s\n", broadcastId, err.Error())
+				ctx.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": broadcast})
+		}).
+		GET("/events/:id/broadcasts/:broadcastId/recipients", canSend, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			broadcastId, err := uuid.Parse(ctx.Param("broadcastId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var filters types.BroadcastRecipientQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if _, err := common.GetBroadcast(ctx.GetUint("org_id"), params.ID, broadcastId); err != nil {
+				ctx.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recipients, count, err := common.ListBroadcastRecipients(broadcastId, &filters)
+			if err != nil {
+				log.Printf("Error retrieving recipients of broadcast [%s]: %s\n", broadcastId, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  recipients,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		})
+	return g
+}
+
+func broadcastErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrBroadcastNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrNoBroadcastRecipients):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,193 @@
+package common
+
+import (
+	"crypto/rand"
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// APIKeyTTL is how long an API key is valid unless another expiry is requested
+	APIKeyTTL = 90 * 24 * time.Hour
+	// APIKeyMaxTTL is the longest an API key is valid
+	APIKeyMaxTTL = 365 * 24 * time.Hour
+	// apiKeyPrefixLength is how much of a key is kept in clear to tell keys apart
+	apiKeyPrefixLength = 12
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrInvalidAPIKey     = errors.New("invalid API key scopes or expiry")
+	ErrAPIKeyScopeDenied = errors.New("API key scopes exceed your permissions")
+)
+
+// CreatePersonalAPIKey mints a key acting as the user within its scopes.
+// The secret is only returned here, it is stored hashed.
+func CreatePersonalAPIKey(userId uint, body types.CreateAPIKeyRequestBody) (*models.APIKey, string, error) {
+	expiresAt, err := apiKeyExpiry(body)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := newAPIKeySecret(types.API_KEY_PERSONAL)
+	if err != nil {
+		return nil, "", err
+	}
+	key := models.APIKey{
+		Kind:       types.API_KEY_PERSONAL,
+		Name:       body.Name,
+		Prefix:     secret[:apiKeyPrefixLength],
+		SecretHash: models.HashAPIKey(secret),
+		UserID:     userId,
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(body.Scopes))),
+		CreatedBy:  userId,
+		ExpiresAt:  expiresAt,
+	}
+	db := db.GetDb()
+	if err := db.Create(&key).Error; err != nil {
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+// CreateOrganizationAPIKey mints a key acting for the organization within its scopes. The scopes may not
+// exceed the permissions of the role of the member creating it. The secret is only returned here, it is stored hashed.
+func CreateOrganizationAPIKey(orgId uint, createdBy uint, role types.MemberRole, body types.CreateAPIKeyRequestBody) (*models.APIKey, string, error) {
+	expiresAt, err := apiKeyExpiry(body)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := newAPIKeySecret(types.API_KEY_ORGANIZATION)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var key models.APIKey
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		perms, err := models.GetRolePermissions(tx, role)
+		if err != nil {
+			return err
+		}
+		for _, scope := range body.Scopes {
+			if !slices.Contains(perms, scope) {
+				return ErrAPIKeyScopeDenied
+			}
+		}
+		id := uuid.New()
+		user := models.User{
+			Name:      body.Name,
+			Email:     fmt.Sprintf("apikey+%s@keys.invalid", id),
+			Role:      types.ROLE_SERVICE,
+			Status:    "active",
+			ActiveOrg: orgId,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		key = models.APIKey{
+			ID:             id,
+			Kind:           types.API_KEY_ORGANIZATION,
+			Name:           body.Name,
+			Prefix:         secret[:apiKeyPrefixLength],
+			SecretHash:     models.HashAPIKey(secret),
+			UserID:         user.ID,
+			OrganizationID: &orgId,
+			Scopes:         slices.Compact(slices.Sorted(slices.Values(body.Scopes))),
+			CreatedBy:      createdBy,
+			ExpiresAt:      expiresAt,
+		}
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+// ListPersonalAPIKeys returns the personal API keys of the user, newest first
+func ListPersonalAPIKeys(userId uint) ([]models.APIKey, error) {
+	return listAPIKeys(&models.APIKey{Kind: types.API_KEY_PERSONAL, UserID: userId})
+}
+
+// ListOrganizationAPIKeys returns the API keys of the organization, newest first
+func ListOrganizationAPIKeys(orgId uint) ([]models.APIKey, error) {
+	return listAPIKeys(&models.APIKey{Kind: types.API_KEY_ORGANIZATION, OrganizationID: &orgId})
+}
+
+// RevokePersonalAPIKey disables a personal API key of the user. It stops working on its next request.
+func RevokePersonalAPIKey(userId uint, keyId uuid.UUID) error {
+	return revokeAPIKey(&models.APIKey{ID: keyId, Kind: types.API_KEY_PERSONAL, UserID: userId}, userId)
+}
+
+// RevokeOrganizationAPIKey disables an API key of the organization. It stops working on its next request.
+func RevokeOrganizationAPIKey(orgId uint, keyId uuid.UUID, revokedBy uint) error {
+	return revokeAPIKey(&models.APIKey{ID: keyId, Kind: types.API_KEY_ORGANIZATION, OrganizationID: &orgId}, revokedBy)
+}
+
+func listAPIKeys(where *models.APIKey) ([]models.APIKey, error) {
+	keys := make([]models.APIKey, 0)
+	db := db.GetDb()
+	if err := db.
+		Where(where).
+		Order("created_at desc").
+		Find(&keys).
+		Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func revokeAPIKey(where *models.APIKey, revokedBy uint) error {
+	db := db.GetDb()
+	res := db.
+		Model(&models.APIKey{}).
+		Where(where).
+		Where("revoked_at IS NULL").
+		Updates(map[string]any{"revoked_at": time.Now(), "revoked_by": revokedBy})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// apiKeyExpiry validates the scopes and expiry of a new key and returns when it expires
+func apiKeyExpiry(body types.CreateAPIKeyRequestBody) (time.Time, error) {
+	for _, scope := range body.Scopes {
+		if !models.IsKnownPermission(scope) {
+			return time.Time{}, ErrInvalidAPIKey
+		}
+	}
+	now := time.Now()
+	if body.ExpiresAt == nil {
+		return now.Add(APIKeyTTL), nil
+	}
+	if body.ExpiresAt.Before(now) || body.ExpiresAt.After(now.Add(APIKeyMaxTTL)) {
+		return time.Time{}, ErrInvalidAPIKey
+	}
+	return *body.ExpiresAt, nil
+}
+
+// newAPIKeySecret returns a random key such as ebs_pat_<hex> or ebs_org_<hex>
+func newAPIKeySecret(kind types.APIKeyKind) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tag := "pat"
+	if kind == types.API_KEY_ORGANIZATION {
+		tag = "org"
+	}
+	return fmt.Sprintf("%s%s_%s", models.APIKeyPrefix, tag, hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+
+	"gorm.io/gorm"
+)
+
+// RecordAudit appends an entry to the audit trail. Pass the transaction making the change so the
+// entry is only kept when the change is.
+func RecordAudit(tx *gorm.DB, entry *models.TrailLog) error {
+	return tx.Create(entry).Error
+}
+
+// ListAuditTrail returns a page of the audit trail entries matching filters, newest first, and their count.
+// The default page and limit are set on filters.
+func ListAuditTrail(filters *types.AuditTrailQueryFilters) ([]models.TrailLog, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	entries := make([]models.TrailLog, 0)
+	var count int64
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		q := tx.
+			Model(&models.TrailLog{}).
+			Where(&models.TrailLog{
+				OrganizationID: filters.OrganizationID,
+				Type:           filters.Type,
+				Group:          filters.Group,
+				ResourceID:     filters.ResourceID,
+				RequestID:      filters.RequestID,
+			})
+		if filters.ActorID > 0 {
+			q = q.Where("actor_id = ?", filters.ActorID)
+		}
+		if filters.From != nil {
+			q = q.Where("created_at >= ?", filters.From)
+		}
+		if filters.To != nil {
+			q = q.Where("created_at < ?", filters.To)
+		}
+		q = q.Session(&gorm.Session{})
+		if err := q.Count(&count).Error; err != nil {
+			return err
+		}
+		return q.
+			Order("created_at desc").
+			Offset((filters.Page - 1) * filters.Limit).
+			Limit(filters.Limit).
+			Find(&entries).
+			Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, count, nil
+}
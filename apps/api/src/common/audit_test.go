@@ -0,0 +1,41 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAuditTrail(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	from := time.Now().Add(-24 * time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "trail_logs" WHERE \("trail_logs"."type" = \$1 AND "trail_logs"."organization_id" = \$2\) AND actor_id = \$3 AND created_at >= \$4`).
+		WithArgs(types.AUDIT_EVENT_STATUS, 3, 7, from).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+	mock.ExpectQuery(`SELECT \* FROM "trail_logs" WHERE .* ORDER BY created_at desc LIMIT \$5 OFFSET \$6`).
+		WithArgs(types.AUDIT_EVENT_STATUS, 3, 7, from, 20, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "organization_id", "resource_id"}).
+			AddRow("0d3c4b4e-2f5a-4a57-9d0e-8a1b2c3d4e5f", types.AUDIT_EVENT_STATUS, 3, "11"))
+	mock.ExpectCommit()
+
+	filters := types.AuditTrailQueryFilters{
+		OrganizationID: 3,
+		Type:           types.AUDIT_EVENT_STATUS,
+		ActorID:        7,
+		From:           &from,
+		Page:           2,
+	}
+	entries, count, err := ListAuditTrail(&filters)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), count)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "11", entries[0].ResourceID)
+	assert.Equal(t, 20, filters.Limit)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,379 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/lib/mailer"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrBroadcastNotFound     = errors.New("broadcast not found")
+	ErrNoBroadcastRecipients = errors.New("no ticket holders match the broadcast")
+)
+
+// Broadcasts are sent in batches of BroadcastBatchSize recipients, one batch every BroadcastBatchInterval,
+// to stay within the rate limits of the email and push providers
+var (
+	BroadcastBatchSize     = 100
+	BroadcastBatchInterval = 30 * time.Second
+)
+
+// broadcastAudience returns the ticket holders of an event matching the filters of a broadcast.
+// Holders of canceled or transferred reservations only are left out.
+func broadcastAudience(tx *gorm.DB, eventId uint, tiers []string, admission types.AdmissionFilter) ([]uint, error) {
+	q := tx.
+		Model(&models.Reservation{}).
+		Joins("JOIN bookings ON bookings.id = reservations.booking_id").
+		Where("bookings.event_id = ? AND bookings.status = ?", eventId, types.BOOKING_COMPLETED).
+		Where("reservations.status NOT IN (?)", []types.ReservationStatus{types.RESERVATION_CANCELED, types.RESERVATION_TRANSFERRED})
+	if len(tiers) > 0 {
+		q = q.
+			Joins("JOIN tickets ON tickets.id = reservations.ticket_id").
+			Where("tickets.tier IN (?)", tiers)
+	}
+	admitted := "SELECT 1 FROM admissions WHERE admissions.reservation_id = reservations.id AND admissions.deleted_at IS NULL"
+	switch admission {
+	case types.ADMISSION_FILTER_ADMITTED:
+		q = q.Where(fmt.Sprintf("EXISTS (%s)", admitted))
+	case types.ADMISSION_FILTER_NOT_ADMITTED:
+		q = q.Where(fmt.Sprintf("NOT EXISTS (%s)", admitted))
+	}
+	var userIds []uint
+	if err := q.
+		Distinct("bookings.user_id").
+		Pluck("bookings.user_id", &userIds).
+		Error; err != nil {
+		return nil, err
+	}
+	return userIds, nil
+}
+
+// CreateBroadcast records a broadcast to the ticket holders of an event and queues its first batch
+func CreateBroadcast(orgId uint, eventId uint, userId uint, body types.CreateBroadcastRequestBody) (*models.Broadcast, error) {
+	broadcast := models.Broadcast{
+		OrganizationID: orgId,
+		EventID:        eventId,
+		SentBy:         userId,
+		Subject:        body.Subject,
+		Message:        body.Message,
+		Tiers:          body.Tiers,
+		Admission:      body.Admission,
+		Status:         types.BROADCAST_QUEUED,
+	}
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userIds, err := broadcastAudience(tx, eventId, body.Tiers, body.Admission)
+		if err != nil {
+			return err
+		}
+		if len(userIds) == 0 {
+			return ErrNoBroadcastRecipients
+		}
+		broadcast.Recipients = len(userIds)
+		if err := tx.Create(&broadcast).Error; err != nil {
+			return err
+		}
+		recipients := make([]models.BroadcastRecipient, 0, len(userIds))
+		for _, id := range userIds {
+			recipients = append(recipients, models.BroadcastRecipient{
+				BroadcastID: broadcast.ID,
+				UserID:      id,
+				Status:      types.BROADCAST_RECIPIENT_PENDING,
+			})
+		}
+		if err := tx.CreateInBatches(&recipients, 500).Error; err != nil {
+			return err
+		}
+		return models.EnqueueOutboxMessage(tx, "Broadcast", broadcast.ID, broker.Topic("BroadcastsToSend"), types.JSONB{
+			"id":    broadcast.ID.String(),
+			"batch": 0,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &broadcast, nil
+}
+
+// ListBroadcasts returns the broadcasts sent for an event, latest first
+func ListBroadcasts(orgId uint, eventId uint, filters *types.BroadcastQueryFilters) ([]models.Broadcast, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	broadcasts := make([]models.Broadcast, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.
+		Model(&models.Broadcast{}).
+		Where("organization_id = ? AND event_id = ?", orgId, eventId).
+		Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Order("created_at desc").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&broadcasts).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return broadcasts, count, nil
+}
+
+// GetBroadcast returns a broadcast of an event of the organization
+func GetBroadcast(orgId uint, eventId uint, broadcastId uuid.UUID) (*models.Broadcast, error) {
+	var broadcast models.Broadcast
+	db := db.GetDb()
+	if err := db.
+		Where("id = ? AND organization_id = ? AND event_id = ?", broadcastId, orgId, eventId).
+		First(&broadcast).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBroadcastNotFound
+		}
+		return nil, err
+	}
+	return &broadcast, nil
+}
+
+// ListBroadcastRecipients returns the delivery status of a broadcast to each of its recipients
+func ListBroadcastRecipients(broadcastId uuid.UUID, filters *types.BroadcastRecipientQueryFilters) ([]models.BroadcastRecipient, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 50
+	}
+	recipients := make([]models.BroadcastRecipient, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.
+		Model(&models.BroadcastRecipient{}).
+		Where("broadcast_id = ?", broadcastId)
+	if filters.Status != "" {
+		q = q.Where("status = ?", filters.Status)
+	}
+	q = q.Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Order("user_id").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&recipients).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return recipients, count, nil
+}
+
+// BroadcastsToSendConsumer sends the next batch of pending recipients of a broadcast and queues the
+// batch after it, until every recipient has been sent to. Each message claims its batch before sending
+// so a duplicate or retried message does not send a batch twice or start a second chain of batches.
+func BroadcastsToSendConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	broadcastId, err := uuid.Parse(gjson.Get(spayload, "id").String())
+	if err != nil {
+		return PermanentError("invalid broadcast id", err)
+	}
+	batchNo := int(gjson.Get(spayload, "batch").Int())
+	db := db.GetDb()
+	var broadcast models.Broadcast
+	if err := db.
+		Where("id = ?", broadcastId).
+		First(&broadcast).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PermanentError(fmt.Sprintf("broadcast %s not found", broadcastId), err)
+		}
+		return RetryableError(fmt.Sprintf("could not get broadcast %s", broadcastId), err)
+	}
+	if broadcast.Status == types.BROADCAST_SENT || broadcast.Batches != batchNo {
+		return nil
+	}
+	var event models.Event
+	if err := db.
+		Where("id = ?", broadcast.EventID).
+		First(&event).
+		Error; err != nil {
+		return RetryableError(fmt.Sprintf("could not get event %d", broadcast.EventID), err)
+	}
+	batch, claimed, err := claimBroadcastBatch(broadcastId, batchNo)
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not claim recipients of broadcast %s", broadcastId), err)
+	}
+	if !claimed {
+		log.Printf("[BroadcastsToSend] Batch %d of broadcast %s was already claimed. Skipping\n", batchNo, broadcastId)
+		return nil
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	recipients := make([]Recipient, 0, len(batch))
+	for _, r := range batch {
+		if r.User == nil {
+			recipients = append(recipients, Recipient{UserID: r.UserID})
+			continue
+		}
+		recipients = append(recipients, recipientOf(r.User))
+	}
+	errs, err := DispatchEach(&Message{
+		Category: types.NOTIFY_BOOKINGS,
+		Subject:  broadcast.Subject,
+		Text:     broadcast.Message,
+		Template: mailer.TemplateEventBroadcast,
+		TemplateData: mailer.BroadcastEmail{
+			Event:   eventView(&event),
+			Subject: broadcast.Subject,
+			Message: broadcast.Message,
+			URL:     eventTicketsURL(&event),
+		},
+		OrganizationID: broadcast.OrganizationID,
+		Data: map[string]string{
+			"type":        "event.broadcast",
+			"eventId":     fmt.Sprint(event.ID),
+			"broadcastId": broadcast.ID.String(),
+		},
+	}, recipients...)
+	if err != nil {
+		errs = make([]error, len(batch))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	for i, r := range batch {
+		if r.User == nil {
+			errs[i] = errors.New("user not found")
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var sent, failed int
+		for i, r := range batch {
+			updates := map[string]any{"status": types.BROADCAST_RECIPIENT_SENT, "sent_at": now}
+			if errs[i] != nil {
+				reason := errs[i].Error()
+				updates = map[string]any{"status": types.BROADCAST_RECIPIENT_FAILED, "error": reason}
+				failed++
+			} else {
+				sent++
+			}
+			if err := tx.
+				Model(&models.BroadcastRecipient{}).
+				Where("id = ?", r.ID).
+				Updates(updates).
+				Error; err != nil {
+				return err
+			}
+		}
+		return tx.
+			Model(&models.Broadcast{}).
+			Where("id = ?", broadcastId).
+			Updates(map[string]any{
+				"sent":   gorm.Expr("sent + ?", sent),
+				"failed": gorm.Expr("failed + ?", failed),
+			}).
+			Error
+	})
+	if err != nil {
+		// The batch has been claimed, sending it again on retry could reach recipients twice
+		log.Printf("[BroadcastsToSend] Error recording batch %d of broadcast %s: %s\n", batchNo, broadcastId, err.Error())
+		return nil
+	}
+	log.Printf("[BroadcastsToSend] Sent batch of %d recipients of broadcast %s\n", len(batch), broadcastId)
+	return nil
+}
+
+// claimBroadcastBatch marks the next batch of pending recipients of a broadcast as sending and queues
+// the batch after it. It reports false when batchNo has already been claimed.
+func claimBroadcastBatch(broadcastId uuid.UUID, batchNo int) ([]models.BroadcastRecipient, bool, error) {
+	var batch []models.BroadcastRecipient
+	var claimed bool
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var broadcast models.Broadcast
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", broadcastId).
+			First(&broadcast).
+			Error; err != nil {
+			return err
+		}
+		if broadcast.Status == types.BROADCAST_SENT || broadcast.Batches != batchNo {
+			return nil
+		}
+		if err := tx.
+			Preload("User", func(tx *gorm.DB) *gorm.DB {
+				return tx.Select("id", "email", "uid", "phone_number", "phone_verified", "locale")
+			}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("broadcast_id = ? AND status = ?", broadcastId, types.BROADCAST_RECIPIENT_PENDING).
+			Order("user_id").
+			Limit(BroadcastBatchSize).
+			Find(&batch).
+			Error; err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			ids := make([]uuid.UUID, len(batch))
+			for i, r := range batch {
+				ids[i] = r.ID
+			}
+			if err := tx.
+				Model(&models.BroadcastRecipient{}).
+				Where("id IN ?", ids).
+				Update("status", types.BROADCAST_RECIPIENT_SENDING).
+				Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		updates := map[string]any{
+			"status":  types.BROADCAST_SENDING,
+			"batches": batchNo + 1,
+		}
+		if len(batch) < BroadcastBatchSize {
+			updates["status"] = types.BROADCAST_SENT
+			updates["completed_at"] = now
+		}
+		if err := tx.
+			Model(&broadcast).
+			Updates(updates).
+			Error; err != nil {
+			return err
+		}
+		claimed = true
+		if len(batch) < BroadcastBatchSize {
+			return nil
+		}
+		return models.EnqueueOutboxMessage(tx, "Broadcast", broadcastId, broker.Topic("BroadcastsToSend"), types.JSONB{
+			"id":    broadcastId.String(),
+			"batch": batchNo + 1,
+		}, now.Add(BroadcastBatchInterval))
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return batch, claimed, nil
+}
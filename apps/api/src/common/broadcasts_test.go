@@ -0,0 +1,88 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcastAudience(t *testing.T) {
+	gormDB, mock := dbtest.NewMockDB(t)
+
+	mock.ExpectQuery(`SELECT DISTINCT bookings.user_id FROM "reservations" JOIN bookings .* JOIN tickets .* `+
+		`WHERE \(bookings.event_id = \$1 AND bookings.status = \$2\) AND reservations.status NOT IN \(\$3,\$4\) `+
+		`AND tickets.tier IN \(\$5\) AND \(NOT EXISTS \(SELECT 1 FROM admissions`).
+		WithArgs(3, types.BOOKING_COMPLETED, types.RESERVATION_CANCELED, types.RESERVATION_TRANSFERRED, "VIP").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(11).AddRow(12))
+
+	userIds, err := broadcastAudience(gormDB, 3, []string{"VIP"}, types.ADMISSION_FILTER_NOT_ADMITTED)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{11, 12}, userIds)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBroadcastsToSendConsumer(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	broadcastId := "5b0f4c1e-8d2a-4e6f-9c3b-7a1d2e3f4a5b"
+	broadcastColumns := []string{"id", "organization_id", "event_id", "subject", "message", "status", "recipients", "sent", "failed", "batches"}
+
+	t.Run("completes once no recipient is pending", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sending", 100, 98, 2, 4))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(3, "Gala"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1 .* FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sending", 100, 98, 2, 4))
+		mock.ExpectQuery(`SELECT \* FROM "broadcast_recipients" WHERE \(broadcast_id = \$1 AND status = \$2\) .* LIMIT \$3 FOR UPDATE SKIP LOCKED`).
+			WithArgs(broadcastId, types.BROADCAST_RECIPIENT_PENDING, BroadcastBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "broadcast_id", "user_id", "status"}))
+		mock.ExpectExec(`UPDATE "broadcasts" SET "batches"=\$1,"completed_at"=\$2,"status"=\$3`).
+			WithArgs(5, sqlmock.AnyArg(), types.BROADCAST_SENT, sqlmock.AnyArg(), broadcastId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.Nil(t, BroadcastsToSendConsumer(`{"id":"`+broadcastId+`","batch":4}`))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("skips batches that were already claimed", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sending", 100, 50, 0, 2))
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(3, "Gala"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1 .* FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sending", 100, 50, 0, 3))
+		mock.ExpectCommit()
+
+		assert.Nil(t, BroadcastsToSendConsumer(`{"id":"`+broadcastId+`","batch":2}`))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("ignores stale messages", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sending", 100, 50, 0, 3))
+
+		assert.Nil(t, BroadcastsToSendConsumer(`{"id":"`+broadcastId+`","batch":2}`))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("ignores sent broadcasts", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "broadcasts" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows(broadcastColumns).
+				AddRow(broadcastId, 1, 3, "Parking", "North lot closed", "sent", 100, 98, 2, 5))
+
+		assert.Nil(t, BroadcastsToSendConsumer(`{"id":"`+broadcastId+`"}`))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -1,46 +1,75 @@
 package common
 
 import (
-	"ebs/src/lib"
-	awslib "ebs/src/lib/aws"
-	"ebs/src/utils"
+	"ebs/src/lib/broker"
+	"ebs/src/lib/mailer"
+	"ebs/src/types"
 	"log"
 )
 
-func SQSConsumers() {
-	dlq := awslib.NewSQSConsumer(utils.WithSuffix("DLQ"), func(payload string) {
-		log.Println("DLQ: message received")
-	})
-	dlq.Listen()
-	pr := awslib.NewSQSConsumer(utils.WithSuffix("PendingReservations"), func(payload string) {
-		// TODO: implement PendingReservations handler
-		log.Println("PendingReservations: message received")
-	})
-	pr.Listen()
-	eb := awslib.NewSQSConsumer(utils.WithSuffix("ExpiredBookings"), func(payload string) {
-		// TODO: implement ExpiredBookings handler
-		log.Println("ExpiredBookings: message received")
-	})
-	eb.Listen()
-	pp := awslib.NewSQSConsumer(utils.WithSuffix("PaymentsProcessing"), func(payload string) {
-		// TODO: implement PaymentsProcessing handler
-		log.Println("PaymentsProcessing: message received")
-	})
-	pp.Listen()
-
-	go EmailsToSendConsumer()
-	go EventsToOpenConsumer()
-	go EventsToCloseConsumer()
-	go EventsToCompleteConsumer()
-	go PendingTransactionsConsumer()
-	go PaymentTransactionUpdatesConsumer()
+// Topics returns every topic the app publishes to or consumes from
+func Topics() []string {
+	return []string{
+		broker.Topic("Retry"),
+		broker.Topic("DLQ"),
+		broker.Topic("EventsToOpen"),
+		broker.Topic("EventsToClose"),
+		broker.Topic("EventsToComplete"),
+		broker.Topic("PendingReservations"),
+		broker.Topic("PendingTransactions"),
+		broker.Topic("PaymentsProcessing"),
+		broker.Topic("ExpiredBookings"),
+		broker.Topic("PaymentTransactionUpdates"),
+		broker.Topic("StripeEvents"),
+		broker.Topic("NotificationsToPush"),
+		broker.Topic("EventReminders"),
+		broker.Topic("BroadcastsToSend"),
+		broker.Topic("TicketLinksToSend"),
+		broker.Topic("SurveysToSend"),
+		mailer.Topic(),
+	}
 }
 
-func SNSSubscribes() {
-	eventsToOpen := awslib.NewSNSSubscriber(utils.WithSuffix("EventsToOpen"))
-	eventsToOpen.Subscribe("sqs", lib.GetQueueArn(utils.WithSuffix("EventsToOpen")))
-	eventsToClose := awslib.NewSNSSubscriber(utils.WithSuffix("EventsToClose"))
-	eventsToClose.Subscribe("sqs", lib.GetQueueArn(utils.WithSuffix("EventsToClose")))
-	eventsToComplete := awslib.NewSNSSubscriber(utils.WithSuffix("EventsToComplete"))
-	eventsToComplete.Subscribe("sqs", lib.GetQueueArn(utils.WithSuffix("EventsToComplete")))
+// RegisterConsumers subscribes the app's message handlers to their topics.
+// Failed messages are retried through the Retry topic and end up in the DLQ.
+func RegisterConsumers(b broker.Broker) error {
+	consumers := []struct {
+		group   string
+		topic   string
+		handler types.Handler
+	}{
+		{"retry", broker.Topic("Retry"), RetryConsumer},
+		{"dlq", broker.Topic("DLQ"), DeadLetterConsumer},
+		{"events", broker.Topic("EventsToOpen"), WithRetry(broker.Topic("EventsToOpen"), EventsToOpenConsumer)},
+		{"events", broker.Topic("EventsToClose"), WithRetry(broker.Topic("EventsToClose"), EventsToCloseConsumer)},
+		{"events", broker.Topic("EventsToComplete"), WithRetry(broker.Topic("EventsToComplete"), EventsToCompleteConsumer)},
+		{"reservations", broker.Topic("PendingReservations"), func(payload string) {
+			// TODO: implement PendingReservations handler
+			log.Println("PendingReservations: message received")
+		}},
+		{"bookings", broker.Topic("ExpiredBookings"), func(payload string) {
+			// TODO: implement ExpiredBookings handler
+			log.Println("ExpiredBookings: message received")
+		}},
+		{"payments", broker.Topic("PaymentsProcessing"), func(payload string) {
+			// TODO: implement PaymentsProcessing handler
+			log.Println("PaymentsProcessing: message received")
+		}},
+		{"transactions", broker.Topic("PendingTransactions"), WithRetry(broker.Topic("PendingTransactions"), PendingTransactionsConsumer)},
+		{"payments", broker.Topic("PaymentTransactionUpdates"), WithRetry(broker.Topic("PaymentTransactionUpdates"), PaymentTransactionUpdatesConsumer)},
+		{"stripe", broker.Topic("StripeEvents"), WithRetry(broker.Topic("StripeEvents"), StripeEventsConsumer)},
+		{"notifications", broker.Topic("NotificationsToPush"), WithRetry(broker.Topic("NotificationsToPush"), NotificationsToPushConsumer)},
+		{"notifications", broker.Topic("EventReminders"), WithRetry(broker.Topic("EventReminders"), EventRemindersConsumer)},
+		{"notifications", broker.Topic("BroadcastsToSend"), WithRetry(broker.Topic("BroadcastsToSend"), BroadcastsToSendConsumer)},
+		{"notifications", broker.Topic("TicketLinksToSend"), WithRetry(broker.Topic("TicketLinksToSend"), TicketLinksToSendConsumer)},
+		{"notifications", broker.Topic("SurveysToSend"), WithRetry(broker.Topic("SurveysToSend"), SurveysToSendConsumer)},
+		{"emails", mailer.Topic(), WithRetry(mailer.Topic(), EmailsToSendConsumer)},
+	}
+	for _, c := range consumers {
+		if err := b.Subscribe(c.group, c.topic, c.handler); err != nil {
+			log.Printf("[%s] Error subscribing to topic %s: %s\n", b.Name(), c.topic, err.Error())
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,433 @@
+package common
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/lib/mailer"
+	"ebs/src/lib/sms"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"firebase.google.com/go/v4/messaging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// fcmMulticastLimit is the most device tokens FCM accepts in a multicast message
+const fcmMulticastLimit = 500
+
+var (
+	ErrInvalidUnsubscribeToken  = errors.New("invalid unsubscribe link")
+	ErrInvalidNotificationPrefs = errors.New("invalid notification preferences")
+)
+
+// Message is sent to users through the channels their preferences allow for its category
+type Message struct {
+	Category types.NotificationCategory
+	// Subject is the email subject and push notification title
+	Subject string
+	// Text is the push notification and text message body, the message is only sent by email without it
+	Text string
+	// SMS replaces Text in text messages
+	SMS string
+	// HTML is the email body of messages without a template
+	HTML     string
+	FromName string
+	// Template is the email template rendered in the locale of each recipient with TemplateData
+	Template     string
+	TemplateData any
+	// OrganizationID is who the message is sent on behalf of, its email branding is applied to templates
+	OrganizationID uint
+	// Data is extra data of the push notification
+	Data map[string]string
+	// Channels restricts the channels the message is sent through, all of them when empty
+	Channels []types.NotificationChannel
+}
+
+// allows reports whether the message is sent through the channel when the recipient allows it
+func (m *Message) allows(channel types.NotificationChannel) bool {
+	return len(m.Channels) == 0 || slices.Contains(m.Channels, channel)
+}
+
+func (m *Message) smsText() string {
+	if m.SMS != "" {
+		return m.SMS
+	}
+	return m.Text
+}
+
+// Recipient is who a message is dispatched to. Addresses without an account have no UserID and only get email.
+type Recipient struct {
+	UserID uint
+	Email  string
+	UID    string
+	Phone  string
+	Locale string
+}
+
+// DispatchToUsers sends the message to the users through the channels they allow
+func DispatchToUsers(msg *Message, userIds ...uint) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	var users []models.User
+	db := db.GetDb()
+	if err := db.
+		Select("id", "email", "uid", "phone_number", "phone_verified", "locale").
+		Where("id IN ?", userIds).
+		Find(&users).
+		Error; err != nil {
+		return err
+	}
+	recipients := make([]Recipient, len(users))
+	for i, user := range users {
+		recipients[i] = recipientOf(&user)
+	}
+	return Dispatch(msg, recipients...)
+}
+
+// DispatchToAddress sends the message to an email address, applying the preferences of its account if it has one
+func DispatchToAddress(msg *Message, email string) error {
+	var user models.User
+	db := db.GetDb()
+	err := db.
+		Select("id", "email", "uid", "phone_number", "phone_verified", "locale").
+		Where("email = ?", email).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Dispatch(msg, Recipient{Email: email})
+	}
+	if err != nil {
+		return err
+	}
+	return Dispatch(msg, recipientOf(&user))
+}
+
+// Dispatch sends the message to each recipient through the channels of its category they allow.
+// Email goes through the mailer queue one message per recipient, so each carries its own unsubscribe link.
+func Dispatch(msg *Message, recipients ...Recipient) error {
+	errs, err := DispatchEach(msg, recipients...)
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// DispatchEach is Dispatch reporting the outcome of each recipient, in the order they are given.
+// The error is set when the message could not be sent to anyone.
+func DispatchEach(msg *Message, recipients ...Recipient) ([]error, error) {
+	userIds := make([]uint, 0, len(recipients))
+	for _, r := range recipients {
+		if r.UserID != 0 {
+			userIds = append(userIds, r.UserID)
+		}
+	}
+	prefs, err := notificationPreferences(userIds, msg.Category)
+	if err != nil {
+		return nil, err
+	}
+	brand, sender, err := EmailBrandingOf(msg.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.FromName == "" {
+		msg.FromName = sender
+	}
+	errs := make([]error, len(recipients))
+	var tokens []string
+	var owners []int
+	for i, r := range recipients {
+		pref := models.DefaultNotificationPreference(r.UserID, msg.Category)
+		if p, ok := prefs[r.UserID]; ok {
+			pref = p
+		}
+		if r.Email != "" && msg.allows(types.CHANNEL_EMAIL) && pref.Allows(types.CHANNEL_EMAIL) {
+			input, err := dispatchEmail(msg, &r, brand)
+			if err == nil {
+				err = mailer.NewMailerMessage(input)
+			}
+			if err != nil {
+				errs[i] = errors.Join(errs[i], err)
+			}
+		}
+		if r.UID != "" && msg.allows(types.CHANNEL_PUSH) && pref.Allows(types.CHANNEL_PUSH) && msg.Text != "" {
+			if token := fcmToken(r.UID); token != "" {
+				tokens = append(tokens, token)
+				owners = append(owners, i)
+			}
+		}
+		if r.Phone != "" && msg.allows(types.CHANNEL_SMS) && pref.Allows(types.CHANNEL_SMS) && msg.smsText() != "" {
+			if err := sms.Send(context.Background(), r.Phone, msg.smsText()); err != nil {
+				errs[i] = errors.Join(errs[i], err)
+			}
+		}
+	}
+	if len(tokens) > 0 {
+		for j, err := range dispatchPush(msg, tokens) {
+			if err != nil {
+				errs[owners[j]] = errors.Join(errs[owners[j]], err)
+			}
+		}
+	}
+	return errs, nil
+}
+
+func recipientOf(user *models.User) Recipient {
+	r := Recipient{UserID: user.ID, Email: user.Email, UID: user.UID, Locale: user.Locale}
+	if user.PhoneVerified {
+		r.Phone = user.PhoneNumber
+	}
+	return r
+}
+
+// dispatchEmail builds the email of a recipient, rendering the message template in their locale.
+// Messages of categories users can opt out of carry a one-click unsubscribe link.
+func dispatchEmail(msg *Message, r *Recipient, brand mailer.Branding) (*lib.SendMailInput, error) {
+	input := &lib.SendMailInput{
+		From:     config.SMTP_FROM,
+		FromName: msg.FromName,
+		To:       []string{r.Email},
+		Subject:  msg.Subject,
+		Body:     msg.HTML,
+		Html:     true,
+
+		Template:       msg.Template,
+		OrganizationID: msg.OrganizationID,
+	}
+	if input.FromName == "" {
+		input.FromName = "noreply"
+	}
+	var link string
+	if r.UserID != 0 && msg.Category != types.NOTIFY_ACCOUNT {
+		link = UnsubscribeURL(r.UserID, msg.Category)
+		// RFC 8058 one-click unsubscribe
+		input.Headers = map[string]string{
+			"List-Unsubscribe":      fmt.Sprintf("<%s>", link),
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	if msg.Template == "" {
+		if link != "" {
+			input.Body += fmt.Sprintf(`<p style="font-size:12px;color:#888">Don't want these emails? <a href="%s">Unsubscribe</a></p>`, link)
+		}
+		return input, nil
+	}
+	email, err := mailer.Render(msg.Template, mailer.RenderInput{
+		Locale:         r.Locale,
+		Brand:          brand,
+		Data:           msg.TemplateData,
+		UnsubscribeURL: link,
+	})
+	if err != nil {
+		return nil, err
+	}
+	input.Subject = email.Subject
+	input.Body = email.HTML
+	input.Text = email.Text
+	return input, nil
+}
+
+// dispatchPush sends the message to the devices and returns the outcome of each token
+func dispatchPush(msg *Message, tokens []string) []error {
+	errs := make([]error, len(tokens))
+	fcm, err := lib.GetFirebaseMessaging()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	data := map[string]string{
+		"title": msg.Subject,
+		"body":  msg.Text,
+	}
+	for k, v := range msg.Data {
+		data[k] = v
+	}
+	for start := 0; start < len(tokens); start += fcmMulticastLimit {
+		batch := tokens[start:min(start+fcmMulticastLimit, len(tokens))]
+		res, err := fcm.SendEachForMulticast(context.Background(), &messaging.MulticastMessage{
+			Tokens: batch,
+			Data:   data,
+		})
+		if err != nil {
+			for i := range batch {
+				errs[start+i] = err
+			}
+			continue
+		}
+		for i, r := range res.Responses {
+			errs[start+i] = r.Error
+		}
+		log.Printf("[FCM] notification sent to %d devices, %d failed\n", res.SuccessCount, res.FailureCount)
+	}
+	return errs
+}
+
+// fcmToken returns the cached device token of a user
+func fcmToken(uid string) string {
+	rd := lib.GetRedisClient()
+	if rd == nil {
+		return ""
+	}
+	val := rd.JSONGet(context.Background(), fmt.Sprintf("%s:fcm", uid), "$.token").Val()
+	// JSONPath results are arrays
+	var tokens []string
+	if err := json.Unmarshal([]byte(val), &tokens); err == nil {
+		if len(tokens) == 0 {
+			return ""
+		}
+		return tokens[0]
+	}
+	return val
+}
+
+func notificationPreferences(userIds []uint, category types.NotificationCategory) (map[uint]models.NotificationPreference, error) {
+	prefs := make(map[uint]models.NotificationPreference)
+	if len(userIds) == 0 {
+		return prefs, nil
+	}
+	var rows []models.NotificationPreference
+	db := db.GetDb()
+	if err := db.
+		Where("user_id IN ? AND category = ?", userIds, category).
+		Find(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		prefs[row.UserID] = row
+	}
+	return prefs, nil
+}
+
+// ListNotificationPreferences returns the preferences of a user for every category, defaults included
+func ListNotificationPreferences(userId uint) ([]models.NotificationPreference, error) {
+	var rows []models.NotificationPreference
+	db := db.GetDb()
+	if err := db.
+		Where("user_id = ?", userId).
+		Find(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+	prefs := make([]models.NotificationPreference, len(types.NotificationCategories))
+	for i, category := range types.NotificationCategories {
+		prefs[i] = models.DefaultNotificationPreference(userId, category)
+		for _, row := range rows {
+			if row.Category == category {
+				prefs[i] = row
+			}
+		}
+	}
+	return prefs, nil
+}
+
+// UpdateNotificationPreferences saves the channels of the given categories for a user
+func UpdateNotificationPreferences(userId uint, body []types.NotificationPreferenceBody) ([]models.NotificationPreference, error) {
+	rows := make([]models.NotificationPreference, len(body))
+	for i, p := range body {
+		if !slices.Contains(types.NotificationCategories, p.Category) {
+			return nil, fmt.Errorf("%w: unknown category %s", ErrInvalidNotificationPrefs, p.Category)
+		}
+		if p.Category == types.NOTIFY_ACCOUNT && !p.Email {
+			return nil, fmt.Errorf("%w: account messages are always sent by email", ErrInvalidNotificationPrefs)
+		}
+		rows[i] = models.NotificationPreference{
+			UserID:   userId,
+			Category: p.Category,
+			Email:    p.Email,
+			Push:     p.Push,
+			SMS:      p.SMS,
+		}
+	}
+	db := db.GetDb()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return upsertNotificationPreferences(tx, rows...)
+	}); err != nil {
+		return nil, err
+	}
+	return ListNotificationPreferences(userId)
+}
+
+func upsertNotificationPreferences(tx *gorm.DB, rows ...models.NotificationPreference) error {
+	return tx.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "category"}},
+			DoUpdates: clause.AssignmentColumns([]string{"email", "push", "sms", "updated_at"}),
+		}).
+		Create(&rows).
+		Error
+}
+
+// UnsubscribeToken signs the user and category of a one-click unsubscribe link
+func UnsubscribeToken(userId uint, category types.NotificationCategory) string {
+	subject := fmt.Sprintf("%d:%s", userId, category)
+	return base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." + unsubscribeSignature(subject)
+}
+
+// UnsubscribeURL is the one-click link that stops emails of the category to the user
+func UnsubscribeURL(userId uint, category types.NotificationCategory) string {
+	return fmt.Sprintf("%s/api/v1/notifications/unsubscribe?token=%s", config.API_HOST, url.QueryEscape(UnsubscribeToken(userId, category)))
+}
+
+// Unsubscribe turns off emails of the category of an unsubscribe link for its user, keeping the other channels
+func Unsubscribe(token string) (types.NotificationCategory, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	subject := string(b)
+	if !hmac.Equal([]byte(signature), []byte(unsubscribeSignature(subject))) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	id, c, _ := strings.Cut(subject, ":")
+	userId, err := strconv.ParseUint(id, 10, 0)
+	category := types.NotificationCategory(c)
+	if err != nil || !slices.Contains(types.NotificationCategories, category) || category == types.NOTIFY_ACCOUNT {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		prefs, err := notificationPreferences([]uint{uint(userId)}, category)
+		if err != nil {
+			return err
+		}
+		pref, ok := prefs[uint(userId)]
+		if !ok {
+			pref = models.DefaultNotificationPreference(uint(userId), category)
+		}
+		pref.ID = 0
+		pref.Email = false
+		return upsertNotificationPreferences(tx, pref)
+	})
+	if err != nil {
+		return "", err
+	}
+	return category, nil
+}
+
+func unsubscribeSignature(subject string) string {
+	mac := hmac.New(sha256.New, []byte(config.API_SECRET))
+	mac.Write([]byte("unsubscribe:" + subject))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"ebs/src/config"
+	"ebs/src/db/dbtest"
+	"ebs/src/lib/mailer"
+	"ebs/src/types"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	config.API_SECRET = strings.Repeat("ab", 32)
+	mock := dbtest.UseMockDB(t)
+
+	t.Run("turns off emails of the category", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "notification_preferences" WHERE \(user_id IN \(\$1\) AND category = \$2\)`).
+			WithArgs(42, "events").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "category", "email", "push", "sms"}).
+				AddRow(3, 42, "events", true, false, true))
+		mock.ExpectQuery(`INSERT INTO "notification_preferences" .* ON CONFLICT \("user_id","category"\) DO UPDATE`).
+			WithArgs(uint(42), "events", false, false, true, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectCommit()
+
+		category, err := Unsubscribe(UnsubscribeToken(42, types.NOTIFY_EVENTS))
+		assert.Nil(t, err)
+		assert.Equal(t, types.NOTIFY_EVENTS, category)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejects tampered and account links", func(t *testing.T) {
+		token := UnsubscribeToken(42, types.NOTIFY_EVENTS)
+		other := UnsubscribeToken(43, types.NOTIFY_EVENTS)
+		encoded, _, _ := strings.Cut(other, ".")
+		_, signature, _ := strings.Cut(token, ".")
+		_, err := Unsubscribe(encoded + "." + signature)
+		assert.ErrorIs(t, err, ErrInvalidUnsubscribeToken)
+
+		_, err = Unsubscribe(UnsubscribeToken(42, types.NOTIFY_ACCOUNT))
+		assert.ErrorIs(t, err, ErrInvalidUnsubscribeToken)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDispatchEmail(t *testing.T) {
+	config.API_SECRET = strings.Repeat("ab", 32)
+	msg := &Message{Category: types.NOTIFY_EVENTS, Subject: "Registration open", HTML: "<p>Open</p>"}
+
+	input, err := dispatchEmail(msg, &Recipient{UserID: 42, Email: "guest@example.com"}, mailer.DefaultBranding)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"guest@example.com"}, input.To)
+	assert.Contains(t, input.Body, UnsubscribeURL(42, types.NOTIFY_EVENTS))
+	assert.Equal(t, "List-Unsubscribe=One-Click", input.Headers["List-Unsubscribe-Post"])
+
+	// Addresses without an account and account messages can't unsubscribe
+	input, err = dispatchEmail(msg, &Recipient{Email: "invitee@example.com"}, mailer.DefaultBranding)
+	assert.Nil(t, err)
+	assert.Equal(t, "<p>Open</p>", input.Body)
+	assert.Empty(t, input.Headers)
+	msg.Category = types.NOTIFY_ACCOUNT
+	input, err = dispatchEmail(msg, &Recipient{UserID: 42, Email: "guest@example.com"}, mailer.DefaultBranding)
+	assert.Nil(t, err)
+	assert.Empty(t, input.Headers)
+}
+
+func TestDispatchTemplatedEmail(t *testing.T) {
+	config.API_SECRET = strings.Repeat("ab", 32)
+	msg := &Message{
+		Category:     types.NOTIFY_EVENTS,
+		Template:     mailer.TemplateEventOpened,
+		TemplateData: mailer.EventEmail{Event: mailer.EventView{Title: "Fiesta", Timezone: "UTC"}},
+	}
+	brand := mailer.Branding{Name: "Manila Events Co.", PrimaryColor: "#c2185b"}
+
+	input, err := dispatchEmail(msg, &Recipient{UserID: 42, Email: "guest@example.com", Locale: "es-MX"}, brand)
+	assert.Nil(t, err)
+	english, err := dispatchEmail(msg, &Recipient{UserID: 42, Email: "guest@example.com"}, brand)
+	assert.Nil(t, err)
+	assert.NotEqual(t, english.Subject, input.Subject)
+	assert.Contains(t, input.Body, "Manila Events Co.")
+	assert.Contains(t, input.Body, "#c2185b")
+	assert.NotEmpty(t, input.Text)
+	assert.Contains(t, input.Text, "Fiesta")
+	assert.NotEmpty(t, input.Headers["List-Unsubscribe"])
+
+	msg.Template = "missing"
+	_, err = dispatchEmail(msg, &Recipient{UserID: 42, Email: "guest@example.com"}, brand)
+	assert.ErrorIs(t, err, mailer.ErrUnknownTemplate)
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/lib/mailer"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnsupportedLocale = errors.New("unsupported locale")
+
+// EmailBrandingOf returns the branding and sender name of the emails sent on behalf of an organization.
+// Organization 0 is the platform itself.
+func EmailBrandingOf(orgId uint) (mailer.Branding, string, error) {
+	brand := mailer.DefaultBranding
+	if orgId == 0 {
+		return brand, "", nil
+	}
+	var org models.Organization
+	var branding models.EmailBranding
+	db := db.GetDb()
+	if err := db.
+		Select("id", "name").
+		Where("id = ?", orgId).
+		First(&org).
+		Error; err != nil {
+		return brand, "", err
+	}
+	if err := db.
+		Where("organization_id = ?", orgId).
+		Limit(1).
+		Find(&branding).
+		Error; err != nil {
+		return brand, "", err
+	}
+	brand.Name = org.Name
+	if branding.LogoURL != "" {
+		brand.LogoURL = branding.LogoURL
+	}
+	if branding.PrimaryColor != "" {
+		brand.PrimaryColor = branding.PrimaryColor
+	}
+	brand.FooterText = branding.FooterText
+	sender := branding.SenderName
+	if sender == "" {
+		sender = org.Name
+	}
+	return brand, sender, nil
+}
+
+// GetEmailBranding returns the branding overrides of an organization, empty when it has none
+func GetEmailBranding(orgId uint) (*models.EmailBranding, error) {
+	branding := models.EmailBranding{OrganizationID: orgId}
+	db := db.GetDb()
+	if err := db.
+		Where("organization_id = ?", orgId).
+		Limit(1).
+		Find(&branding).
+		Error; err != nil {
+		return nil, err
+	}
+	return &branding, nil
+}
+
+// UpdateEmailBranding replaces the branding overrides of an organization and returns them before and after
+func UpdateEmailBranding(orgId uint, body types.UpdateEmailBrandingRequestBody) (*models.EmailBranding, *models.EmailBranding, error) {
+	before, err := GetEmailBranding(orgId)
+	if err != nil {
+		return nil, nil, err
+	}
+	after := &models.EmailBranding{
+		OrganizationID: orgId,
+		SenderName:     body.SenderName,
+		LogoURL:        body.LogoURL,
+		PrimaryColor:   body.PrimaryColor,
+		FooterText:     body.FooterText,
+	}
+	db := db.GetDb()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.
+			Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "organization_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"sender_name", "logo_url", "primary_color", "footer_text", "updated_at"}),
+			}).
+			Create(after).
+			Error
+	}); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// PreviewEmail renders a template with sample data and the branding of the organization
+func PreviewEmail(orgId uint, name string, locale string) (*mailer.Email, error) {
+	brand, _, err := EmailBrandingOf(orgId)
+	if err != nil {
+		return nil, err
+	}
+	return mailer.Preview(name, locale, brand)
+}
+
+// SetUserLocale sets the language of the emails sent to a user
+func SetUserLocale(userId uint, locale string) (string, error) {
+	if !mailer.SupportsLocale(locale) {
+		return "", ErrUnsupportedLocale
+	}
+	resolved := mailer.ResolveLocale(locale)
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Update("locale", resolved).
+			Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
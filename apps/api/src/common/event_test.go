@@ -0,0 +1,40 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateEventStatus(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	t.Run("resumes events already in the new status", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "events" WHERE .* FOR UPDATE OF "events"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "events" WHERE \("events"."id" = \$1 AND "events"."status" = \$2\)`).
+			WithArgs(3, types.EVENT_COMPLETED).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		assert.Nil(t, updateEventStatus(3, types.EVENT_COMPLETED, types.EVENT_ADMISSION))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up on events in another status", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "events"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "events"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		err := updateEventStatus(3, types.EVENT_COMPLETED, types.EVENT_ADMISSION)
+		var herr *HandlerError
+		assert.ErrorAs(t, err, &herr)
+		assert.False(t, herr.Retryable)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"ebs/src/db"
+
+	"gorm.io/gorm"
+)
+
+// withSessionLock runs fn while holding the session level advisory lock key, so only one instance runs it
+// at a time. No transaction is held open while fn runs. It reports false without running fn when
+// another session holds the lock.
+func withSessionLock(key int64, fn func() error) (bool, error) {
+	var ran bool
+	db := db.GetDb()
+	err := db.Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+		ran = true
+		return fn()
+	})
+	return ran, err
+}
@@ -0,0 +1,313 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/types"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidMailEventSignature = errors.New("invalid mail event signature")
+	ErrUnknownMailEventTopic     = errors.New("unknown mail event topic")
+)
+
+// SendGridEventsTolerance is how far the timestamp of a SendGrid event webhook may be from now
+const SendGridEventsTolerance = 5 * time.Minute
+
+// snsHost matches the hosts SNS serves signing certificates and subscription links from
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+var snsClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	snsCertsMu sync.Mutex
+	snsCerts   = map[string]*x509.Certificate{}
+)
+
+// snsMessage is an HTTP notification of SNS
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// stringToSign builds the canonical message SNS signs, which depends on the message type
+func (m *snsMessage) stringToSign() string {
+	fields := [][2]string{{"Message", m.Message}, {"MessageId", m.MessageId}}
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", m.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", m.Timestamp})
+	if m.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", m.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", m.TopicArn}, [2]string{"Type", m.Type})
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+func isSNSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && snsHost.MatchString(u.Host)
+}
+
+// snsCertificate returns the signing certificate of SNS at the URL, which is fetched once
+var snsCertificate = func(certURL string) (*x509.Certificate, error) {
+	snsCertsMu.Lock()
+	defer snsCertsMu.Unlock()
+	if cert, ok := snsCerts[certURL]; ok {
+		return cert, nil
+	}
+	res, err := snsClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate at %s", certURL)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	snsCerts[certURL] = cert
+	return cert, nil
+}
+
+// verifySNSMessage checks the signature of an SNS message against the certificate it links to
+func verifySNSMessage(m *snsMessage) error {
+	if !isSNSURL(m.SigningCertURL) {
+		return ErrInvalidMailEventSignature
+	}
+	var h hash.Hash
+	var alg crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		h, alg = sha1.New(), crypto.SHA1
+	case "2":
+		h, alg = sha256.New(), crypto.SHA256
+	default:
+		return ErrInvalidMailEventSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrInvalidMailEventSignature
+	}
+	cert, err := snsCertificate(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidMailEventSignature
+	}
+	h.Write([]byte(m.stringToSign()))
+	if err := rsa.VerifyPKCS1v15(key, alg, h.Sum(nil), signature); err != nil {
+		return ErrInvalidMailEventSignature
+	}
+	return nil
+}
+
+// HandleSESNotification applies the bounce, complaint and delivery notifications SES publishes through SNS.
+// Subscriptions to the topic are confirmed as SNS sends them. Messages of any topic other than
+// SES_EVENTS_TOPIC_ARN are rejected, as are all messages when it is not set.
+func HandleSESNotification(payload []byte) error {
+	var m snsMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return err
+	}
+	if err := verifySNSMessage(&m); err != nil {
+		return err
+	}
+	if config.SES_EVENTS_TOPIC_ARN == "" || m.TopicArn != config.SES_EVENTS_TOPIC_ARN {
+		return ErrUnknownMailEventTopic
+	}
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		if !isSNSURL(m.SubscribeURL) {
+			return ErrInvalidMailEventSignature
+		}
+		res, err := snsClient.Get(m.SubscribeURL)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		log.Printf("[MailEvents] Confirmed subscription to %s\n", m.TopicArn)
+		return nil
+	case "Notification":
+		db := db.GetDb()
+		return db.Transaction(func(tx *gorm.DB) error {
+			return applySESEvent(tx, m.Message)
+		})
+	}
+	return nil
+}
+
+// applySESEvent applies an SES notification, either of a notification topic or of a configuration set
+func applySESEvent(tx *gorm.DB, message string) error {
+	kind := gjson.Get(message, "notificationType").String()
+	if kind == "" {
+		kind = gjson.Get(message, "eventType").String()
+	}
+	messageId := gjson.Get(message, "mail.commonHeaders.messageId").String()
+	switch kind {
+	case "Bounce":
+		permanent := gjson.Get(message, "bounce.bounceType").String() == "Permanent"
+		for _, r := range gjson.Get(message, "bounce.bouncedRecipients").Array() {
+			email := r.Get("emailAddress").String()
+			detail := r.Get("diagnosticCode").String()
+			if err := recordMailEvent(tx, messageId, email, types.MAIL_BOUNCED, detail); err != nil {
+				return err
+			}
+			if !permanent {
+				continue
+			}
+			if _, err := SuppressEmail(tx, email, types.SUPPRESSION_BOUNCE, "ses", detail); err != nil {
+				return err
+			}
+		}
+	case "Complaint":
+		detail := gjson.Get(message, "complaint.complaintFeedbackType").String()
+		for _, r := range gjson.Get(message, "complaint.complainedRecipients").Array() {
+			email := r.Get("emailAddress").String()
+			if err := recordMailEvent(tx, messageId, email, types.MAIL_COMPLAINED, detail); err != nil {
+				return err
+			}
+			if _, err := SuppressEmail(tx, email, types.SUPPRESSION_COMPLAINT, "ses", detail); err != nil {
+				return err
+			}
+		}
+	case "Delivery":
+		for _, r := range gjson.Get(message, "delivery.recipients").Array() {
+			if err := recordMailEvent(tx, messageId, r.String(), types.MAIL_DELIVERED, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifySendGridEvents checks the signature of a signed SendGrid event webhook, which is an ECDSA
+// signature of the timestamp followed by the payload, sent within SendGridEventsTolerance
+func verifySendGridEvents(payload []byte, signature string, timestamp string) error {
+	der, err := base64.StdEncoding.DecodeString(config.SENDGRID_WEBHOOK_PUBLIC_KEY)
+	if err != nil || len(der) == 0 {
+		return ErrInvalidMailEventSignature
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return ErrInvalidMailEventSignature
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrInvalidMailEventSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidMailEventSignature
+	}
+	digest := sha256.Sum256(append([]byte(timestamp), payload...))
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return ErrInvalidMailEventSignature
+	}
+	// The timestamp is signed, reject stale ones so captured requests can not be replayed
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidMailEventSignature
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > SendGridEventsTolerance || age < -SendGridEventsTolerance {
+		return ErrInvalidMailEventSignature
+	}
+	return nil
+}
+
+// HandleSendGridEvents applies the bounce, spam report and delivery events of the SendGrid event webhook
+func HandleSendGridEvents(payload []byte, signature string, timestamp string) error {
+	if err := verifySendGridEvents(payload, signature, timestamp); err != nil {
+		return err
+	}
+	if !gjson.ValidBytes(payload) {
+		return errors.New("invalid json body")
+	}
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, e := range gjson.ParseBytes(payload).Array() {
+			email := e.Get("email").String()
+			messageId := e.Get("smtp-id").String()
+			detail := e.Get("reason").String()
+			switch e.Get("event").String() {
+			case "bounce":
+				if err := recordMailEvent(tx, messageId, email, types.MAIL_BOUNCED, detail); err != nil {
+					return err
+				}
+				// blocked messages are temporary rejections
+				if e.Get("type").String() == "blocked" {
+					continue
+				}
+				if _, err := SuppressEmail(tx, email, types.SUPPRESSION_BOUNCE, "sendgrid", detail); err != nil {
+					return err
+				}
+			case "dropped":
+				if err := recordMailEvent(tx, messageId, email, types.MAIL_FAILED, detail); err != nil {
+					return err
+				}
+			case "spamreport":
+				if err := recordMailEvent(tx, messageId, email, types.MAIL_COMPLAINED, ""); err != nil {
+					return err
+				}
+				if _, err := SuppressEmail(tx, email, types.SUPPRESSION_COMPLAINT, "sendgrid", ""); err != nil {
+					return err
+				}
+			case "delivered":
+				if err := recordMailEvent(tx, messageId, email, types.MAIL_DELIVERED, ""); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
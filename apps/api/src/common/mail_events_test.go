@@ -0,0 +1,180 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"ebs/src/config"
+	"ebs/src/db/dbtest"
+	"ebs/src/lib"
+	"ebs/src/types"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSendLoggedMailSkipsSuppressed(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	mock.ExpectQuery(`SELECT "email" FROM "email_suppressions" WHERE email IN \(\$1,\$2,\$3\)`).
+		WithArgs("ana@example.com", "ben@example.com", "cy@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("ana@example.com").AddRow("ben@example.com").AddRow("cy@example.com"))
+	for _, recipient := range []string{"ana@example.com", "ben@example.com", "cy@example.com"} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_logs" .* ON CONFLICT \("message_id","recipient"\) DO UPDATE`).
+			WithArgs("m-1@example.com", recipient, uint(3), "ticket_confirmation", "Your tickets", "smtp", types.MAIL_SUPPRESSED,
+				nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"))
+		mock.ExpectCommit()
+	}
+
+	// Nothing is sent, so no SMTP server is needed
+	err := sendLoggedMail(&lib.SendMailInput{
+		From:           "noreply@example.com",
+		To:             []string{"Ana@Example.com"},
+		Cc:             []string{"ben@example.com"},
+		Bcc:            []string{"cy@example.com"},
+		Subject:        "Your tickets",
+		MessageID:      "m-1@example.com",
+		Template:       "ticket_confirmation",
+		OrganizationID: 3,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestApplySESEvent(t *testing.T) {
+	gormDB, mock := dbtest.NewMockDB(t)
+
+	t.Run("suppresses hard bounces", func(t *testing.T) {
+		message := `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bouncedRecipients":[` +
+			`{"emailAddress":"ana@example.com","diagnosticCode":"550 5.1.1 user unknown"}]},` +
+			`"mail":{"messageId":"ses-1","commonHeaders":{"messageId":"<m-1@example.com>"}}}`
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_logs" SET "error"=\$1,"status"=\$2,"updated_at"=\$3 WHERE message_id = \$4 AND recipient = \$5`).
+			WithArgs("550 5.1.1 user unknown", types.MAIL_BOUNCED, sqlmock.AnyArg(), "m-1@example.com", "ana@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "email_suppressions" .* ON CONFLICT \("email"\) DO UPDATE`).
+			WithArgs("ana@example.com", types.SUPPRESSION_BOUNCE, "ses", "550 5.1.1 user unknown", sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		assert.Nil(t, gormDB.Transaction(func(tx *gorm.DB) error {
+			return applySESEvent(tx, message)
+		}))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("only logs soft bounces", func(t *testing.T) {
+		message := `{"eventType":"Bounce","bounce":{"bounceType":"Transient","bouncedRecipients":[` +
+			`{"emailAddress":"ana@example.com"}]},"mail":{"commonHeaders":{"messageId":"<m-1@example.com>"}}}`
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_logs" SET "status"=\$1`).
+			WithArgs(types.MAIL_BOUNCED, sqlmock.AnyArg(), "m-1@example.com", "ana@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.Nil(t, gormDB.Transaction(func(tx *gorm.DB) error {
+			return applySESEvent(tx, message)
+		}))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("falls back to the latest email of rewritten message ids", func(t *testing.T) {
+		message := `{"notificationType":"Delivery","delivery":{"recipients":["ana@example.com"]},` +
+			`"mail":{"commonHeaders":{"messageId":"<ses-rewritten@email.amazonses.com>"}}}`
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_logs" SET "status"=\$1,"updated_at"=\$2 WHERE message_id = \$3`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE "mail_logs" SET "status"=\$1,"updated_at"=\$2 WHERE id = \(SELECT "id" FROM "mail_logs" WHERE recipient = \$3 AND "mail_logs"."deleted_at" IS NULL ORDER BY created_at desc LIMIT \$4\) AND recipient = \$5 AND status NOT IN`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.Nil(t, gormDB.Transaction(func(tx *gorm.DB) error {
+			return applySESEvent(tx, message)
+		}))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestVerifySNSMessage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	fetch := snsCertificate
+	snsCertificate = func(string) (*x509.Certificate, error) { return cert, nil }
+	defer func() { snsCertificate = fetch }()
+
+	m := &snsMessage{
+		Type:             "Notification",
+		MessageId:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         "arn:aws:sns:us-east-1:123456789012:ses-events",
+		Message:          `{"notificationType":"Delivery"}`,
+		Timestamp:        "2026-10-19T06:00:00.000Z",
+		SignatureVersion: "2",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem",
+	}
+	digest := sha256.Sum256([]byte(m.stringToSign()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+	assert.Nil(t, verifySNSMessage(m))
+
+	tampered := *m
+	tampered.Message = `{"notificationType":"Complaint"}`
+	assert.ErrorIs(t, verifySNSMessage(&tampered), ErrInvalidMailEventSignature)
+
+	foreign := *m
+	foreign.SigningCertURL = "https://sns.us-east-1.amazonaws.com.evil.example/cert.pem"
+	assert.ErrorIs(t, verifySNSMessage(&foreign), ErrInvalidMailEventSignature)
+
+	payload, _ := json.Marshal(m)
+	assert.ErrorIs(t, HandleSESNotification(payload), ErrUnknownMailEventTopic)
+	config.SES_EVENTS_TOPIC_ARN = "arn:aws:sns:us-east-1:123456789012:other"
+	defer func() { config.SES_EVENTS_TOPIC_ARN = "" }()
+	assert.ErrorIs(t, HandleSESNotification(payload), ErrUnknownMailEventTopic)
+}
+
+func TestVerifySendGridEvents(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	config.SENDGRID_WEBHOOK_PUBLIC_KEY = base64.StdEncoding.EncodeToString(der)
+	defer func() { config.SENDGRID_WEBHOOK_PUBLIC_KEY = "" }()
+
+	payload, _ := json.Marshal([]map[string]any{{"email": "ana@example.com", "event": "delivered"}})
+	sign := func(timestamp string) string {
+		digest := sha256.Sum256(append([]byte(timestamp), payload...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		assert.Nil(t, err)
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := sign(timestamp)
+
+	assert.Nil(t, verifySendGridEvents(payload, signature, timestamp))
+	assert.ErrorIs(t, verifySendGridEvents(payload, signature, strconv.FormatInt(time.Now().Unix()+1, 10)), ErrInvalidMailEventSignature)
+	assert.ErrorIs(t, verifySendGridEvents([]byte(`[]`), signature, timestamp), ErrInvalidMailEventSignature)
+
+	stale := strconv.FormatInt(time.Now().Add(-SendGridEventsTolerance-time.Minute).Unix(), 10)
+	assert.ErrorIs(t, verifySendGridEvents(payload, sign(stale), stale), ErrInvalidMailEventSignature)
+}
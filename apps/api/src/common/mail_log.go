@@ -0,0 +1,273 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrEmailSuppressionNotFound = errors.New("email suppression not found")
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizeMessageID strips the angle brackets of a Message-ID header
+func normalizeMessageID(messageId string) string {
+	return strings.Trim(strings.TrimSpace(messageId), "<>")
+}
+
+// suppressedEmails returns which of the addresses are on the suppression list
+func suppressedEmails(tx *gorm.DB, emails []string) (map[string]bool, error) {
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = normalizeEmail(email)
+	}
+	var rows []string
+	if err := tx.
+		Model(&models.EmailSuppression{}).
+		Where("email IN ?", normalized).
+		Pluck("email", &rows).
+		Error; err != nil {
+		return nil, err
+	}
+	suppressed := make(map[string]bool, len(rows))
+	for _, email := range rows {
+		suppressed[email] = true
+	}
+	return suppressed, nil
+}
+
+// sendLoggedMail sends an email to the To, Cc and Bcc addresses that are not suppressed and records the
+// outcome for each of them
+func sendLoggedMail(input *lib.SendMailInput) error {
+	if input.MessageID == "" {
+		input.MessageID = lib.NewMessageID(input.From)
+	}
+	db := db.GetDb()
+	suppressed, err := suppressedEmails(db, slices.Concat(input.To, input.Cc, input.Bcc))
+	if err != nil {
+		return err
+	}
+	allowed := func(emails []string) []string {
+		kept := make([]string, 0, len(emails))
+		for _, email := range emails {
+			if suppressed[normalizeEmail(email)] {
+				log.Printf("[MAILER]: %s is suppressed, skipping\n", email)
+				logMail(input, email, types.MAIL_SUPPRESSED, nil)
+				continue
+			}
+			kept = append(kept, email)
+		}
+		return kept
+	}
+	input.To, input.Cc, input.Bcc = allowed(input.To), allowed(input.Cc), allowed(input.Bcc)
+	recipients := slices.Concat(input.To, input.Cc, input.Bcc)
+	if len(recipients) == 0 {
+		return nil
+	}
+	sendErr := lib.SendMail(input)
+	for _, email := range recipients {
+		if sendErr != nil {
+			logMail(input, email, types.MAIL_FAILED, sendErr)
+		} else {
+			logMail(input, email, types.MAIL_SENT, nil)
+		}
+	}
+	return sendErr
+}
+
+// logMail records the status of an email to a recipient. Retries of the same email update its entry.
+func logMail(input *lib.SendMailInput, recipient string, status types.MailStatus, sendErr error) {
+	entry := models.MailLog{
+		MessageID:      input.MessageID,
+		Recipient:      normalizeEmail(recipient),
+		OrganizationID: input.OrganizationID,
+		Template:       input.Template,
+		Subject:        input.Subject,
+		Provider:       lib.MailProvider(),
+		Status:         status,
+	}
+	if sendErr != nil {
+		reason := sendErr.Error()
+		entry.Error = &reason
+	}
+	if status == types.MAIL_SENT {
+		now := time.Now()
+		entry.SentAt = &now
+	}
+	db := db.GetDb()
+	if err := db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}, {Name: "recipient"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "error", "sent_at", "provider", "updated_at"}),
+		}).
+		Create(&entry).
+		Error; err != nil {
+		log.Printf("[MAILER]: Error logging email %s to %s: %s\n", input.MessageID, recipient, err.Error())
+	}
+}
+
+// recordMailEvent applies a delivery, bounce or complaint notification to the mail log. Providers that
+// rewrite the Message-ID are matched to the latest email to the recipient. A delivery does not
+// overwrite a bounce or complaint received before it.
+func recordMailEvent(tx *gorm.DB, messageId string, recipient string, status types.MailStatus, detail string) error {
+	recipient = normalizeEmail(recipient)
+	updates := map[string]any{"status": status}
+	if detail != "" {
+		updates["error"] = detail
+	}
+	update := func(q *gorm.DB) (int64, error) {
+		q = q.Model(&models.MailLog{}).Where("recipient = ?", recipient)
+		if status == types.MAIL_DELIVERED {
+			q = q.Where("status NOT IN ?", []types.MailStatus{types.MAIL_BOUNCED, types.MAIL_COMPLAINED})
+		}
+		res := q.Updates(updates)
+		return res.RowsAffected, res.Error
+	}
+	if messageId = normalizeMessageID(messageId); messageId != "" {
+		n, err := update(tx.Where("message_id = ?", messageId))
+		if err != nil || n > 0 {
+			return err
+		}
+	}
+	latest := tx.
+		Model(&models.MailLog{}).
+		Select("id").
+		Where("recipient = ?", recipient).
+		Order("created_at desc").
+		Limit(1)
+	_, err := update(tx.Where("id = (?)", latest))
+	return err
+}
+
+// SuppressEmail stops all email to an address. A complaint replaces the reason of an earlier bounce.
+func SuppressEmail(tx *gorm.DB, email string, reason types.SuppressionReason, source string, detail string) (*models.EmailSuppression, error) {
+	suppression := models.EmailSuppression{
+		Email:  normalizeEmail(email),
+		Reason: reason,
+		Source: source,
+		Detail: detail,
+	}
+	if err := tx.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "email"}},
+			DoUpdates: clause.AssignmentColumns([]string{"reason", "source", "detail", "updated_at"}),
+		}).
+		Create(&suppression).
+		Error; err != nil {
+		return nil, err
+	}
+	return &suppression, nil
+}
+
+// AddEmailSuppression suppresses an address by hand
+func AddEmailSuppression(body types.CreateEmailSuppressionRequestBody) (*models.EmailSuppression, error) {
+	var suppression *models.EmailSuppression
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		suppression, err = SuppressEmail(tx, body.Email, types.SUPPRESSION_MANUAL, "admin", body.Detail)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return suppression, nil
+}
+
+// RemoveEmailSuppression lets email be sent to a suppressed address again
+func RemoveEmailSuppression(id uint) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("id = ?", id).Delete(&models.EmailSuppression{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrEmailSuppressionNotFound
+		}
+		return nil
+	})
+}
+
+// ListEmailSuppressions returns the suppressed addresses, latest first
+func ListEmailSuppressions(filters *types.EmailSuppressionQueryFilters) ([]models.EmailSuppression, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	suppressions := make([]models.EmailSuppression, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.Model(&models.EmailSuppression{})
+	if filters.Email != "" {
+		q = q.Where("email = ?", normalizeEmail(filters.Email))
+	}
+	if filters.Reason != "" {
+		q = q.Where("reason = ?", filters.Reason)
+	}
+	q = q.Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Order("updated_at desc").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&suppressions).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return suppressions, count, nil
+}
+
+// ListMailLogs returns the emails sent on behalf of an organization, or every email when orgId is 0, latest first
+func ListMailLogs(orgId uint, filters *types.MailLogQueryFilters) ([]models.MailLog, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	logs := make([]models.MailLog, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.Model(&models.MailLog{})
+	if orgId != 0 {
+		q = q.Where("organization_id = ?", orgId)
+	}
+	if filters.Recipient != "" {
+		q = q.Where("recipient = ?", normalizeEmail(filters.Recipient))
+	}
+	if filters.Template != "" {
+		q = q.Where("template = ?", filters.Template)
+	}
+	if filters.Status != "" {
+		q = q.Where("status = ?", filters.Status)
+	}
+	q = q.Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Order("created_at desc").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&logs).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return logs, count, nil
+}
@@ -0,0 +1,376 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"ebs/src/utils"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// TOTPIssuer is the account issuer shown by authenticator apps
+	TOTPIssuer = "Silver Elven"
+	// TOTPPeriod is the time step of authenticator app codes (RFC 6238)
+	TOTPPeriod = 30
+	// TOTPDigits is the length of authenticator app codes
+	TOTPDigits = 6
+	// totpSkew is how many time steps before and after the current one are accepted to allow for clock drift
+	totpSkew = 1
+	// RecoveryCodeCount is how many recovery codes are issued at a time
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPNotEnrolled    = errors.New("authenticator app is not enrolled")
+	ErrTOTPAlreadyEnabled = errors.New("authenticator app is already enabled")
+	ErrInvalidMFACode     = errors.New("invalid verification code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollTOTP generates a new authenticator app secret for the user. It is not used as a second factor
+// until confirmed with ConfirmTOTP, enrolling again replaces an unconfirmed secret.
+func EnrollTOTP(userId uint, email string) (*types.TOTPEnrollment, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(b)
+	enc, err := encryptMFASecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var cred models.TOTPCredential
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.TOTPCredential{UserID: userId}).
+			First(&cred).
+			Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			if cred.ConfirmedAt != nil {
+				return ErrTOTPAlreadyEnabled
+			}
+			if err := tx.Unscoped().Delete(&models.TOTPCredential{}, cred.ID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&models.TOTPCredential{UserID: userId, Secret: enc}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTPIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(TOTPPeriod))
+	uri := fmt.Sprintf("otpauth://totp/%s?%s", url.PathEscape(TOTPIssuer+":"+email), params.Encode())
+	qr, err := qrDataURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &types.TOTPEnrollment{Secret: secret, URI: uri, QR: qr}, nil
+}
+
+// ConfirmTOTP enables the enrolled authenticator app once the user proves it generates valid codes
+// and returns the recovery codes of the user. They are only returned here, they are stored hashed.
+func ConfirmTOTP(userId uint, code string) ([]string, error) {
+	var codes []string
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var cred models.TOTPCredential
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.TOTPCredential{UserID: userId}).
+			First(&cred).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTOTPNotEnrolled
+			}
+			return err
+		}
+		if cred.ConfirmedAt != nil {
+			return ErrTOTPAlreadyEnabled
+		}
+		secret, err := decryptMFASecret(cred.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := validateTOTP(secret, normalizeMFACode(code), time.Now(), cred.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		if err := tx.
+			Model(&models.TOTPCredential{}).
+			Where("id = ?", cred.ID).
+			Updates(map[string]any{"confirmed_at": time.Now(), "last_used_step": step}).
+			Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the authenticator app and the recovery codes of the user after checking code
+func DisableTOTP(userId uint, code string) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := VerifyMFACode(tx, userId, code); err != nil {
+			return err
+		}
+		if err := tx.
+			Unscoped().
+			Where(&models.TOTPCredential{UserID: userId}).
+			Delete(&models.TOTPCredential{}).
+			Error; err != nil {
+			return err
+		}
+		return tx.
+			Unscoped().
+			Where(&models.RecoveryCode{UserID: userId}).
+			Delete(&models.RecoveryCode{}).
+			Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after checking code
+func RegenerateRecoveryCodes(userId uint, code string) ([]string, error) {
+	var codes []string
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := VerifyMFACode(tx, userId, code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyMFACode checks an authenticator app code or a recovery code of the user and marks it as used
+func VerifyMFACode(tx *gorm.DB, userId uint, code string) error {
+	code = normalizeMFACode(code)
+	if len(code) == TOTPDigits {
+		var cred models.TOTPCredential
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.TOTPCredential{UserID: userId}).
+			Where("confirmed_at IS NOT NULL").
+			First(&cred).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidMFACode
+			}
+			return err
+		}
+		secret, err := decryptMFASecret(cred.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := validateTOTP(secret, code, time.Now(), cred.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		return tx.
+			Model(&models.TOTPCredential{}).
+			Where("id = ?", cred.ID).
+			Update("last_used_step", step).
+			Error
+	}
+	res := tx.
+		Model(&models.RecoveryCode{}).
+		Where(&models.RecoveryCode{UserID: userId, CodeHash: hashRecoveryCode(code)}).
+		Where("used_at IS NULL").
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// MFAMethods returns the second factors the user can sign in with
+func MFAMethods(tx *gorm.DB, userId uint) ([]types.MFAMethod, error) {
+	status, err := mfaStatus(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	methods := make([]types.MFAMethod, 0, 2)
+	if status.Passkeys > 0 {
+		methods = append(methods, types.MFA_PASSKEY)
+	}
+	if status.TOTP {
+		methods = append(methods, types.MFA_TOTP)
+	}
+	return methods, nil
+}
+
+// GetMFAStatus returns the second factors of the user and how many unused recovery codes are left
+func GetMFAStatus(userId uint) (*types.MFAStatus, error) {
+	return mfaStatus(db.GetDb(), userId)
+}
+
+func mfaStatus(tx *gorm.DB, userId uint) (*types.MFAStatus, error) {
+	var passkeys, totp int64
+	status := &types.MFAStatus{}
+	if err := tx.
+		Model(&models.Credential{}).
+		Where("user_id = ?", userId).
+		Count(&passkeys).
+		Error; err != nil {
+		return nil, err
+	}
+	if err := tx.
+		Model(&models.TOTPCredential{}).
+		Where(&models.TOTPCredential{UserID: userId}).
+		Where("confirmed_at IS NOT NULL").
+		Count(&totp).
+		Error; err != nil {
+		return nil, err
+	}
+	if err := tx.
+		Model(&models.RecoveryCode{}).
+		Where(&models.RecoveryCode{UserID: userId}).
+		Where("used_at IS NULL").
+		Count(&status.RecoveryCodes).
+		Error; err != nil {
+		return nil, err
+	}
+	status.Passkeys = int(passkeys)
+	status.TOTP = totp > 0
+	return status, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+	if err := tx.
+		Unscoped().
+		Where(&models.RecoveryCode{UserID: userId}).
+		Delete(&models.RecoveryCode{}).
+		Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = codeAlphabet[int(b[j])%len(codeAlphabet)]
+		}
+		codes[i] = fmt.Sprintf("%s-%s", b[:5], b[5:])
+		rows[i] = models.RecoveryCode{UserID: userId, CodeHash: hashRecoveryCode(string(b))}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// validateTOTP returns the time step code is valid for, steps up to lastStep are rejected as already used
+func validateTOTP(secret []byte, code string, at time.Time, lastStep int64) (int64, bool) {
+	current := at.Unix() / TOTPPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the HOTP value of secret for the time step (RFC 4226)
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range TOTPDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+func normalizeMFACode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// encryptMFASecret encrypts an authenticator app secret with API_SECRET
+func encryptMFASecret(secret string) (string, error) {
+	return encryptSecret(secret)
+}
+
+func decryptMFASecret(enc string) ([]byte, error) {
+	secret, err := decryptSecret(enc)
+	if err != nil {
+		return nil, err
+	}
+	return totpEncoding.DecodeString(secret)
+}
+
+// encryptSecret encrypts a secret stored for later use, such as a signing secret, with API_SECRET
+func encryptSecret(secret string) (string, error) {
+	key, err := hex.DecodeString(config.API_SECRET)
+	if err != nil {
+		return "", err
+	}
+	return utils.EncryptMessage(key, secret)
+}
+
+func decryptSecret(enc string) (string, error) {
+	key, err := hex.DecodeString(config.API_SECRET)
+	if err != nil {
+		return "", err
+	}
+	secret, err := utils.DecryptMessage(key, enc)
+	if err != nil {
+		return "", err
+	}
+	return *secret, nil
+}
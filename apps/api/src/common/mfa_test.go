@@ -0,0 +1,47 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, code := range vectors {
+		assert.Equal(t, code, totpCode(secret, ts/TOTPPeriod))
+	}
+
+	t.Run("accepts codes of adjacent time steps", func(t *testing.T) {
+		now := time.Unix(1111111109, 0)
+		step, ok := validateTOTP(secret, totpCode(secret, now.Unix()/TOTPPeriod-1), now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, now.Unix()/TOTPPeriod-1, step)
+
+		_, ok = validateTOTP(secret, totpCode(secret, now.Unix()/TOTPPeriod-2), now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("rejects codes that were already used", func(t *testing.T) {
+		now := time.Unix(1111111109, 0)
+		code := totpCode(secret, now.Unix()/TOTPPeriod)
+		step, ok := validateTOTP(secret, code, now, 0)
+		assert.True(t, ok)
+		_, ok = validateTOTP(secret, code, now, step)
+		assert.False(t, ok)
+	})
+
+	t.Run("normalizes recovery codes", func(t *testing.T) {
+		assert.Equal(t, "ABCDE23456", normalizeMFACode("abcde-23456"))
+		assert.Equal(t, "123456", normalizeMFACode("123 456"))
+	})
+}
@@ -0,0 +1,286 @@
+package common
+
+import (
+	"context"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/lib/broker"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"github.com/zishang520/socket.io/v2/socket"
+	"gorm.io/gorm"
+)
+
+const (
+	// NotificationEvent is the socket.io event a new notification is emitted as
+	NotificationEvent = "notification"
+	// notificationsChannel is the redis channel new notifications are fanned out on, each instance
+	// pushes them to the sockets connected to it
+	notificationsChannel = "notifications"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+var (
+	notificationNsp   socket.Namespace
+	notificationNspMu sync.RWMutex
+)
+
+// SetNotificationNamespace sets the socket.io namespace notifications are pushed to as they are created.
+// Sockets of the namespace join the room of their user, see NotificationRoom.
+func SetNotificationNamespace(nsp socket.Namespace) {
+	notificationNspMu.Lock()
+	defer notificationNspMu.Unlock()
+	notificationNsp = nsp
+}
+
+// SetupNotificationNamespace creates the socket.io namespace notifications are pushed to and sets it with
+// SetNotificationNamespace. Sockets authenticate with an access token in the auth of their handshake.
+func SetupNotificationNamespace(wss *socket.Server) socket.Namespace {
+	nsp := wss.Of("/notifications", nil)
+	nsp.Use(authenticateNotificationSocket)
+	nsp.On("connection", func(clients ...any) {
+		client := clients[0].(*socket.Socket)
+		client.Join(NotificationRoom(client.Data().(uint)))
+	})
+	SetNotificationNamespace(nsp)
+	return nsp
+}
+
+func authenticateNotificationSocket(client *socket.Socket, next func(*socket.ExtendedError)) {
+	var token string
+	if auth, ok := client.Handshake().Auth.(map[string]any); ok {
+		token, _ = auth["token"].(string)
+	}
+	claims, err := VerifyAccessToken(context.Background(), token)
+	if err != nil {
+		next(socket.NewExtendedError("Unauthorized", nil))
+		return
+	}
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		next(socket.NewExtendedError("Unauthorized", nil))
+		return
+	}
+	client.SetData(uint(userId))
+	next(nil)
+}
+
+// NotificationRoom is the socket.io room of the connections of a user
+func NotificationRoom(userId uint) socket.Room {
+	return socket.Room(fmt.Sprintf("user:%d", userId))
+}
+
+// notifyUsers stores a notification for the users and queues it to be pushed to their open connections
+// once the transaction commits
+func notifyUsers(tx *gorm.DB, n *models.Notification, userIds []uint) error {
+	userIds = slices.Compact(slices.Sorted(slices.Values(userIds)))
+	userIds = slices.DeleteFunc(userIds, func(id uint) bool { return id == 0 })
+	if len(userIds) == 0 {
+		return nil
+	}
+	if err := tx.Create(n).Error; err != nil {
+		return err
+	}
+	recipients := make([]models.NotificationRecipient, len(userIds))
+	for i, userId := range userIds {
+		recipients[i] = models.NotificationRecipient{
+			NotificationID: n.ID,
+			UserID:         userId,
+		}
+	}
+	if err := tx.CreateInBatches(&recipients, 500).Error; err != nil {
+		return err
+	}
+	return models.EnqueueOutboxMessage(tx, "notification", n.ID, broker.Topic("NotificationsToPush"), types.JSONB{
+		"id": n.ID.String(),
+	})
+}
+
+// notificationPush is a notification pushed to the sockets of its recipient
+type notificationPush struct {
+	UserID    uint                         `json:"userId"`
+	Recipient models.NotificationRecipient `json:"recipient"`
+}
+
+// NotificationsToPushConsumer emits a new notification to the connected sockets of its recipients.
+// The notification is published to every instance through redis, see SubscribeNotifications, and only
+// pushed to the sockets of this instance when redis is not set up.
+func NotificationsToPushConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	id, err := uuid.Parse(gjson.Get(spayload, "id").String())
+	if err != nil {
+		return PermanentError("invalid notification id", err)
+	}
+	var recipients []models.NotificationRecipient
+	db := db.GetDb()
+	if err := db.
+		Preload("Notification").
+		Where("notification_id = ?", id).
+		Find(&recipients).
+		Error; err != nil {
+		return RetryableError(fmt.Sprintf("could not retrieve recipients of notification %s", id), err)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+	pushes := make([]notificationPush, len(recipients))
+	for i, recipient := range recipients {
+		pushes[i] = notificationPush{UserID: recipient.UserID, Recipient: recipient}
+	}
+	rd := lib.GetRedisClient()
+	if rd == nil {
+		pushNotifications(pushes)
+		return nil
+	}
+	b, err := json.Marshal(pushes)
+	if err != nil {
+		return PermanentError(fmt.Sprintf("could not encode notification %s", id), err)
+	}
+	if err := rd.Publish(context.Background(), notificationsChannel, b).Err(); err != nil {
+		return RetryableError(fmt.Sprintf("could not publish notification %s", id), err)
+	}
+	return nil
+}
+
+// SubscribeNotifications pushes the notifications published by any instance to the sockets connected
+// to this one until ctx is done
+func SubscribeNotifications(ctx context.Context) {
+	rd := lib.GetRedisClient()
+	if rd == nil {
+		return
+	}
+	sub := rd.Subscribe(ctx, notificationsChannel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var pushes []notificationPush
+			if err := json.Unmarshal([]byte(msg.Payload), &pushes); err != nil {
+				log.Printf("[Notifications] Error decoding pushed notifications: %s\n", err.Error())
+				continue
+			}
+			pushNotifications(pushes)
+		}
+	}
+}
+
+// pushNotifications emits notifications to the sockets of their recipients connected to this instance
+func pushNotifications(pushes []notificationPush) {
+	notificationNspMu.RLock()
+	nsp := notificationNsp
+	notificationNspMu.RUnlock()
+	if nsp == nil {
+		return
+	}
+	for _, push := range pushes {
+		if err := nsp.To(NotificationRoom(push.UserID)).Emit(NotificationEvent, push.Recipient); err != nil {
+			log.Printf("[Notifications] Error pushing notification %s to User [%d]: %s\n", push.Recipient.NotificationID, push.UserID, err.Error())
+		}
+	}
+}
+
+// ListNotifications returns a page of the notifications of a user, newest first, with their count and the
+// count of unread ones. The default page and limit are set on filters.
+func ListNotifications(userId uint, filters *types.NotificationQueryFilters) ([]models.NotificationRecipient, int64, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	notifications := make([]models.NotificationRecipient, 0)
+	var count, unread int64
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Model(&models.NotificationRecipient{}).
+			Where("user_id = ? AND read_at IS NULL", userId).
+			Count(&unread).
+			Error; err != nil {
+			return err
+		}
+		q := tx.
+			Model(&models.NotificationRecipient{}).
+			Where("user_id = ?", userId)
+		if filters.Unread {
+			q = q.Where("read_at IS NULL")
+		}
+		q = q.Session(&gorm.Session{})
+		if err := q.Count(&count).Error; err != nil {
+			return err
+		}
+		return q.
+			Preload("Notification").
+			Order("created_at desc").
+			Offset((filters.Page - 1) * filters.Limit).
+			Limit(filters.Limit).
+			Find(&notifications).
+			Error
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return notifications, count, unread, nil
+}
+
+// MarkNotificationsRead marks notifications of a user as read, all of them when no ids are given.
+// It returns how many were unread.
+func MarkNotificationsRead(userId uint, ids []uuid.UUID) (int64, error) {
+	var marked int64
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		q := tx.
+			Model(&models.NotificationRecipient{}).
+			Where("user_id = ? AND read_at IS NULL", userId)
+		if len(ids) > 0 {
+			q = q.Where("id IN ?", ids)
+		}
+		res := q.Update("read_at", time.Now())
+		marked = res.RowsAffected
+		return res.Error
+	})
+	return marked, err
+}
+
+// ClearNotifications removes notifications from the notification center of a user, all of them when no
+// ids are given. It returns how many were removed.
+func ClearNotifications(userId uint, ids []uuid.UUID) (int64, error) {
+	var cleared int64
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		q := tx.Where("user_id = ?", userId)
+		if len(ids) > 0 {
+			q = q.Where("id IN ?", ids)
+		}
+		res := q.Delete(&models.NotificationRecipient{})
+		cleared = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 1 && cleared == 0 {
+		return 0, ErrNotificationNotFound
+	}
+	return cleared, nil
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/utils"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+func TestNotificationSocket(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	signingKey, err := utils.NewJWTKey("EdDSA")
+	assert.Nil(t, err)
+	utils.NewSigningKeys(signingKey)
+	lib.NewRedisClient(nil)
+
+	sessionId := "8e0c1f4a-3b2d-4c5e-9f6a-7b8c9d0e1f2a"
+	token, err := utils.GenerateJWT("user@example.com", 12, 0, sessionId)
+	assert.Nil(t, err)
+
+	wss := socket.NewServer(nil, nil)
+	SetupNotificationNamespace(wss)
+	defer SetNotificationNamespace(nil)
+	srv := httptest.NewServer(wss.ServeHandler(socket.DefaultServerOptions()))
+	defer srv.Close()
+
+	// connect opens an engine.io websocket and connects to the notifications namespace
+	connect := func(t *testing.T, auth string) (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/socket.io/?EIO=4&transport=websocket", nil)
+		assert.Nil(t, err)
+		_, open, err := conn.ReadMessage()
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(open), "0"))
+		assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte(`40/notifications,`+auth)))
+		return conn, readPacket(t, conn)
+	}
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		conn, packet := connect(t, `{"token":"invalid"}`)
+		defer conn.Close()
+		assert.Equal(t, `44/notifications,{"data":null,"message":"Unauthorized"}`, packet)
+	})
+
+	t.Run("rejects revoked sessions", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "tokens"`).
+			WithArgs(models.TokenTypeSession, models.TokenStatusActive, sessionId).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		conn, packet := connect(t, `{"token":"`+token+`"}`)
+		defer conn.Close()
+		assert.Equal(t, `44/notifications,{"data":null,"message":"Unauthorized"}`, packet)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("pushes notifications to the room of the user", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "tokens"`).
+			WithArgs(models.TokenTypeSession, models.TokenStatusActive, sessionId).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		conn, packet := connect(t, `{"token":"`+token+`"}`)
+		defer conn.Close()
+		assert.True(t, strings.HasPrefix(packet, `40/notifications,{"sid":`), packet)
+
+		notificationId := "2d7c9e1a-5b3f-4e8d-a6c0-1f4b7e2d9a3c"
+		mock.ExpectQuery(`SELECT \* FROM "notification_recipients"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "notification_id", "user_id"}).
+				AddRow("5e2a8c4f-1d7b-4a9e-b3c6-0f8d2e7a1b5c", notificationId, 12).
+				AddRow("7b4d1f9e-3c6a-4e2b-8d5f-a1c9e7b3d2f0", notificationId, 13))
+		mock.ExpectQuery(`SELECT \* FROM "notifications"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(notificationId, "Event published"))
+
+		assert.Nil(t, NotificationsToPushConsumer(`{"id":"`+notificationId+`"}`))
+		packet = readPacket(t, conn)
+		assert.True(t, strings.HasPrefix(packet, `42/notifications,["notification",`), packet)
+		var event []json.RawMessage
+		assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(packet, "42/notifications,")), &event))
+		var recipient models.NotificationRecipient
+		assert.Nil(t, json.Unmarshal(event[1], &recipient))
+		assert.Equal(t, "5e2a8c4f-1d7b-4a9e-b3c6-0f8d2e7a1b5c", recipient.ID.String())
+		assert.Equal(t, "Event published", recipient.Notification.Title)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestNotificationsToPushConsumer(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	rc, rmock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+
+	notificationId := "2d7c9e1a-5b3f-4e8d-a6c0-1f4b7e2d9a3c"
+	mock.ExpectQuery(`SELECT \* FROM "notification_recipients"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "notification_id", "user_id"}).
+			AddRow("5e2a8c4f-1d7b-4a9e-b3c6-0f8d2e7a1b5c", notificationId, 12))
+	mock.ExpectQuery(`SELECT \* FROM "notifications"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(notificationId, "Event published"))
+	payload, err := json.Marshal([]notificationPush{{
+		UserID: 12,
+		Recipient: models.NotificationRecipient{
+			ID:             uuid.MustParse("5e2a8c4f-1d7b-4a9e-b3c6-0f8d2e7a1b5c"),
+			NotificationID: uuid.MustParse(notificationId),
+			UserID:         12,
+			Notification:   &models.Notification{ID: uuid.MustParse(notificationId), Title: "Event published"},
+		},
+	}})
+	assert.Nil(t, err)
+	rmock.ExpectPublish(notificationsChannel, payload).SetVal(1)
+
+	assert.Nil(t, NotificationsToPushConsumer(`{"id":"`+notificationId+`"}`))
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Nil(t, rmock.ExpectationsWereMet())
+}
+
+// readPacket returns the next socket.io packet, answering the pings of the server
+func readPacket(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, b, err := conn.ReadMessage()
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		if string(b) == "2" {
+			conn.WriteMessage(websocket.TextMessage, []byte("3"))
+			continue
+		}
+		return string(b)
+	}
+}
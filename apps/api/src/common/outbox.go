@@ -0,0 +1,110 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxBatchSize    = 100
+	OutboxMaxAttempts  = 10
+	OutboxPollInterval = 2 * time.Second
+	// Key of the advisory lock held while relaying, so only one relay publishes at a time
+	outboxLockKey = 7_349_201
+)
+
+// RelayOutbox publishes available outbox messages to the broker.
+// Messages of the same aggregate are published in the order they were written, a message that
+// fails to publish is retried after RetryBackoff and holds back the later messages of its aggregate
+// until it succeeds or is sent to the DLQ.
+// The lock is held on its own connection so no transaction stays open while publishing.
+func RelayOutbox() error {
+	db := db.GetDb()
+	_, err := withSessionLock(outboxLockKey, func() error {
+		var messages []models.OutboxMessage
+		if err := db.
+			Where(&models.OutboxMessage{Status: types.OUTBOX_PENDING}).
+			Where("available_at <= ?", time.Now()).
+			Where(`NOT EXISTS (
+				SELECT 1 FROM outbox_messages held
+				WHERE held.aggregate_type = outbox_messages.aggregate_type AND held.aggregate_id = outbox_messages.aggregate_id
+				AND held.id < outbox_messages.id AND held.status = ? AND held.attempts > 0 AND held.deleted_at IS NULL
+			)`, types.OUTBOX_PENDING).
+			Order("id asc").
+			Limit(OutboxBatchSize).
+			Find(&messages).
+			Error; err != nil {
+			return err
+		}
+		blocked := make(map[string]bool)
+		for _, msg := range messages {
+			key := fmt.Sprintf("%s:%s", msg.AggregateType, msg.AggregateID)
+			if blocked[key] {
+				continue
+			}
+			if err := relayOutboxMessage(db, &msg); err != nil {
+				blocked[key] = true
+				log.Printf("[Outbox] Error publishing message %d to %s: %s\n", msg.ID, msg.Topic, err.Error())
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func relayOutboxMessage(tx *gorm.DB, msg *models.OutboxMessage) error {
+	perr := broker.Publish(msg.Topic, &msg.Payload)
+	if perr == nil {
+		now := time.Now()
+		return tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.
+				Model(msg).
+				Updates(&models.OutboxMessage{
+					Status:      types.OUTBOX_PUBLISHED,
+					Attempts:    msg.Attempts + 1,
+					PublishedAt: &now,
+				}).
+				Error; err != nil {
+				return err
+			}
+			// Jobs handed to the broker are no longer pending, so the expiry sweep leaves them alone
+			jobId, ok := msg.Payload["JobID"].(string)
+			if !ok {
+				return nil
+			}
+			return tx.
+				Model(&models.JobTask{}).
+				Where("id = ? AND status = ?", jobId, "pending").
+				Update("status", "published").
+				Error
+		})
+	}
+	reason := perr.Error()
+	updates := &models.OutboxMessage{
+		Attempts:  msg.Attempts + 1,
+		LastError: &reason,
+	}
+	// Back off like consumer retries so a broker outage does not use up the attempts in seconds
+	updates.AvailableAt = time.Now().Add(RetryBackoff(updates.Attempts))
+	if updates.Attempts >= OutboxMaxAttempts {
+		// Give up on the message so the rest of the aggregate can move on
+		updates.Status = types.OUTBOX_FAILED
+		payload, _ := json.Marshal(msg.Payload)
+		sendToDeadLetters(msg.Topic, string(payload), updates.Attempts, perr)
+	}
+	if err := tx.Model(msg).Updates(updates).Error; err != nil {
+		return err
+	}
+	if updates.Status == types.OUTBOX_FAILED {
+		return nil
+	}
+	return perr
+}
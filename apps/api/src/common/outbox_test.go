@@ -0,0 +1,86 @@
+package common
+
+import (
+	"database/sql/driver"
+	"ebs/src/db/dbtest"
+	"ebs/src/lib/broker"
+	"ebs/src/types"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBroker struct {
+	broker.MemoryBroker
+	failing   map[string]bool
+	published []string
+}
+
+func (f *fakeBroker) Publish(topic string, payload *types.JSONB) error {
+	if f.failing[(*payload)["id"].(string)] {
+		return errors.New("broker unavailable")
+	}
+	f.published = append(f.published, (*payload)["id"].(string))
+	return nil
+}
+
+func TestRelayOutbox(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	fb := &fakeBroker{failing: map[string]bool{"b1": true}}
+	broker.NewBroker(fb)
+	defer broker.NewBroker(nil)
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock`).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	// Messages behind one that is backing off after a failed publish are held back
+	mock.ExpectQuery(`SELECT \* FROM "outbox_messages" WHERE "outbox_messages"."status" = \$1 AND available_at <= \$2 AND \(NOT EXISTS \(.*held.status = \$3 AND held.attempts > 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "aggregate_type", "aggregate_id", "topic", "payload", "status", "attempts"}).
+			AddRow(1, "Booking", "1", "Bookings_test", []byte(`{"id":"a1"}`), "pending", 0).
+			AddRow(2, "Booking", "2", "Bookings_test", []byte(`{"id":"b1"}`), "pending", 0).
+			AddRow(3, "Booking", "1", "Bookings_test", []byte(`{"id":"a2"}`), "pending", 0).
+			AddRow(4, "Booking", "2", "Bookings_test", []byte(`{"id":"b2"}`), "pending", 0).
+			AddRow(5, "Event", "1", "EventReminders_test", []byte(`{"id":"c1","JobID":"5d1f0a4b-2e7d-4f1b-9a3c-0c6b8f7a5d2e"}`), "pending", 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_messages" SET .*"status"=.*WHERE .*"id" = \$\d+`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_messages" SET "attempts"=\$1,"last_error"=\$2,"available_at"=\$3,"updated_at"=\$4 WHERE .*"id" = \$5`).
+		WithArgs(1, "broker unavailable", availableAfter(RetryBackoff(1)), sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_messages" SET .*"status"=.*WHERE .*"id" = \$\d+`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// The job of a published message is marked as published
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_messages" SET .*"status"=.*WHERE .*"id" = \$\d+`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "job_tasks" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND status = \$4\)`).
+		WithArgs("published", sqlmock.AnyArg(), "5d1f0a4b-2e7d-4f1b-9a3c-0c6b8f7a5d2e", "pending").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.Nil(t, RelayOutbox())
+	// b2 is held back until b1 has been published
+	assert.Equal(t, []string{"a1", "a2", "c1"}, fb.published)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// availableAfter matches a time at least d from now
+type availableAfter time.Duration
+
+func (a availableAfter) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && !t.Before(time.Now().Add(time.Duration(a)-time.Second))
+}
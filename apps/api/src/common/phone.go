@@ -0,0 +1,127 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"ebs/src/db"
+	"ebs/src/lib/sms"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	phoneTokenName = "phone_verification_code"
+	// PhoneCodeTTL is how long a phone verification code can be used
+	PhoneCodeTTL = 10 * time.Minute
+)
+
+var (
+	ErrInvalidPhoneCode = errors.New("invalid verification code")
+	ErrPhoneCodeExpired = errors.New("verification code has expired")
+)
+
+// RequestPhoneVerification texts a one-time code to the phone number. The number is only set on the
+// user once the code is confirmed with VerifyPhone. Returns the number in E.164 format.
+func RequestPhoneVerification(userId uint, phone string) (string, error) {
+	phone, err := sms.NormalizePhone(phone)
+	if err != nil {
+		return "", err
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Model(&models.Token{}).
+			Where("requested_by = ? AND token_name = ? AND status = ?", userId, phoneTokenName, "pending").
+			Update("status", "invalid").
+			Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Token{
+			RequestedBy:   userId,
+			RequesterType: "user",
+			Type:          models.TokenTypeVerification,
+			TokenName:     phoneTokenName,
+			TokenValue: types.JSONB{
+				"code":  hashRecoveryCode(code),
+				"phone": phone,
+			},
+			TTL: uint(PhoneCodeTTL.Seconds()),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	text := fmt.Sprintf("Your Silver Elven verification code is %s. It expires in %d minutes.", code, int(PhoneCodeTTL.Minutes()))
+	if err := sms.Send(context.Background(), phone, text); err != nil {
+		return "", err
+	}
+	return phone, nil
+}
+
+// VerifyPhone confirms the last code texted to the user and sets the phone number it was sent to as verified
+func VerifyPhone(userId uint, code string) (string, error) {
+	var phone string
+	var expired bool
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var token models.Token
+		if err := tx.
+			Where("requested_by = ? AND token_name = ? AND status = ?", userId, phoneTokenName, "pending").
+			Order("created_at desc").
+			First(&token).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidPhoneCode
+			}
+			return err
+		}
+		if token.ExpiresAt.Before(time.Now()) {
+			expired = true
+			return tx.Model(&token).Update("status", "expired").Error
+		}
+		hash, _ := token.TokenValue["code"].(string)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(hashRecoveryCode(code))) != 1 {
+			return ErrInvalidPhoneCode
+		}
+		phone, _ = token.TokenValue["phone"].(string)
+		if err := tx.Model(&token).Update("status", "done").Error; err != nil {
+			return err
+		}
+		return tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Updates(map[string]any{"phone_number": phone, "phone_verified": true}).
+			Error
+	})
+	if err != nil {
+		return "", err
+	}
+	if expired {
+		return "", ErrPhoneCodeExpired
+	}
+	return phone, nil
+}
+
+// RemovePhone removes the phone number of the user, who stops receiving text messages
+func RemovePhone(userId uint) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		return tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Updates(map[string]any{"phone_number": "", "phone_verified": false}).
+			Error
+	})
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/lib/sms"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestPhoneVerification(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	provider := sms.NewMemoryProvider()
+	sms.NewProvider(provider)
+	defer sms.NewProvider(nil)
+
+	t.Run("rejects invalid numbers", func(t *testing.T) {
+		_, err := RequestPhoneVerification(11, "0917 123")
+		assert.ErrorIs(t, err, sms.ErrInvalidPhoneNumber)
+		assert.Empty(t, provider.Messages())
+	})
+
+	t.Run("texts a code to the number", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs("invalid", sqlmock.AnyArg(), uint(11), phoneTokenName, "pending").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "tokens"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow("0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c", "pending"))
+		mock.ExpectCommit()
+
+		phone, err := RequestPhoneVerification(11, "+63 917 123 4567")
+		assert.Nil(t, err)
+		assert.Equal(t, "+639171234567", phone)
+		messages := provider.Messages()
+		assert.Len(t, messages, 1)
+		assert.Equal(t, "+639171234567", messages[0].Phone)
+		assert.Regexp(t, `code is \d{6}\.`, messages[0].Text)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestVerifyPhone(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	tokenId := "0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"
+	tokenValue := `{"code":"` + hashRecoveryCode("123456") + `","phone":"+639171234567"}`
+	token := func(createdAt time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "token_value", "ttl", "status", "created_at"}).
+			AddRow(tokenId, []byte(tokenValue), uint(PhoneCodeTTL.Seconds()), "pending", createdAt)
+	}
+
+	t.Run("rejects a wrong code", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).WillReturnRows(token(time.Now()))
+		mock.ExpectRollback()
+		_, err := VerifyPhone(11, "654321")
+		assert.ErrorIs(t, err, ErrInvalidPhoneCode)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("expires old codes", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).WillReturnRows(token(time.Now().Add(-time.Hour)))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs("expired", sqlmock.AnyArg(), tokenId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		_, err := VerifyPhone(11, "123456")
+		assert.ErrorIs(t, err, ErrPhoneCodeExpired)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("verifies the number the code was sent to", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).WillReturnRows(token(time.Now()))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs("done", sqlmock.AnyArg(), tokenId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "users" SET "phone_number"=\$1,"phone_verified"=\$2`).
+			WithArgs("+639171234567", true, sqlmock.AnyArg(), uint(11)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		phone, err := VerifyPhone(11, "123456")
+		assert.Nil(t, err)
+		assert.Equal(t, "+639171234567", phone)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
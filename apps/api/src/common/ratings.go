@@ -0,0 +1,289 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRatingNotFound    = errors.New("rating not found")
+	ErrNotRatingEligible = errors.New("only attendees admitted to a completed event of the organization can rate it")
+	ErrAlreadyRated      = errors.New("the event has already been rated")
+	ErrAlreadyReported   = errors.New("the rating has already been reported")
+)
+
+// attendedEvent reports whether the user was admitted to the event with one of their reservations
+func attendedEvent(tx *gorm.DB, userId uint, eventId uint) (bool, error) {
+	var count int64
+	if err := tx.
+		Model(&models.Admission{}).
+		Joins("JOIN reservations ON reservations.id = admissions.reservation_id").
+		Joins("JOIN bookings ON bookings.id = reservations.booking_id").
+		Where("bookings.user_id = ? AND bookings.event_id = ?", userId, eventId).
+		Count(&count).
+		Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateRating records the rating of an organization by an attendee of one of its completed events
+func CreateRating(orgId uint, userId uint, body types.CreateRatingRequestBody) (*models.Rating, error) {
+	rating := models.Rating{
+		OrganizationID: orgId,
+		EventID:        body.EventID,
+		ByUser:         userId,
+		Value:          body.Value,
+		Comment:        body.Comment,
+		Status:         types.RATING_PUBLISHED,
+	}
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var event models.Event
+		if err := tx.
+			Select("id", "organizer_id", "status").
+			Where("id = ? AND organizer_id = ?", body.EventID, orgId).
+			First(&event).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotRatingEligible
+			}
+			return err
+		}
+		if event.Status != types.EVENT_COMPLETED {
+			return ErrNotRatingEligible
+		}
+		attended, err := attendedEvent(tx, userId, event.ID)
+		if err != nil {
+			return err
+		}
+		if !attended {
+			return ErrNotRatingEligible
+		}
+		res := tx.
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}, {Name: "by_user"}}, DoNothing: true}).
+			Create(&rating)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyRated
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// ListRatings returns the published ratings of an organization, latest first
+func ListRatings(orgId uint, filters *types.RatingQueryFilters) ([]models.Rating, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	ratings := make([]models.Rating, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.
+		Model(&models.Rating{}).
+		Where("organization_id = ? AND status = ?", orgId, types.RATING_PUBLISHED)
+	if filters.EventID != 0 {
+		q = q.Where("event_id = ?", filters.EventID)
+	}
+	q = q.Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Order("created_at desc").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&ratings).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return ratings, count, nil
+}
+
+// RatingSummaryOf aggregates the published ratings of an organization
+func RatingSummaryOf(orgId uint) (*models.RatingSummary, error) {
+	var rows []struct {
+		Value uint
+		Count int64
+	}
+	db := db.GetDb()
+	if err := db.
+		Model(&models.Rating{}).
+		Select("value, COUNT(*) AS count").
+		Where("organization_id = ? AND status = ?", orgId, types.RATING_PUBLISHED).
+		Group("value").
+		Scan(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+	summary := &models.RatingSummary{Distribution: map[uint]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	var total uint64
+	for _, row := range rows {
+		summary.Distribution[row.Value] = row.Count
+		summary.Count += row.Count
+		total += uint64(row.Value) * uint64(row.Count)
+	}
+	if summary.Count > 0 {
+		summary.Average = math.Round(float64(total)/float64(summary.Count)*100) / 100
+	}
+	return summary, nil
+}
+
+// ReplyToRating sets the reply of the organization to one of its ratings, replacing an earlier one
+func ReplyToRating(orgId uint, ratingId uint, reply string) (before *models.Rating, after *models.Rating, err error) {
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var rating models.Rating
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND organization_id = ?", ratingId, orgId).
+			First(&rating).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRatingNotFound
+			}
+			return err
+		}
+		prev := rating
+		before = &prev
+		now := time.Now()
+		if err := tx.
+			Model(&rating).
+			Updates(map[string]any{"reply": reply, "replied_at": now}).
+			Error; err != nil {
+			return err
+		}
+		rating.Reply = reply
+		rating.RepliedAt = &now
+		after = &rating
+		return nil
+	})
+	return before, after, err
+}
+
+// ReportRating flags a published rating of an organization for moderation, once per user
+func ReportRating(orgId uint, ratingId uint, userId uint, reason string) (*models.RatingReport, error) {
+	report := models.RatingReport{
+		RatingID:   ratingId,
+		ReportedBy: userId,
+		Reason:     reason,
+		Status:     types.RATING_REPORT_OPEN,
+	}
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.
+			Model(&models.Rating{}).
+			Where("id = ? AND organization_id = ? AND status = ?", ratingId, orgId, types.RATING_PUBLISHED).
+			Count(&count).
+			Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrRatingNotFound
+		}
+		res := tx.
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "rating_id"}, {Name: "reported_by"}}, DoNothing: true}).
+			Create(&report)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyReported
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ListRatingReports returns the reports of ratings with the rating they flag, oldest first
+func ListRatingReports(filters *types.RatingReportQueryFilters) ([]models.RatingReport, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	reports := make([]models.RatingReport, 0)
+	var count int64
+	db := db.GetDb()
+	q := db.Model(&models.RatingReport{})
+	if filters.Status != "" {
+		q = q.Where("status = ?", filters.Status)
+	}
+	q = q.Session(&gorm.Session{})
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.
+		Preload("Rating").
+		Order("created_at").
+		Offset((filters.Page - 1) * filters.Limit).
+		Limit(filters.Limit).
+		Find(&reports).
+		Error; err != nil {
+		return nil, 0, err
+	}
+	return reports, count, nil
+}
+
+// ModerateRating hides a rating or publishes it again. Its open reports are upheld when it is hidden
+// and dismissed otherwise.
+func ModerateRating(ratingId uint, status types.RatingStatus, adminId uint) (before *models.Rating, after *models.Rating, err error) {
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var rating models.Rating
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", ratingId).
+			First(&rating).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRatingNotFound
+			}
+			return err
+		}
+		prev := rating
+		before = &prev
+		if err := tx.
+			Model(&rating).
+			Update("status", status).
+			Error; err != nil {
+			return err
+		}
+		resolution := types.RATING_REPORT_DISMISSED
+		if status == types.RATING_HIDDEN {
+			resolution = types.RATING_REPORT_UPHELD
+		}
+		if err := tx.
+			Model(&models.RatingReport{}).
+			Where("rating_id = ? AND status = ?", ratingId, types.RATING_REPORT_OPEN).
+			Updates(map[string]any{"status": resolution, "resolved_by": adminId, "resolved_at": time.Now()}).
+			Error; err != nil {
+			return err
+		}
+		rating.Status = status
+		after = &rating
+		return nil
+	})
+	return before, after, err
+}
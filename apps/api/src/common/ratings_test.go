@@ -0,0 +1,88 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRating(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	body := types.CreateRatingRequestBody{EventID: 3, Value: 5, Comment: "Great show"}
+	event := func(status types.EventStatus) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "organizer_id", "status"}).AddRow(3, 1, status)
+	}
+
+	t.Run("rejects events that are not completed", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id","organizer_id","status" FROM "events" WHERE \(id = \$1 AND organizer_id = \$2\)`).
+			WithArgs(uint(3), uint(1), 1).
+			WillReturnRows(event(types.EVENT_ADMISSION))
+		mock.ExpectRollback()
+		_, err := CreateRating(1, 11, body)
+		assert.ErrorIs(t, err, ErrNotRatingEligible)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejects users who were not admitted", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id","organizer_id","status" FROM "events"`).
+			WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "admissions" JOIN reservations .* JOIN bookings .* WHERE \(bookings.user_id = \$1 AND bookings.event_id = \$2\)`).
+			WithArgs(uint(11), uint(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectRollback()
+		_, err := CreateRating(1, 11, body)
+		assert.ErrorIs(t, err, ErrNotRatingEligible)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rates each event once", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id","organizer_id","status" FROM "events"`).
+			WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "admissions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`INSERT INTO "ratings" .* ON CONFLICT \("event_id","by_user"\) DO NOTHING`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		_, err := CreateRating(1, 11, body)
+		assert.ErrorIs(t, err, ErrAlreadyRated)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("records the rating of an attendee", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id","organizer_id","status" FROM "events"`).
+			WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "admissions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`INSERT INTO "ratings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectCommit()
+		rating, err := CreateRating(1, 11, body)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(8), rating.ID)
+		assert.Equal(t, types.RATING_PUBLISHED, rating.Status)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRatingSummaryOf(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	mock.ExpectQuery(`SELECT value, COUNT\(\*\) AS count FROM "ratings" WHERE \(organization_id = \$1 AND status = \$2\) .* GROUP BY "value"`).
+		WithArgs(uint(1), types.RATING_PUBLISHED).
+		WillReturnRows(sqlmock.NewRows([]string{"value", "count"}).AddRow(5, 2).AddRow(4, 1))
+
+	summary, err := RatingSummaryOf(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), summary.Count)
+	assert.Equal(t, 4.67, summary.Average)
+	assert.Equal(t, map[uint]int64{1: 0, 2: 0, 3: 0, 4: 1, 5: 2}, summary.Distribution)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,377 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v82"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// ReconciliationWindow is how far back each reconciliation run looks for payments
+	ReconciliationWindow = 7 * 24 * time.Hour
+	// Key of the advisory lock held while reconciling, so only one instance reconciles at a time
+	reconciliationLockKey = 7_349_203
+)
+
+// ReconcilePayments compares the payments of every connected Stripe account created after since
+// with the local Transactions. Safe mismatches are fixed, the rest are stored as open discrepancies.
+// A run is skipped while another instance is reconciling.
+func ReconcilePayments(since time.Time) error {
+	ran, err := withSessionLock(reconciliationLockKey, func() error {
+		var orgs []models.Organization
+		db := db.GetDb()
+		if err := db.
+			Where("stripe_account_id IS NOT NULL").
+			Find(&orgs).
+			Error; err != nil {
+			return err
+		}
+		for _, org := range orgs {
+			if err := ReconcileOrganization(&org, since); err != nil {
+				log.Printf("[Reconciliation] Error reconciling organization %d: %s\n", org.ID, err.Error())
+			}
+		}
+		return nil
+	})
+	if err == nil && !ran {
+		log.Println("[Reconciliation] Another instance is reconciling. Skipping")
+	}
+	return err
+}
+
+// ReconcileOrganization reconciles the PaymentIntents of an organization's connected account
+func ReconcileOrganization(org *models.Organization, since time.Time) error {
+	if org.StripeAccountID == nil {
+		return fmt.Errorf("organization %d has no Stripe account", org.ID)
+	}
+	sc := lib.GetStripeClient()
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since.Unix()},
+	}
+	params.StripeAccount = org.StripeAccountID
+	params.AddExpand("data.latest_charge.balance_transaction")
+	// PaymentIntent IDs and request IDs found in Stripe
+	seen := make(map[string]bool)
+	for pi, err := range sc.V1PaymentIntents.List(context.Background(), params) {
+		if err != nil {
+			return err
+		}
+		seen[pi.ID] = true
+		requestId := pi.Metadata["requestId"]
+		if requestId == "" {
+			// Not created through a checkout of ours
+			continue
+		}
+		seen[requestId] = true
+		if err := reconcilePaymentIntent(org, pi); err != nil {
+			log.Printf("[Reconciliation] Error reconciling PaymentIntent %s: %s\n", pi.ID, err.Error())
+		}
+	}
+	return reconcileMissingPayments(org, since, seen)
+}
+
+func reconcilePaymentIntent(org *models.Organization, pi *stripe.PaymentIntent) error {
+	requestId := pi.Metadata["requestId"]
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var txn models.Transaction
+		if err := tx.
+			Where("payment_intent_id = ?", pi.ID).
+			Or("reference_id = ?", requestId).
+			First(&txn).
+			Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if pi.Status != stripe.PaymentIntentStatusSucceeded {
+				return nil
+			}
+			return recordDiscrepancy(tx, &models.PaymentDiscrepancy{
+				OrganizationID:  org.ID,
+				PaymentIntentID: &pi.ID,
+				Kind:            types.DISCREPANCY_MISSING_TRANSACTION,
+				Expected:        formatAmount(float64(pi.AmountReceived), string(pi.Currency)),
+				Status:          types.DISCREPANCY_OPEN,
+			})
+		}
+		d := &models.PaymentDiscrepancy{
+			OrganizationID:  org.ID,
+			TransactionID:   &txn.ID,
+			PaymentIntentID: &pi.ID,
+			Status:          types.DISCREPANCY_OPEN,
+		}
+		charge := pi.LatestCharge
+		succeeded := pi.Status == stripe.PaymentIntentStatusSucceeded
+		switch {
+		case succeeded && (txn.Status == types.TRANSACTION_PENDING || txn.Status == types.TRANSACTION_PROCESSING):
+			d.Kind = types.DISCREPANCY_STATUS_MISMATCH
+			d.Expected = string(types.TRANSACTION_COMPLETED)
+			d.Actual = string(txn.Status)
+			fixed, err := markTransactionPaid(tx, &txn, pi)
+			if err != nil {
+				return err
+			}
+			if fixed {
+				d.Status = types.DISCREPANCY_FIXED
+			}
+			if err := recordDiscrepancy(tx, d); err != nil {
+				return err
+			}
+		case succeeded && txn.Status == types.TRANSACTION_COMPLETED:
+			if txn.AmountPaid != float64(pi.AmountReceived) {
+				amount := *d
+				amount.Kind = types.DISCREPANCY_AMOUNT_MISMATCH
+				amount.Expected = formatAmount(float64(pi.AmountReceived), string(pi.Currency))
+				amount.Actual = formatAmount(txn.AmountPaid, txn.Currency)
+				if err := recordDiscrepancy(tx, &amount); err != nil {
+					return err
+				}
+			}
+			if err := reconcileFees(tx, d, &txn, pi); err != nil {
+				return err
+			}
+		case succeeded || txn.Status == types.TRANSACTION_COMPLETED:
+			d.Kind = types.DISCREPANCY_STATUS_MISMATCH
+			d.Expected = string(pi.Status)
+			d.Actual = string(txn.Status)
+			if err := recordDiscrepancy(tx, d); err != nil {
+				return err
+			}
+		}
+		if charge == nil {
+			return nil
+		}
+		if charge.AmountRefunded > 0 {
+			refund := *d
+			refund.Kind = types.DISCREPANCY_REFUNDED
+			refund.Status = types.DISCREPANCY_OPEN
+			refund.Expected = formatAmount(float64(charge.AmountRefunded), string(charge.Currency))
+			refund.Actual = formatAmount(txn.AmountPaid, txn.Currency)
+			if err := recordDiscrepancy(tx, &refund); err != nil {
+				return err
+			}
+		}
+		if charge.Disputed {
+			dispute := *d
+			dispute.Kind = types.DISCREPANCY_DISPUTED
+			dispute.Status = types.DISCREPANCY_OPEN
+			dispute.Expected = "disputed"
+			dispute.Actual = string(txn.Status)
+			if err := recordDiscrepancy(tx, &dispute); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// markTransactionPaid applies a succeeded PaymentIntent whose webhook was missed.
+// It returns false without changes when a booking of the transaction has already expired or been canceled.
+func markTransactionPaid(tx *gorm.DB, txn *models.Transaction, pi *stripe.PaymentIntent) (bool, error) {
+	var bookings []models.Booking
+	if err := tx.
+		Where(&models.Booking{TransactionID: &txn.ID}).
+		Preload("Event").
+		Find(&bookings).
+		Error; err != nil {
+		return false, err
+	}
+	for _, booking := range bookings {
+		if booking.Status != types.BOOKING_PENDING && booking.Status != types.BOOKING_COMPLETED {
+			return false, nil
+		}
+	}
+	updates := &models.Transaction{
+		AmountPaid:      float64(pi.AmountReceived),
+		Currency:        string(pi.Currency),
+		SourceName:      "PaymentIntent",
+		SourceValue:     pi.ID,
+		Status:          types.TRANSACTION_COMPLETED,
+		PaymentIntentId: &pi.ID,
+	}
+	if bt := balanceTransaction(pi); bt != nil {
+		updates.FeeAmount = float64(bt.Fee)
+		updates.FeeCurrency = string(bt.Currency)
+		updates.NetAmount = float64(bt.Net)
+	}
+	if err := tx.
+		Model(txn).
+		Updates(updates).
+		Error; err != nil {
+		return false, err
+	}
+	for _, booking := range bookings {
+		var validUntil *time.Time
+		if booking.Event != nil {
+			validUntil = booking.Event.DateTime
+		}
+		if booking.Status != types.BOOKING_COMPLETED {
+			if err := emitBookingCompleted(tx, &booking); err != nil {
+				return false, err
+			}
+		}
+		if err := tx.
+			Model(&models.Booking{}).
+			Where("id = ?", booking.ID).
+			Updates(&models.Booking{
+				Status:          types.BOOKING_COMPLETED,
+				PaymentIntentId: &pi.ID,
+			}).
+			Error; err != nil {
+			return false, err
+		}
+		if err := tx.
+			Model(&models.Reservation{}).
+			Where("booking_id = ?", booking.ID).
+			Updates(&models.Reservation{
+				Status:     string(types.RESERVATION_PAID),
+				ValidUntil: validUntil,
+			}).
+			Error; err != nil {
+			return false, err
+		}
+	}
+	log.Printf("[Reconciliation] Transaction %s marked as paid from PaymentIntent %s\n", txn.ID, pi.ID)
+	return true, nil
+}
+
+// reconcileFees copies the fees of the balance transaction, which are only known to Stripe
+func reconcileFees(tx *gorm.DB, d *models.PaymentDiscrepancy, txn *models.Transaction, pi *stripe.PaymentIntent) error {
+	bt := balanceTransaction(pi)
+	if bt == nil || (txn.FeeAmount == float64(bt.Fee) && txn.NetAmount == float64(bt.Net)) {
+		return nil
+	}
+	fee := *d
+	fee.Kind = types.DISCREPANCY_FEE_MISMATCH
+	fee.Status = types.DISCREPANCY_FIXED
+	fee.Expected = fmt.Sprintf("fee %d net %d %s", bt.Fee, bt.Net, bt.Currency)
+	fee.Actual = fmt.Sprintf("fee %.0f net %.0f %s", txn.FeeAmount, txn.NetAmount, txn.FeeCurrency)
+	if err := tx.
+		Model(txn).
+		Updates(&models.Transaction{
+			FeeAmount:       float64(bt.Fee),
+			FeeCurrency:     string(bt.Currency),
+			NetAmount:       float64(bt.Net),
+			PaymentIntentId: &pi.ID,
+		}).
+		Error; err != nil {
+		return err
+	}
+	return recordDiscrepancy(tx, &fee)
+}
+
+// reconcileMissingPayments flags paid transactions of the organization that have no payment in Stripe
+func reconcileMissingPayments(org *models.Organization, since time.Time, seen map[string]bool) error {
+	var txns []models.Transaction
+	db := db.GetDb()
+	if err := db.
+		Where("id IN (?)", db.
+			Model(&models.Booking{}).
+			Select("bookings.transaction_id").
+			Joins("JOIN events ON events.id = bookings.event_id").
+			Where("events.organizer_id = ?", org.ID)).
+		Where(&models.Transaction{Status: types.TRANSACTION_COMPLETED}).
+		Where("created_at >= ?", since).
+		Find(&txns).
+		Error; err != nil {
+		return err
+	}
+	for _, txn := range txns {
+		if seen[txn.ReferenceID] || (txn.PaymentIntentId != nil && seen[*txn.PaymentIntentId]) {
+			continue
+		}
+		if err := recordDiscrepancy(db, &models.PaymentDiscrepancy{
+			OrganizationID:  org.ID,
+			TransactionID:   &txn.ID,
+			PaymentIntentID: txn.PaymentIntentId,
+			Kind:            types.DISCREPANCY_MISSING_PAYMENT,
+			Actual:          formatAmount(txn.AmountPaid, txn.Currency),
+			Status:          types.DISCREPANCY_OPEN,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discrepancyFingerprint identifies a discrepancy by what was compared and the values found
+func discrepancyFingerprint(d *models.PaymentDiscrepancy) string {
+	var txnId, piId string
+	if d.TransactionID != nil {
+		txnId = d.TransactionID.String()
+	}
+	if d.PaymentIntentID != nil {
+		piId = *d.PaymentIntentID
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{txnId, piId, string(d.Kind), d.Expected, d.Actual}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordDiscrepancy stores a discrepancy unless the same one was already reported
+func recordDiscrepancy(tx *gorm.DB, d *models.PaymentDiscrepancy) error {
+	fingerprint := discrepancyFingerprint(d)
+	d.Fingerprint = &fingerprint
+	res := tx.
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "fingerprint"}}, DoNothing: true}).
+		Create(d)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("[Reconciliation] %s discrepancy for organization %d: expected %q, got %q\n", d.Kind, d.OrganizationID, d.Expected, d.Actual)
+	}
+	return nil
+}
+
+// ResolvePaymentDiscrepancy marks an open discrepancy as resolved by an admin
+func ResolvePaymentDiscrepancy(id uuid.UUID, userId uint) (*models.PaymentDiscrepancy, error) {
+	var d models.PaymentDiscrepancy
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.PaymentDiscrepancy{ID: id, Status: types.DISCREPANCY_OPEN}).
+			First(&d).
+			Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		return tx.
+			Model(&d).
+			Updates(&models.PaymentDiscrepancy{
+				Status:     types.DISCREPANCY_RESOLVED,
+				ResolvedBy: &userId,
+				ResolvedAt: &now,
+			}).
+			Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func balanceTransaction(pi *stripe.PaymentIntent) *stripe.BalanceTransaction {
+	if pi.LatestCharge == nil {
+		return nil
+	}
+	return pi.LatestCharge.BalanceTransaction
+}
+
+func formatAmount(amount float64, currency string) string {
+	return fmt.Sprintf("%.0f %s", amount, currency)
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v82"
+)
+
+const paymentIntentsFixture = `{
+	"object": "list",
+	"url": "/v1/payment_intents",
+	"has_more": false,
+	"data": [
+		{
+			"id": "pi_missed",
+			"object": "payment_intent",
+			"amount": 2500,
+			"amount_received": 2500,
+			"currency": "usd",
+			"status": "succeeded",
+			"metadata": {"requestId": "req-missed"},
+			"latest_charge": {
+				"id": "ch_missed",
+				"object": "charge",
+				"amount_refunded": 0,
+				"currency": "usd",
+				"disputed": false,
+				"balance_transaction": {"id": "txn_missed", "object": "balance_transaction", "currency": "usd", "fee": 103, "net": 2397}
+			}
+		},
+		{
+			"id": "pi_disputed",
+			"object": "payment_intent",
+			"amount": 5000,
+			"amount_received": 5000,
+			"currency": "usd",
+			"status": "succeeded",
+			"metadata": {"requestId": "req-disputed"},
+			"latest_charge": {
+				"id": "ch_disputed",
+				"object": "charge",
+				"amount_refunded": 0,
+				"currency": "usd",
+				"disputed": true,
+				"balance_transaction": {"id": "txn_disputed", "object": "balance_transaction", "currency": "usd", "fee": 175, "net": 4825}
+			}
+		},
+		{
+			"id": "pi_other",
+			"object": "payment_intent",
+			"amount": 900,
+			"amount_received": 900,
+			"currency": "usd",
+			"status": "succeeded",
+			"metadata": {}
+		}
+	]
+}`
+
+func TestReconcileOrganization(t *testing.T) {
+	var stripeAccount string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/payment_intents", r.URL.Path)
+		assert.Equal(t, "data.latest_charge.balance_transaction", r.URL.Query().Get("expand[0]"))
+		stripeAccount = r.Header.Get("Stripe-Account")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(paymentIntentsFixture))
+	}))
+	defer srv.Close()
+	lib.NewStripeClient(stripe.NewClient("sk_test_fake", stripe.WithBackends(stripe.NewBackendsWithConfig(&stripe.BackendConfig{
+		URL: stripe.String(srv.URL),
+	}))))
+	defer lib.NewStripeClient(nil)
+
+	mock := dbtest.UseMockDB(t)
+
+	txnColumns := []string{"id", "amount", "amount_paid", "fee_amount", "fee_currency", "net_amount", "currency", "reference_id", "status", "payment_intent_id"}
+
+	// Missed payment_intent.succeeded webhook is applied
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(payment_intent_id = \$1 OR reference_id = \$2\)`).
+		WithArgs("pi_missed", "req-missed", 1).
+		WillReturnRows(sqlmock.NewRows(txnColumns).
+			AddRow("2b1c9f4e-0d0e-4c1a-8a55-0f3e2d6c7b11", 2500, 2500, 0, "", 0, "usd", "req-missed", "processing", nil))
+	mock.ExpectQuery(`SELECT \* FROM "bookings"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "event_id", "user_id"}).AddRow(7, "pending", 3, 11))
+	mock.ExpectQuery(`SELECT \* FROM "events"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "date_time", "title"}).AddRow(3, time.Now().Add(24*time.Hour), "Gala"))
+	mock.ExpectExec(`UPDATE "transactions" SET .*"status"=`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "notifications"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"))
+	mock.ExpectQuery(`INSERT INTO "notification_recipients"`).
+		WithArgs("0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c", uint(11), nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("6f1e2d3c-4b5a-4968-8776-a5b4c3d2e1f0"))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// Only the 2 hour reminder of the default schedule is still ahead
+	mock.ExpectQuery(`INSERT INTO "job_tasks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	// Ticket links texted to holders who opted in
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(`SELECT "id" FROM "webhook_endpoints" WHERE .* AND events @> \$\d+`).
+		WithArgs(sqlmock.AnyArg(), true, `["booking.completed"]`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(`UPDATE "bookings" SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "reservations" SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "payment_discrepancies" .* ON CONFLICT \("fingerprint"\) DO NOTHING`).
+		WithArgs(uint(1), sqlmock.AnyArg(), "pi_missed", "status_mismatch", "paid", "processing", "fixed",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("8a3f3a52-3f5b-4b2e-9a0d-6b1a3f0c2d01"))
+	mock.ExpectCommit()
+
+	// Disputed charge is flagged for review
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "transactions"`).
+		WithArgs("pi_disputed", "req-disputed", 1).
+		WillReturnRows(sqlmock.NewRows(txnColumns).
+			AddRow("5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b10", 5000, 5000, 175, "usd", 4825, "usd", "req-disputed", "paid", "pi_disputed"))
+	mock.ExpectQuery(`INSERT INTO "payment_discrepancies" .* ON CONFLICT \("fingerprint"\) DO NOTHING`).
+		WithArgs(uint(1), sqlmock.AnyArg(), "pi_disputed", "disputed", "disputed", "paid", "open",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("8a3f3a52-3f5b-4b2e-9a0d-6b1a3f0c2d02"))
+	mock.ExpectCommit()
+
+	// Paid transactions found in Stripe are not reported as missing
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE id IN \(SELECT bookings.transaction_id FROM "bookings" JOIN events`).
+		WillReturnRows(sqlmock.NewRows(txnColumns).
+			AddRow("5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b10", 5000, 5000, 175, "usd", 4825, "usd", "req-disputed", "paid", "pi_disputed"))
+
+	account := "acct_test"
+	err := ReconcileOrganization(&models.Organization{ID: 1, StripeAccountID: &account}, time.Now().Add(-ReconciliationWindow))
+	assert.Nil(t, err)
+	assert.Equal(t, account, stripeAccount)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,263 @@
+package common
+
+import (
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/lib/mailer"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+var ErrEventNotFound = errors.New("event not found")
+
+// scheduleBookingReminders schedules a reminder job per booking and reminder of the event that is still
+// ahead, through the outbox of the given transaction
+func scheduleBookingReminders(tx *gorm.DB, event *models.Event, bookingIds ...uint) error {
+	if event.DateTime == nil {
+		return nil
+	}
+	topic := broker.Topic("EventReminders")
+	for _, bookingId := range bookingIds {
+		for _, offset := range event.ReminderOffsets() {
+			runsAt := event.DateTime.Add(-offset).UTC().Truncate(time.Minute)
+			if !runsAt.After(time.Now()) {
+				continue
+			}
+			jobTaskID := uuid.New()
+			payloadId := jobTaskID.String()
+			jobTask := models.JobTask{
+				ID:     jobTaskID,
+				Name:   broker.Topic(fmt.Sprintf("Booking_%d_Reminder_%d", bookingId, int(offset.Minutes()))),
+				RunsAt: runsAt,
+				HandlerParams: []any{
+					bookingId,
+				},
+				PayloadID: payloadId,
+				Payload: map[string]any{
+					"payloadId":        payloadId,
+					"id":               int64(bookingId),
+					"eventId":          int64(event.ID),
+					"startsAt":         event.DateTime.UTC().Format(time.RFC3339),
+					"producerClientId": "EventRemindersProducer",
+					"topic":            topic,
+					"table":            "bookings",
+				},
+				Source:     "Booking",
+				SourceType: "table",
+				Topic:      topic,
+			}
+			if err := jobTask.CreateOutboxJobTask(tx, "Booking", bookingId); err != nil {
+				log.Printf("Error creating reminder job for Booking: id=%d error=%s\n", bookingId, err.Error())
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateEventReminders replaces the reminder schedule of an event and reschedules the reminders of its
+// completed bookings. Nil restores DefaultEventReminders. Returns the schedule before and after.
+func UpdateEventReminders(eventId uint, reminders []uint) ([]uint, []uint, error) {
+	var before []uint
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var event models.Event
+		if err := tx.
+			Select("id", "date_time", "timezone", "reminders").
+			Where(&models.Event{ID: eventId}).
+			First(&event).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEventNotFound
+			}
+			return err
+		}
+		before = event.Reminders
+		event.Reminders = reminders
+		if err := tx.
+			Model(&event).
+			Select("reminders").
+			Updates(&event).
+			Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Model(&models.JobTask{}).
+			Where("topic = ? AND status = ? AND payload ->> 'eventId' = ?", broker.Topic("EventReminders"), "pending", fmt.Sprint(eventId)).
+			Update("status", "canceled").
+			Error; err != nil {
+			return err
+		}
+		var bookingIds []uint
+		if err := tx.
+			Model(&models.Booking{}).
+			Where("event_id = ? AND status = ?", eventId, types.BOOKING_COMPLETED).
+			Pluck("id", &bookingIds).
+			Error; err != nil {
+			return err
+		}
+		return scheduleBookingReminders(tx, &event, bookingIds...)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, reminders, nil
+}
+
+// EventRemindersConsumer reminds the holder of a booking of its event. Reminders of canceled
+// bookings, of rescheduled events and of replaced schedules are dropped, and only the reservations
+// the holder still has are listed.
+func EventRemindersConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	payloadId := gjson.Get(spayload, "payloadId").String()
+	bookingId := uint(gjson.Get(spayload, "id").Int())
+	startsAt, err := time.Parse(time.RFC3339, gjson.Get(spayload, "startsAt").String())
+	if err != nil {
+		return PermanentError("invalid startsAt", err)
+	}
+	db := db.GetDb()
+	var job models.JobTask
+	if err := db.
+		Select("id", "status").
+		Where(&models.JobTask{PayloadID: payloadId}).
+		Limit(1).
+		Find(&job).
+		Error; err != nil {
+		return RetryableError(fmt.Sprintf("could not get job %s", payloadId), err)
+	}
+	if job.Status == "canceled" || job.Status == "done" {
+		return nil
+	}
+	var booking models.Booking
+	if err := db.
+		Preload("Event").
+		Where("id = ?", bookingId).
+		First(&booking).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PermanentError(fmt.Sprintf("booking %d not found", bookingId), err)
+		}
+		return RetryableError(fmt.Sprintf("could not get booking %d", bookingId), err)
+	}
+	event := booking.Event
+	if booking.Status != types.BOOKING_COMPLETED || event == nil || event.DateTime == nil || !event.DateTime.Equal(startsAt) ||
+		event.Status == types.EVENT_CANCELED || event.Status == types.EVENT_COMPLETED {
+		log.Printf("[EventReminders] Skipping reminder of Booking [%d]\n", bookingId)
+		return markJobDone(payloadId)
+	}
+	tickets, err := heldTickets(bookingId)
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not get reservations of booking %d", bookingId), err)
+	}
+	if len(tickets) == 0 {
+		log.Printf("[EventReminders] Booking [%d] has no reservations left\n", bookingId)
+		return markJobDone(payloadId)
+	}
+	// Push and SMS have no locale of their own, the time is still shown in the timezone of the event
+	text := fmt.Sprintf("%s at %s starts %s", event.Title, event.Location, mailer.FormatDateTime(event.DateTime, event.Timezone, mailer.DefaultLocale))
+	if err := DispatchToUsers(&Message{
+		Category: types.NOTIFY_BOOKINGS,
+		Text:     text,
+		SMS:      fmt.Sprintf("Reminder: %s. Your tickets: %s", text, ticketURLs(tickets)),
+		Template: mailer.TemplateEventReminder,
+		TemplateData: mailer.EventReminderEmail{
+			Event:   eventView(event),
+			URL:     eventTicketsURL(event),
+			Tickets: tickets,
+		},
+		OrganizationID: event.OrganizerID,
+		Data: map[string]string{
+			"type":      "event.reminder",
+			"eventId":   fmt.Sprint(event.ID),
+			"bookingId": fmt.Sprint(booking.ID),
+		},
+	}, booking.UserID); err != nil {
+		log.Printf("[EventReminders] Error dispatching reminder of Booking [%d]: %s\n", bookingId, err.Error())
+	}
+	return markJobDone(payloadId)
+}
+
+// heldTickets returns links to the reservations of a booking its holder still has, leaving out
+// canceled and transferred ones
+func heldTickets(bookingId uint) ([]mailer.TicketLink, error) {
+	var reservations []models.Reservation
+	db := db.GetDb()
+	if err := db.
+		Preload("Ticket").
+		Where("booking_id = ?", bookingId).
+		Where("status NOT IN (?)", []types.ReservationStatus{types.RESERVATION_CANCELED, types.RESERVATION_TRANSFERRED}).
+		Order("id").
+		Find(&reservations).
+		Error; err != nil {
+		return nil, err
+	}
+	tickets := make([]mailer.TicketLink, 0, len(reservations))
+	for _, r := range reservations {
+		name := "Ticket"
+		if r.Ticket != nil && r.Ticket.Tier != "" {
+			name = r.Ticket.Tier
+		}
+		tickets = append(tickets, mailer.TicketLink{
+			Name: name,
+			URL:  fmt.Sprintf("%s/reservations/%d", config.APP_HOST, r.ID),
+		})
+	}
+	return tickets, nil
+}
+
+func ticketURLs(tickets []mailer.TicketLink) string {
+	urls := make([]string, 0, len(tickets))
+	for _, t := range tickets {
+		urls = append(urls, t.URL)
+	}
+	return strings.Join(urls, " ")
+}
+
+// TicketLinksToSendConsumer texts the links of their tickets to holders of confirmed bookings who opted in to text messages
+func TicketLinksToSendConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	bookingId := uint(gjson.Get(spayload, "id").Int())
+	var booking models.Booking
+	db := db.GetDb()
+	if err := db.
+		Preload("Event", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "title", "organizer_id") }).
+		Select("id", "user_id", "event_id").
+		Where("id = ?", bookingId).
+		First(&booking).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PermanentError(fmt.Sprintf("booking %d not found", bookingId), err)
+		}
+		return RetryableError(fmt.Sprintf("could not get booking %d", bookingId), err)
+	}
+	tickets, err := heldTickets(bookingId)
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not get reservations of booking %d", bookingId), err)
+	}
+	if len(tickets) == 0 || booking.Event == nil {
+		return nil
+	}
+	if err := DispatchToUsers(&Message{
+		Category:       types.NOTIFY_BOOKINGS,
+		SMS:            fmt.Sprintf("Your tickets for %s: %s", booking.Event.Title, ticketURLs(tickets)),
+		OrganizationID: booking.Event.OrganizerID,
+		Channels:       []types.NotificationChannel{types.CHANNEL_SMS},
+	}, booking.UserID); err != nil {
+		log.Printf("[TicketLinksToSend] Error texting tickets of Booking [%d]: %s\n", bookingId, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestScheduleBookingReminders(t *testing.T) {
+	gormDB, mock := dbtest.NewMockDB(t)
+
+	startsAt := time.Now().Add(72 * time.Hour)
+
+	t.Run("skips reminders already past", func(t *testing.T) {
+		// 24 hours and 2 hours before, the 7 day reminder is past
+		mock.ExpectBegin()
+		for i := range 2 {
+			mock.ExpectQuery(`INSERT INTO "job_tasks"`).
+				WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+			mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 1))
+		}
+		mock.ExpectCommit()
+		event := &models.Event{ID: 3, DateTime: &startsAt}
+		assert.Nil(t, gormDB.Transaction(func(tx *gorm.DB) error {
+			return scheduleBookingReminders(tx, event, 7)
+		}))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("schedules nothing when reminders are off", func(t *testing.T) {
+		event := &models.Event{ID: 3, DateTime: &startsAt, Reminders: []uint{}}
+		assert.Nil(t, scheduleBookingReminders(gormDB, event, 7))
+		event = &models.Event{ID: 3}
+		assert.Nil(t, scheduleBookingReminders(gormDB, event, 7))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEventRemindersConsumer(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	payload := `{"payloadId":"job-1","id":7,"eventId":3,"startsAt":"2026-03-14T10:00:00Z"}`
+
+	t.Run("drops reminders of replaced schedules", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT "id","status" FROM "job_tasks" WHERE "job_tasks"."payload_id" = \$1`).
+			WithArgs("job-1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", "canceled"))
+		assert.Nil(t, EventRemindersConsumer(payload))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("drops reminders of rescheduled events", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT "id","status" FROM "job_tasks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", "pending"))
+		mock.ExpectQuery(`SELECT \* FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "event_id", "user_id"}).AddRow(7, "completed", 3, 11))
+		mock.ExpectQuery(`SELECT \* FROM "events"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "date_time", "status"}).
+				AddRow(3, time.Date(2026, time.March, 15, 10, 0, 0, 0, time.UTC), "registration"))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "job_tasks" SET "status"=\$1`).
+			WithArgs("done", sqlmock.AnyArg(), "job-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.Nil(t, EventRemindersConsumer(payload))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const (
+	RetryMaxAttempts = 5
+	RetryBaseDelay   = 2 * time.Second
+	RetryMaxDelay    = 5 * time.Minute
+)
+
+// ConsumerFunc handles a message, returning an error when the message could not be processed
+type ConsumerFunc func(payload string) error
+
+// HandlerError describes why a consumer failed to process a message.
+// Messages failing with a non retryable error are sent to the DLQ right away.
+type HandlerError struct {
+	Reason    string
+	Retryable bool
+	Err       error
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err == nil {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Err.Error())
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+func RetryableError(reason string, err error) error {
+	return &HandlerError{Reason: reason, Retryable: true, Err: err}
+}
+
+func PermanentError(reason string, err error) error {
+	return &HandlerError{Reason: reason, Retryable: false, Err: err}
+}
+
+var (
+	consumers   = make(map[string]ConsumerFunc)
+	consumersMu sync.RWMutex
+)
+
+// WithRetry registers fn as the consumer of a topic and returns a handler that retries failed messages
+// with exponential backoff before sending them to the DLQ
+func WithRetry(topic string, fn ConsumerFunc) types.Handler {
+	consumersMu.Lock()
+	consumers[topic] = fn
+	consumersMu.Unlock()
+	return func(payload string) {
+		handleWithRetry(topic, fn, payload, 1)
+	}
+}
+
+// RetryBackoff returns the delay before the next attempt, doubling from RetryBaseDelay up to RetryMaxDelay
+func RetryBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 16 {
+		return RetryMaxDelay
+	}
+	d := RetryBaseDelay << (attempt - 1)
+	if d > RetryMaxDelay {
+		return RetryMaxDelay
+	}
+	return d
+}
+
+func handleWithRetry(topic string, fn ConsumerFunc, payload string, attempt int) {
+	err := fn(payload)
+	if err == nil {
+		return
+	}
+	log.Printf("[%s] Failed to handle message on attempt %d: %s\n", topic, attempt, err.Error())
+	var herr *HandlerError
+	if errors.As(err, &herr) && !herr.Retryable {
+		sendToDeadLetters(topic, payload, attempt, err)
+		return
+	}
+	if attempt >= RetryMaxAttempts {
+		sendToDeadLetters(topic, payload, attempt, err)
+		return
+	}
+	retryAt := time.Now().Add(RetryBackoff(attempt))
+	if serr := scheduleRetry(types.JSONB{
+		"topic":   topic,
+		"payload": payload,
+		"attempt": attempt + 1,
+		"reason":  err.Error(),
+		"retryAt": retryAt.Format(time.RFC3339Nano),
+	}, retryAt); serr != nil {
+		log.Printf("[%s] Could not schedule retry: %s\n", topic, serr.Error())
+		sendToDeadLetters(topic, payload, attempt, err)
+	}
+}
+
+// scheduleRetry stores a retry in the outbox, which publishes it to the Retry topic once retryAt is
+// reached. Pending retries survive restarts.
+func scheduleRetry(retry types.JSONB, retryAt time.Time) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Each retry is its own aggregate so it is not held back by other messages
+		return models.EnqueueOutboxMessage(tx, "Retry", uuid.New(), broker.Topic("Retry"), retry, retryAt)
+	})
+}
+
+func sendToDeadLetters(topic string, payload string, attempts int, reason error) {
+	body := &types.JSONB{
+		"topic":    topic,
+		"payload":  payload,
+		"attempts": attempts,
+		"reason":   reason.Error(),
+	}
+	if err := broker.Publish(broker.Topic("DLQ"), body); err != nil {
+		log.Printf("[%s] Could not send message to DLQ, storing it directly: %s\n", topic, err.Error())
+		b, _ := json.Marshal(body)
+		DeadLetterConsumer(string(b))
+	}
+}
+
+// RetryConsumer runs a failed message through its original consumer once its backoff has elapsed
+func RetryConsumer(spayload string) {
+	if !gjson.Valid(spayload) {
+		log.Println("[Retry] Received invalid json body. Aborting")
+		return
+	}
+	topic := gjson.Get(spayload, "topic").String()
+	payload := gjson.Get(spayload, "payload").String()
+	attempt := int(gjson.Get(spayload, "attempt").Int())
+	retryAt, err := time.Parse(time.RFC3339Nano, gjson.Get(spayload, "retryAt").String())
+	if err != nil {
+		retryAt = time.Now()
+	}
+	consumersMu.RLock()
+	fn, ok := consumers[topic]
+	consumersMu.RUnlock()
+	if !ok {
+		sendToDeadLetters(topic, payload, attempt, fmt.Errorf("no consumer registered for topic %s", topic))
+		return
+	}
+	if time.Until(retryAt) > 0 {
+		// Delivered early, wait in the outbox until it is due
+		var retry types.JSONB
+		err := json.Unmarshal([]byte(spayload), &retry)
+		if err == nil {
+			err = scheduleRetry(retry, retryAt)
+		}
+		if err != nil {
+			log.Printf("[%s] Could not schedule retry: %s\n", topic, err.Error())
+			sendToDeadLetters(topic, payload, attempt, err)
+		}
+		return
+	}
+	log.Printf("[%s] Retrying message, attempt %d\n", topic, attempt)
+	handleWithRetry(topic, fn, payload, attempt)
+}
+
+// DeadLetterConsumer stores messages sent to the DLQ so they can be inspected and replayed
+func DeadLetterConsumer(spayload string) {
+	if !gjson.Valid(spayload) {
+		log.Println("[DLQ] Received invalid json body. Aborting")
+		return
+	}
+	deadLetter := models.DeadLetter{
+		Topic:    gjson.Get(spayload, "topic").String(),
+		Payload:  gjson.Get(spayload, "payload").String(),
+		Reason:   gjson.Get(spayload, "reason").String(),
+		Attempts: int(gjson.Get(spayload, "attempts").Int()),
+		Status:   types.DEAD_LETTER_PENDING,
+	}
+	db := db.GetDb()
+	if err := db.Create(&deadLetter).Error; err != nil {
+		log.Printf("[DLQ] Error storing message for topic %s: %s\n", deadLetter.Topic, err.Error())
+		return
+	}
+	log.Printf("[DLQ] Stored message %s for topic %s: %s\n", deadLetter.ID, deadLetter.Topic, deadLetter.Reason)
+}
+
+// ReplayDeadLetter queues a pending dead letter back to its original topic through the outbox
+func ReplayDeadLetter(id uuid.UUID, userId uint) (*models.DeadLetter, error) {
+	return resolveDeadLetter(id, userId, types.DEAD_LETTER_REPLAYED, func(tx *gorm.DB, deadLetter *models.DeadLetter) error {
+		var payload types.JSONB
+		if err := json.Unmarshal([]byte(deadLetter.Payload), &payload); err != nil {
+			return err
+		}
+		return models.EnqueueOutboxMessage(tx, "DeadLetter", deadLetter.ID, deadLetter.Topic, payload)
+	})
+}
+
+// DiscardDeadLetter marks a pending dead letter as discarded without processing it
+func DiscardDeadLetter(id uuid.UUID, userId uint) (*models.DeadLetter, error) {
+	return resolveDeadLetter(id, userId, types.DEAD_LETTER_DISCARDED, nil)
+}
+
+// resolveDeadLetter claims a pending dead letter with a conditional update so concurrent resolutions of
+// the same dead letter apply once, then runs fn in the same transaction
+func resolveDeadLetter(id uuid.UUID, userId uint, status types.DeadLetterStatus, fn func(*gorm.DB, *models.DeadLetter) error) (*models.DeadLetter, error) {
+	var deadLetter models.DeadLetter
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.
+			Model(&models.DeadLetter{}).
+			Where(&models.DeadLetter{ID: id, Status: types.DEAD_LETTER_PENDING}).
+			Updates(&models.DeadLetter{
+				Status:     status,
+				ResolvedBy: &userId,
+				ResolvedAt: &now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.
+			Where(&models.DeadLetter{ID: id}).
+			First(&deadLetter).
+			Error; err != nil {
+			return err
+		}
+		if fn != nil {
+			return fn(tx, &deadLetter)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"database/sql/driver"
+	"ebs/src/db/dbtest"
+	"ebs/src/lib/broker"
+	"ebs/src/types"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, RetryBaseDelay, RetryBackoff(1))
+	assert.Equal(t, 2*RetryBaseDelay, RetryBackoff(2))
+	assert.Equal(t, 8*RetryBaseDelay, RetryBackoff(4))
+	assert.Equal(t, RetryMaxDelay, RetryBackoff(100))
+}
+
+// outboxPayload captures the payloads of the outbox messages it is matched against
+type outboxPayload struct {
+	payloads *[]string
+}
+
+func (o outboxPayload) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if ok {
+		*o.payloads = append(*o.payloads, s)
+	}
+	return ok
+}
+
+func TestWithRetry(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	b := broker.NewMemoryBroker()
+	broker.NewBroker(b)
+	defer broker.NewBroker(nil)
+
+	retries := make([]string, 0)
+	deadLetters := make([]string, 0)
+	b.Subscribe("dlq", broker.Topic("DLQ"), func(payload string) {
+		deadLetters = append(deadLetters, payload)
+	})
+
+	// Retries wait in the outbox until they are due
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs("Retry", sqlmock.AnyArg(), broker.Topic("Retry"), outboxPayload{&retries}, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	WithRetry("Flaky_test", func(payload string) error {
+		return errors.New("connection reset")
+	})(`{"id":1}`)
+	assert.Len(t, retries, 1)
+	assert.Empty(t, deadLetters)
+	assert.Equal(t, "Flaky_test", gjson.Get(retries[0], "topic").String())
+	assert.Equal(t, `{"id":1}`, gjson.Get(retries[0], "payload").String())
+	assert.Equal(t, int64(2), gjson.Get(retries[0], "attempt").Int())
+	retryAt, err := time.Parse(time.RFC3339Nano, gjson.Get(retries[0], "retryAt").String())
+	assert.Nil(t, err)
+	assert.True(t, retryAt.After(time.Now()))
+
+	WithRetry("Broken_test", func(payload string) error {
+		return PermanentError("invalid json body", nil)
+	})(`{`)
+	assert.Len(t, retries, 1)
+	assert.Len(t, deadLetters, 1)
+	assert.Equal(t, "Broken_test", gjson.Get(deadLetters[0], "topic").String())
+	assert.Equal(t, "invalid json body", gjson.Get(deadLetters[0], "reason").String())
+
+	// The last attempt goes to the DLQ instead of the Retry topic
+	handleWithRetry("Flaky_test", func(payload string) error {
+		return RetryableError("timeout", nil)
+	}, `{"id":1}`, RetryMaxAttempts)
+	assert.Len(t, retries, 1)
+	assert.Len(t, deadLetters, 2)
+	assert.Equal(t, int64(RetryMaxAttempts), gjson.Get(deadLetters[1], "attempts").Int())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	id := "3a7e5c1d-9b2f-4d8e-a6c4-1f0b7d3e5a9c"
+	expectClaim := func(mock sqlmock.Sqlmock, claimed int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "dead_letters" SET "status"=\$1,"resolved_by"=\$2,"resolved_at"=\$3,"updated_at"=\$4 WHERE \("dead_letters"."id" = \$5 AND "dead_letters"."status" = \$6\)`).
+			WithArgs(types.DEAD_LETTER_REPLAYED, 12, sqlmock.AnyArg(), sqlmock.AnyArg(), id, types.DEAD_LETTER_PENDING).
+			WillReturnResult(sqlmock.NewResult(0, claimed))
+	}
+
+	t.Run("queues the message through the outbox", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		expectClaim(mock, 1)
+		mock.ExpectQuery(`SELECT \* FROM "dead_letters" WHERE "dead_letters"."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "payload", "status"}).
+				AddRow(id, "Bookings_test", `{"id":1}`, types.DEAD_LETTER_REPLAYED))
+		var payloads []string
+		mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+			WithArgs("DeadLetter", id, "Bookings_test", outboxPayload{&payloads}, types.OUTBOX_PENDING, 0, nil, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		deadLetter, err := ReplayDeadLetter(uuid.MustParse(id), 12)
+		assert.Nil(t, err)
+		assert.Equal(t, types.DEAD_LETTER_REPLAYED, deadLetter.Status)
+		assert.Equal(t, []string{`{"id":1}`}, payloads)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("replays a dead letter once", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		expectClaim(mock, 0)
+		mock.ExpectRollback()
+
+		_, err := ReplayDeadLetter(uuid.MustParse(id), 12)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,311 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/types"
+	"ebs/src/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshTokenTTL is how long a session stays signed in without being refreshed
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrInvalidRefreshToken  = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used, the session has been revoked")
+	ErrSessionRevoked       = errors.New("session has been revoked")
+	ErrSessionTokenRequired = errors.New("access token is not bound to a session")
+)
+
+// StartSession signs the user in on a new session and returns its access and refresh tokens
+func StartSession(email string, userId uint, orgId uint, userAgent string, ip string) (*types.AuthTokens, error) {
+	sessionId := uuid.NewString()
+	var refreshToken string
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refreshToken, err = createRefreshToken(tx, userId, sessionId, time.Now(), userAgent, ip)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(email, userId, orgId, sessionId, refreshToken)
+}
+
+// RefreshSession rotates refreshToken and returns new tokens for its session.
+// Using a refresh token that was already rotated revokes the whole session.
+func RefreshSession(refreshToken string, userAgent string, ip string) (*types.AuthTokens, error) {
+	var user models.User
+	var sessionId, nextToken string
+	reused := false
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var token models.Token
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.Token{Type: models.TokenTypeSession}).
+			Where("token_value ->> 'hash' = ?", hashRefreshToken(refreshToken)).
+			First(&token).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		sessionId, _ = token.TokenValue["sessionId"].(string)
+		switch token.Status {
+		case models.TokenStatusActive:
+		case models.TokenStatusRotated:
+			reused = true
+			if _, err := revokeSessions(tx, token.RequestedBy, []string{sessionId}); err != nil {
+				return err
+			}
+			return denySessions(sessionId)
+		default:
+			return ErrInvalidRefreshToken
+		}
+		if token.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.
+			Model(&models.Token{}).
+			Where("id = ?", token.ID).
+			Update("status", models.TokenStatusRotated).
+			Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Select("id", "email", "active_org").
+			Where(&models.User{ID: token.RequestedBy}).
+			First(&user).
+			Error; err != nil {
+			return err
+		}
+		startedAt, err := time.Parse(time.RFC3339, fmt.Sprint(token.TokenValue["startedAt"]))
+		if err != nil {
+			startedAt = *token.CreatedAt
+		}
+		nextToken, err = createRefreshToken(tx, user.ID, sessionId, startedAt, userAgent, ip)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return issueTokens(user.Email, user.ID, user.ActiveOrg, sessionId, nextToken)
+}
+
+// ListSessions returns the signed in sessions of the user, current is the session of the request
+func ListSessions(userId uint, current string) ([]types.UserSession, error) {
+	tokens := make([]models.Token, 0)
+	db := db.GetDb()
+	if err := db.
+		Where(&models.Token{Type: models.TokenTypeSession, RequestedBy: userId, Status: models.TokenStatusActive}).
+		Where("created_at + ttl * interval '1 second' > ?", time.Now()).
+		Order("created_at desc").
+		Find(&tokens).
+		Error; err != nil {
+		return nil, err
+	}
+	sessions := make([]types.UserSession, 0, len(tokens))
+	for _, token := range tokens {
+		sessionId, _ := token.TokenValue["sessionId"].(string)
+		userAgent, _ := token.TokenValue["userAgent"].(string)
+		ip, _ := token.TokenValue["ip"].(string)
+		startedAt, err := time.Parse(time.RFC3339, fmt.Sprint(token.TokenValue["startedAt"]))
+		if err != nil {
+			startedAt = *token.CreatedAt
+		}
+		sessions = append(sessions, types.UserSession{
+			ID:         sessionId,
+			UserAgent:  userAgent,
+			IPAddress:  ip,
+			Current:    sessionId == current,
+			CreatedAt:  startedAt,
+			LastUsedAt: *token.CreatedAt,
+			ExpiresAt:  token.ExpiresAt,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs the user out of a session. Its access tokens are rejected from then on.
+func RevokeSession(userId uint, sessionId string) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		revoked, err := revokeSessions(tx, userId, []string{sessionId})
+		if err != nil {
+			return err
+		}
+		if revoked == 0 {
+			return ErrSessionNotFound
+		}
+		return denySessions(sessionId)
+	})
+}
+
+// RevokeOtherSessions signs the user out of every session but current and returns how many were revoked
+func RevokeOtherSessions(userId uint, current string) (int, error) {
+	var sessionIds []string
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Model(&models.Token{}).
+			Where(&models.Token{Type: models.TokenTypeSession, RequestedBy: userId, Status: models.TokenStatusActive}).
+			Where("token_value ->> 'sessionId' <> ?", current).
+			Pluck("token_value ->> 'sessionId'", &sessionIds).
+			Error; err != nil {
+			return err
+		}
+		if len(sessionIds) == 0 {
+			return nil
+		}
+		if _, err := revokeSessions(tx, userId, sessionIds); err != nil {
+			return err
+		}
+		return denySessions(sessionIds...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(sessionIds), nil
+}
+
+// IsSessionRevoked checks the session of an access token against the denylist, and against the database
+// when the denylist is unavailable
+func IsSessionRevoked(ctx context.Context, sessionId string) (bool, error) {
+	if rd := lib.GetRedisClient(); rd != nil {
+		n, err := rd.Exists(ctx, sessionDenylistKey(sessionId)).Result()
+		if err == nil {
+			return n > 0, nil
+		}
+		log.Printf("[redis] Error checking session denylist: %s\n", err.Error())
+	}
+	var count int64
+	db := db.GetDb()
+	if err := db.
+		Model(&models.Token{}).
+		Where(&models.Token{Type: models.TokenTypeSession, Status: models.TokenStatusActive}).
+		Where("token_value ->> 'sessionId' = ?", sessionId).
+		Count(&count).
+		Error; err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// VerifyAccessToken validates an access token and the session it is bound to, for connections that
+// don't go through the auth middleware
+func VerifyAccessToken(ctx context.Context, token string) (*types.Claims, error) {
+	claims := &types.Claims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, utils.VerificationKey, jwt.WithValidMethods(utils.SigningMethods()))
+	if err != nil {
+		return nil, err
+	}
+	if !tkn.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.Session == "" {
+		return nil, ErrSessionTokenRequired
+	}
+	revoked, err := IsSessionRevoked(ctx, claims.Session)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+func issueTokens(email string, userId uint, orgId uint, sessionId string, refreshToken string) (*types.AuthTokens, error) {
+	accessToken, err := utils.GenerateJWT(email, userId, orgId, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(utils.AccessTokenTTL),
+	}, nil
+}
+
+func createRefreshToken(tx *gorm.DB, userId uint, sessionId string, startedAt time.Time, userAgent string, ip string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(b)
+	token := models.Token{
+		RequestedBy:   userId,
+		RequesterType: "user",
+		Type:          models.TokenTypeSession,
+		TokenName:     "refresh_token",
+		TokenValue: types.JSONB{
+			"hash":      hashRefreshToken(secret),
+			"sessionId": sessionId,
+			"startedAt": startedAt.Format(time.RFC3339),
+			"userAgent": userAgent,
+			"ip":        ip,
+		},
+		TTL:    uint(RefreshTokenTTL.Seconds()),
+		Status: models.TokenStatusActive,
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func revokeSessions(tx *gorm.DB, userId uint, sessionIds []string) (int64, error) {
+	res := tx.
+		Model(&models.Token{}).
+		Where(&models.Token{Type: models.TokenTypeSession, RequestedBy: userId}).
+		Where("status IN ?", []string{models.TokenStatusActive, models.TokenStatusRotated}).
+		Where("token_value ->> 'sessionId' IN ?", sessionIds).
+		Update("status", models.TokenStatusRevoked)
+	return res.RowsAffected, res.Error
+}
+
+// denySessions rejects access tokens of the sessions until they expire. It is called before the revocation
+// commits so a session is not reported revoked while its access tokens still pass the denylist.
+func denySessions(sessionIds ...string) error {
+	rd := lib.GetRedisClient()
+	if rd == nil {
+		return nil
+	}
+	for _, sessionId := range sessionIds {
+		if err := rd.Set(context.Background(), sessionDenylistKey(sessionId), 1, utils.AccessTokenTTL).Err(); err != nil {
+			log.Printf("[redis] Error adding session [%s] to denylist: %s\n", sessionId, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func sessionDenylistKey(sessionId string) string {
+	return fmt.Sprintf("%s:session:revoked", sessionId)
+}
+
+func hashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
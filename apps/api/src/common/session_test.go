@@ -0,0 +1,111 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/utils"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSession(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	signingKey, err := utils.NewJWTKey("EdDSA")
+	assert.Nil(t, err)
+	utils.NewSigningKeys(signingKey)
+
+	secret := "5f0e7d2c"
+	sessionId := "8e0c1f4a-3b2d-4c5e-9f6a-7b8c9d0e1f2a"
+	tokenColumns := []string{"id", "requested_by", "type", "token_value", "ttl", "status", "created_at"}
+	tokenValue := []byte(`{"hash":"` + hashRefreshToken(secret) + `","sessionId":"` + sessionId + `","startedAt":"2026-10-01T10:00:00Z"}`)
+	ttl := int(RefreshTokenTTL.Seconds())
+
+	t.Run("rotates the refresh token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens" WHERE .*token_value ->> 'hash' = \$\d+ .*FOR UPDATE`).
+			WithArgs(models.TokenTypeSession, hashRefreshToken(secret), 1).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow("0f1e2d3c-4b5a-4978-8695-a4b3c2d1e0f9", 12, "session", tokenValue, ttl, models.TokenStatusActive, time.Now()))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs(models.TokenStatusRotated, sqlmock.AnyArg(), "0f1e2d3c-4b5a-4978-8695-a4b3c2d1e0f9").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT "id","email","active_org" FROM "users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "active_org"}).AddRow(12, "user@example.com", 0))
+		mock.ExpectQuery(`INSERT INTO "tokens"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("6a5b4c3d-2e1f-4a0b-9c8d-7e6f5a4b3c2d"))
+		mock.ExpectCommit()
+
+		tokens, err := RefreshSession(secret, "test", "127.0.0.1")
+		assert.Nil(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEqual(t, secret, tokens.RefreshToken)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("revokes the session when a rotated token is reused", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow("0f1e2d3c-4b5a-4978-8695-a4b3c2d1e0f9", 12, "session", tokenValue, ttl, models.TokenStatusRotated, time.Now()))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1.* token_value ->> 'sessionId' IN \(\$\d+\)`).
+			WithArgs(models.TokenStatusRevoked, sqlmock.AnyArg(), 12, models.TokenTypeSession, models.TokenStatusActive, models.TokenStatusRotated, sessionId).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		tokens, err := RefreshSession(secret, "test", "127.0.0.1")
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		assert.Nil(t, tokens)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejects unknown tokens", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).
+			WillReturnRows(sqlmock.NewRows(tokenColumns))
+		mock.ExpectRollback()
+
+		_, err := RefreshSession("unknown", "test", "127.0.0.1")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRevokeSession(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+	rc, rmock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+
+	sessionId := "8e0c1f4a-3b2d-4c5e-9f6a-7b8c9d0e1f2a"
+
+	t.Run("denies the access tokens of the session", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs(models.TokenStatusRevoked, sqlmock.AnyArg(), 12, models.TokenTypeSession, models.TokenStatusActive, models.TokenStatusRotated, sessionId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		rmock.ExpectSet(sessionDenylistKey(sessionId), 1, utils.AccessTokenTTL).SetVal("OK")
+		mock.ExpectCommit()
+
+		assert.Nil(t, RevokeSession(12, sessionId))
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Nil(t, rmock.ExpectationsWereMet())
+	})
+
+	t.Run("keeps the session when the denylist is unavailable", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		rmock.ExpectSet(sessionDenylistKey(sessionId), 1, utils.AccessTokenTTL).SetErr(errors.New("connection refused"))
+		mock.ExpectRollback()
+
+		assert.NotNil(t, RevokeSession(12, sessionId))
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Nil(t, rmock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yeqown/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const (
+	// StaffCodeTTL is how long a staff code is valid unless an earlier expiry is requested
+	StaffCodeTTL = 12 * time.Hour
+	// StaffDeviceTTL is the longest a QR provisioned device token is valid
+	StaffDeviceTTL = 7 * 24 * time.Hour
+)
+
+// codeAlphabet leaves out characters that are easily mistaken for each other
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	ErrStaffCredentialNotFound = errors.New("staff credential not found")
+	ErrInvalidStaffCredential  = errors.New("invalid staff credential kind or expiry")
+	ErrEventClosed             = errors.New("event no longer accepts admissions")
+)
+
+// CreateStaffCredential mints a credential allowing its holder to admit reservations of the event.
+// The secret is only returned here, it is stored hashed.
+func CreateStaffCredential(eventId uint, createdBy uint, body types.CreateStaffCredentialRequestBody) (*models.StaffCredential, string, error) {
+	kind := body.Kind
+	if kind == "" {
+		kind = types.STAFF_CREDENTIAL_CODE
+	}
+	maxTTL := StaffCodeTTL
+	switch kind {
+	case types.STAFF_CREDENTIAL_CODE:
+	case types.STAFF_CREDENTIAL_DEVICE:
+		maxTTL = StaffDeviceTTL
+	default:
+		return nil, "", ErrInvalidStaffCredential
+	}
+	now := time.Now()
+	expiresAt := now.Add(maxTTL)
+	if body.ExpiresAt != nil {
+		if body.ExpiresAt.Before(now) || body.ExpiresAt.After(expiresAt) {
+			return nil, "", ErrInvalidStaffCredential
+		}
+		expiresAt = *body.ExpiresAt
+	}
+	secret, err := newStaffSecret(kind)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var cred models.StaffCredential
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var event models.Event
+		if err := tx.
+			Select("id", "organizer_id", "status").
+			Where(&models.Event{ID: eventId}).
+			First(&event).
+			Error; err != nil {
+			return err
+		}
+		if event.Status == types.EVENT_COMPLETED || event.Status == types.EVENT_CANCELED {
+			return ErrEventClosed
+		}
+		id := uuid.New()
+		user := models.User{
+			Name:   body.Name,
+			Email:  fmt.Sprintf("staff+%s@staff.invalid", id),
+			Role:   types.ROLE_STAFF,
+			Status: "active",
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		cred = models.StaffCredential{
+			ID:             id,
+			OrganizationID: event.OrganizerID,
+			EventID:        event.ID,
+			UserID:         user.ID,
+			Name:           body.Name,
+			Kind:           kind,
+			SecretHash:     models.HashStaffSecret(secret),
+			CreatedBy:      createdBy,
+			ExpiresAt:      expiresAt,
+		}
+		return tx.Create(&cred).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &cred, secret, nil
+}
+
+// ListStaffCredentials returns the staff credentials of an event, newest first
+func ListStaffCredentials(eventId uint) ([]models.StaffCredential, error) {
+	creds := make([]models.StaffCredential, 0)
+	db := db.GetDb()
+	if err := db.
+		Where(&models.StaffCredential{EventID: eventId}).
+		Order("created_at desc").
+		Find(&creds).
+		Error; err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// RevokeStaffCredential disables a staff credential of the event. It stops working on its next request.
+func RevokeStaffCredential(eventId uint, credId uuid.UUID, revokedBy uint) error {
+	now := time.Now()
+	db := db.GetDb()
+	res := db.
+		Model(&models.StaffCredential{}).
+		Where(&models.StaffCredential{ID: credId, EventID: eventId}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]any{"revoked_at": now, "revoked_by": revokedBy})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStaffCredentialNotFound
+	}
+	return nil
+}
+
+// StaffProvisioningQR returns a PNG data URI of the QR code a scanner device reads to provision a token
+func StaffProvisioningQR(cred *models.StaffCredential, secret string) (string, error) {
+	payload, err := json.Marshal(map[string]any{
+		"api":       config.API_HOST,
+		"eventId":   cred.EventID,
+		"token":     secret,
+		"expiresAt": cred.ExpiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return qrDataURI(string(payload))
+}
+
+// qrDataURI returns a PNG data URI of the QR code of payload
+func qrDataURI(payload string) (string, error) {
+	qrc, err := qrcode.New(payload, qrcode.WithBuiltinImageEncoder(qrcode.PNG_FORMAT))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := qrc.SaveTo(&buf); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// newStaffSecret returns a code short enough to be typed in, or a long random token for devices
+func newStaffSecret(kind types.StaffCredentialKind) (string, error) {
+	if kind == types.STAFF_CREDENTIAL_DEVICE {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,663 @@
+package common
+
+import (
+	"context"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/lib/broker"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v82"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoreStripeEvent records a verified webhook event and queues it for processing.
+// It returns false when the event has already been received.
+func StoreStripeEvent(event *stripe.Event, payload []byte) (*models.StripeEvent, bool, error) {
+	var raw types.JSONB
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, false, err
+	}
+	stripeEvent := models.StripeEvent{
+		EventID: event.ID,
+		Type:    string(event.Type),
+		Payload: raw,
+		Status:  types.STRIPE_EVENT_RECEIVED,
+	}
+	if event.Account != "" {
+		stripeEvent.Account = &event.Account
+	}
+	created := false
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}}, DoNothing: true}).
+			Create(&stripeEvent)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		return enqueueStripeEvent(tx, &stripeEvent)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &stripeEvent, created, nil
+}
+
+func enqueueStripeEvent(tx *gorm.DB, stripeEvent *models.StripeEvent) error {
+	return models.EnqueueOutboxMessage(tx, "StripeEvent", stripeEvent.EventID, broker.Topic("StripeEvents"), types.JSONB{
+		"id":      stripeEvent.ID.String(),
+		"eventId": stripeEvent.EventID,
+		"type":    stripeEvent.Type,
+	})
+}
+
+// ErrStripeEventNotReplayable is returned when replaying an event that was processed or is being processed
+var ErrStripeEventNotReplayable = errors.New("only failed stripe events or events stuck processing can be replayed")
+
+// stripeEventStuckAfter is how long an event is left processing before it is assumed its consumer crashed
+const stripeEventStuckAfter = 15 * time.Minute
+
+// ReplayStripeEvent queues a failed event, or one stuck processing, to be processed again. Processed events are
+// not replayed since their handlers emit webhooks and notifications again.
+func ReplayStripeEvent(id uuid.UUID) (*models.StripeEvent, error) {
+	var stripeEvent models.StripeEvent
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Where(&models.StripeEvent{ID: id}).
+			First(&stripeEvent).
+			Error; err != nil {
+			return err
+		}
+		res := tx.
+			Model(&stripeEvent).
+			Where("status = ? OR (status = ? AND updated_at < ?)",
+				types.STRIPE_EVENT_FAILED, types.STRIPE_EVENT_PROCESSING, time.Now().Add(-stripeEventStuckAfter)).
+			Updates(map[string]any{"status": types.STRIPE_EVENT_RECEIVED, "error": nil})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrStripeEventNotReplayable
+		}
+		return enqueueStripeEvent(tx, &stripeEvent)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stripeEvent, nil
+}
+
+// StripeEventsConsumer processes a stored Stripe webhook event, skipping events that were already processed.
+// The event is claimed before it is handled so concurrent deliveries apply it once. An event left
+// processing by a crashed consumer is recovered with ReplayStripeEvent once stripeEventStuckAfter passed.
+func StripeEventsConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	id, err := uuid.Parse(gjson.Get(spayload, "id").String())
+	if err != nil {
+		return PermanentError("invalid stripe event id", err)
+	}
+	var stripeEvent models.StripeEvent
+	db := db.GetDb()
+	if err := db.
+		Where(&models.StripeEvent{ID: id}).
+		First(&stripeEvent).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PermanentError(fmt.Sprintf("stripe event %s not found", id), err)
+		}
+		return RetryableError(fmt.Sprintf("could not retrieve stripe event %s", id), err)
+	}
+	claim := db.
+		Model(&models.StripeEvent{}).
+		Where("id = ? AND status NOT IN ?", id, []types.StripeEventStatus{types.STRIPE_EVENT_PROCESSING, types.STRIPE_EVENT_PROCESSED}).
+		Update("status", types.STRIPE_EVENT_PROCESSING)
+	if claim.Error != nil {
+		return RetryableError(fmt.Sprintf("could not claim stripe event %s", id), claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		log.Printf("[StripeEvent] %s has already been processed or is being processed. Skipping\n", stripeEvent.EventID)
+		return nil
+	}
+	b, _ := json.Marshal(stripeEvent.Payload)
+	var event stripe.Event
+	if err := json.Unmarshal(b, &event); err != nil {
+		return PermanentError("could not deserialize stripe event", err)
+	}
+	herr := HandleStripeEvent(&event)
+	updates := map[string]any{
+		"attempts": stripeEvent.Attempts + 1,
+		"status":   types.STRIPE_EVENT_PROCESSED,
+		"error":    nil,
+	}
+	if herr != nil {
+		updates["status"] = types.STRIPE_EVENT_FAILED
+		updates["error"] = herr.Error()
+	} else {
+		updates["processed_at"] = time.Now()
+	}
+	if err := db.Model(&stripeEvent).Updates(updates).Error; err != nil {
+		log.Printf("[StripeEvent] Error updating status of %s: %s\n", stripeEvent.EventID, err.Error())
+	}
+	return herr
+}
+
+// HandleStripeEvent applies a Stripe webhook event to the local records
+func HandleStripeEvent(event *stripe.Event) error {
+	log.Printf("[StripeEvent] %s\n", event.Type)
+	switch event.Type {
+	case "customer.created":
+		return handleCustomerCreated(event)
+	case "customer.subscription.created":
+		return handleSubscriptionCreated(event)
+	case "account.updated":
+		return handleAccountUpdated(event)
+	case "capability.updated":
+		var cap stripe.Capability
+		if err := json.Unmarshal(event.Data.Raw, &cap); err != nil {
+			return PermanentError("could not parse Capability", err)
+		}
+	case "invoice.paid":
+		return handleInvoicePaid(event)
+	case "payment_intent.created":
+		return handlePaymentIntentCreated(event)
+	case "payment_intent.succeeded":
+		return handlePaymentIntentSucceeded(event)
+	case "checkout.session.completed":
+		return handleCheckoutSessionCompleted(event)
+	case "charge.refunded":
+		return handleChargeRefunded(event)
+	}
+	return nil
+}
+
+func handleCustomerCreated(event *stripe.Event) error {
+	var cus stripe.Customer
+	if err := json.Unmarshal(event.Data.Raw, &cus); err != nil {
+		return PermanentError("could not parse Customer", err)
+	}
+	id := cus.Metadata["id"]
+	atoi, err := strconv.Atoi(id)
+	if err != nil {
+		return PermanentError(fmt.Sprintf("could not retrieve user id for customer %s", cus.ID), err)
+	}
+	userId := uint(atoi)
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Find(&user).
+			Error; err != nil {
+			log.Printf("Error while retrieving user info for Customer %s: %s\n", cus.ID, err.Error())
+			return errors.New("could not retrieve user information")
+		}
+
+		if err := tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Updates(&models.User{StripeCustomerId: &cus.ID}).
+			Error; err != nil {
+			log.Printf("Error updating user: %s\n", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not update user %d", userId), err)
+	}
+	return nil
+}
+
+func handleSubscriptionCreated(event *stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return PermanentError("could not parse Subscription", err)
+	}
+	id := sub.Metadata["id"]
+	atoi, err := strconv.Atoi(id)
+	if err != nil {
+		return PermanentError(fmt.Sprintf("could not retrieve user id for Subscription %s", sub.ID), err)
+	}
+	userId := uint(atoi)
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Find(&user).
+			Error; err != nil {
+			log.Printf("Error while retrieving user info for Subscription %s: %s\n", sub.ID, err.Error())
+			return errors.New("could not retrieve user information")
+		}
+
+		if err := tx.
+			Model(&models.User{}).
+			Where("id = ?", userId).
+			Updates(&models.User{StripeSubscriptionId: &sub.ID}).
+			Error; err != nil {
+			log.Printf("Error updating user: %s\n", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not update user %d", userId), err)
+	}
+	return nil
+}
+
+func handleAccountUpdated(event *stripe.Event) error {
+	var acc stripe.Account
+	if err := json.Unmarshal(event.Data.Raw, &acc); err != nil {
+		return PermanentError("could not parse Account", err)
+	}
+	md := acc.Metadata
+	organizationId := md["organizationId"]
+	orgId, err := strconv.Atoi(organizationId)
+	if err != nil {
+		return PermanentError("could not read property organizationId from Metadata", err)
+	}
+	completed := acc.Requirements != nil &&
+		len(acc.Requirements.Errors) == 0 &&
+		acc.ChargesEnabled &&
+		acc.PayoutsEnabled &&
+		acc.DetailsSubmitted
+	if !completed {
+		return nil
+	}
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Model(&models.Organization{}).
+			Where("id = ?", orgId).
+			Updates(&models.Organization{
+				Verified:        completed,
+				PaymentVerified: acc.ChargesEnabled,
+			}).
+			Error; err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not update organization %d", orgId), err)
+	}
+	return nil
+}
+
+func handleInvoicePaid(event *stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return PermanentError("could not parse Invoice", err)
+	}
+	md := inv.Metadata
+	requestId := md["requestId"]
+	var bookings []models.Booking
+	var txn models.Transaction
+	db := db.GetDb()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Model(&models.Transaction{}).
+			Where("reference_id = ?", requestId).
+			First(&txn).
+			Error
+		if err != nil {
+			return err
+		}
+		txnId := txn.ID
+		if err := tx.
+			Model(&models.Booking{}).
+			Preload("User").
+			Where("transaction_id = ?", txnId).
+			Select("event_id", "user_id").
+			Find(&bookings).
+			Error; err != nil {
+			log.Printf("Could not retrieve Event IDs for selected Booking: %s\n", err.Error())
+			return err
+		}
+		if len(bookings) > 0 {
+			go subscribeToEventTopics(bookings[0].User, bookings)
+		}
+
+		if err := emitBookingsCompleted(tx, "transaction_id = ?", txnId); err != nil {
+			return err
+		}
+		err = tx.
+			Model(&models.Booking{}).
+			Where(&models.Booking{TransactionID: &txnId}).
+			Updates(&models.Booking{
+				Status: types.BOOKING_COMPLETED,
+			}).
+			Error
+		if err != nil {
+			log.Printf("Error updating Booking group [%s]: %s\n", requestId, err.Error())
+			return err
+		}
+		if err := tx.
+			Model(&models.Transaction{}).
+			Where("reference_id", requestId).
+			Where(clause.IN{Column: "status", Values: []any{
+				types.TRANSACTION_PENDING,
+				types.TRANSACTION_PROCESSING,
+			}}).
+			Updates(&models.Transaction{
+				Amount:     float64(inv.Subtotal),
+				AmountPaid: float64(inv.Total),
+				Currency:   string(inv.Currency),
+				Status:     types.TRANSACTION_COMPLETED,
+			}).
+			Error; err != nil {
+			return err
+		}
+		log.Printf("[invoice.paid] Transaction with request ID [%s] has completed successfully!\n", requestId)
+		return nil
+	}); err != nil {
+		return stripeTransactionError(requestId, err)
+	}
+	return nil
+}
+
+func subscribeToEventTopics(user *models.User, bookings []models.Booking) {
+	fcm, err := lib.GetFirebaseMessaging()
+	if err != nil {
+		log.Printf("Could not retrieve FCM instance: %s\n", err.Error())
+		return
+	}
+	rd := lib.GetRedisClient()
+	token := rd.JSONGet(context.Background(), fmt.Sprintf("%s:fcm", user.UID), "$.token").Val()
+	log.Printf("[%d] retrieved token from cache: %s", user.ID, token)
+	for _, b := range bookings {
+		topic := fmt.Sprintf("EventsToOpen_%d", b.EventID)
+		sub, err := fcm.SubscribeToTopic(context.Background(), []string{token}, topic)
+		if err != nil {
+			log.Printf("Error subscribing to topic %s: %s\n", topic, err.Error())
+		} else {
+			log.Printf("Added topic %s to subscription: %d\n", topic, sub.SuccessCount)
+		}
+	}
+}
+
+func handlePaymentIntentCreated(event *stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return PermanentError("could not parse PaymentIntent", err)
+	}
+	log.Printf("[PaymentIntent] ID: %s %s\n", pi.ID, pi.Status)
+	md := pi.Metadata
+	log.Printf("[%s] Metadata: %v\n", pi.ID, md)
+	requestId := md["requestId"]
+	var txn models.Transaction
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Model(&models.Transaction{}).
+			Where("reference_id = ?", requestId).
+			First(&txn).
+			Error
+		if err != nil {
+			return err
+		}
+		txnId := txn.ID
+		if err := emitBookingsCompleted(tx, "transaction_id = ?", txnId); err != nil {
+			return err
+		}
+		err = tx.
+			Model(&models.Booking{}).
+			Where(&models.Booking{TransactionID: &txnId}).
+			Updates(&models.Booking{
+				Status:          types.BOOKING_COMPLETED,
+				PaymentIntentId: &pi.ID,
+			}).
+			Error
+		if err != nil {
+			log.Printf("Error updating Booking group [%s]: %s\n", requestId, err.Error())
+			return err
+		}
+		bUpdates, _ := json.Marshal(&models.Transaction{
+			SourceName:  "PaymentIntent",
+			SourceValue: pi.ID,
+			Status:      types.TRANSACTION_PROCESSING,
+			Currency:    string(pi.Currency),
+		})
+		updates := string(bUpdates)
+		bConds, _ := json.Marshal(&models.Transaction{
+			ID:     txn.ID,
+			Status: types.TRANSACTION_PENDING,
+		})
+		conds := string(bConds)
+		if err := models.EnqueueOutboxMessage(tx, "Transaction", txn.ID, broker.Topic("PaymentTransactionUpdates"), types.JSONB{
+			"source":  "payment_intent.created",
+			"id":      txn.ID.String(),
+			"conds":   conds,
+			"updates": updates,
+		}); err != nil {
+			log.Printf("Could not add message to outbox: %s\n", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return stripeTransactionError(requestId, err)
+	}
+	return nil
+}
+
+func handlePaymentIntentSucceeded(event *stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return PermanentError("could not parse PaymentIntent", err)
+	}
+	log.Printf("[PaymentIntent] ID: %s %s\n", pi.ID, pi.Status)
+	md := pi.Metadata
+	log.Printf("[%s] Metadata: %v\n", pi.ID, md)
+	requestId := md["requestId"]
+	var txn models.Transaction
+	var bookings []models.Booking
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Model(&models.Transaction{}).
+			Where("reference_id = ?", requestId).
+			First(&txn).
+			Error
+		if err != nil {
+			return err
+		}
+		err = tx.
+			Model(&models.Booking{}).
+			Where("metadata ->> 'requestId' = ?", requestId).
+			Preload("Event").
+			Find(&bookings).
+			Error
+		if err != nil {
+			return err
+		}
+		if err := emitBookingsCompleted(tx, "metadata ->> 'requestId' = ?", requestId); err != nil {
+			return err
+		}
+		err = tx.
+			Model(&models.Booking{}).
+			Where("metadata ->> 'requestId' = ?", requestId).
+			Updates(&models.Booking{
+				Status:          types.BOOKING_COMPLETED,
+				PaymentIntentId: &pi.ID,
+			}).Error
+		if err != nil {
+			log.Printf("Error updating Booking group [%s]: %s\n", requestId, err.Error())
+			return err
+		}
+		for _, booking := range bookings {
+			err := tx.
+				Model(&models.Reservation{}).
+				Where("booking_id = ?", booking.ID).
+				Preload("Booking").
+				Updates(&models.Reservation{
+					Status:     string(types.RESERVATION_PAID),
+					ValidUntil: booking.Event.DateTime,
+				}).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		txnUpdates := &models.Transaction{
+			SourceName:      "PaymentIntent",
+			SourceValue:     pi.ID,
+			Status:          types.TRANSACTION_PROCESSING,
+			Currency:        string(pi.Currency),
+			PaymentIntentId: &pi.ID,
+		}
+		txnConds := &models.Transaction{
+			ID:     txn.ID,
+			Status: types.TRANSACTION_PENDING,
+		}
+		bUpdates, _ := json.Marshal(txnUpdates)
+		updates := string(bUpdates)
+		bConds, _ := json.Marshal(txnConds)
+		conds := string(bConds)
+		if err := models.EnqueueOutboxMessage(tx, "Transaction", txn.ID, broker.Topic("PaymentTransactionUpdates"), types.JSONB{
+			"source":  "payment_intent.succeeded",
+			"id":      txn.ID.String(),
+			"conds":   conds,
+			"updates": updates,
+		}); err != nil {
+			log.Printf("Error adding message to outbox: %s\n", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return stripeTransactionError(requestId, err)
+	}
+	return nil
+}
+
+func handleCheckoutSessionCompleted(event *stripe.Event) error {
+	var cs stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &cs); err != nil {
+		return PermanentError("could not parse CheckoutSession", err)
+	}
+	log.Printf("[CheckoutSession] ID: %s %s\n", cs.ID, cs.Status)
+	md := cs.Metadata
+	log.Printf("[%s] Metadata: %v\n", cs.ID, md)
+	requestId := md["requestId"]
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Model(&models.Booking{}).
+			Where("metadata ->> 'requestId' = ?", requestId).
+			Updates(&models.Booking{
+				CheckoutSessionId: &cs.ID,
+			}).
+			Error
+		if err != nil {
+			return err
+		}
+		if len(cs.Discounts) > 0 {
+			discount := cs.Discounts[0]
+			promo := discount.PromotionCode
+			if promo == nil {
+				return nil
+			}
+			if err := tx.
+				Model(&models.Transaction{}).
+				Where("reference_id = ?", requestId).
+				Updates(&models.Transaction{
+					PromoId: &promo.ID,
+				}).
+				Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stripeTransactionError(requestId, err)
+	}
+	return nil
+}
+
+func stripeTransactionError(requestId string, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return PermanentError(fmt.Sprintf("no Transaction found for request %s", requestId), err)
+	}
+	return RetryableError(fmt.Sprintf("could not update records for request %s", requestId), err)
+}
+
+// handleChargeRefunded notifies the organizer of the bookings paid by a refunded charge
+func handleChargeRefunded(event *stripe.Event) error {
+	var charge stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+		return PermanentError("could not parse Charge", err)
+	}
+	if charge.PaymentIntent == nil {
+		log.Printf("[charge.refunded] Charge %s has no PaymentIntent. Skipping\n", charge.ID)
+		return nil
+	}
+	piId := charge.PaymentIntent.ID
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var bookings []models.Booking
+		if err := tx.
+			Preload("Event", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "organizer_id") }).
+			Where(&models.Booking{PaymentIntentId: &piId}).
+			Find(&bookings).
+			Error; err != nil {
+			return err
+		}
+		if len(bookings) == 0 || bookings[0].Event == nil {
+			return nil
+		}
+		ids := make([]uint, len(bookings))
+		for i, booking := range bookings {
+			ids[i] = booking.ID
+		}
+		orgId := bookings[0].Event.OrganizerID
+		refund := types.JSONB{
+			"chargeId":        charge.ID,
+			"paymentIntentId": piId,
+			"amount":          charge.Amount,
+			"amountRefunded":  charge.AmountRefunded,
+			"currency":        charge.Currency,
+			"refunded":        charge.Refunded,
+			"transactionId":   bookings[0].TransactionID,
+			"bookings":        ids,
+		}
+		if err := RecordAudit(tx, &models.TrailLog{
+			Type:           types.AUDIT_REFUND_SUCCEEDED,
+			Initiator:      types.AUDIT_BY_SYSTEM,
+			Group:          "refund",
+			OrganizationID: orgId,
+			ResourceID:     charge.ID,
+			After:          refund,
+			RequestID:      event.ID,
+		}); err != nil {
+			return err
+		}
+		return models.EmitWebhookEvent(tx, orgId, types.WEBHOOK_REFUND_SUCCEEDED, refund)
+	})
+	if err != nil {
+		return RetryableError(fmt.Sprintf("could not record refund of charge %s", charge.ID), err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v82"
+)
+
+func TestStripeEventsConsumerClaimsEvents(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	id := "0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"
+	mock.ExpectQuery(`SELECT \* FROM "stripe_events" WHERE "stripe_events"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "type", "status"}).
+			AddRow(id, "evt_1", "payment_intent.succeeded", types.STRIPE_EVENT_PROCESSING))
+	// Another consumer holds the event, so it is not handled again
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "stripe_events" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND status NOT IN \(\$4,\$5\)\)`).
+		WithArgs(types.STRIPE_EVENT_PROCESSING, sqlmock.AnyArg(), sqlmock.AnyArg(), types.STRIPE_EVENT_PROCESSING, types.STRIPE_EVENT_PROCESSED).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.Nil(t, StripeEventsConsumer(`{"id":"`+id+`","eventId":"evt_1","type":"payment_intent.succeeded"}`))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleChargeRefunded(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	event := &stripe.Event{
+		ID:   "evt_2",
+		Type: "charge.refunded",
+		Data: &stripe.EventData{Raw: []byte(`{"id":"ch_1","amount":5000,"amount_refunded":5000,"refunded":true,"payment_intent":"pi_1"}`)},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "bookings" WHERE "bookings"."payment_intent_id" = \$1`).
+		WithArgs("pi_1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "transaction_id"}).AddRow(5, 3, "0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"))
+	mock.ExpectQuery(`SELECT "id","organizer_id" FROM "events" WHERE "events"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organizer_id"}).AddRow(3, 1))
+	// The refund is audited in the transaction that emits its webhook event
+	mock.ExpectQuery(`INSERT INTO "trail_logs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5b0f4c1e-8d2a-4e6f-9c3b-7a1d2e3f4a5b"))
+	mock.ExpectQuery(`SELECT "id" FROM "webhook_endpoints"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	assert.Nil(t, handleChargeRefunded(event))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayStripeEvent(t *testing.T) {
+	id := "0c6b8f7a-5d2e-4f1b-9a3c-2e7d1f0a4b5c"
+	expectReplay := func(mock sqlmock.Sqlmock, status types.StripeEventStatus, affected int64) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "stripe_events" WHERE "stripe_events"."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "type", "status"}).
+				AddRow(id, "evt_1", "invoice.paid", status))
+		mock.ExpectExec(`UPDATE "stripe_events" SET "error"=\$1,"status"=\$2,"updated_at"=\$3 WHERE \(status = \$4 OR \(status = \$5 AND updated_at < \$6\)\) AND "stripe_events"."deleted_at" IS NULL AND "id" = \$7`).
+			WithArgs(nil, types.STRIPE_EVENT_RECEIVED, sqlmock.AnyArg(), types.STRIPE_EVENT_FAILED, types.STRIPE_EVENT_PROCESSING, sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+
+	t.Run("does not replay processed events", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		expectReplay(mock, types.STRIPE_EVENT_PROCESSED, 0)
+		mock.ExpectRollback()
+
+		_, err := ReplayStripeEvent(uuid.MustParse(id))
+		assert.ErrorIs(t, err, ErrStripeEventNotReplayable)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("queues failed events again", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		expectReplay(mock, types.STRIPE_EVENT_FAILED, 1)
+		mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		stripeEvent, err := ReplayStripeEvent(uuid.MustParse(id))
+		assert.Nil(t, err)
+		assert.Equal(t, "evt_1", stripeEvent.EventID)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
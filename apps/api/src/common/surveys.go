@@ -0,0 +1,529 @@
+package common
+
+import (
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/lib/mailer"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSurveyNotFound       = errors.New("survey not found")
+	ErrSurveyLocked         = errors.New("the survey has been sent and can no longer be changed")
+	ErrInvalidSurvey        = errors.New("invalid survey")
+	ErrInvalidSurveyAnswers = errors.New("invalid survey answers")
+	ErrSurveyNotOpen        = errors.New("the survey opens once the event has completed")
+	ErrNotSurveyEligible    = errors.New("only reservations admitted to the event can answer its survey")
+	ErrAlreadyResponded     = errors.New("the survey has already been answered for this reservation")
+)
+
+const (
+	// surveyTextLimit is the longest answer to a text question
+	surveyTextLimit = 2000
+	// surveyTextSamples is how many of the latest answers to a text question the results include
+	surveyTextSamples = 50
+)
+
+// SurveyQuestionResult aggregates the answers to a question. Rating questions have an average and the
+// count of each star, choice questions the count of each option and text questions their latest answers.
+type SurveyQuestionResult struct {
+	types.SurveyQuestion
+	Responses    int            `json:"responses"`
+	Average      *float64       `json:"average,omitempty"`
+	Distribution map[string]int `json:"distribution,omitempty"`
+	Answers      []string       `json:"answers,omitempty"`
+}
+
+// SurveyResults aggregates the responses to the survey of an event
+type SurveyResults struct {
+	Survey    *models.Survey         `json:"survey"`
+	Responses int                    `json:"responses"`
+	Questions []SurveyQuestionResult `json:"questions"`
+}
+
+func surveyURL(surveyId uint) string {
+	return fmt.Sprintf("%s/surveys/%d", config.APP_HOST, surveyId)
+}
+
+func validateSurvey(questions []types.SurveyQuestion) error {
+	seen := make(map[string]bool, len(questions))
+	for _, q := range questions {
+		if seen[q.ID] {
+			return fmt.Errorf("%w: duplicate question id %s", ErrInvalidSurvey, q.ID)
+		}
+		seen[q.ID] = true
+		switch q.Type {
+		case types.SURVEY_QUESTION_CHOICE:
+			if len(q.Options) < 2 {
+				return fmt.Errorf("%w: question %s needs at least 2 options", ErrInvalidSurvey, q.ID)
+			}
+			for i, option := range q.Options {
+				if slices.Contains(q.Options[:i], option) {
+					return fmt.Errorf("%w: question %s has duplicate option %s", ErrInvalidSurvey, q.ID, option)
+				}
+			}
+		default:
+			if len(q.Options) > 0 {
+				return fmt.Errorf("%w: only choice questions have options", ErrInvalidSurvey)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAnswers checks the answers against the questions of the survey and returns them normalized
+func validateAnswers(questions []types.SurveyQuestion, answers map[string]any) (types.JSONB, error) {
+	for id := range answers {
+		if !slices.ContainsFunc(questions, func(q types.SurveyQuestion) bool { return q.ID == id }) {
+			return nil, fmt.Errorf("%w: unknown question %s", ErrInvalidSurveyAnswers, id)
+		}
+	}
+	normalized := types.JSONB{}
+	for _, q := range questions {
+		v := answers[q.ID]
+		if s, ok := v.(string); ok {
+			v = strings.TrimSpace(s)
+		}
+		if v == nil || v == "" {
+			if q.Required {
+				return nil, fmt.Errorf("%w: question %s is required", ErrInvalidSurveyAnswers, q.ID)
+			}
+			continue
+		}
+		switch q.Type {
+		case types.SURVEY_QUESTION_RATING:
+			n, ok := v.(float64)
+			if !ok || n != math.Trunc(n) || n < 1 || n > 5 {
+				return nil, fmt.Errorf("%w: question %s is rated from 1 to 5", ErrInvalidSurveyAnswers, q.ID)
+			}
+			normalized[q.ID] = int(n)
+		case types.SURVEY_QUESTION_CHOICE:
+			s, ok := v.(string)
+			if !ok || !slices.Contains(q.Options, s) {
+				return nil, fmt.Errorf("%w: question %s is answered with one of its options", ErrInvalidSurveyAnswers, q.ID)
+			}
+			normalized[q.ID] = s
+		case types.SURVEY_QUESTION_TEXT:
+			s, ok := v.(string)
+			if !ok || len(s) > surveyTextLimit {
+				return nil, fmt.Errorf("%w: question %s is answered with up to %d characters", ErrInvalidSurveyAnswers, q.ID, surveyTextLimit)
+			}
+			normalized[q.ID] = s
+		}
+	}
+	return normalized, nil
+}
+
+func enqueueSurvey(tx *gorm.DB, eventId uint) error {
+	return models.EnqueueOutboxMessage(tx, "Event", eventId, broker.Topic("SurveysToSend"), types.JSONB{"id": eventId})
+}
+
+// queueEventSurvey queues the survey of a completed event, if it has one that has not been sent
+func queueEventSurvey(eventId uint) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.
+			Model(&models.Survey{}).
+			Where("event_id = ? AND sent_at IS NULL", eventId).
+			Count(&count).
+			Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		return enqueueSurvey(tx, eventId)
+	})
+}
+
+// GetEventSurvey returns the survey of an event of the organization
+func GetEventSurvey(orgId uint, eventId uint) (*models.Survey, error) {
+	var survey models.Survey
+	db := db.GetDb()
+	if err := db.
+		Where("event_id = ? AND organization_id = ?", eventId, orgId).
+		First(&survey).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSurveyNotFound
+		}
+		return nil, err
+	}
+	return &survey, nil
+}
+
+// UpdateEventSurvey sets the survey of an event until it has been sent. The survey of an event that has
+// already completed is sent right away.
+func UpdateEventSurvey(orgId uint, eventId uint, body types.UpdateSurveyRequestBody) (before *models.Survey, after *models.Survey, err error) {
+	if err := validateSurvey(body.Questions); err != nil {
+		return nil, nil, err
+	}
+	db := db.GetDb()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var event models.Event
+		if err := tx.
+			Select("id", "organizer_id", "status").
+			Where("id = ? AND organizer_id = ?", eventId, orgId).
+			First(&event).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEventNotFound
+			}
+			return err
+		}
+		var survey models.Survey
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("event_id = ?", eventId).
+			First(&survey).
+			Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			survey = models.Survey{EventID: eventId, OrganizationID: orgId}
+		case err != nil:
+			return err
+		default:
+			if survey.SentAt != nil {
+				return ErrSurveyLocked
+			}
+			prev := survey
+			before = &prev
+		}
+		survey.Title = body.Title
+		survey.Questions = body.Questions
+		if err := tx.Save(&survey).Error; err != nil {
+			return err
+		}
+		after = &survey
+		if event.Status != types.EVENT_COMPLETED {
+			return nil
+		}
+		return enqueueSurvey(tx, eventId)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// SurveysToSendConsumer emails the survey of a completed event to the users admitted to it. The survey
+// is marked sent before it is dispatched, so it is sent at most once.
+func SurveysToSendConsumer(spayload string) error {
+	if !gjson.Valid(spayload) {
+		return PermanentError("invalid json body", nil)
+	}
+	eventId := uint(gjson.Get(spayload, "id").Int())
+	db := db.GetDb()
+	var survey models.Survey
+	if err := db.
+		Where("event_id = ?", eventId).
+		First(&survey).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return RetryableError(fmt.Sprintf("could not get survey of event %d", eventId), err)
+	}
+	if survey.SentAt != nil {
+		return nil
+	}
+	var event models.Event
+	if err := db.
+		Where("id = ?", eventId).
+		First(&event).
+		Error; err != nil {
+		return RetryableError(fmt.Sprintf("could not get event %d", eventId), err)
+	}
+	var userIds []uint
+	if err := db.
+		Model(&models.Admission{}).
+		Joins("JOIN reservations ON reservations.id = admissions.reservation_id").
+		Joins("JOIN bookings ON bookings.id = reservations.booking_id").
+		Where("bookings.event_id = ?", eventId).
+		Distinct("bookings.user_id").
+		Pluck("bookings.user_id", &userIds).
+		Error; err != nil {
+		return RetryableError(fmt.Sprintf("could not get attendees of event %d", eventId), err)
+	}
+	// Claim the survey before sending it so a redelivered message does not send it twice
+	claim := db.
+		Model(&models.Survey{}).
+		Where("id = ? AND sent_at IS NULL", survey.ID).
+		Update("sent_at", time.Now())
+	if claim.Error != nil {
+		return RetryableError(fmt.Sprintf("could not update survey of event %d", eventId), claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+	if err := DispatchToUsers(&Message{
+		Category:       types.NOTIFY_EVENTS,
+		Text:           fmt.Sprintf("How was %s? Tell the organizer in a short survey", event.Title),
+		Template:       mailer.TemplateEventSurvey,
+		TemplateData:   mailer.SurveyEmail{Event: eventView(&event), Title: survey.Title, URL: surveyURL(survey.ID)},
+		OrganizationID: survey.OrganizationID,
+		Data: map[string]string{
+			"type":     "event.survey",
+			"eventId":  fmt.Sprint(eventId),
+			"surveyId": fmt.Sprint(survey.ID),
+		},
+	}, userIds...); err != nil {
+		log.Printf("[SurveysToSend] Error dispatching survey of Event [%d]: %s\n", eventId, err.Error())
+	}
+	log.Printf("[SurveysToSend] Sent survey of Event [%d] to %d attendees\n", eventId, len(userIds))
+	return nil
+}
+
+// admittedReservations returns the reservations of the user admitted to the event
+func admittedReservations(tx *gorm.DB, userId uint, eventId uint) ([]uint, error) {
+	var ids []uint
+	if err := tx.
+		Model(&models.Admission{}).
+		Joins("JOIN reservations ON reservations.id = admissions.reservation_id").
+		Joins("JOIN bookings ON bookings.id = reservations.booking_id").
+		Where("bookings.user_id = ? AND bookings.event_id = ?", userId, eventId).
+		Distinct("reservations.id").
+		Pluck("reservations.id", &ids).
+		Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetSurvey returns a survey to an attendee of its event along with their reservations that have yet to answer it
+func GetSurvey(surveyId uint, userId uint) (*models.Survey, []uint, error) {
+	var survey models.Survey
+	db := db.GetDb()
+	if err := db.
+		Where("id = ?", surveyId).
+		First(&survey).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSurveyNotFound
+		}
+		return nil, nil, err
+	}
+	admitted, err := admittedReservations(db, userId, survey.EventID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(admitted) == 0 {
+		return nil, nil, ErrNotSurveyEligible
+	}
+	var answered []uint
+	if err := db.
+		Model(&models.SurveyResponse{}).
+		Where("survey_id = ? AND reservation_id IN ?", surveyId, admitted).
+		Pluck("reservation_id", &answered).
+		Error; err != nil {
+		return nil, nil, err
+	}
+	pending := make([]uint, 0, len(admitted))
+	for _, id := range admitted {
+		if !slices.Contains(answered, id) {
+			pending = append(pending, id)
+		}
+	}
+	return &survey, pending, nil
+}
+
+// RespondToSurvey records the answers of an attendee for one of their admitted reservations, once per reservation
+func RespondToSurvey(surveyId uint, userId uint, body types.SurveyResponseRequestBody) (*models.SurveyResponse, error) {
+	var response models.SurveyResponse
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var survey models.Survey
+		if err := tx.
+			Where("id = ?", surveyId).
+			First(&survey).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSurveyNotFound
+			}
+			return err
+		}
+		var event models.Event
+		if err := tx.
+			Select("id", "status").
+			Where("id = ?", survey.EventID).
+			First(&event).
+			Error; err != nil {
+			return err
+		}
+		if event.Status != types.EVENT_COMPLETED {
+			return ErrSurveyNotOpen
+		}
+		admitted, err := admittedReservations(tx, userId, survey.EventID)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(admitted, body.ReservationID) {
+			return ErrNotSurveyEligible
+		}
+		answers, err := validateAnswers(survey.Questions, body.Answers)
+		if err != nil {
+			return err
+		}
+		response = models.SurveyResponse{
+			SurveyID:      surveyId,
+			ReservationID: body.ReservationID,
+			UserID:        userId,
+			Answers:       answers,
+		}
+		res := tx.
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "survey_id"}, {Name: "reservation_id"}}, DoNothing: true}).
+			Create(&response)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyResponded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func surveyResponses(surveyId uint) ([]models.SurveyResponse, error) {
+	var responses []models.SurveyResponse
+	db := db.GetDb()
+	if err := db.
+		Where("survey_id = ?", surveyId).
+		Order("created_at desc").
+		Find(&responses).
+		Error; err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// aggregateSurvey sums up the responses to each question of a survey. Responses are expected latest first.
+func aggregateSurvey(survey *models.Survey, responses []models.SurveyResponse) *SurveyResults {
+	results := &SurveyResults{
+		Survey:    survey,
+		Responses: len(responses),
+		Questions: make([]SurveyQuestionResult, len(survey.Questions)),
+	}
+	for i, q := range survey.Questions {
+		result := SurveyQuestionResult{SurveyQuestion: q}
+		switch q.Type {
+		case types.SURVEY_QUESTION_RATING:
+			result.Distribution = map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}
+		case types.SURVEY_QUESTION_CHOICE:
+			result.Distribution = make(map[string]int, len(q.Options))
+			for _, option := range q.Options {
+				result.Distribution[option] = 0
+			}
+		}
+		var total float64
+		for _, r := range responses {
+			v, ok := r.Answers[q.ID]
+			if !ok {
+				continue
+			}
+			switch q.Type {
+			case types.SURVEY_QUESTION_RATING:
+				n, ok := v.(float64)
+				if !ok {
+					continue
+				}
+				total += n
+				result.Distribution[strconv.Itoa(int(n))]++
+			case types.SURVEY_QUESTION_CHOICE:
+				result.Distribution[fmt.Sprint(v)]++
+			case types.SURVEY_QUESTION_TEXT:
+				if len(result.Answers) < surveyTextSamples {
+					result.Answers = append(result.Answers, fmt.Sprint(v))
+				}
+			}
+			result.Responses++
+		}
+		if q.Type == types.SURVEY_QUESTION_RATING && result.Responses > 0 {
+			average := math.Round(total/float64(result.Responses)*100) / 100
+			result.Average = &average
+		}
+		results.Questions[i] = result
+	}
+	return results
+}
+
+// SurveyResultsOf aggregates the responses to the survey of an event of the organization
+func SurveyResultsOf(orgId uint, eventId uint) (*SurveyResults, error) {
+	survey, err := GetEventSurvey(orgId, eventId)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := surveyResponses(survey.ID)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateSurvey(survey, responses), nil
+}
+
+// ExportSurveyResponses writes the responses to the survey of an event as CSV, one row per response
+// and one column per question
+func ExportSurveyResponses(orgId uint, eventId uint, w io.Writer) error {
+	survey, err := GetEventSurvey(orgId, eventId)
+	if err != nil {
+		return err
+	}
+	responses, err := surveyResponses(survey.ID)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	header := []string{"response_id", "reservation_id", "submitted_at"}
+	for _, q := range survey.Questions {
+		header = append(header, csvCell(q.Prompt))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range responses {
+		row := []string{fmt.Sprint(r.ID), fmt.Sprint(r.ReservationID), ""}
+		if r.CreatedAt != nil {
+			row[2] = r.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		for _, q := range survey.Questions {
+			v, ok := r.Answers[q.ID]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, csvCell(fmt.Sprint(v)))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell keeps a spreadsheet from evaluating a cell as a formula by prefixing it with a quote
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"bytes"
+	"ebs/src/db/dbtest"
+	"ebs/src/models"
+	"ebs/src/types"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var surveyQuestions = []types.SurveyQuestion{
+	{ID: "overall", Type: types.SURVEY_QUESTION_RATING, Prompt: "How was the event?", Required: true},
+	{ID: "venue", Type: types.SURVEY_QUESTION_CHOICE, Prompt: "How was the venue?", Options: []string{"Good", "Bad"}},
+	{ID: "comments", Type: types.SURVEY_QUESTION_TEXT, Prompt: "Anything else?"},
+}
+
+func TestValidateSurvey(t *testing.T) {
+	assert.Nil(t, validateSurvey(surveyQuestions))
+	assert.ErrorIs(t, validateSurvey([]types.SurveyQuestion{surveyQuestions[0], surveyQuestions[0]}), ErrInvalidSurvey)
+	assert.ErrorIs(t, validateSurvey([]types.SurveyQuestion{
+		{ID: "venue", Type: types.SURVEY_QUESTION_CHOICE, Prompt: "How was the venue?", Options: []string{"Good", "Good"}},
+	}), ErrInvalidSurvey)
+	assert.ErrorIs(t, validateSurvey([]types.SurveyQuestion{
+		{ID: "overall", Type: types.SURVEY_QUESTION_RATING, Prompt: "How was the event?", Options: []string{"Good", "Bad"}},
+	}), ErrInvalidSurvey)
+}
+
+func TestValidateAnswers(t *testing.T) {
+	answers, err := validateAnswers(surveyQuestions, map[string]any{"overall": float64(4), "venue": "Good", "comments": "  "})
+	assert.Nil(t, err)
+	assert.Equal(t, types.JSONB{"overall": 4, "venue": "Good"}, answers)
+
+	for name, answers := range map[string]map[string]any{
+		"missing required":  {"venue": "Good"},
+		"rating out of 1-5": {"overall": float64(6)},
+		"fractional rating": {"overall": 3.5},
+		"unknown option":    {"overall": float64(4), "venue": "Great"},
+		"unknown question":  {"overall": float64(4), "food": "Good"},
+	} {
+		_, err := validateAnswers(surveyQuestions, answers)
+		assert.ErrorIs(t, err, ErrInvalidSurveyAnswers, name)
+	}
+}
+
+func TestRespondToSurvey(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	body := types.SurveyResponseRequestBody{ReservationID: 21, Answers: map[string]any{"overall": float64(5)}}
+	survey := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "event_id", "organization_id", "title", "questions"}).
+			AddRow(4, 3, 1, "Tell us", []byte(`[{"id":"overall","type":"rating","prompt":"How was the event?","required":true}]`))
+	}
+	event := func(status types.EventStatus) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "status"}).AddRow(3, status)
+	}
+
+	t.Run("waits for the event to complete", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "surveys" WHERE id = \$1`).WillReturnRows(survey())
+		mock.ExpectQuery(`SELECT "id","status" FROM "events"`).WillReturnRows(event(types.EVENT_ADMISSION))
+		mock.ExpectRollback()
+		_, err := RespondToSurvey(4, 11, body)
+		assert.ErrorIs(t, err, ErrSurveyNotOpen)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejects reservations that were not admitted", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "surveys"`).WillReturnRows(survey())
+		mock.ExpectQuery(`SELECT "id","status" FROM "events"`).WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT DISTINCT reservations.id FROM "admissions" JOIN reservations .* JOIN bookings .* WHERE \(bookings.user_id = \$1 AND bookings.event_id = \$2\)`).
+			WithArgs(uint(11), uint(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(22))
+		mock.ExpectRollback()
+		_, err := RespondToSurvey(4, 11, body)
+		assert.ErrorIs(t, err, ErrNotSurveyEligible)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("answers once per reservation", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "surveys"`).WillReturnRows(survey())
+		mock.ExpectQuery(`SELECT "id","status" FROM "events"`).WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT DISTINCT reservations.id FROM "admissions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+		mock.ExpectQuery(`INSERT INTO "survey_responses" .* ON CONFLICT \("survey_id","reservation_id"\) DO NOTHING`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		_, err := RespondToSurvey(4, 11, body)
+		assert.ErrorIs(t, err, ErrAlreadyResponded)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("records the answers of an attendee", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "surveys"`).WillReturnRows(survey())
+		mock.ExpectQuery(`SELECT "id","status" FROM "events"`).WillReturnRows(event(types.EVENT_COMPLETED))
+		mock.ExpectQuery(`SELECT DISTINCT reservations.id FROM "admissions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+		mock.ExpectQuery(`INSERT INTO "survey_responses"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectCommit()
+		response, err := RespondToSurvey(4, 11, body)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(9), response.ID)
+		assert.Equal(t, types.JSONB{"overall": 5}, response.Answers)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAggregateSurvey(t *testing.T) {
+	survey := &models.Survey{ID: 4, Questions: surveyQuestions}
+	responses := []models.SurveyResponse{
+		{Answers: types.JSONB{"overall": float64(5), "venue": "Good", "comments": "Loved it"}},
+		{Answers: types.JSONB{"overall": float64(4), "venue": "Bad"}},
+		{Answers: types.JSONB{"overall": float64(4)}},
+	}
+	results := aggregateSurvey(survey, responses)
+	assert.Equal(t, 3, results.Responses)
+
+	overall := results.Questions[0]
+	assert.Equal(t, 3, overall.Responses)
+	assert.Equal(t, 4.33, *overall.Average)
+	assert.Equal(t, map[string]int{"1": 0, "2": 0, "3": 0, "4": 2, "5": 1}, overall.Distribution)
+
+	venue := results.Questions[1]
+	assert.Equal(t, 2, venue.Responses)
+	assert.Nil(t, venue.Average)
+	assert.Equal(t, map[string]int{"Good": 1, "Bad": 1}, venue.Distribution)
+
+	comments := results.Questions[2]
+	assert.Equal(t, 1, comments.Responses)
+	assert.Equal(t, []string{"Loved it"}, comments.Answers)
+}
+
+func TestSurveysToSendConsumer(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "surveys" WHERE event_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "organization_id", "title"}).AddRow(4, 3, 1, "Tell us"))
+	mock.ExpectQuery(`SELECT \* FROM "events" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(3, "Gala"))
+	mock.ExpectQuery(`SELECT DISTINCT bookings.user_id FROM "admissions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(11))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "surveys" SET "sent_at"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND sent_at IS NULL\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uint(4)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.Nil(t, SurveysToSendConsumer(`{"id":3}`))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestExportSurveyResponses(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "surveys" WHERE \(event_id = \$1 AND organization_id = \$2\)`).
+		WithArgs(uint(3), uint(1), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "organization_id", "title", "questions"}).
+			AddRow(4, 3, 1, "Tell us", []byte(`[{"id":"comments","type":"text","prompt":"=Anything else?"}]`)))
+	mock.ExpectQuery(`SELECT \* FROM "survey_responses" WHERE survey_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "survey_id", "reservation_id", "answers"}).
+			AddRow(9, 4, 21, []byte(`{"comments":"=HYPERLINK(\"http://evil\")"}`)).
+			AddRow(8, 4, 22, []byte(`{"comments":"-1"}`)).
+			AddRow(7, 4, 23, []byte(`{"comments":"Loved it"}`)))
+
+	var buf bytes.Buffer
+	assert.Nil(t, ExportSurveyResponses(1, 3, &buf))
+	assert.Equal(t, "response_id,reservation_id,submitted_at,'=Anything else?\n"+
+		"9,21,,\"'=HYPERLINK(\"\"http://evil\"\")\"\n"+
+		"8,22,,'-1\n"+
+		"7,23,,Loved it\n", buf.String())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
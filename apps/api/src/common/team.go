@@ -0,0 +1,472 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/lib/mailer"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InviteTTL is how long a team invitation can be accepted
+const InviteTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInviteNotFound      = errors.New("invite not found")
+	ErrInviteExpired       = errors.New("invite has expired")
+	ErrInviteEmailMismatch = errors.New("invite was sent to a different email")
+	ErrInvalidMemberRole   = errors.New("invalid member role")
+	ErrAlreadyMember       = errors.New("user is already a member of the organization")
+	ErrMemberNotFound      = errors.New("member not found")
+	ErrOwnerMember         = errors.New("the owner of the organization cannot be changed or removed")
+	ErrNotOwner            = errors.New("only the owner can perform this action")
+)
+
+// GetDefaultTeam returns the team members of an organization are added to, creating it if needed
+func GetDefaultTeam(tx *gorm.DB, orgId uint) (*models.Team, error) {
+	var team models.Team
+	err := tx.
+		Where(&models.Team{OrganizationID: orgId}).
+		Order("id asc").
+		First(&team).
+		Error
+	if err == nil {
+		return &team, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	var org models.Organization
+	if err := tx.
+		Where(&models.Organization{ID: orgId}).
+		First(&org).
+		Error; err != nil {
+		return nil, err
+	}
+	team = models.Team{
+		OrganizationID: org.ID,
+		OwnerID:        org.OwnerID,
+		Name:           "Default",
+		Status:         "active",
+		TenantID:       org.TenantID,
+	}
+	if err := tx.Create(&team).Error; err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// ListMembers returns the owner and the active members of an organization
+func ListMembers(orgId uint) ([]types.OrganizationMember, error) {
+	members := make([]types.OrganizationMember, 0)
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var org models.Organization
+		if err := tx.
+			Preload("Owner").
+			Where(&models.Organization{ID: orgId}).
+			First(&org).
+			Error; err != nil {
+			return err
+		}
+		members = append(members, types.OrganizationMember{
+			UserID:   org.Owner.ID,
+			Name:     org.Owner.Name,
+			Email:    org.Owner.Email,
+			Role:     types.MEMBER_ROLE_OWNER,
+			Status:   types.MEMBER_ACTIVE,
+			JoinedAt: org.CreatedAt,
+		})
+		var rows []types.OrganizationMember
+		if err := tx.
+			Model(&models.TeamMember{}).
+			Select("users.id AS user_id", "users.name", "users.email", "team_members.role", "team_members.status", "team_members.created_at AS joined_at").
+			Joins("JOIN teams ON teams.id = team_members.team_id").
+			Joins("JOIN users ON users.id = team_members.user_id").
+			Where("teams.organization_id = ?", orgId).
+			Where("team_members.status = ?", types.MEMBER_ACTIVE).
+			Where("team_members.user_id <> ?", org.OwnerID).
+			Order("team_members.created_at asc").
+			Scan(&rows).
+			Error; err != nil {
+			return err
+		}
+		members = append(members, rows...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// InviteMember creates an invitation for email to join the organization with role and emails it.
+// Pending invitations previously sent to the same email are revoked.
+func InviteMember(orgId uint, invitedBy uint, actor types.MemberRole, email string, role types.MemberRole) (*types.TeamInvite, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	var invite models.Token
+	var org models.Organization
+	var secret string
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := validateMemberRole(tx, actor, role); err != nil {
+			return err
+		}
+		if err := tx.
+			Where(&models.Organization{ID: orgId}).
+			First(&org).
+			Error; err != nil {
+			return err
+		}
+		team, err := GetDefaultTeam(tx, orgId)
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.
+			Model(&models.User{}).
+			Where("LOWER(email) = ?", email).
+			Where("id = ? OR id IN (?)", org.OwnerID, tx.
+				Model(&models.TeamMember{}).
+				Select("user_id").
+				Where(&models.TeamMember{TeamID: team.ID, Status: string(types.MEMBER_ACTIVE)})).
+			Count(&count).
+			Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyMember
+		}
+		if err := tx.
+			Model(&models.Token{}).
+			Where(&models.Token{Type: models.TokenTypeInvite, RequestedBy: orgId, Status: string(types.INVITE_PENDING)}).
+			Where("token_value ->> 'email' = ?", email).
+			Update("status", types.INVITE_REVOKED).
+			Error; err != nil {
+			return err
+		}
+		secret, err = newInviteSecret()
+		if err != nil {
+			return err
+		}
+		invite = models.Token{
+			RequestedBy:   orgId,
+			RequesterType: "org",
+			Type:          models.TokenTypeInvite,
+			TokenName:     "team_invite",
+			TokenValue: types.JSONB{
+				"hash":      hashInviteSecret(secret),
+				"email":     email,
+				"role":      role,
+				"teamId":    team.ID,
+				"invitedBy": invitedBy,
+			},
+			TTL: uint(InviteTTL.Seconds()),
+		}
+		return tx.Create(&invite).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := DispatchToAddress(&Message{
+		Category: types.NOTIFY_TEAM,
+		Text:     fmt.Sprintf("You have been invited to join %s as %s", org.Name, role),
+		Template: mailer.TemplateTeamInvite,
+		TemplateData: mailer.TeamInviteEmail{
+			Organization:  org.Name,
+			Role:          string(role),
+			URL:           fmt.Sprintf("%s/invites?token=%s", config.APP_HOST, secret),
+			ExpiresInDays: int(InviteTTL.Hours() / 24),
+		},
+		OrganizationID: orgId,
+	}, email); err != nil {
+		log.Printf("Could not send invite email to [%s]: %s\n", email, err.Error())
+	}
+	// ExpiresAt is only computed when the token is read back
+	invite.ExpiresAt = time.Now().Add(InviteTTL)
+	return inviteView(&invite), nil
+}
+
+// ListInvites returns the pending invitations of an organization
+func ListInvites(orgId uint) ([]types.TeamInvite, error) {
+	var tokens []models.Token
+	db := db.GetDb()
+	if err := db.
+		Where(&models.Token{Type: models.TokenTypeInvite, RequestedBy: orgId, Status: string(types.INVITE_PENDING)}).
+		Order("created_at desc").
+		Find(&tokens).
+		Error; err != nil {
+		return nil, err
+	}
+	invites := make([]types.TeamInvite, 0, len(tokens))
+	for _, token := range tokens {
+		invites = append(invites, *inviteView(&token))
+	}
+	return invites, nil
+}
+
+// RevokeInvite cancels a pending invitation of an organization
+func RevokeInvite(orgId uint, inviteId uuid.UUID) error {
+	db := db.GetDb()
+	res := db.
+		Model(&models.Token{}).
+		Where(&models.Token{ID: inviteId, Type: models.TokenTypeInvite, RequestedBy: orgId, Status: string(types.INVITE_PENDING)}).
+		Update("status", types.INVITE_REVOKED)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// RespondToInvite accepts or declines the invitation identified by secret on behalf of the invited user
+func RespondToInvite(secret string, userId uint, accept bool) (*types.TeamInvite, error) {
+	var invite models.Token
+	var status types.InviteStatus
+	expired := false
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.Token{Type: models.TokenTypeInvite, Status: string(types.INVITE_PENDING)}).
+			Where("token_value ->> 'hash' = ?", hashInviteSecret(secret)).
+			First(&invite).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteNotFound
+			}
+			return err
+		}
+		if invite.ExpiresAt.Before(time.Now()) {
+			expired = true
+			return tx.Model(&invite).Update("status", types.INVITE_EXPIRED).Error
+		}
+		var user models.User
+		if err := tx.
+			Select("id", "email").
+			Where(&models.User{ID: userId}).
+			First(&user).
+			Error; err != nil {
+			return err
+		}
+		email, _ := invite.TokenValue["email"].(string)
+		if !strings.EqualFold(user.Email, email) {
+			return ErrInviteEmailMismatch
+		}
+		status = types.INVITE_DECLINED
+		if accept {
+			status = types.INVITE_ACCEPTED
+			teamId, _ := invite.TokenValue["teamId"].(float64)
+			role, _ := invite.TokenValue["role"].(string)
+			if err := addMember(tx, uint(teamId), userId, types.MemberRole(role)); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&invite).Update("status", status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrInviteExpired
+	}
+	view := inviteView(&invite)
+	view.Status = status
+	return view, nil
+}
+
+// UpdateMemberRole changes the role of an active member of the organization and returns the previous role
+func UpdateMemberRole(orgId uint, actor types.MemberRole, userId uint, role types.MemberRole) (types.MemberRole, error) {
+	var previous types.MemberRole
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := validateMemberRole(tx, actor, role); err != nil {
+			return err
+		}
+		member, err := findMember(tx, orgId, userId)
+		if err != nil {
+			return err
+		}
+		previous = types.MemberRole(member.Role)
+		if previous == types.MEMBER_ROLE_ADMIN && actor != types.MEMBER_ROLE_OWNER {
+			return ErrNotOwner
+		}
+		return tx.
+			Model(member).
+			Update("role", role).
+			Error
+	})
+	return previous, err
+}
+
+// RemoveMember removes an active member from the organization.
+// The organization API keys the member created are revoked with it.
+func RemoveMember(orgId uint, actor types.MemberRole, removedBy uint, userId uint) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		member, err := findMember(tx, orgId, userId)
+		if err != nil {
+			return err
+		}
+		if types.MemberRole(member.Role) == types.MEMBER_ROLE_ADMIN && actor != types.MEMBER_ROLE_OWNER {
+			return ErrNotOwner
+		}
+		if err := tx.
+			Model(member).
+			Update("status", types.MEMBER_REMOVED).
+			Error; err != nil {
+			return err
+		}
+		return tx.
+			Model(&models.APIKey{}).
+			Where(&models.APIKey{Kind: types.API_KEY_ORGANIZATION, OrganizationID: &orgId, CreatedBy: userId}).
+			Where("revoked_at IS NULL").
+			Updates(map[string]any{"revoked_at": time.Now(), "revoked_by": removedBy}).
+			Error
+	})
+}
+
+// TransferOwnership makes an active member the owner of the organization.
+// The previous owner stays in the organization as an admin.
+func TransferOwnership(orgId uint, ownerId uint, newOwnerId uint) error {
+	db := db.GetDb()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var org models.Organization
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&models.Organization{ID: orgId}).
+			First(&org).
+			Error; err != nil {
+			return err
+		}
+		if org.OwnerID != ownerId {
+			return ErrNotOwner
+		}
+		if _, err := findMember(tx, orgId, newOwnerId); err != nil {
+			return err
+		}
+		if err := tx.
+			Model(&models.Organization{}).
+			Where("id = ?", orgId).
+			Update("owner_id", newOwnerId).
+			Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Model(&models.Team{}).
+			Where(&models.Team{OrganizationID: orgId}).
+			Update("owner_id", newOwnerId).
+			Error; err != nil {
+			return err
+		}
+		team, err := GetDefaultTeam(tx, orgId)
+		if err != nil {
+			return err
+		}
+		return addMember(tx, team.ID, ownerId, types.MEMBER_ROLE_ADMIN)
+	})
+}
+
+// findMember returns the active membership of a user who is not the owner of the organization
+func findMember(tx *gorm.DB, orgId uint, userId uint) (*models.TeamMember, error) {
+	var org models.Organization
+	if err := tx.
+		Select("id", "owner_id").
+		Where(&models.Organization{ID: orgId}).
+		First(&org).
+		Error; err != nil {
+		return nil, err
+	}
+	if org.OwnerID == userId {
+		return nil, ErrOwnerMember
+	}
+	var member models.TeamMember
+	if err := tx.
+		Joins("JOIN teams ON teams.id = team_members.team_id").
+		Where("teams.organization_id = ?", orgId).
+		Where("team_members.user_id = ?", userId).
+		Where("team_members.status = ?", types.MEMBER_ACTIVE).
+		First(&member).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func addMember(tx *gorm.DB, teamId uint, userId uint, role types.MemberRole) error {
+	var member models.TeamMember
+	if err := tx.
+		Where(&models.TeamMember{TeamID: teamId, UserID: userId}).
+		FirstOrInit(&member).
+		Error; err != nil {
+		return err
+	}
+	member.Role = string(role)
+	member.Status = string(types.MEMBER_ACTIVE)
+	return tx.Save(&member).Error
+}
+
+// validateMemberRole checks that role exists and can be granted by a member with the actor role.
+// Only the owner can grant the admin role, the owner role is only granted through a transfer.
+func validateMemberRole(tx *gorm.DB, actor types.MemberRole, role types.MemberRole) error {
+	if role == types.MEMBER_ROLE_OWNER {
+		return ErrInvalidMemberRole
+	}
+	if role == types.MEMBER_ROLE_ADMIN && actor != types.MEMBER_ROLE_OWNER {
+		return ErrNotOwner
+	}
+	if err := tx.
+		Where(&models.Role{Name: string(role)}).
+		First(&models.Role{}).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidMemberRole
+		}
+		return err
+	}
+	return nil
+}
+
+func inviteView(token *models.Token) *types.TeamInvite {
+	email, _ := token.TokenValue["email"].(string)
+	role, _ := token.TokenValue["role"].(string)
+	return &types.TeamInvite{
+		ID:             token.ID.String(),
+		OrganizationID: token.RequestedBy,
+		Email:          email,
+		Role:           types.MemberRole(role),
+		Status:         types.InviteStatus(token.Status),
+		ExpiresAt:      token.ExpiresAt,
+		CreatedAt:      token.CreatedAt,
+	}
+}
+
+func newInviteSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashInviteSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespondToInvite(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	secret := "6b1f0c3e"
+	tokenColumns := []string{"id", "requested_by", "type", "token_value", "ttl", "status", "created_at"}
+	tokenValue := []byte(`{"email":"staff@example.com","role":"staff","teamId":4,"invitedBy":1}`)
+
+	t.Run("accept", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens" WHERE .*token_value ->> 'hash' = \$\d+ .*FOR UPDATE`).
+			WithArgs("invite", "pending", hashInviteSecret(secret), 1).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow("c5a1b7e2-6a4e-4e0b-9a43-1f2b3c4d5e6f", 9, "invite", tokenValue, 3600, "pending", time.Now()))
+		mock.ExpectQuery(`SELECT "id","email" FROM "users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(12, "Staff@Example.com"))
+		mock.ExpectQuery(`SELECT \* FROM "team_members"`).
+			WithArgs(4, 12, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "team_members"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(30))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs(types.INVITE_ACCEPTED, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		invite, err := RespondToInvite(secret, 12, true)
+		assert.Nil(t, err)
+		assert.Equal(t, types.INVITE_ACCEPTED, invite.Status)
+		assert.Equal(t, types.MEMBER_ROLE_STAFF, invite.Role)
+		assert.Equal(t, uint(9), invite.OrganizationID)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow("c5a1b7e2-6a4e-4e0b-9a43-1f2b3c4d5e6f", 9, "invite", tokenValue, 3600, "pending", time.Now().Add(-2*time.Hour)))
+		mock.ExpectExec(`UPDATE "tokens" SET "status"=\$1`).
+			WithArgs(types.INVITE_EXPIRED, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err := RespondToInvite(secret, 12, true)
+		assert.ErrorIs(t, err, ErrInviteExpired)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("different email", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "tokens"`).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow("c5a1b7e2-6a4e-4e0b-9a43-1f2b3c4d5e6f", 9, "invite", tokenValue, 3600, "pending", time.Now()))
+		mock.ExpectQuery(`SELECT "id","email" FROM "users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(13, "someone@example.com"))
+		mock.ExpectRollback()
+
+		_, err := RespondToInvite(secret, 13, false)
+		assert.ErrorIs(t, err, ErrInviteEmailMismatch)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRemoveMember(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(9, 1))
+	mock.ExpectQuery(`SELECT "team_members"\."id".* FROM "team_members" JOIN teams`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "team_id", "user_id", "role", "status"}).
+			AddRow(30, 4, 12, types.MEMBER_ROLE_STAFF, types.MEMBER_ACTIVE))
+	mock.ExpectExec(`UPDATE "team_members" SET "status"=\$1`).
+		WithArgs(types.MEMBER_REMOVED, sqlmock.AnyArg(), 30, 4, 12).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "api_keys" SET "revoked_at"=\$1,"revoked_by"=\$2,"updated_at"=\$3 WHERE \("api_keys"\."kind" = \$4 AND "api_keys"\."organization_id" = \$5 AND "api_keys"\."created_by" = \$6\) AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), types.API_KEY_ORGANIZATION, 9, 12).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	assert.Nil(t, RemoveMember(9, types.MEMBER_ROLE_OWNER, 1, 12))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
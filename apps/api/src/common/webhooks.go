@@ -0,0 +1,620 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"ebs/src/db"
+	"ebs/src/lib/broker"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WebhookBatchSize    = 50
+	WebhookMaxAttempts  = 8
+	WebhookPollInterval = 5 * time.Second
+	WebhookTimeout      = 10 * time.Second
+	// WebhookBaseDelay is the delay before the first retry of a failed delivery, it doubles up to WebhookMaxDelay
+	WebhookBaseDelay = 30 * time.Second
+	WebhookMaxDelay  = 6 * time.Hour
+	// webhookConcurrency is how many deliveries of a batch are sent at a time
+	webhookConcurrency = 8
+	// webhookResponseLimit is how much of the response body of an endpoint is kept in the delivery log
+	webhookResponseLimit = 1024
+	// webhookClaimLease is how long a claimed delivery is held back from other instances while it is sent
+	webhookClaimLease = 5 * time.Minute
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook endpoint not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidWebhookEvent     = errors.New("unknown webhook event type")
+	ErrInvalidWebhookURL       = errors.New("webhook URL must be an http or https URL")
+	errWebhookAddress          = errors.New("webhook URL resolves to a private address")
+)
+
+// reservedWebhookPrefixes are ranges that are not reachable on the public internet and are not covered by the
+// checks of netip.Addr
+var reservedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// nat64Prefix is the well-known NAT64 prefix, its addresses embed an IPv4 address in their last 32 bits
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// publicWebhookAddress reports whether a webhook may be delivered to addr. IPv4-mapped and NAT64 addresses
+// are checked as the IPv4 address they reach.
+func publicWebhookAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if nat64Prefix.Contains(addr) {
+		b := addr.As16()
+		addr = netip.AddrFrom4([4]byte(b[12:]))
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() ||
+		addr.IsMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, prefix := range reservedWebhookPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookClient sends deliveries. It refuses to connect to loopback, private, link local and reserved
+// addresses so an endpoint cannot be used to reach internal services.
+var webhookClient = &http.Client{
+	Timeout: WebhookTimeout,
+	Transport: &http.Transport{
+		// A proxy would dial the endpoint itself, past the address check below
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: WebhookTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				addr, err := netip.ParseAddr(host)
+				if err != nil || !publicWebhookAddress(addr) {
+					return errWebhookAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// CreateWebhook adds an endpoint to the organization. The signing secret is only returned here and by RotateWebhookSecret.
+func CreateWebhook(orgId uint, createdBy uint, body types.CreateWebhookRequestBody) (*models.WebhookEndpoint, string, error) {
+	if err := validateWebhook(body.URL, body.Events); err != nil {
+		return nil, "", err
+	}
+	secret, enc, err := newWebhookSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	endpoint := models.WebhookEndpoint{
+		OrganizationID: orgId,
+		URL:            body.URL,
+		Description:    body.Description,
+		Events:         slices.Compact(slices.Sorted(slices.Values(body.Events))),
+		Secret:         enc,
+		Enabled:        true,
+		CreatedBy:      createdBy,
+	}
+	db := db.GetDb()
+	if err := db.Create(&endpoint).Error; err != nil {
+		return nil, "", err
+	}
+	return &endpoint, secret, nil
+}
+
+// ListWebhooks returns the endpoints of the organization, newest first
+func ListWebhooks(orgId uint) ([]models.WebhookEndpoint, error) {
+	endpoints := make([]models.WebhookEndpoint, 0)
+	db := db.GetDb()
+	if err := db.
+		Where(&models.WebhookEndpoint{OrganizationID: orgId}).
+		Order("created_at desc").
+		Find(&endpoints).
+		Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// UpdateWebhook changes an endpoint of the organization and returns it before and after the change
+func UpdateWebhook(orgId uint, id uuid.UUID, body types.UpdateWebhookRequestBody) (*models.WebhookEndpoint, *models.WebhookEndpoint, error) {
+	var before, after models.WebhookEndpoint
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := findWebhook(tx, orgId, id, &before); err != nil {
+			return err
+		}
+		after = before
+		if body.URL != nil {
+			after.URL = *body.URL
+		}
+		if body.Description != nil {
+			after.Description = *body.Description
+		}
+		if body.Events != nil {
+			after.Events = slices.Compact(slices.Sorted(slices.Values(body.Events)))
+		}
+		if body.Enabled != nil {
+			after.Enabled = *body.Enabled
+		}
+		if err := validateWebhook(after.URL, after.Events); err != nil {
+			return err
+		}
+		return tx.
+			Model(&models.WebhookEndpoint{}).
+			Where("id = ?", id).
+			Updates(map[string]any{
+				"url":         after.URL,
+				"description": after.Description,
+				"events":      after.Events,
+				"enabled":     after.Enabled,
+			}).
+			Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &before, &after, nil
+}
+
+// DeleteWebhook removes an endpoint of the organization. Its pending deliveries are not sent.
+func DeleteWebhook(orgId uint, id uuid.UUID) error {
+	db := db.GetDb()
+	res := db.
+		Where(&models.WebhookEndpoint{ID: id, OrganizationID: orgId}).
+		Delete(&models.WebhookEndpoint{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// RotateWebhookSecret replaces the signing secret of an endpoint and returns the new one
+func RotateWebhookSecret(orgId uint, id uuid.UUID) (string, error) {
+	secret, enc, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	db := db.GetDb()
+	res := db.
+		Model(&models.WebhookEndpoint{}).
+		Where(&models.WebhookEndpoint{ID: id, OrganizationID: orgId}).
+		Update("secret", enc)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", ErrWebhookNotFound
+	}
+	return secret, nil
+}
+
+// ListWebhookDeliveries returns a page of the delivery log of an endpoint, newest first, and their count.
+// The default page and limit are set on filters.
+func ListWebhookDeliveries(orgId uint, endpointId uuid.UUID, filters *types.WebhookDeliveryQueryFilters) ([]models.WebhookDelivery, int64, error) {
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Limit == 0 {
+		filters.Limit = 20
+	}
+	deliveries := make([]models.WebhookDelivery, 0)
+	var count int64
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		q := tx.
+			Model(&models.WebhookDelivery{}).
+			Where(&models.WebhookDelivery{
+				EndpointID:     endpointId,
+				OrganizationID: orgId,
+				Status:         filters.Status,
+			}).
+			Session(&gorm.Session{})
+		if err := q.Count(&count).Error; err != nil {
+			return err
+		}
+		return q.
+			Order("created_at desc").
+			Offset((filters.Page - 1) * filters.Limit).
+			Limit(filters.Limit).
+			Find(&deliveries).
+			Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
+
+// RedeliverWebhook queues the event of a delivery to be sent to its endpoint again, whatever the delivery's status
+func RedeliverWebhook(orgId uint, endpointId uuid.UUID, deliveryId uuid.UUID) (*models.WebhookDelivery, error) {
+	var redelivery models.WebhookDelivery
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var endpoint models.WebhookEndpoint
+		if err := findWebhook(tx, orgId, endpointId, &endpoint); err != nil {
+			return err
+		}
+		var delivery models.WebhookDelivery
+		if err := tx.
+			Where(&models.WebhookDelivery{ID: deliveryId, EndpointID: endpointId}).
+			First(&delivery).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWebhookDeliveryNotFound
+			}
+			return err
+		}
+		redelivery = models.WebhookDelivery{
+			EndpointID:     delivery.EndpointID,
+			OrganizationID: delivery.OrganizationID,
+			EventID:        delivery.EventID,
+			EventType:      delivery.EventType,
+			Payload:        delivery.Payload,
+			Status:         types.WEBHOOK_DELIVERY_PENDING,
+			NextAttemptAt:  time.Now(),
+		}
+		return tx.Create(&redelivery).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &redelivery, nil
+}
+
+// webhookResult is the outcome of sending a delivery
+type webhookResult struct {
+	status int
+	body   string
+	err    error
+}
+
+// DeliverWebhooks sends the deliveries that are due. A failed delivery is retried with exponential backoff
+// from WebhookBaseDelay until it failed WebhookMaxAttempts times. Deliveries are claimed before they are
+// sent, so no transaction is held open while endpoints are called.
+func DeliverWebhooks() error {
+	deliveries, err := claimWebhookDeliveries()
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+	endpointIds := make([]uuid.UUID, 0, len(deliveries))
+	for _, d := range deliveries {
+		endpointIds = append(endpointIds, d.EndpointID)
+	}
+	var endpoints []models.WebhookEndpoint
+	db := db.GetDb()
+	if err := db.
+		Where("id IN (?)", endpointIds).
+		Find(&endpoints).
+		Error; err != nil {
+		return err
+	}
+	byId := make(map[uuid.UUID]*models.WebhookEndpoint, len(endpoints))
+	for i := range endpoints {
+		byId[endpoints[i].ID] = &endpoints[i]
+	}
+
+	results := make([]webhookResult, len(deliveries))
+	sem := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		endpoint, ok := byId[deliveries[i].EndpointID]
+		if !ok || !endpoint.Enabled {
+			results[i] = webhookResult{err: ErrWebhookNotFound}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, endpoint *models.WebhookEndpoint) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = sendWebhook(endpoint, &deliveries[i])
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	for i := range deliveries {
+		if err := recordWebhookResult(db, &deliveries[i], results[i]); err != nil {
+			log.Printf("[Webhooks] Error updating delivery %s: %s\n", deliveries[i].ID, err.Error())
+		}
+	}
+	return nil
+}
+
+// claimWebhookDeliveries returns a batch of due deliveries and holds them back for webhookClaimLease, so
+// other instances skip them while they are sent. A delivery whose result is never recorded is sent
+// again once the lease runs out.
+func claimWebhookDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(&models.WebhookDelivery{Status: types.WEBHOOK_DELIVERY_PENDING}).
+			Where("next_attempt_at <= ?", now).
+			Order("next_attempt_at asc").
+			Limit(WebhookBatchSize).
+			Find(&deliveries).
+			Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(deliveries))
+		for i, d := range deliveries {
+			ids[i] = d.ID
+		}
+		return tx.
+			Model(&models.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(webhookClaimLease)).
+			Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func recordWebhookResult(tx *gorm.DB, d *models.WebhookDelivery, res webhookResult) error {
+	now := time.Now()
+	updates := map[string]any{"attempts": d.Attempts + 1}
+	if res.status > 0 {
+		updates["response_status"] = res.status
+		updates["response_body"] = res.body
+	}
+	switch {
+	case res.err == nil && res.status >= 200 && res.status < 300:
+		updates["status"] = types.WEBHOOK_DELIVERY_SUCCEEDED
+		updates["delivered_at"] = now
+		updates["last_error"] = nil
+	case errors.Is(res.err, ErrWebhookNotFound):
+		// The endpoint was deleted or disabled since the delivery was queued
+		updates["status"] = types.WEBHOOK_DELIVERY_FAILED
+		updates["last_error"] = res.err.Error()
+	default:
+		reason := fmt.Sprintf("endpoint responded with status %d", res.status)
+		if res.err != nil {
+			reason = res.err.Error()
+		}
+		updates["last_error"] = reason
+		if d.Attempts+1 >= WebhookMaxAttempts {
+			updates["status"] = types.WEBHOOK_DELIVERY_FAILED
+		} else {
+			updates["next_attempt_at"] = now.Add(WebhookBackoff(d.Attempts + 1))
+		}
+	}
+	return tx.
+		Model(&models.WebhookDelivery{}).
+		Where("id = ?", d.ID).
+		Updates(updates).
+		Error
+}
+
+// sendWebhook posts the payload of a delivery to its endpoint, signed with the endpoint's secret
+func sendWebhook(endpoint *models.WebhookEndpoint, d *models.WebhookDelivery) webhookResult {
+	secret, err := decryptSecret(endpoint.Secret)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	body, err := json.Marshal(d.Payload)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SilverElven-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", d.EventID.String())
+	req.Header.Set("X-Webhook-Event", string(d.EventType))
+	req.Header.Set("X-Webhook-Delivery", d.ID.String())
+	req.Header.Set("X-Webhook-Signature", SignWebhookPayload(secret, time.Now(), body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	return webhookResult{status: resp.StatusCode, body: string(b)}
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature header of a delivery: its timestamp and the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint secret. Receivers should reject old timestamps.
+func SignWebhookPayload(secret string, at time.Time, body []byte) string {
+	ts := at.Unix()
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// WebhookBackoff returns the delay before the next attempt of a delivery, doubling from WebhookBaseDelay up to WebhookMaxDelay
+func WebhookBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 16 {
+		return WebhookMaxDelay
+	}
+	return min(WebhookBaseDelay<<(attempt-1), WebhookMaxDelay)
+}
+
+// emitBookingsCompleted emits booking.completed for the bookings matching the conditions that are not
+// completed yet, notifies their holders and schedules their reminders. Call it in the transaction completing them, before their
+// status is updated.
+func emitBookingsCompleted(tx *gorm.DB, query any, args ...any) error {
+	var bookings []models.Booking
+	if err := tx.
+		Preload("Event", func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id", "organizer_id", "title", "name", "date_time", "timezone", "reminders")
+		}).
+		Where(query, args...).
+		Where("status <> ?", types.BOOKING_COMPLETED).
+		Find(&bookings).
+		Error; err != nil {
+		return err
+	}
+	for i := range bookings {
+		if err := emitBookingCompleted(tx, &bookings[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func emitBookingCompleted(tx *gorm.DB, booking *models.Booking) error {
+	if booking.Event == nil {
+		return nil
+	}
+	n := eventNotification(booking.Event, types.NOTIFICATION_BOOKING_CONFIRMED,
+		fmt.Sprintf("Your booking for %s is confirmed", booking.Event.Title),
+	)
+	n.ReferenceBody = &types.JSONB{"bookingId": booking.ID}
+	if err := notifyUsers(tx, n, []uint{booking.UserID}); err != nil {
+		return err
+	}
+	if err := scheduleBookingReminders(tx, booking.Event, booking.ID); err != nil {
+		return err
+	}
+	if err := models.EnqueueOutboxMessage(tx, "Booking", booking.ID, broker.Topic("TicketLinksToSend"), types.JSONB{"id": booking.ID}); err != nil {
+		return err
+	}
+	return models.EmitWebhookEvent(tx, booking.Event.OrganizerID, types.WEBHOOK_BOOKING_COMPLETED, types.JSONB{
+		"id":            booking.ID,
+		"eventId":       booking.EventID,
+		"ticketId":      booking.TicketID,
+		"userId":        booking.UserID,
+		"qty":           booking.Qty,
+		"subtotal":      booking.Subtotal,
+		"currency":      booking.Currency,
+		"transactionId": booking.TransactionID,
+	})
+}
+
+// EmitReservationsCanceled emits reservation.canceled for the reservations of the bookings that are not
+// canceled yet. Call it in the transaction canceling them, before their status is updated.
+func EmitReservationsCanceled(tx *gorm.DB, bookingIds ...uint) error {
+	if len(bookingIds) == 0 {
+		return nil
+	}
+	var rows []struct {
+		ID          uint
+		BookingID   uint
+		TicketID    uint
+		EventID     uint
+		OrganizerID uint
+	}
+	if err := tx.
+		Model(&models.Reservation{}).
+		Select("reservations.id", "reservations.booking_id", "reservations.ticket_id", "bookings.event_id", "events.organizer_id").
+		Joins("JOIN bookings ON bookings.id = reservations.booking_id").
+		Joins("JOIN events ON events.id = bookings.event_id").
+		Where("reservations.booking_id IN (?)", bookingIds).
+		Where("reservations.status <> ?", types.RESERVATION_CANCELED).
+		Scan(&rows).
+		Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := models.EmitWebhookEvent(tx, r.OrganizerID, types.WEBHOOK_RESERVATION_CANCELED, types.JSONB{
+			"id":        r.ID,
+			"bookingId": r.BookingID,
+			"ticketId":  r.TicketID,
+			"eventId":   r.EventID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findWebhook(tx *gorm.DB, orgId uint, id uuid.UUID, endpoint *models.WebhookEndpoint) error {
+	if err := tx.
+		Where(&models.WebhookEndpoint{ID: id, OrganizationID: orgId}).
+		First(endpoint).
+		Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWebhookNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func validateWebhook(rawURL string, events []types.WebhookEventType) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	for _, event := range events {
+		if !slices.Contains(types.WebhookEventTypes, event) {
+			return ErrInvalidWebhookEvent
+		}
+	}
+	return nil
+}
+
+// newWebhookSecret returns a signing secret and its encrypted form
+func newWebhookSecret() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := "whsec_" + hex.EncodeToString(b)
+	enc, err := encryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, enc, nil
+}
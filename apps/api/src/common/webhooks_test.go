@@ -0,0 +1,102 @@
+package common
+
+import (
+	"ebs/src/config"
+	"ebs/src/db/dbtest"
+	"ebs/src/models"
+	"ebs/src/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendWebhook(t *testing.T) {
+	config.API_SECRET = strings.Repeat("ab", 32)
+	secret, enc, err := newWebhookSecret()
+	assert.Nil(t, err)
+
+	var signature, eventType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Webhook-Signature")
+		eventType = r.Header.Get("X-Webhook-Event")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	// The test server listens on loopback, which the delivery client refuses
+	client := webhookClient
+	webhookClient = server.Client()
+	t.Cleanup(func() { webhookClient = client })
+
+	endpoint := &models.WebhookEndpoint{URL: server.URL, Secret: enc}
+	delivery := &models.WebhookDelivery{
+		ID:        uuid.New(),
+		EventID:   uuid.New(),
+		EventType: types.WEBHOOK_BOOKING_COMPLETED,
+		Payload:   types.JSONB{"type": types.WEBHOOK_BOOKING_COMPLETED},
+	}
+	res := sendWebhook(endpoint, delivery)
+	assert.Nil(t, res.err)
+	assert.Equal(t, http.StatusNoContent, res.status)
+	assert.Equal(t, string(types.WEBHOOK_BOOKING_COMPLETED), eventType)
+
+	// Receivers verify the signature by recomputing it over the raw body
+	ts, _, ok := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	assert.True(t, ok)
+	at, err := strconv.ParseInt(ts, 10, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, SignWebhookPayload(secret, time.Unix(at, 0), body), signature)
+	assert.NotEqual(t, SignWebhookPayload("whsec_other", time.Unix(at, 0), body), signature)
+}
+
+func TestPublicWebhookAddress(t *testing.T) {
+	for _, addr := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "0.1.2.3",
+		"100.64.0.1", "100.127.255.254", "192.0.0.8", "198.18.0.1", "198.19.255.255", "240.0.0.1", "255.255.255.255",
+		"::1", "::", "fd00::1", "fe80::1", "ff02::1",
+		"::ffff:127.0.0.1", "::ffff:100.64.0.1", "::ffff:198.18.0.1",
+		"64:ff9b::a9fe:a9fe", "64:ff9b::a00:1", "64:ff9b::6440:1", "64:ff9b:1::1",
+	} {
+		assert.False(t, publicWebhookAddress(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"8.8.8.8", "100.128.0.1", "198.20.0.1", "::ffff:8.8.8.8", "64:ff9b::808:808", "2606:4700::1111"} {
+		assert.True(t, publicWebhookAddress(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, WebhookBaseDelay, WebhookBackoff(1))
+	assert.Equal(t, 2*WebhookBaseDelay, WebhookBackoff(2))
+	assert.Equal(t, 4*WebhookBaseDelay, WebhookBackoff(3))
+	assert.Equal(t, WebhookMaxDelay, WebhookBackoff(WebhookMaxAttempts*4))
+}
+
+func TestClaimWebhookDeliveries(t *testing.T) {
+	mock := dbtest.UseMockDB(t)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "webhook_deliveries" WHERE "webhook_deliveries"."status" = \$1 AND next_attempt_at <= \$2 .* `+
+		`ORDER BY next_attempt_at asc LIMIT \$3 FOR UPDATE SKIP LOCKED`).
+		WithArgs(types.WEBHOOK_DELIVERY_PENDING, sqlmock.AnyArg(), WebhookBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "endpoint_id", "status"}).AddRow(id, uuid.New(), types.WEBHOOK_DELIVERY_PENDING))
+	mock.ExpectExec(`UPDATE "webhook_deliveries" SET "next_attempt_at"=\$1,"updated_at"=\$2 WHERE id IN \(\$3\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	deliveries, err := claimWebhookDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
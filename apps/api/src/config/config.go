@@ -22,8 +22,16 @@ var (
 	APP_DOMAIN          = os.Getenv("APP_DOMAIN")
 	SMTP_FROM           = os.Getenv("SMTP_FROM")
 	JWT_SECRET          = os.Getenv("JWT_SECRET")
+	JWT_SIGNING_ALG     = os.Getenv("JWT_SIGNING_ALG")
 	API_SECRET          = os.Getenv("API_SECRET")
 	OAUTH_CLIENT_ID     = os.Getenv("OAUTH_CLIENT_ID")
 	OAUTH_CLIENT_SECRET = os.Getenv("OAUTH_CLIENT_SECRET")
 	GAPI_API_KEY        = os.Getenv("GAPI_API_KEY")
+	MESSAGE_BROKER      = os.Getenv("MESSAGE_BROKER")
+	SMS_PROVIDER        = os.Getenv("SMS_PROVIDER")
+	SMS_SENDER_ID       = os.Getenv("SMS_SENDER_ID")
+	// SES_EVENTS_TOPIC_ARN is the SNS topic SES publishes bounces and complaints to
+	SES_EVENTS_TOPIC_ARN = os.Getenv("SES_EVENTS_TOPIC_ARN")
+	// SENDGRID_WEBHOOK_PUBLIC_KEY verifies signed SendGrid event webhooks
+	SENDGRID_WEBHOOK_PUBLIC_KEY = os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY")
 )
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"ebs/src/common"
 	"ebs/src/config"
 	"ebs/src/db"
 	"ebs/src/lib"
@@ -155,22 +156,13 @@ func AccountsVerify(ctx *gin.Context) (status int, err error) {
 		return http.StatusInternalServerError, err
 	}
 	tx.Commit()
-	senderFrom := os.Getenv("SMTP_FROM")
 	verifyLink := fmt.Sprintf("%s/accounts/verify?token=%s", os.Getenv("APP_HOST"), token)
-	input := &lib.SendMailInput{
-		From:     senderFrom,
-		FromName: "noreply",
-		Subject:  "Verify Email",
-		To:       []string{email},
-		Body: fmt.Sprintf(`
-					<p>You have requested an email verification. Please click the following link to proceed.</p>
-					<a href="%s">verify email</a>
-					<p>If link does not work, try copying the url below and pasting in your browser</p>
-					<p>%s</p>
-					`, verifyLink, verifyLink),
-		Html: true,
-	}
-	if err := mailer.NewMailerMessage(input); err != nil {
+	input := &common.Message{
+		Category:     types.NOTIFY_ACCOUNT,
+		Template:     mailer.TemplateVerifyEmail,
+		TemplateData: mailer.VerifyEmail{URL: verifyLink},
+	}
+	if err := common.DispatchToAddress(input, email); err != nil {
 		return http.StatusBadRequest, err
 	}
 	return http.StatusOK, nil
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"crypto/rand"
+	"ebs/src/common"
 	"ebs/src/config"
 	"ebs/src/db"
 	"ebs/src/lib"
@@ -14,6 +15,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,7 +24,7 @@ import (
 	"gorm.io/gorm"
 )
 
-func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
+func AuthLogin(ctx *gin.Context) (tokens *types.AuthTokens, status int, err error) {
 	var body types.RegisterUserRequestBody
 	if err := ctx.ShouldBindJSON(&body); err != nil {
 		return nil, http.StatusBadRequest, err
@@ -37,6 +39,10 @@ func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
 		log.Printf("error from Firebase: %s\n", err.Error())
 		return nil, http.StatusNotFound, err
 	}
+	if user.UID != ctx.GetString("uid") {
+		err := errors.New("ID token does not belong to the account")
+		return nil, http.StatusUnauthorized, err
+	}
 
 	db := db.GetDb()
 	var muser models.User
@@ -72,7 +78,12 @@ func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
 		log.Printf("Could not retrieve credentials for user [%d]: %s\n", muser.ID, err.Error())
 		return nil, http.StatusBadRequest, err
 	}
-	if ctx.Request.Header.Get("origin") != "app:mobile" && len(muser.StoredCredentials) > 0 {
+	methods, err := common.MFAMethods(db, muser.ID)
+	if err != nil {
+		log.Printf("Could not retrieve second factors for user [%d]: %s\n", muser.ID, err.Error())
+		return nil, http.StatusBadRequest, err
+	}
+	if ctx.Request.Header.Get("origin") != "app:mobile" && len(methods) > 0 {
 		flowId := uuid.NewString()
 		bNonce := make([]byte, 32)
 		rand.Read(bNonce)
@@ -88,6 +99,7 @@ func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
 			"state":     "pending",
 			"flow_id":   flowId,
 			"user_id":   int(muser.ID),
+			"methods":   methods,
 			"timestamp": time.Now().UnixMilli(),
 		})
 		exp := 5 * time.Minute
@@ -96,11 +108,16 @@ func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
 		ctx.Header("X-Authenticate-MFA", "true")
 		ctx.Header("X-MFA-Flow-ID", flowId)
 		ctx.Header("X-MFA-Challenge", nonce)
+		ctx.Header("X-MFA-Methods", strings.Join(mfaMethodNames(methods), ","))
 		log.Println("Credentials found: initializing secondary auth")
 		return nil, http.StatusUnauthorized, nil
 	}
 
-	jwt, _ := utils.GenerateJWT(user.Email, muser.ID, muser.ActiveOrg)
+	tokens, err = common.StartSession(user.Email, muser.ID, muser.ActiveOrg, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		log.Printf("Error starting session for user [%d]: %s\n", muser.ID, err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
 
 	_, err = rd.JSONSet(ctx, fmt.Sprintf("%d:user", muser.ID), "$", &muser).Result()
 	if err != nil {
@@ -114,7 +131,7 @@ func AuthLogin(ctx *gin.Context) (token *string, status int, err error) {
 	fcm, _ := lib.GetFirebaseMessaging()
 	fcm.SubscribeToTopic(ctx, []string{val}, "Notifications")
 
-	return &jwt, http.StatusOK, nil
+	return tokens, http.StatusOK, nil
 }
 
 func AuthRegister(ctx *gin.Context) (uid *string, status int, err error) {
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"ebs/src/common"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/lib"
+	"ebs/src/models"
+	"ebs/src/types"
+	"ebs/src/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxMFAAttempts is how many invalid codes are accepted before the sign in has to be started again
+const maxMFAAttempts = 5
+
+// TOTPLoginFinish completes a sign in with an authenticator app code or a recovery code
+func TOTPLoginFinish(ctx *gin.Context) (tokens *types.AuthTokens, status int, err error) {
+	mfaStateKey, mfaState, status, err := verifyMFAChallenge(ctx)
+	if err != nil {
+		return nil, status, err
+	}
+	var body types.MFALoginRequestBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	var user models.User
+	db := db.GetDb()
+	if err := db.
+		Select("id", "email", "active_org").
+		Where(&models.User{Email: body.Email}).
+		First(&user).
+		Error; err != nil {
+		log.Printf("Error retrieving user [%s]: %s\n", body.Email, err.Error())
+		return nil, http.StatusBadRequest, errors.New("invalid request")
+	}
+	if userId, _ := mfaState["user_id"].(float64); uint(userId) != user.ID {
+		log.Printf("MFA state user mismatch: expected=%v got=%d\n", mfaState["user_id"], user.ID)
+		return nil, http.StatusUnauthorized, errors.New("access denied")
+	}
+	rd := lib.GetRedisClient()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return common.VerifyMFACode(tx, user.ID, body.Code)
+	})
+	if errors.Is(err, common.ErrInvalidMFACode) {
+		attempts, _ := mfaState["attempts"].(float64)
+		mfaState["attempts"] = attempts + 1
+		if attempts+1 >= maxMFAAttempts {
+			rd.Del(ctx, mfaStateKey)
+		} else if err := rd.JSONSet(ctx, mfaStateKey, "$", mfaState).Err(); err != nil {
+			log.Printf("Error updating MFA state: %s\n", err.Error())
+		}
+		return nil, http.StatusUnauthorized, err
+	}
+	if err != nil {
+		log.Printf("Error verifying MFA code of user [%d]: %s\n", user.ID, err.Error())
+		return nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	rd.Del(ctx, mfaStateKey)
+	tokens, err = common.StartSession(user.Email, user.ID, user.ActiveOrg, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		log.Printf("Error starting session for user [%d]: %s\n", user.ID, err.Error())
+		return nil, http.StatusBadRequest, errors.New("invalid request")
+	}
+	return tokens, http.StatusOK, nil
+}
+
+// verifyMFAChallenge checks the X-MFA-Flow-ID and X-MFA-Challenge headers against the MFA state created
+// by AuthLogin and marks the state as complete
+func verifyMFAChallenge(ctx *gin.Context) (key string, mfaState map[string]any, status int, err error) {
+	hFlowId := ctx.Request.Header.Get("X-MFA-Flow-ID")
+	hChallenge := ctx.Request.Header.Get("X-MFA-Challenge")
+	rd := lib.GetRedisClient()
+	uid := ctx.GetString("uid")
+	mfaStateKey := fmt.Sprintf("%s:mfa_state", uid)
+	state, err := rd.JSONGet(ctx, mfaStateKey).Result()
+	if err != nil {
+		log.Printf("Error reading state cache: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	if err := json.Unmarshal([]byte(state), &mfaState); err != nil {
+		log.Printf("Could not read state: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	realFlowId, _ := mfaState["flow_id"].(string)
+	if subtle.ConstantTimeCompare([]byte(hFlowId), []byte(realFlowId)) != 1 {
+		log.Printf("Flow ID mismatch: expected=%s got=%s", realFlowId, hFlowId)
+		return "", nil, http.StatusUnauthorized, errors.New("access denied")
+	}
+	hNonce, err := hex.DecodeString(hChallenge)
+	if err != nil {
+		log.Printf("Error decoding challenge: %s\n", err.Error())
+		return "", nil, http.StatusBadRequest, errors.New("invalid request")
+	}
+	encNonce, _ := mfaState["nonce"].(string)
+	secret, err := hex.DecodeString(config.API_SECRET)
+	if err != nil {
+		log.Printf("Error reading secret: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	decNonce, err := utils.DecryptMessage(secret, encNonce)
+	if err != nil {
+		log.Printf("Error decrypting message: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	nonce, err := hex.DecodeString(*decNonce)
+	if err != nil {
+		log.Printf("Error decoding nonce: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	if subtle.ConstantTimeCompare(hNonce, nonce) != 1 {
+		log.Printf("Nonce mismatch: expected=%s got=%s\n", nonce, hNonce)
+		return "", nil, http.StatusUnauthorized, errors.New("access denied")
+	}
+	mfaState["state"] = "complete"
+	_, err = rd.JSONSet(ctx, mfaStateKey, "$", mfaState).Result()
+	if err != nil {
+		log.Printf("Error updating MFA state: %s\n", err.Error())
+		return "", nil, http.StatusInternalServerError, errors.New("something went wrong")
+	}
+	return mfaStateKey, mfaState, http.StatusOK, nil
+}
+
+func mfaMethodNames(methods []types.MFAMethod) []string {
+	names := make([]string, len(methods))
+	for i, method := range methods {
+		names[i] = string(method)
+	}
+	return names
+}
@@ -3,12 +3,12 @@ package controllers
 import (
 	"context"
 	"crypto/subtle"
-	"ebs/src/config"
+	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/lib"
 	"ebs/src/models"
+	"ebs/src/types"
 	"ebs/src/utils"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -71,58 +71,12 @@ func PasskeyLoginStart(ctx *gin.Context) (opts *protocol.CredentialAssertion, st
 	rd.JSONSet(context.Background(), fmt.Sprintf("%d:passkey:login", user.ID), "$", ses)
 	return opts, http.StatusOK, nil
 }
-func PasskeyLoginFinish(ctx *gin.Context) (token *string, status int, err error) {
-	hFlowId := ctx.Request.Header.Get("X-MFA-Flow-ID")
-	hChallenge := ctx.Request.Header.Get("X-MFA-Challenge")
+func PasskeyLoginFinish(ctx *gin.Context) (tokens *types.AuthTokens, status int, err error) {
+	if _, _, status, err := verifyMFAChallenge(ctx); err != nil {
+		return nil, status, err
+	}
 	rd := lib.GetRedisClient()
 	uid := ctx.GetString("uid")
-	mfaStateKey := fmt.Sprintf("%s:mfa_state", uid)
-	state, err := rd.JSONGet(ctx, mfaStateKey).Result()
-	if err != nil {
-		log.Printf("Error reading state cache: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
-	var mfaState map[string]any
-	if err := json.Unmarshal([]byte(state), &mfaState); err != nil {
-		log.Printf("Could not read state: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
-	realFlowId := mfaState["flow_id"].(string)
-	if subtle.ConstantTimeCompare([]byte(hFlowId), []byte(realFlowId)) != 1 {
-		log.Printf("Flow ID mismatch: expected=%s got=%s", realFlowId, hFlowId)
-		return nil, http.StatusUnauthorized, errors.New("access denied")
-	}
-	hNonce, err := hex.DecodeString(hChallenge)
-	if err != nil {
-		log.Printf("Error decoding challenge: %s\n", err.Error())
-		return nil, http.StatusBadRequest, errors.New("invalid request")
-	}
-	encNonce := mfaState["nonce"].(string)
-	secret, err := hex.DecodeString(config.API_SECRET)
-	if err != nil {
-		log.Printf("Error reading secret: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
-	decNonce, err := utils.DecryptMessage(secret, encNonce)
-	if err != nil {
-		log.Printf("Error decrypting message: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
-	nonce, err := hex.DecodeString(*decNonce)
-	if err != nil {
-		log.Printf("Error decoding nonce: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
-	if subtle.ConstantTimeCompare(hNonce, nonce) != 1 {
-		log.Printf("Nonce mismatch: expected=%s got=%s\n", nonce, hNonce)
-		return nil, http.StatusUnauthorized, errors.New("access denied")
-	}
-	mfaState["state"] = "complete"
-	_, err = rd.JSONSet(ctx, mfaStateKey, "$", mfaState).Result()
-	if err != nil {
-		log.Printf("Error updating MFA state: %s\n", err.Error())
-		return nil, http.StatusInternalServerError, errors.New("something went wrong")
-	}
 	var query struct {
 		Email string `form:"email" binding:"required"`
 	}
@@ -164,8 +118,9 @@ func PasskeyLoginFinish(ctx *gin.Context) (token *string, status int, err error)
 		log.Printf("Passkey login failed: %s\n", err.Error())
 		return nil, http.StatusUnauthorized, errors.New("access denied")
 	}
-	jwt, err := utils.GenerateJWT(user.Email, user.ID, user.ActiveOrg)
+	tokens, err = common.StartSession(user.Email, user.ID, user.ActiveOrg, ctx.Request.UserAgent(), ctx.ClientIP())
 	if err != nil {
+		log.Printf("Error starting session for user [%d]: %s\n", user.ID, err.Error())
 		return nil, http.StatusBadRequest, errors.New("invalid request")
 	}
 	go func() {
@@ -178,5 +133,5 @@ func PasskeyLoginFinish(ctx *gin.Context) (token *string, status int, err error)
 		fcm, _ := lib.GetFirebaseMessaging()
 		fcm.SubscribeToTopic(ctx.Copy(), []string{token}, "Notifications")
 	}()
-	return &jwt, http.StatusOK, nil
+	return tokens, http.StatusOK, nil
 }
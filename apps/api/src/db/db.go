@@ -25,11 +25,19 @@ func GetDb() *gorm.DB {
 	}
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
+	if err := RegisterTenantCallbacks(_db); err != nil {
+		log.Fatalf("Error registering tenant callbacks: %s\n", err.Error())
+	}
 
 	db = _db
 	return _db
 }
 
 func NewDB(newdb *gorm.DB) {
+	if newdb != nil {
+		if err := RegisterTenantCallbacks(newdb); err != nil {
+			log.Printf("Error registering tenant callbacks: %s\n", err.Error())
+		}
+	}
 	db = newdb
 }
@@ -0,0 +1,34 @@
+// Package dbtest provides a mock database for tests of code that queries the shared database.
+package dbtest
+
+import (
+	"ebs/src/db"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// NewMockDB opens a postgres gorm database backed by sqlmock
+func NewMockDB(t testing.TB) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not open a stub database connection: %s", err)
+	}
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("could not open gorm database: %s", err)
+	}
+	return gormDB, mock
+}
+
+// UseMockDB replaces the shared database with a mock until the test ends
+func UseMockDB(t testing.TB) sqlmock.Sqlmock {
+	t.Helper()
+	gormDB, mock := NewMockDB(t)
+	db.NewDB(gormDB)
+	t.Cleanup(func() { db.NewDB(nil) })
+	return mock
+}
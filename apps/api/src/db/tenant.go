@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+type tenantKey struct{}
+
+type organizationKey struct{}
+
+const (
+	tenantCallback       = "app:set_tenant"
+	tenantBeginCallback  = "app:begin_tenant_transaction"
+	tenantCommitCallback = "app:commit_tenant_transaction"
+)
+
+// ErrTenantOutsideTransaction is returned by Rows and Scan of a tenant scoped session outside a transaction.
+// app.current_tenant is transaction local and their rows are read after the statement returns, so they can
+// not run in a transaction of their own. Row returns a nil *sql.Row in that case.
+var ErrTenantOutsideTransaction = errors.New("tenant scoped statements must run in a transaction")
+
+// Tenant returns a session of the database scoped to tenantId. Every statement it runs sets
+// app.current_tenant first so the row level security policies apply to it, queries outside a
+// transaction run in one of their own like writes do. Row, Rows and Scan must run in a transaction,
+// see ErrTenantOutsideTransaction. An empty tenantId matches no tenant.
+func Tenant(tenantId string) *gorm.DB {
+	if tenantId == "" {
+		tenantId = uuid.Nil.String()
+	}
+	return GetDb().WithContext(WithTenant(context.Background(), tenantId))
+}
+
+// WithTenant returns a copy of ctx scoping database statements run with it to tenantId
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+// TenantFromContext returns the tenant database statements run with ctx are scoped to
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantId, ok := ctx.Value(tenantKey{}).(string)
+	return tenantId, ok
+}
+
+// WithOrganization returns a copy of ctx that also lets the tenant scoped statements run with it read the
+// bookings, reservations, admissions, transactions and subscriptions of the events of orgId. Callers check
+// the permissions of the user on the organization.
+func WithOrganization(ctx context.Context, orgId uint) context.Context {
+	return context.WithValue(ctx, organizationKey{}, orgId)
+}
+
+// OrganizationFromContext returns the organization set by WithOrganization
+func OrganizationFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	orgId, ok := ctx.Value(organizationKey{}).(uint)
+	return orgId, ok
+}
+
+// RegisterTenantCallbacks makes statements run with a tenant scoped context set app.current_tenant
+func RegisterTenantCallbacks(d *gorm.DB) error {
+	cb := d.Callback()
+	if cb.Query().Get(tenantCallback) != nil {
+		return nil
+	}
+	if err := cb.Create().After("gorm:begin_transaction").Before("gorm:create").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:begin_transaction").Before("gorm:update").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:begin_transaction").Before("gorm:delete").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register(tenantBeginCallback, beginTenantTransaction); err != nil {
+		return err
+	}
+	if err := cb.Query().After(tenantBeginCallback).Before("gorm:query").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:after_query").Register(tenantCommitCallback, commitTenantTransaction); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register(tenantBeginCallback, beginTenantTransaction); err != nil {
+		return err
+	}
+	if err := cb.Raw().After(tenantBeginCallback).Before("gorm:raw").Register(tenantCallback, setTenant); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register(tenantCommitCallback, commitTenantTransaction)
+}
+
+// beginTenantTransaction starts a transaction for tenant scoped queries that are not run in one.
+// Rows are read by the caller after the statement so Row and Rows are left out.
+func beginTenantTransaction(tx *gorm.DB) {
+	if _, ok := TenantFromContext(tx.Statement.Context); ok {
+		callbacks.BeginTransaction(tx)
+	}
+}
+
+func commitTenantTransaction(tx *gorm.DB) {
+	if _, ok := TenantFromContext(tx.Statement.Context); ok {
+		callbacks.CommitOrRollbackTransaction(tx)
+	}
+}
+
+func setTenant(tx *gorm.DB) {
+	if tx.Error != nil {
+		return
+	}
+	tenantId, ok := TenantFromContext(tx.Statement.Context)
+	if !ok {
+		return
+	}
+	if _, ok := tx.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		tx.AddError(ErrTenantOutsideTransaction)
+		return
+	}
+	var orgId string
+	if id, ok := OrganizationFromContext(tx.Statement.Context); ok {
+		orgId = strconv.FormatUint(uint64(id), 10)
+	}
+	if _, err := tx.Statement.ConnPool.ExecContext(tx.Statement.Context, "SELECT set_tenant($1, $2)", tenantId, orgId); err != nil {
+		tx.AddError(err)
+	}
+}
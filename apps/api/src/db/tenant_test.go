@@ -0,0 +1,116 @@
+package db_test
+
+import (
+	"context"
+	"ebs/src/db"
+	"ebs/src/db/dbtest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestTenant(t *testing.T) {
+	const tenantId = "6f1c2d3e-4b5a-4c7d-8e9f-0a1b2c3d4e5f"
+
+	t.Run("sets the tenant in transactions", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_tenant\(\$1, \$2\)`).
+			WithArgs(tenantId, "").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_tenant\(\$1, \$2\)`).
+			WithArgs(tenantId, "").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE "bookings" SET "status"=\$1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := db.Tenant(tenantId).Transaction(func(tx *gorm.DB) error {
+			var id uint
+			if err := tx.Table("bookings").Select("id").Where("id = ?", 1).Take(&id).Error; err != nil {
+				return err
+			}
+			return tx.Table("bookings").Where("id = ?", id).Update("status", "canceled").Error
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("runs queries outside a transaction in one", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_tenant\(\$1, \$2\)`).
+			WithArgs(tenantId, "").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		var id uint
+		err := db.Tenant(tenantId).Table("bookings").Select("id").Take(&id).Error
+		assert.Nil(t, err)
+		assert.Equal(t, uint(1), id)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses rows outside a transaction", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		scoped := db.Tenant(tenantId).Table("bookings").Select("id")
+		_, err := scoped.Rows()
+		assert.ErrorIs(t, err, db.ErrTenantOutsideTransaction)
+		var id uint
+		assert.ErrorIs(t, scoped.Scan(&id).Error, db.ErrTenantOutsideTransaction)
+		assert.Nil(t, scoped.Row())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reads rows in a transaction", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_tenant\(\$1, \$2\)`).
+			WithArgs(tenantId, "").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		var id uint
+		err := db.Tenant(tenantId).Transaction(func(tx *gorm.DB) error {
+			return tx.Table("bookings").Select("id").Scan(&id).Error
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, uint(1), id)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("sets the organization of the tenant", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_tenant\(\$1, \$2\)`).
+			WithArgs(tenantId, "7").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		ctx := db.WithOrganization(db.WithTenant(context.Background(), tenantId), 7)
+		var id uint
+		err := db.GetDb().WithContext(ctx).Table("bookings").Select("id").Take(&id).Error
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("leaves unscoped statements alone", func(t *testing.T) {
+		mock := dbtest.UseMockDB(t)
+		mock.ExpectQuery(`SELECT id FROM "bookings"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		var id uint
+		err := db.GetDb().Table("bookings").Select("id").Take(&id).Error
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
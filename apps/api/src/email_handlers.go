@@ -0,0 +1,100 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/lib/mailer"
+	"ebs/src/middlewares"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func emailHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canUpdate := middlewares.RequirePermission(types.PERM_ORG_UPDATE, middlewares.OrganizationParam("orgId"))
+	g.
+		PUT("/users/me/locale", func(ctx *gin.Context) {
+			var body types.UpdateLocaleRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			locale, err := common.SetUserLocale(ctx.GetUint("id"), body.Locale)
+			if err != nil {
+				log.Printf("Error updating locale of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(emailErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"locale": locale})
+		})
+	keys.
+		GET("/organizations/:orgId/email-branding", canUpdate, func(ctx *gin.Context) {
+			branding, err := common.GetEmailBranding(ctx.GetUint("org_id"))
+			if err != nil {
+				log.Printf("Error retrieving email branding of Organization [%d]: %s\n", ctx.GetUint("org_id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": branding})
+		}).
+		PUT("/organizations/:orgId/email-branding", canUpdate, func(ctx *gin.Context) {
+			var body types.UpdateEmailBrandingRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			before, after, err := common.UpdateEmailBranding(orgId, body)
+			if err != nil {
+				log.Printf("Error updating email branding of Organization [%d]: %s\n", orgId, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_EMAIL_BRANDING_UPDATE, orgId, orgId, brandingAudit(before), brandingAudit(after))
+			ctx.JSON(http.StatusOK, gin.H{"data": after})
+		}).
+		GET("/organizations/:orgId/email-templates", canUpdate, func(ctx *gin.Context) {
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":    mailer.TemplateNames(),
+				"locales": mailer.Locales(),
+			})
+		}).
+		GET("/organizations/:orgId/email-templates/:name/preview", canUpdate, func(ctx *gin.Context) {
+			var query types.EmailPreviewQuery
+			if err := ctx.ShouldBindQuery(&query); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			email, err := common.PreviewEmail(ctx.GetUint("org_id"), ctx.Param("name"), query.Locale)
+			if err != nil {
+				log.Printf("Error previewing email template [%s]: %s\n", ctx.Param("name"), err.Error())
+				ctx.JSON(emailErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": email})
+		})
+	return g
+}
+
+func brandingAudit(branding *models.EmailBranding) types.JSONB {
+	return types.JSONB{
+		"senderName":   branding.SenderName,
+		"logoUrl":      branding.LogoURL,
+		"primaryColor": branding.PrimaryColor,
+		"footerText":   branding.FooterText,
+	}
+}
+
+func emailErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, mailer.ErrUnknownTemplate):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrUnsupportedLocale):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
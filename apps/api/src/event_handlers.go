@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/lib"
+	"ebs/src/middlewares"
 	"ebs/src/models"
 	"ebs/src/types"
 	"ebs/src/utils"
@@ -22,8 +24,9 @@ import (
 )
 
 func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
-	g.
-		PATCH("/events/:id/status", func(ctx *gin.Context) {
+	keys := middlewares.APIKeyRoutes(g)
+	keys.
+		PATCH("/events/:id/status", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
 			var body struct {
 				NewStatus types.EventStatus `json:"new_status" binding:"required"`
 			}
@@ -38,8 +41,19 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			tenantId := ctx.GetString("tenant_id")
 			tid, _ := uuid.Parse(tenantId)
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			if err := db.Transaction(func(tx *gorm.DB) error {
+				var event models.Event
+				if err := tx.
+					Select("id", "status", "mode").
+					Where(&models.Event{
+						ID:       params.ID,
+						TenantID: &tid,
+					}).
+					First(&event).
+					Error; err != nil {
+					return err
+				}
 				if err := tx.
 					Model(&models.Event{}).
 					Where(&models.Event{
@@ -51,7 +65,12 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					log.Printf("Error updating event status: %s\n", err.Error())
 					return err
 				}
-				return nil
+				if err := models.EmitEventStatusChanged(tx, ctx.GetUint("org_id"), params.ID, event.Status, body.NewStatus); err != nil {
+					return err
+				}
+				return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_EVENT_STATUS, ctx.GetUint("org_id"), params.ID,
+					types.JSONB{"status": event.Status, "mode": event.Mode},
+					types.JSONB{"status": body.NewStatus, "mode": "manual"}))
 			}); err != nil {
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					ctx.Status(http.StatusNotFound)
@@ -61,14 +80,11 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.Status(http.StatusNoContent)
-		}).
+		})
+	g.
 		GET("/events", func(ctx *gin.Context) {
 			var events []models.Event
-			tenantId := ctx.GetString("tenant_id")
-			db := db.GetDb()
-			if err := db.Exec("select set_tenant(?)", tenantId).Error; err != nil {
-				log.Printf("Error on Exec: %s\n", err.Error())
-			}
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err := db.Transaction(func(tx *gorm.DB) error {
 				today := time.Now()
 				in1m := today.Add(1 * time.Minute)
@@ -103,7 +119,7 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 		GET("/events/waitlist", func(ctx *gin.Context) {
 			userId := ctx.GetUint("id")
 			var subscriptions []models.EventSubscription
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err := db.Transaction(func(tx *gorm.DB) error {
 				err := tx.
 					Model(&models.EventSubscription{}).
@@ -141,7 +157,7 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			eventId := uint(atoi)
 			var event models.Event
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err = db.
 				Model(&models.Event{}).
 				Where(db.Where(&models.Event{ID: eventId})).
@@ -178,7 +194,11 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			eventId := uint(atoi)
 			userId := ctx.GetUint("id")
 			uid := ctx.GetString("uid")
-			db := db.GetDb()
+			var tenantId *uuid.UUID
+			if tid, err := uuid.Parse(ctx.GetString("tenant_id")); err == nil {
+				tenantId = &tid
+			}
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			var subscription models.EventSubscription
 			err = db.Transaction(func(tx *gorm.DB) error {
 				var event models.Event
@@ -187,10 +207,12 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					return err
 				}
 
-				err = tx.FirstOrCreate(&subscription, &models.EventSubscription{
-					EventID:      eventId,
-					SubscriberID: userId,
-				}).Error
+				err = tx.
+					Attrs(&models.EventSubscription{TenantID: tenantId}).
+					FirstOrCreate(&subscription, &models.EventSubscription{
+						EventID:      eventId,
+						SubscriberID: userId,
+					}).Error
 				if err != nil {
 					return err
 				}
@@ -217,8 +239,9 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}()
 
 			ctx.JSON(http.StatusCreated, gin.H{"id": subscription.ID})
-		}).
-		PATCH("/events/:id/publish", func(ctx *gin.Context) {
+		})
+	keys.
+		PATCH("/events/:id/publish", middlewares.RequirePermission(types.PERM_EVENTS_PUBLISH, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
 			id := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(id)
 			if err != nil {
@@ -226,13 +249,65 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			eventId := uint(atoi)
+			var event models.Event
+			if err := db.GetDb().Select("id", "status").Where(&models.Event{ID: eventId}).First(&event).Error; err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
 			err = utils.PublishEvent(eventId)
 			if err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			recordAudit(ctx, types.AUDIT_EVENT_PUBLISH, ctx.GetUint("org_id"), eventId,
+				types.JSONB{"status": event.Status},
+				types.JSONB{"status": types.EVENT_REGISTRATION})
 			ctx.JSON(http.StatusOK, gin.H{"id": eventId})
 		}).
+		GET("/events/:id/reminders", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var event models.Event
+			if err := db.GetDb().Select("id", "reminders").Where(&models.Event{ID: params.ID}).First(&event).Error; err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			reminders := event.Reminders
+			if reminders == nil {
+				reminders = models.DefaultEventReminders
+			}
+			ctx.JSON(http.StatusOK, gin.H{"reminders": reminders, "default": event.Reminders == nil})
+		}).
+		PUT("/events/:id/reminders", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.UpdateEventRemindersRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			before, after, err := common.UpdateEventReminders(params.ID, body.Reminders)
+			if err != nil {
+				log.Printf("Error updating reminders of Event [%d]: %s\n", params.ID, err.Error())
+				status := http.StatusBadRequest
+				if errors.Is(err, common.ErrEventNotFound) {
+					status = http.StatusNotFound
+				}
+				ctx.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_EVENT_REMINDERS, ctx.GetUint("org_id"), params.ID,
+				types.JSONB{"reminders": before},
+				types.JSONB{"reminders": after})
+			ctx.JSON(http.StatusOK, gin.H{"reminders": after})
+		})
+	g.
 		GET("/events/:id/tickets", func(ctx *gin.Context) {
 			id := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(id)
@@ -247,8 +322,9 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": tickets})
-		}).
-		POST("/events/:id/tickets", func(ctx *gin.Context) {
+		})
+	keys.
+		POST("/events/:id/tickets", middlewares.RequirePermission(types.PERM_TICKETS_CREATE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
 			id := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(id)
 			if err != nil {
@@ -293,7 +369,7 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusCreated, gin.H{"id": newId})
 		}).
-		POST("/events/:id/coupon", func(ctx *gin.Context) {
+		POST("/events/:id/coupon", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
 			var params types.SimpleRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.Status(http.StatusBadRequest)
@@ -374,7 +450,7 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			log.Printf("Created coupon with ID %s\n", coupon.ID)
 			ctx.Status(http.StatusCreated)
 		}).
-		POST("/events", func(ctx *gin.Context) {
+		POST("/events", middlewares.RequirePermission(types.PERM_EVENTS_CREATE, middlewares.ActiveOrganization), func(ctx *gin.Context) {
 			var body types.CreateEventRequestBody
 			if err := ctx.ShouldBindJSON(&body); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -398,7 +474,8 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				log.Printf("Could not subscribe to topic [%s]: %s\n", topic, err.Error())
 			}
 			ctx.JSON(http.StatusCreated, gin.H{"id": id})
-		}).
+		})
+	g.
 		GET("/events/:id/subscription", func(ctx *gin.Context) {
 			var params types.SimpleRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
@@ -409,12 +486,12 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ID uint
 			}
 			subscriber := ctx.GetUint("id")
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			if err := db.
 				Model(&models.EventSubscription{}).
 				Where(&models.EventSubscription{EventID: params.ID, SubscriberID: subscriber, Status: types.EVENT_SUBSCRIPTION_NOTIFY}).
 				Select("id").
-				Scan(&sub).
+				Take(&sub).
 				Error; err != nil {
 				log.Printf("Error retrieving EventSubscription: %s\n", err.Error())
 				if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -432,14 +509,8 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
-			tenantId := ctx.GetString("tenant_id")
 			subscriber := ctx.GetUint("id")
-			db := db.GetDb()
-			if err := db.Exec("select set_tenant(?)", tenantId).Error; err != nil {
-				log.Printf("Error on Exec: %s\n", err.Error())
-				ctx.Status(http.StatusBadRequest)
-				return
-			}
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			if err := db.
 				Model(&models.EventSubscription{}).
 				Delete(&models.EventSubscription{EventID: params.ID, SubscriberID: subscriber}).
@@ -453,24 +524,20 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.Status(http.StatusNoContent)
-		}).
-		DELETE("/events/:id", func(ctx *gin.Context) {
+		})
+	keys.
+		DELETE("/events/:id", middlewares.RequirePermission(types.PERM_EVENTS_DELETE, middlewares.EventOrganization("id")), func(ctx *gin.Context) {
 			var params types.SimpleRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
-			tenantId := ctx.GetString("tenant_id")
-			db := db.GetDb()
-			if err := db.Exec("select set_tenant(?)", tenantId).Error; err != nil {
-				log.Printf("Error on Exec: %s\n", err.Error())
-				ctx.Status(http.StatusBadRequest)
-				return
-			}
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			if err := db.Transaction(func(tx *gorm.DB) error {
+				var event models.Event
 				if err := tx.
 					Where(&models.Event{ID: params.ID}).
-					First(&models.Event{}).
+					First(&event).
 					Error; err != nil {
 					return err
 				}
@@ -480,6 +547,9 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					Error; err != nil {
 					return err
 				}
+				if err := models.EmitEventStatusChanged(tx, event.OrganizerID, params.ID, event.Status, types.EVENT_ARCHIVED); err != nil {
+					return err
+				}
 				if err := tx.
 					Model(&models.Event{}).
 					Select(clause.Associations).
@@ -487,7 +557,9 @@ func eventHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 					Error; err != nil {
 					return err
 				}
-				return nil
+				return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_EVENT_DELETE, event.OrganizerID, params.ID,
+					types.JSONB{"status": event.Status},
+					types.JSONB{"status": types.EVENT_ARCHIVED, "deleted": true}))
 			}); err != nil {
 				log.Printf("DELETE Event transaction failed: %s\n", err.Error())
 				if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"ebs/src/config"
+	"ebs/src/types"
+	"ebs/src/utils"
+	"log"
+	"sync"
+)
+
+// Broker publishes messages to and consumes messages from named topics.
+// Topic names are expected to be fully qualified, see Topic.
+type Broker interface {
+	Name() string
+	CreateTopics(topics ...string) error
+	Publish(topic string, payload *types.JSONB) error
+	Subscribe(group string, topic string, handler types.Handler) error
+	Close() error
+}
+
+var (
+	broker Broker
+	mu     sync.Mutex
+)
+
+// Topic returns the environment-qualified name of a topic
+func Topic(name string) string {
+	return utils.WithSuffix(name)
+}
+
+// NewBroker replaces the shared Broker instance with a custom implementation
+func NewBroker(b Broker) Broker {
+	mu.Lock()
+	defer mu.Unlock()
+	broker = b
+	return broker
+}
+
+// GetBroker returns the shared Broker instance, creating one based on the app environment value if none is set
+func GetBroker() Broker {
+	mu.Lock()
+	defer mu.Unlock()
+	if broker != nil {
+		return broker
+	}
+	broker = CreateBroker()
+	log.Printf("[BROKER] Using %s broker\n", broker.Name())
+	return broker
+}
+
+// CreateBroker returns either an instance of SQSBroker, KafkaBroker or MemoryBroker based on the app environment value.
+// MESSAGE_BROKER can be set to "sqs", "kafka" or "memory" to override the default.
+func CreateBroker() Broker {
+	switch config.MESSAGE_BROKER {
+	case "sqs":
+		return NewSQSBroker()
+	case "kafka":
+		return NewKafkaBroker("ebs")
+	case "memory":
+		return NewMemoryBroker()
+	}
+	env := config.API_ENV
+	if env == string(types.Production) || env == string(types.Test) {
+		return NewSQSBroker()
+	}
+	return NewKafkaBroker("ebs")
+}
+
+// Publish sends a payload to a topic using the shared Broker instance
+func Publish(topic string, payload *types.JSONB) error {
+	return GetBroker().Publish(topic, payload)
+}
+
+// Subscribe registers a handler for a topic using the shared Broker instance
+func Subscribe(group string, topic string, handler types.Handler) error {
+	return GetBroker().Subscribe(group, topic, handler)
+}
@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"ebs/src/lib"
+	"ebs/src/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+type KafkaBroker struct {
+	clientId  string
+	mu        sync.Mutex
+	producer  *kafka.Producer
+	consumers []*kafka.Consumer
+	done      chan struct{}
+}
+
+func NewKafkaBroker(clientId string) *KafkaBroker {
+	k := KafkaBroker{
+		clientId: clientId,
+		done:     make(chan struct{}),
+	}
+	return &k
+}
+
+func (k *KafkaBroker) Name() string {
+	return "Kafka"
+}
+
+func (k *KafkaBroker) CreateTopics(topics ...string) error {
+	_, err := lib.KafkaCreateTopics(topics...)
+	return err
+}
+
+func (k *KafkaBroker) getProducer() (*kafka.Producer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.producer != nil {
+		return k.producer, nil
+	}
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": os.Getenv("KAFKA_BROKER"),
+		"client.id":         k.clientId,
+		"acks":              "all",
+	})
+	if err != nil {
+		log.Printf("Error on producer: %s\n", err.Error())
+		return nil, err
+	}
+	go func() {
+		for e := range p.Events() {
+			if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+				log.Printf("[KAFKA:%s] Delivery failed: %s\n", *m.TopicPartition.Topic, m.TopicPartition.Error.Error())
+			}
+		}
+	}()
+	k.producer = p
+	return p, nil
+}
+
+func (k *KafkaBroker) Publish(topic string, payload *types.JSONB) error {
+	p, err := k.getProducer()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return p.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          value,
+	}, nil)
+}
+
+func (k *KafkaBroker) Subscribe(group string, topic string, handler types.Handler) error {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": os.Getenv("KAFKA_BROKER"),
+		"group.id":          group,
+		"auto.offset.reset": "smallest",
+	})
+	if err != nil {
+		log.Printf("Error on consumer: %s\n", err.Error())
+		return err
+	}
+	if err := c.SubscribeTopics([]string{topic}, func(c *kafka.Consumer, e kafka.Event) error {
+		log.Printf("[KAFKA:%s] Consumer rebalancing...\n", topic)
+		return nil
+	}); err != nil {
+		c.Close()
+		return fmt.Errorf("error subscribing to topic %s: %s", topic, err.Error())
+	}
+	k.mu.Lock()
+	k.consumers = append(k.consumers, c)
+	k.mu.Unlock()
+	go func() {
+		log.Printf("[KAFKA:%s] waiting for messages...\n", topic)
+		for {
+			select {
+			case <-k.done:
+				return
+			default:
+			}
+			switch e := c.Poll(100).(type) {
+			case *kafka.Message:
+				handler(string(e.Value))
+			case kafka.Error:
+				log.Printf("[KAFKA:%s] Error: %s\n", topic, e.Error())
+				if e.IsFatal() {
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (k *KafkaBroker) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	select {
+	case <-k.done:
+		return nil
+	default:
+		close(k.done)
+	}
+	for _, c := range k.consumers {
+		c.Close()
+	}
+	if k.producer != nil {
+		k.producer.Flush(5000)
+		k.producer.Close()
+	}
+	return nil
+}
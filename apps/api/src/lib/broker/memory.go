@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"ebs/src/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+type memoryGroup struct {
+	handlers []types.Handler
+	next     int
+}
+
+// MemoryBroker delivers messages in-process without any external service.
+// Each consumer group receives a message once, handlers within a group take turns.
+// Delivery is synchronous so a Publish returns after every group has handled the message.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	topics map[string]map[string]*memoryGroup
+	closed bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	m := MemoryBroker{
+		topics: make(map[string]map[string]*memoryGroup),
+	}
+	return &m
+}
+
+func (m *MemoryBroker) Name() string {
+	return "Memory"
+}
+
+func (m *MemoryBroker) CreateTopics(topics ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, topic := range topics {
+		if _, ok := m.topics[topic]; !ok {
+			m.topics[topic] = make(map[string]*memoryGroup)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBroker) Publish(topic string, payload *types.JSONB) error {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return fmt.Errorf("broker is closed")
+	}
+	handlers := make([]types.Handler, 0)
+	for _, group := range m.topics[topic] {
+		if len(group.handlers) == 0 {
+			continue
+		}
+		handlers = append(handlers, group.handlers[group.next%len(group.handlers)])
+		group.next++
+	}
+	m.mu.Unlock()
+	if len(handlers) == 0 {
+		log.Printf("[%s] No subscribers for topic %s\n", m.Name(), topic)
+	}
+	for _, h := range handlers {
+		h(string(value))
+	}
+	return nil
+}
+
+func (m *MemoryBroker) Subscribe(group string, topic string, handler types.Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.topics[topic]; !ok {
+		m.topics[topic] = make(map[string]*memoryGroup)
+	}
+	g, ok := m.topics[topic][group]
+	if !ok {
+		g = &memoryGroup{}
+		m.topics[topic][group] = g
+	}
+	g.handlers = append(g.handlers, handler)
+	return nil
+}
+
+func (m *MemoryBroker) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"ebs/src/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	b := NewMemoryBroker()
+	assert.Nil(t, b.CreateTopics("Events_test"))
+
+	received := map[string][]int64{}
+	handler := func(name string) types.Handler {
+		return func(payload string) {
+			received[name] = append(received[name], gjson.Get(payload, "id").Int())
+		}
+	}
+	b.Subscribe("events", "Events_test", handler("a"))
+	b.Subscribe("events", "Events_test", handler("b"))
+	b.Subscribe("audit", "Events_test", handler("c"))
+
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, b.Publish("Events_test", &types.JSONB{"id": i}))
+	}
+
+	// Handlers in the same group take turns, other groups get every message
+	assert.Equal(t, []int64{1, 3}, received["a"])
+	assert.Equal(t, []int64{2, 4}, received["b"])
+	assert.Equal(t, []int64{1, 2, 3, 4}, received["c"])
+
+	assert.Nil(t, b.Close())
+	assert.NotNil(t, b.Publish("Events_test", &types.JSONB{"id": 5}))
+}
+
+func TestUnwrapNotification(t *testing.T) {
+	assert.Equal(t, `{"id":1}`, unwrapNotification(`{"Type":"Notification","Message":"{\"id\":1}"}`))
+	assert.Equal(t, `{"id":1}`, unwrapNotification(`{"id":1}`))
+}
@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"context"
+	"ebs/src/lib"
+	awslib "ebs/src/lib/aws"
+	"ebs/src/types"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/tidwall/gjson"
+)
+
+// SQSBroker backs every topic with an SNS topic fanning out to an SQS queue of the same name.
+// Messages published through the broker go directly to the queue, while messages published
+// to the SNS topic (e.g. by EventBridge schedules) are unwrapped before reaching the handler.
+type SQSBroker struct {
+	mu   sync.Mutex
+	urls map[string]*string
+}
+
+func NewSQSBroker() *SQSBroker {
+	s := SQSBroker{
+		urls: make(map[string]*string),
+	}
+	return &s
+}
+
+func (s *SQSBroker) Name() string {
+	return "SQS"
+}
+
+func (s *SQSBroker) CreateTopics(topics ...string) error {
+	for _, topic := range topics {
+		if _, err := lib.SQSCreateQueue(topic); err != nil {
+			return err
+		}
+		if _, err := lib.SNSCreateTopic(topic); err != nil {
+			return err
+		}
+		lib.SNSSubscribe(topic, topic, "sqs")
+	}
+	return nil
+}
+
+func (s *SQSBroker) queueUrl(client *sqs.Client, topic string) (*string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if url, ok := s.urls[topic]; ok {
+		return url, nil
+	}
+	out, err := client.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(topic),
+	})
+	if err != nil {
+		log.Printf("Failed to retrieve queue URL for %s: %s\n", topic, err.Error())
+		return nil, err
+	}
+	s.urls[topic] = out.QueueUrl
+	return out.QueueUrl, nil
+}
+
+func (s *SQSBroker) Publish(topic string, payload *types.JSONB) error {
+	client := lib.AWSGetSQSClient()
+	url, err := s.queueUrl(client, topic)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	sent, err := client.SendMessage(context.TODO(), &sqs.SendMessageInput{
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    url,
+	})
+	if err != nil {
+		log.Printf("[SQS:%s] Error sending message: %s\n", topic, err.Error())
+		return err
+	}
+	log.Printf("[SQS:%s] Sent message with ID: %s\n", topic, *sent.MessageId)
+	return nil
+}
+
+// Subscribe starts a listener on the queue backing the topic. SQS has no consumer groups
+// so every subscriber of the same topic competes for messages.
+func (s *SQSBroker) Subscribe(group string, topic string, handler types.Handler) error {
+	awslib.NewSQSConsumer(topic, func(payload string) {
+		handler(unwrapNotification(payload))
+	}).Listen()
+	return nil
+}
+
+func (s *SQSBroker) Close() error {
+	return nil
+}
+
+// unwrapNotification returns the original message of an SNS notification envelope
+func unwrapNotification(payload string) string {
+	if gjson.Get(payload, "Type").String() != "Notification" {
+		return payload
+	}
+	message := gjson.Get(payload, "Message")
+	if !message.Exists() {
+		return payload
+	}
+	return message.String()
+}
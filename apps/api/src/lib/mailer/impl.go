@@ -2,38 +2,43 @@ package mailer
 
 import (
 	"ebs/src/lib"
+	"ebs/src/lib/broker"
 	"ebs/src/types"
-	"ebs/src/utils"
-	"encoding/json"
 	"fmt"
 	"os"
 )
 
-func NewMailerMessage(input *lib.SendMailInput) error {
+// Topic returns the name of the topic where outgoing emails are queued
+func Topic() string {
 	emailQueue := os.Getenv("EMAIL_QUEUE")
-	apiEnv := os.Getenv("API_ENV")
-	emailBody := &types.JSONB{
-		"from":      input.From,
-		"from-name": input.FromName,
-		"to":        input.To,
-		"cc":        input.Cc,
-		"bcc":       input.Bcc,
-		"reply-to":  input.ReplyTo,
-		"body":      input.Body,
-		"html":      input.Html,
-		"subject":   input.Subject,
+	if emailQueue == "" {
+		emailQueue = "EmailsToSend"
 	}
-	if apiEnv == "local" {
-		if err := lib.KafkaProduceMessage("emails", utils.WithSuffix(emailQueue), emailBody); err != nil {
-			return fmt.Errorf("error sending message to queue: %s", err.Error())
-		}
-		return nil
+	return broker.Topic(emailQueue)
+}
+
+// NewMailerMessage queues an email. Its Message-ID is set here so the mail log can follow it across retries.
+func NewMailerMessage(input *lib.SendMailInput) error {
+	if input.MessageID == "" {
+		input.MessageID = lib.NewMessageID(input.From)
 	}
-	body, err := json.Marshal(&emailBody)
-	if err != nil {
-		return err
+	emailBody := &types.JSONB{
+		"from":            input.From,
+		"from-name":       input.FromName,
+		"to":              input.To,
+		"cc":              input.Cc,
+		"bcc":             input.Bcc,
+		"reply-to":        input.ReplyTo,
+		"body":            input.Body,
+		"html":            input.Html,
+		"text":            input.Text,
+		"subject":         input.Subject,
+		"headers":         input.Headers,
+		"message-id":      input.MessageID,
+		"template":        input.Template,
+		"organization-id": input.OrganizationID,
 	}
-	if err := lib.SQSProduceMessage(utils.WithSuffix(emailQueue), string(body)); err != nil {
+	if err := broker.Publish(Topic(), emailBody); err != nil {
 		return fmt.Errorf("error sending message to queue: %s", err.Error())
 	}
 	return nil
@@ -0,0 +1,397 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Names of the transactional email templates
+const (
+	TemplateEventOpened      = "event_opened"
+	TemplateEventBroadcast   = "event_broadcast"
+	TemplateEventClosed      = "event_closed"
+	TemplateEventCompleted   = "event_completed"
+	TemplateEventReminder    = "event_reminder"
+	TemplateEventSurvey      = "event_survey"
+	TemplateTeamInvite       = "team_invite"
+	TemplateVerificationCode = "verification_code"
+	TemplateVerifyEmail      = "verify_email"
+)
+
+// DefaultLocale is used for recipients without a locale or with one that has no translations
+const DefaultLocale = "en"
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// Branding is how the emails of an organization look
+type Branding struct {
+	Name         string `json:"name"`
+	LogoURL      string `json:"logo_url,omitempty"`
+	PrimaryColor string `json:"primary_color"`
+	FooterText   string `json:"footer_text,omitempty"`
+}
+
+// DefaultBranding is used for emails not sent on behalf of an organization
+var DefaultBranding = Branding{
+	Name:         "Silver Elven",
+	PrimaryColor: "#1a73e8",
+}
+
+// EventView is the event shown in event emails. Dates are rendered in its Timezone.
+type EventView struct {
+	Title    string
+	Location string
+	DateTime *time.Time
+	Deadline *time.Time
+	Timezone string
+}
+
+// EventEmail is the data of the event_* templates
+type EventEmail struct {
+	Event EventView
+	URL   string
+}
+
+// BroadcastEmail is the data of the event_broadcast template, a message of the organizer of the event
+type BroadcastEmail struct {
+	Event   EventView
+	Subject string
+	Message string
+	URL     string
+}
+
+// SurveyEmail is the data of the event_survey template, sent to attendees when the event completes
+type SurveyEmail struct {
+	Event EventView
+	Title string
+	URL   string
+}
+
+// TicketLink is a ticket of the recipient
+type TicketLink struct {
+	Name string
+	URL  string
+}
+
+// EventReminderEmail is the data of the event_reminder template
+type EventReminderEmail struct {
+	Event   EventView
+	URL     string
+	Tickets []TicketLink
+}
+
+// TeamInviteEmail is the data of the team_invite template, an invitation to join the team of an organization
+type TeamInviteEmail struct {
+	Organization  string
+	Role          string
+	URL           string
+	ExpiresInDays int
+}
+
+// VerificationCodeEmail is the data of the verification_code template
+type VerificationCodeEmail struct {
+	Code             string
+	ExpiresInMinutes int
+}
+
+// VerifyEmail is the data of the verify_email template, a link confirming the address of an account
+type VerifyEmail struct {
+	URL string
+}
+
+// Email is a rendered template
+type Email struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// RenderInput is what a template is rendered with
+type RenderInput struct {
+	Locale         string
+	Brand          Branding
+	Data           any
+	UnsubscribeURL string
+}
+
+type templateData struct {
+	RenderInput
+	Subject string
+}
+
+type emailTemplate struct {
+	html   *htmltemplate.Template
+	text   *texttemplate.Template
+	sample func() any
+}
+
+var (
+	catalogs  = map[string]map[string]string{}
+	templates = map[string]*emailTemplate{}
+)
+
+// placeholderFuncs are replaced by the functions of the locale of each render
+var placeholderFuncs = map[string]any{
+	"t":        func(key string, args ...any) string { return key },
+	"datetime": func(t *time.Time, tz string) string { return "" },
+}
+
+func init() {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		b, err := localeFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Sprintf("invalid locale %s: %s", entry.Name(), err.Error()))
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	samples := map[string]func() any{
+		TemplateEventOpened:      sampleEventEmail,
+		TemplateEventBroadcast:   sampleBroadcastEmail,
+		TemplateEventClosed:      sampleEventEmail,
+		TemplateEventCompleted:   sampleEventEmail,
+		TemplateEventReminder:    sampleEventReminderEmail,
+		TemplateEventSurvey:      sampleSurveyEmail,
+		TemplateTeamInvite:       sampleTeamInviteEmail,
+		TemplateVerificationCode: sampleVerificationCodeEmail,
+		TemplateVerifyEmail:      sampleVerifyEmail,
+	}
+	for name, sample := range samples {
+		templates[name] = &emailTemplate{
+			html: htmltemplate.Must(htmltemplate.New(name).Funcs(placeholderFuncs).ParseFS(templateFS,
+				"templates/layout.html.tmpl", "templates/partials.html.tmpl", fmt.Sprintf("templates/%s.html.tmpl", name))),
+			text: texttemplate.Must(texttemplate.New(name).Funcs(placeholderFuncs).ParseFS(templateFS,
+				"templates/layout.txt.tmpl", "templates/partials.txt.tmpl", fmt.Sprintf("templates/%s.txt.tmpl", name))),
+			sample: sample,
+		}
+	}
+}
+
+// TemplateNames returns the names of the email templates
+func TemplateNames() []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Locales returns the locales emails are translated to
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// ResolveLocale returns the supported locale closest to the requested one, e.g. es for es-MX
+func ResolveLocale(locale string) string {
+	if resolved, ok := resolveLocale(locale); ok {
+		return resolved
+	}
+	return DefaultLocale
+}
+
+// SupportsLocale reports whether emails are translated to the locale or its language
+func SupportsLocale(locale string) bool {
+	_, ok := resolveLocale(locale)
+	return ok
+}
+
+func resolveLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if _, ok := catalogs[locale]; ok {
+		return locale, true
+	}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		if _, ok := catalogs[lang]; ok {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// Render renders the subject, HTML and plain text parts of a template
+func Render(name string, input RenderInput) (*Email, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	input.Locale = ResolveLocale(input.Locale)
+	funcs := localeFuncs(input.Locale)
+	data := templateData{RenderInput: input}
+
+	text, err := tmpl.text.Clone()
+	if err != nil {
+		return nil, err
+	}
+	text.Funcs(funcs)
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	data.Subject = strings.TrimSpace(subject.String())
+	if err := text.ExecuteTemplate(&body, "layout", data); err != nil {
+		return nil, err
+	}
+
+	html, err := tmpl.html.Clone()
+	if err != nil {
+		return nil, err
+	}
+	html.Funcs(funcs)
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return nil, err
+	}
+	return &Email{
+		Subject: data.Subject,
+		HTML:    htmlBody.String(),
+		Text:    body.String(),
+	}, nil
+}
+
+// Preview renders a template with sample data
+func Preview(name string, locale string, brand Branding) (*Email, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	return Render(name, RenderInput{
+		Locale:         locale,
+		Brand:          brand,
+		Data:           tmpl.sample(),
+		UnsubscribeURL: "https://example.com/unsubscribe",
+	})
+}
+
+func localeFuncs(locale string) map[string]any {
+	return map[string]any{
+		"t": func(key string, args ...any) string {
+			format, ok := catalogs[locale][key]
+			if !ok {
+				format, ok = catalogs[DefaultLocale][key]
+			}
+			if !ok {
+				return key
+			}
+			if len(args) == 0 {
+				return format
+			}
+			return fmt.Sprintf(format, args...)
+		},
+		"datetime": func(t *time.Time, tz string) string {
+			return FormatDateTime(t, tz, locale)
+		},
+	}
+}
+
+var (
+	esWeekdays = []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"}
+	esMonths   = []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+)
+
+// FormatDateTime renders a time in the timezone for the locale, falling back to UTC when the timezone is unknown
+func FormatDateTime(t *time.Time, tz string, locale string) string {
+	if t == nil {
+		return ""
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		loc = time.UTC
+	}
+	at := t.In(loc)
+	switch locale {
+	case "es":
+		return fmt.Sprintf("%s, %d de %s de %d, %s", esWeekdays[at.Weekday()], at.Day(), esMonths[at.Month()-1], at.Year(), at.Format("15:04 MST"))
+	}
+	return at.Format("Monday, January 2, 2006 at 3:04 PM MST")
+}
+
+func sampleEventEmail() any {
+	at := time.Date(2026, time.March, 14, 10, 0, 0, 0, time.UTC)
+	deadline := at.Add(2 * time.Hour)
+	return EventEmail{
+		Event: EventView{
+			Title:    "Spring Music Festival",
+			Location: "Rizal Park, Manila",
+			DateTime: &at,
+			Deadline: &deadline,
+			Timezone: "Asia/Manila",
+		},
+		URL: "https://example.com/spring-music-festival/event/1/tickets",
+	}
+}
+
+func sampleBroadcastEmail() any {
+	event := sampleEventEmail().(EventEmail)
+	return BroadcastEmail{
+		Event:   event.Event,
+		Subject: "Parking update for Spring Music Festival",
+		Message: "The north parking lot is closed.\nPlease use the south entrance on Roxas Boulevard.",
+		URL:     event.URL,
+	}
+}
+
+func sampleSurveyEmail() any {
+	event := sampleEventEmail().(EventEmail)
+	return SurveyEmail{
+		Event: event.Event,
+		Title: "Spring Music Festival feedback",
+		URL:   "https://example.com/surveys/1",
+	}
+}
+
+func sampleEventReminderEmail() any {
+	event := sampleEventEmail().(EventEmail)
+	return EventReminderEmail{
+		Event: event.Event,
+		URL:   event.URL,
+		Tickets: []TicketLink{
+			{Name: "General Admission", URL: "https://example.com/reservations/101"},
+			{Name: "General Admission", URL: "https://example.com/reservations/102"},
+		},
+	}
+}
+
+func sampleTeamInviteEmail() any {
+	return TeamInviteEmail{
+		Organization:  "Manila Events Co.",
+		Role:          "manager",
+		URL:           "https://example.com/invites?token=3f9a1c",
+		ExpiresInDays: 7,
+	}
+}
+
+func sampleVerificationCodeEmail() any {
+	return VerificationCodeEmail{Code: "482913", ExpiresInMinutes: 10}
+}
+
+func sampleVerifyEmail() any {
+	return VerifyEmail{URL: "https://example.com/accounts/verify?token=9c2e4b"}
+}
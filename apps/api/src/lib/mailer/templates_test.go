@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the email templates")
+
+func TestTemplatesGolden(t *testing.T) {
+	brand := Branding{
+		Name:         "Manila Events Co.",
+		LogoURL:      "https://example.com/logo.png",
+		PrimaryColor: "#c2185b",
+		FooterText:   "Manila Events Co., 1000 Manila",
+	}
+	for _, name := range TemplateNames() {
+		for _, locale := range Locales() {
+			t.Run(fmt.Sprintf("%s.%s", name, locale), func(t *testing.T) {
+				email, err := Preview(name, locale, brand)
+				assert.Nil(t, err)
+				assertGolden(t, fmt.Sprintf("%s.%s.html", name, locale), email.HTML)
+				assertGolden(t, fmt.Sprintf("%s.%s.txt", name, locale), fmt.Sprintf("Subject: %s\n\n%s", email.Subject, email.Text))
+			})
+		}
+	}
+}
+
+func assertGolden(t *testing.T, file string, actual string) {
+	golden := path.Join("testdata", "golden", file)
+	if *update {
+		assert.Nil(t, os.WriteFile(golden, []byte(actual), 0o644))
+		return
+	}
+	expected, err := os.ReadFile(golden)
+	assert.Nil(t, err, "missing golden file, run the tests with -update")
+	assert.Equal(t, string(expected), actual)
+}
+
+func TestResolveLocale(t *testing.T) {
+	assert.Equal(t, "es", ResolveLocale("es-MX"))
+	assert.Equal(t, "es", ResolveLocale("ES"))
+	assert.Equal(t, DefaultLocale, ResolveLocale("de"))
+	assert.Equal(t, DefaultLocale, ResolveLocale(""))
+	assert.True(t, SupportsLocale("en-US"))
+	assert.False(t, SupportsLocale("de"))
+}
+
+func TestRenderEscapes(t *testing.T) {
+	email, err := Render(TemplateEventOpened, RenderInput{
+		Brand: DefaultBranding,
+		Data: EventEmail{
+			Event: EventView{Title: "<script>alert(1)</script>", Timezone: "UTC"},
+			URL:   "javascript:alert(1)",
+		},
+	})
+	assert.Nil(t, err)
+	assert.NotContains(t, email.HTML, "<script>")
+	assert.NotContains(t, email.HTML, "javascript:alert")
+	// Plain text parts are not escaped
+	assert.Contains(t, email.Text, "<script>alert(1)</script>")
+}
+
+func TestRenderEscapesInvites(t *testing.T) {
+	email, err := Render(TemplateTeamInvite, RenderInput{
+		Brand: DefaultBranding,
+		Data:  TeamInviteEmail{Organization: `<a href="https://evil.example">Acme</a>`, Role: "admin", ExpiresInDays: 7},
+	})
+	assert.Nil(t, err)
+	assert.NotContains(t, email.HTML, `<a href="https://evil.example">`)
+}
+
+func TestFormatDateTime(t *testing.T) {
+	at := time.Date(2026, time.March, 14, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Saturday, March 14, 2026 at 6:00 PM PST", FormatDateTime(&at, "Asia/Manila", "en"))
+	assert.Equal(t, "sábado, 14 de marzo de 2026, 18:00 PST", FormatDateTime(&at, "Asia/Manila", "es"))
+	assert.Equal(t, "Saturday, March 14, 2026 at 10:00 AM UTC", FormatDateTime(&at, "Mars/Olympus", "en"))
+}
@@ -25,6 +25,16 @@ const (
 
 var scheduler gocron.Scheduler
 
+// TaskPublisherFunc sends the payload of a scheduled task to a topic
+type TaskPublisherFunc func(clientId string, topic string, p *types.JSONB) error
+
+var taskPublisher TaskPublisherFunc = KafkaProduceMessage
+
+// NewTaskPublisher replaces the function used by scheduled tasks to publish their payload
+func NewTaskPublisher(fn TaskPublisherFunc) {
+	taskPublisher = fn
+}
+
 func NewScheduler(s gocron.Scheduler) {
 	scheduler = s
 }
@@ -121,7 +131,7 @@ func CreateCronSchedule(clientId, topic string, startDate time.Time, scheduleExp
 	j, err := s.NewJob(
 		gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(startDate)),
 		gocron.NewTask(func(id uint) {
-			KafkaProduceMessage(clientId, topic, &payload)
+			taskPublisher(clientId, topic, &payload)
 		}, 1),
 	)
 	if err != nil {
@@ -191,7 +201,7 @@ func (l *LocalScheduler) CreateScheduleWithStartDate(ctx context.Context, s time
 		gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(s)),
 		gocron.NewTask(func(ctx context.Context, p types.JSONB) {
 			log.Printf("[%s] Running scheduled task...\n", l.Name())
-			KafkaTaskHandlerFunc(ctx, &p)
+			TaskHandlerFunc(ctx, &p)
 		}, ctx, p),
 	)
 	if err != nil {
@@ -241,9 +251,9 @@ func NewScheduledJob(startDate time.Time, vars map[string]string, p types.JSONB)
 	return sid, nil
 }
 
-func KafkaTaskHandlerFunc(ctx context.Context, p *types.JSONB) {
+func TaskHandlerFunc(ctx context.Context, p *types.JSONB) {
 	vars := ctx.Value(varsKey).(map[string]string)
 	clientId := vars["clientId"]
 	topic := vars["topic"]
-	go KafkaProduceMessage(clientId, topic, p)
+	go taskPublisher(clientId, topic, p)
 }
@@ -0,0 +1,43 @@
+package sms
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+)
+
+// Message is a text message sent through the MemoryProvider
+type Message struct {
+	Phone string
+	Text  string
+}
+
+// MemoryProvider keeps text messages in memory instead of sending them, for development and tests
+type MemoryProvider struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{}
+}
+
+func (m *MemoryProvider) Name() string {
+	return "Memory"
+}
+
+func (m *MemoryProvider) Send(ctx context.Context, phone string, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, Message{Phone: phone, Text: text})
+	log.Printf("[SMS] Text message to %s kept in memory\n", phone)
+	return nil
+}
+
+// Messages returns the text messages sent so far
+func (m *MemoryProvider) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages)
+}
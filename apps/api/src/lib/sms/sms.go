@@ -0,0 +1,77 @@
+package sms
+
+import (
+	"context"
+	"ebs/src/config"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Provider sends text messages to phone numbers in E.164 format
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, phone string, text string) error
+}
+
+var ErrInvalidPhoneNumber = errors.New("phone number must be in E.164 format, e.g. +639171234567")
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+var (
+	provider Provider
+	mu       sync.Mutex
+)
+
+// NormalizePhone strips the separators of a phone number and checks it is in E.164 format
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(phone)
+	if !e164.MatchString(phone) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return phone, nil
+}
+
+// NewProvider replaces the shared Provider instance with a custom implementation
+func NewProvider(p Provider) Provider {
+	mu.Lock()
+	defer mu.Unlock()
+	provider = p
+	return provider
+}
+
+// GetProvider returns the shared Provider instance, creating one based on the app environment value if none is set
+func GetProvider() Provider {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider != nil {
+		return provider
+	}
+	provider = CreateProvider()
+	log.Printf("[SMS] Using %s provider\n", provider.Name())
+	return provider
+}
+
+// CreateProvider returns either an instance of SNSProvider or MemoryProvider based on the app environment value.
+// SMS_PROVIDER can be set to "sns" or "memory" to override the default.
+func CreateProvider() Provider {
+	switch config.SMS_PROVIDER {
+	case "sns":
+		return NewSNSProvider()
+	case "memory":
+		return NewMemoryProvider()
+	}
+	env := config.API_ENV
+	if env == string(types.Production) || env == string(types.Test) {
+		return NewSNSProvider()
+	}
+	return NewMemoryProvider()
+}
+
+// Send sends a text message using the shared Provider instance
+func Send(ctx context.Context, phone string, text string) error {
+	return GetProvider().Send(ctx, phone, text)
+}
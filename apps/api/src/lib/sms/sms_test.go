@@ -0,0 +1,28 @@
+package sms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	phone, err := NormalizePhone("+63 917-123-4567")
+	assert.Nil(t, err)
+	assert.Equal(t, "+639171234567", phone)
+
+	for _, invalid := range []string{"09171234567", "+0123456789", "+63abc", ""} {
+		_, err := NormalizePhone(invalid)
+		assert.ErrorIs(t, err, ErrInvalidPhoneNumber, invalid)
+	}
+}
+
+func TestMemoryProvider(t *testing.T) {
+	m := NewMemoryProvider()
+	NewProvider(m)
+	defer NewProvider(nil)
+
+	assert.Nil(t, Send(context.Background(), "+639171234567", "Your code is 123456"))
+	assert.Equal(t, []Message{{Phone: "+639171234567", Text: "Your code is 123456"}}, m.Messages())
+}
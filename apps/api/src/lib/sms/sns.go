@@ -0,0 +1,54 @@
+package sms
+
+import (
+	"context"
+	"ebs/src/config"
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// SNSProvider sends transactional text messages through Amazon SNS
+type SNSProvider struct {
+	inner *sns.Client
+}
+
+func NewSNSProvider() *SNSProvider {
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Printf("Error loading default config: %s\n", err.Error())
+		return &SNSProvider{}
+	}
+	return &SNSProvider{inner: sns.NewFromConfig(cfg)}
+}
+
+func (s *SNSProvider) Name() string {
+	return "SNS"
+}
+
+func (s *SNSProvider) Send(ctx context.Context, phone string, text string) error {
+	if s.inner == nil {
+		return errors.New("SNS client is not configured")
+	}
+	attributes := map[string]types.MessageAttributeValue{
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+	}
+	if config.SMS_SENDER_ID != "" {
+		attributes["AWS.SNS.SMS.SenderID"] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(config.SMS_SENDER_ID)}
+	}
+	out, err := s.inner.Publish(ctx, &sns.PublishInput{
+		PhoneNumber:       aws.String(phone),
+		Message:           aws.String(text),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		log.Printf("[SNS] Error sending text message: %s\n", err.Error())
+		return err
+	}
+	log.Printf("[SNS] Sent text message with id: %s\n", aws.ToString(out.MessageId))
+	return nil
+}
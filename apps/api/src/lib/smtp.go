@@ -1,10 +1,13 @@
 package lib
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/wneessen/go-mail"
 )
 
@@ -107,7 +110,18 @@ func SendMail(inputParams *SendMailInput) error {
 		log.Printf("Failed to set Bcc address: %s\n", err.Error())
 	}
 	msg.Subject(inputParams.Subject)
-	if inputParams.Html {
+	if inputParams.MessageID != "" {
+		msg.SetMessageIDWithValue(inputParams.MessageID)
+	} else {
+		msg.SetMessageID()
+	}
+	for header, value := range inputParams.Headers {
+		msg.SetGenHeader(mail.Header(header), value)
+	}
+	if inputParams.Html && inputParams.Text != "" {
+		msg.SetBodyString(mail.TypeTextPlain, inputParams.Text)
+		msg.AddAlternativeString(mail.TypeTextHTML, inputParams.Body)
+	} else if inputParams.Html {
 		msg.SetBodyString(mail.TypeTextHTML, inputParams.Body)
 	} else {
 		msg.SetBodyString(mail.TypeTextPlain, inputParams.Body)
@@ -128,4 +142,36 @@ type SendMailInput struct {
 	Subject  string
 	Body     string
 	Html     bool
+	// Text is the plain text alternative of an HTML body
+	Text string
+	// Headers are extra headers of the message, e.g. List-Unsubscribe
+	Headers map[string]string
+	// MessageID is the Message-ID header without angle brackets, generated when empty
+	MessageID string
+	// Template and OrganizationID are recorded in the mail log
+	Template       string
+	OrganizationID uint
+}
+
+// NewMessageID returns a unique Message-ID on the domain of the sender address
+func NewMessageID(from string) string {
+	_, domain, ok := strings.Cut(from, "@")
+	if !ok || domain == "" {
+		domain = "localhost.localdomain"
+	}
+	return fmt.Sprintf("%s@%s", uuid.NewString(), domain)
+}
+
+// MailProvider names the provider behind SMTP_HOST, as recorded in the mail log
+func MailProvider() string {
+	host := os.Getenv("SMTP_HOST")
+	switch {
+	case strings.Contains(host, "sendgrid"):
+		return "sendgrid"
+	case strings.Contains(host, "amazonaws"):
+		return "ses"
+	case strings.Contains(host, "gmail"):
+		return "gmail"
+	}
+	return "smtp"
 }
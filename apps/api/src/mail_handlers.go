@@ -0,0 +1,99 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/config"
+	"ebs/src/lib"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"ebs/src/utils"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mailEventsRoute receives the bounce, complaint and delivery notifications of the email providers.
+// The SES webhook is only mounted when SES_EVENTS_TOPIC_ARN is set, which production requires when mail is sent through SES.
+func mailEventsRoute(g *gin.Engine) *gin.RouterGroup {
+	apiv1 := apiv1Group(g)
+	if config.SES_EVENTS_TOPIC_ARN != "" {
+		apiv1.POST("/webhook/ses", func(ctx *gin.Context) {
+			payload, err := io.ReadAll(ctx.Request.Body)
+			if err != nil {
+				log.Printf("Error reading request body: %s\n", err.Error())
+				ctx.Status(http.StatusServiceUnavailable)
+				return
+			}
+			if err := common.HandleSESNotification(payload); err != nil {
+				log.Printf("Error handling SES notification: %s\n", err.Error())
+				ctx.Status(mailEventErrorStatus(err))
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		})
+	} else if utils.IsProd() && lib.MailProvider() == "ses" {
+		log.Fatalln("SES_EVENTS_TOPIC_ARN is required to send mail through SES")
+	}
+	apiv1.
+		POST("/webhook/sendgrid", func(ctx *gin.Context) {
+			payload, err := io.ReadAll(ctx.Request.Body)
+			if err != nil {
+				log.Printf("Error reading request body: %s\n", err.Error())
+				ctx.Status(http.StatusServiceUnavailable)
+				return
+			}
+			if err := common.HandleSendGridEvents(
+				payload,
+				ctx.GetHeader("X-Twilio-Email-Event-Webhook-Signature"),
+				ctx.GetHeader("X-Twilio-Email-Event-Webhook-Timestamp"),
+			); err != nil {
+				log.Printf("Error handling SendGrid events: %s\n", err.Error())
+				ctx.Status(mailEventErrorStatus(err))
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		})
+	return apiv1
+}
+
+func mailLogHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canRead := middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId"))
+	keys.
+		GET("/organizations/:orgId/mail-logs", canRead, func(ctx *gin.Context) {
+			var filters types.MailLogQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			listMailLogs(ctx, ctx.GetUint("org_id"), &filters)
+		})
+	return g
+}
+
+func listMailLogs(ctx *gin.Context, orgId uint, filters *types.MailLogQueryFilters) {
+	logs, count, err := common.ListMailLogs(orgId, filters)
+	if err != nil {
+		log.Printf("Error retrieving mail logs: %s\n", err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  logs,
+		"count": count,
+		"page":  filters.Page,
+		"limit": filters.Limit,
+	})
+}
+
+func mailEventErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrInvalidMailEventSignature),
+		errors.Is(err, common.ErrUnknownMailEventTopic):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
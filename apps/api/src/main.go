@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"ebs/src/boot"
+	"ebs/src/common"
 	"ebs/src/config"
 	"ebs/src/controllers"
 	"ebs/src/db"
@@ -176,9 +177,14 @@ var betweenfields validator.Func = func(fl validator.FieldLevel) bool {
 func setupRouter() *gin.Engine {
 	router := gin.Default()
 	router.Use(middlewares.SecureHeaders)
+	router.Use(middlewares.RequestID)
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, "ok")
 	})
+	router.GET("/.well-known/jwks.json", func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(http.StatusOK, utils.JWKS())
+	})
 	return router
 }
 
@@ -221,27 +227,25 @@ func publicRoutes(g *gin.Engine) *gin.RouterGroup {
 			filePath := path.Join(assets, fmt.Sprintf("%s.jpeg", params.Filename))
 			log.Printf("filePath: %s", filePath)
 			ctx.File(filePath)
+		}).
+		POST("/auth/refresh", refreshRateLimit, func(ctx *gin.Context) {
+			var body types.RefreshTokenRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			tokens, err := common.RefreshSession(body.RefreshToken, ctx.Request.UserAgent(), ctx.ClientIP())
+			if err != nil {
+				log.Printf("Error refreshing session: %s\n", err.Error())
+				ctx.JSON(sessionErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, tokens)
 		})
 
 	passkey := apiv1.Group("/passkey")
 	passkey.Use(middlewares.VerifyIdToken)
-	passkey.Use(func(ctx *gin.Context) {
-		authMFA := ctx.Request.Header.Get("X-Authenticate-MFA")
-		log.Printf("X-Authenticate-MFA: %s", authMFA)
-		if authMFA != "true" {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Access denied"})
-			return
-		}
-		if ctx.Request.Header.Get("X-MFA-Flow-ID") == "" {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-		flowId := ctx.Request.Header.Get("X-MFA-Flow-ID")
-		if err := uuid.Validate(flowId); err != nil {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-	})
+	passkey.Use(mfaHandshake)
 	passkey.
 		POST("/login/start", func(ctx *gin.Context) {
 			opts, status, err := controllers.PasskeyLoginStart(ctx.Copy())
@@ -256,8 +260,8 @@ func publicRoutes(g *gin.Engine) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"publicKey": opts.Response})
 		}).
-		POST("/login/finish", func(ctx *gin.Context) {
-			token, status, err := controllers.PasskeyLoginFinish(ctx.Copy())
+		POST("/login/finish", mfaRateLimit, mfaLockout, func(ctx *gin.Context) {
+			tokens, status, err := controllers.PasskeyLoginFinish(ctx.Copy())
 			if err != nil {
 				log.Printf("Error on PasskeyLoginFinish: %s\n", err.Error())
 				if status >= http.StatusBadRequest && status <= http.StatusInternalServerError {
@@ -270,9 +274,21 @@ func publicRoutes(g *gin.Engine) *gin.RouterGroup {
 				ctx.Status(status)
 				return
 			}
-			ctx.JSON(http.StatusOK, gin.H{
-				"token": token,
-			})
+			ctx.JSON(http.StatusOK, tokens)
+		})
+
+	mfa := apiv1.Group("/mfa")
+	mfa.Use(middlewares.VerifyIdToken)
+	mfa.Use(mfaHandshake)
+	mfa.
+		POST("/totp/login", mfaRateLimit, mfaLockout, func(ctx *gin.Context) {
+			tokens, status, err := controllers.TOTPLoginFinish(ctx)
+			if err != nil {
+				log.Printf("Error on TOTPLoginFinish: %s\n", err.Error())
+				ctx.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, tokens)
 		})
 
 	oauthcb := apiv1.Group("/oauth")
@@ -468,30 +484,49 @@ func publicRoutes(g *gin.Engine) *gin.RouterGroup {
 			go rd.Del(context.Background(), nonceKey)
 			ctx.Redirect(http.StatusTemporaryRedirect, state.Redirect)
 		})
+	unsubscribeHandlers(apiv1)
 	return apiv1
 }
 
+// mfaHandshake requires the headers of a second factor sign in started by POST /auth/login
+func mfaHandshake(ctx *gin.Context) {
+	authMFA := ctx.Request.Header.Get("X-Authenticate-MFA")
+	log.Printf("X-Authenticate-MFA: %s", authMFA)
+	if authMFA != "true" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Access denied"})
+		return
+	}
+	if ctx.Request.Header.Get("X-MFA-Flow-ID") == "" {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	flowId := ctx.Request.Header.Get("X-MFA-Flow-ID")
+	if err := uuid.Validate(flowId); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+}
+
 func guestAuthRoutes(g *gin.Engine) *gin.RouterGroup {
 	apiv1 := apiv1Group(g)
-	guest := apiv1.Group("/auth")
-	guest.Use(middlewares.VerifyIdToken)
-	guest.
-		POST("/login", func(ctx *gin.Context) {
-			token, status, err := controllers.AuthLogin(ctx)
-			if err != nil {
-				log.Printf("[AuthLogin] error: %s\n", err.Error())
-				ctx.Status(status)
-				return
-			}
-			if status != http.StatusOK {
-				ctx.Status(status)
-				return
-			}
+	auth := apiv1.Group("/auth")
+	// The lockout is keyed by the verified Firebase user so it can not be triggered for accounts by others
+	auth.POST("/login", middlewares.VerifyIdToken, loginRateLimit, loginLockout, func(ctx *gin.Context) {
+		tokens, status, err := controllers.AuthLogin(ctx)
+		if err != nil {
+			log.Printf("[AuthLogin] error: %s\n", err.Error())
+			ctx.Status(status)
+			return
+		}
+		if status != http.StatusOK {
+			ctx.Status(status)
+			return
+		}
 
-			ctx.JSON(status, gin.H{
-				"token": token,
-			})
-		}).
+		ctx.JSON(status, tokens)
+	})
+	guest := auth.Group("", middlewares.VerifyIdToken)
+	guest.
 		POST("/register", func(ctx *gin.Context) {
 			uid, status, err := controllers.AuthRegister(ctx)
 			if err != nil {
@@ -545,6 +580,8 @@ func setupSocketServer(r *gin.Engine) *socket.Server {
 			})
 		})
 	})
+	common.SetupNotificationNamespace(wss)
+	go common.SubscribeNotifications(context.Background())
 	wss.Emit("test", "ping")
 
 	r.GET("/socket.io/*any", gin.WrapH(wss.ServeHandler(c)))
@@ -622,6 +659,7 @@ func main() {
 
 	boot.InitDb()
 	boot.InitScheduler()
+	boot.InitSigningKeys()
 	lib.InitWebAuthn(time.Hour, !utils.IsProd())
 
 	go boot.DownloadSDKFileFromS3()
@@ -643,7 +681,10 @@ func main() {
 	} else {
 		cc := cors.DefaultConfig()
 		cc.AllowMethods = append(cc.AllowMethods, "GET", "POST", "PATCH", "PUT", "DELETE", "HEAD")
-		cc.AllowHeaders = append(cc.AllowHeaders, "Origin", "Authorization", "x-secret")
+		cc.AllowHeaders = append(cc.AllowHeaders, "Origin", "Authorization", "x-secret", "X-Staff-Token", "X-Request-ID",
+			"X-Authenticate-MFA", "X-MFA-Flow-ID", "X-MFA-Challenge")
+		cc.ExposeHeaders = append(cc.ExposeHeaders, "X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+			"X-Authenticate-MFA", "X-MFA-Flow-ID", "X-MFA-Challenge", "X-MFA-Methods")
 		cc.AllowOriginFunc = func(origin string) bool {
 			match, _ := regexp.MatchString(`(\w+.?)+\.amazonaws\.com$`, origin)
 			log.Printf("Origin matches %s: %v\n", origin, match)
@@ -677,8 +718,14 @@ func main() {
 
 	stripeWebhookRoute(router)
 
+	mailEventsRoute(router)
+
+	scanner := router.Group(apiPrefix)
+	scanner.Use(middlewares.StaffAuthMiddleware, middlewares.TenantContext)
+	admissionHandlers(scanner)
+
 	authorized := router.Group(apiPrefix)
-	authorized.Use(middlewares.AuthMiddleware)
+	authorized.Use(middlewares.AuthMiddleware, middlewares.TenantContext)
 	{
 		authorized.GET("/countries", func(ctx *gin.Context) {
 			countries := cacheCountries()
@@ -762,6 +809,13 @@ func main() {
 					ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 					return
 				}
+				// The session must not outlive a successful logout, a session that is already revoked is fine
+				err := common.RevokeSession(ctx.GetUint("id"), ctx.GetString("session_id"))
+				if err != nil && !errors.Is(err, common.ErrSessionNotFound) {
+					log.Printf("Error revoking session on user logout: %s\n", err.Error())
+					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not end the session"})
+					return
+				}
 				uid := ctx.GetString("uid")
 
 				cctx, cancel := context.WithTimeout(ctx, 15*time.Second)
@@ -781,8 +835,22 @@ func main() {
 		authorized = ticketHandlers(authorized)
 		authorized = bookingHandlers(authorized)
 		authorized = reservationHandlers(authorized)
-		authorized = admissionHandlers(authorized)
 		authorized = transactionHandlers(authorized)
+		authorized = teamHandlers(authorized)
+		authorized = staffHandlers(authorized)
+		authorized = auditHandlers(authorized)
+		authorized = sessionHandlers(authorized)
+		authorized = mfaHandlers(authorized)
+		authorized = apiKeyHandlers(authorized)
+		authorized = webhookHandlers(authorized)
+		authorized = notificationHandlers(authorized)
+		authorized = emailHandlers(authorized)
+		authorized = broadcastHandlers(authorized)
+		authorized = phoneHandlers(authorized)
+		authorized = mailLogHandlers(authorized)
+		authorized = ratingHandlers(authorized)
+		authorized = surveyHandlers(authorized)
+		authorized = adminHandlers(authorized)
 
 		authorized.
 			GET("/users/me", func(ctx *gin.Context) {
@@ -978,7 +1046,7 @@ func main() {
 				}
 				ctx.Status(http.StatusOK)
 			}).
-			POST("/verification/request_code", func(ctx *gin.Context) {
+			POST("/verification/request_code", requestCodeRateLimit, func(ctx *gin.Context) {
 				var body struct {
 					Email string `json:"email" binding:"required"`
 				}
@@ -1022,24 +1090,18 @@ func main() {
 						log.Printf("Error storing generated token: %s\n", err.Error())
 					}
 				}()
-				if err := mailer.NewMailerMessage(&lib.SendMailInput{
-					From:     config.SMTP_FROM,
-					FromName: "noreply",
-					Subject:  "Verify Authentication Code",
-					To:       []string{body.Email},
-					Body: fmt.Sprintf(`
-					<p>You have requested a verification code</p>
-					<p>Your verfication code: %d</p>
-				`, bi),
-					Html: true,
-				}); err != nil {
+				if err := common.DispatchToAddress(&common.Message{
+					Category:     types.NOTIFY_ACCOUNT,
+					Template:     mailer.TemplateVerificationCode,
+					TemplateData: mailer.VerificationCodeEmail{Code: bi.String(), ExpiresInMinutes: 10},
+				}, body.Email); err != nil {
 					log.Printf("Could not send verification email to [%s]: %s\n", body.Email, err.Error())
 					ctx.Status(http.StatusBadRequest)
 					return
 				}
 				ctx.Status(http.StatusOK)
 			}).
-			POST("/verification/verify_code", func(ctx *gin.Context) {
+			POST("/verification/verify_code", verifyCodeRateLimit, verifyCodeLockout, func(ctx *gin.Context) {
 				var body struct {
 					Email string `json:"email" binding:"required"`
 					Code  string `json:"code" binding:"required"`
@@ -1204,7 +1266,11 @@ func main() {
 					if err != nil {
 						return err
 					}
-					return nil
+					return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_SETTING_CREATE, 0, setting.ID, nil, types.JSONB{
+						"key":   setting.SettingKey,
+						"group": setting.Group,
+						"value": setting.SettingValue,
+					}))
 				})
 				if err != nil {
 					ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -78,9 +78,7 @@ func authMiddleware(ctx *gin.Context) {
 		return
 	}
 	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(reqToken, claims, func(t *jwt.Token) (any, error) {
-		return []byte(config.JWT_SECRET), nil
-	})
+	tkn, err := jwt.ParseWithClaims(reqToken, claims, utils.VerificationKey)
 	if err != nil {
 		log.Printf("token error: %s\n", err.Error())
 		if err == jwt.ErrSignatureInvalid || err == jwt.ErrTokenMalformed {
@@ -435,10 +433,14 @@ func (s *TestSuite) SetupSuite() {
 	if err != nil {
 		log.Fatalf("error migration: %s", err.Error())
 	}
+	if err = models.SeedDefaultRoles(d); err != nil {
+		log.Fatalf("error seeding roles: %s", err.Error())
+	}
 	if err = d.Exec(`
-	CREATE OR REPLACE FUNCTION set_tenant(tenant_id text) RETURNS void AS $$
+	CREATE OR REPLACE FUNCTION set_tenant(tenant_id text, organization_id text) RETURNS void AS $$
 	BEGIN
-		PERFORM set_config('app.current_tenant', tenant_id, false);
+		PERFORM set_config('app.current_tenant', tenant_id, true);
+		PERFORM set_config('app.current_organization', organization_id, true);
 	END;
 	$$ LANGUAGE plpgsql;
 	`).Error; err != nil {
@@ -450,6 +452,9 @@ func (s *TestSuite) SetupSuite() {
 	assert.NotNil(s.T(), ss)
 	lib.NewScheduler(ss) */
 	boot.InitScheduler()
+	signingKey, err := utils.NewJWTKey("EdDSA")
+	assert.Nil(s.T(), err)
+	utils.NewSigningKeys(signingKey)
 
 	// Mock Redis API
 	rc, rmock := redismock.NewClientMock()
@@ -781,7 +786,7 @@ func (s *TestSuite) TestRegisterWithoutFirebaseAccount() {
 
 func (s *TestSuite) TestEvents() {
 	email := *s.Email
-	token, err := utils.GenerateJWT(email, *s.UserId, 1)
+	token, err := utils.GenerateJWT(email, *s.UserId, 1, "")
 	assert.NoError(s.T(), err)
 
 	router := setupRouter()
@@ -905,7 +910,7 @@ func (s *TestSuite) TestTickets() {
 	assert.NoError(s.T(), err)
 
 	email := *s.Email
-	token, err := utils.GenerateJWT(email, *s.UserId, event.OrganizerID)
+	token, err := utils.GenerateJWT(email, *s.UserId, event.OrganizerID, "")
 	assert.NoError(s.T(), err)
 
 	router := setupRouter()
@@ -984,7 +989,7 @@ func (s *TestSuite) TestBookings() {
 	assert.NoError(s.T(), err)
 
 	email := *s.Email
-	token, err := utils.GenerateJWT(email, *s.UserId, ticket.Event.OrganizerID)
+	token, err := utils.GenerateJWT(email, *s.UserId, ticket.Event.OrganizerID, "")
 	assert.NoError(s.T(), err)
 
 	router := setupRouter()
@@ -1044,7 +1049,7 @@ func (s *TestSuite) TestReservations() {
 	assert.NoError(s.T(), err)
 
 	email := *s.Email
-	token, err := utils.GenerateJWT(email, *s.UserId, ticket.Event.OrganizerID)
+	token, err := utils.GenerateJWT(email, *s.UserId, ticket.Event.OrganizerID, "")
 	assert.NoError(s.T(), err)
 
 	router := setupRouter()
@@ -1105,7 +1110,7 @@ func (s *TestSuite) TestTransactions() {
 	assert.NoError(s.T(), err)
 
 	email := *s.Email
-	token, err := utils.GenerateJWT(email, *s.UserId, *s.OrgId)
+	token, err := utils.GenerateJWT(email, *s.UserId, *s.OrgId, "")
 	assert.NoError(s.T(), err)
 
 	router := setupRouter()
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func mfaHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	g.
+		GET("/accounts/mfa", func(ctx *gin.Context) {
+			status, err := common.GetMFAStatus(ctx.GetUint("id"))
+			if err != nil {
+				log.Printf("Error retrieving MFA status: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": status})
+		}).
+		POST("/accounts/mfa/totp", func(ctx *gin.Context) {
+			enrollment, err := common.EnrollTOTP(ctx.GetUint("id"), ctx.GetString("email"))
+			if err != nil {
+				log.Printf("Error enrolling authenticator app: %s\n", err.Error())
+				ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": enrollment})
+		}).
+		POST("/accounts/mfa/totp/confirm", func(ctx *gin.Context) {
+			var body types.MFACodeRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			codes, err := common.ConfirmTOTP(ctx.GetUint("id"), body.Code)
+			if err != nil {
+				log.Printf("Error confirming authenticator app: %s\n", err.Error())
+				ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+		}).
+		DELETE("/accounts/mfa/totp", func(ctx *gin.Context) {
+			var body types.MFACodeRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.DisableTOTP(ctx.GetUint("id"), body.Code); err != nil {
+				log.Printf("Error disabling authenticator app: %s\n", err.Error())
+				ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		}).
+		POST("/accounts/mfa/recovery-codes", func(ctx *gin.Context) {
+			var body types.MFACodeRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			codes, err := common.RegenerateRecoveryCodes(ctx.GetUint("id"), body.Code)
+			if err != nil {
+				log.Printf("Error regenerating recovery codes: %s\n", err.Error())
+				ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+		})
+	return g
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrTOTPNotEnrolled):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrTOTPAlreadyEnabled):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrInvalidMFACode):
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "ebs_org_0123456789abcdef"
+
+func newAPIKeyRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mock := dbtest.UseMockDB(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware)
+	keys := APIKeyRoutes(&router.RouterGroup)
+	keys.GET("/organizations/:orgId/events", RequirePermission(types.PERM_EVENTS_READ, OrganizationParam("orgId")), func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"id": ctx.GetUint("id"), "org": ctx.GetUint("org_id")})
+	})
+	keys.DELETE("/organizations/:orgId/events", RequirePermission(types.PERM_EVENTS_DELETE, OrganizationParam("orgId")), func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+	router.GET("/users/me", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/organizations/:orgId/members", RequirePermission(types.PERM_EVENTS_READ, OrganizationParam("orgId")), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	return router, mock
+}
+
+func expectOrganizationAPIKey(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT \* FROM "api_keys" WHERE "api_keys"\."secret_hash" = \$1 AND \(revoked_at IS NULL AND expires_at > \$2\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "user_id", "organization_id", "scopes", "created_by"}).
+			AddRow("5f0c2a1e-8b7d-4e6f-a1b2-c3d4e5f60718", "organization", 42, 7, `["events:read"]`, 3))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "status"}).AddRow(42, "service", "active"))
+}
+
+func expectAPIKeyUse(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "api_keys" SET "last_used_at"=\$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	t.Run("within its scopes and organization", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		expectAPIKeyUse(mock)
+		// The member who created the key still owns the organization
+		mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(7, 3))
+		mock.ExpectQuery(`SELECT "permission_name" FROM "role_permissions"`).
+			WithArgs("owner").
+			WillReturnRows(sqlmock.NewRows([]string{"permission_name"}).AddRow("events:read"))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/organizations/7/events", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":42,"org":7}`, w.Body.String())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("creator no longer a member", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		expectAPIKeyUse(mock)
+		mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(7, 1))
+		mock.ExpectQuery(`FROM "team_members" JOIN teams`).
+			WithArgs(7, 3, "active", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/organizations/7/events", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("other organization", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		expectAPIKeyUse(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/organizations/8/events", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("outside its scopes", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		expectAPIKeyUse(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/organizations/7/events", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("route without permission check", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("guarded route outside the API key routes", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		expectOrganizationAPIKey(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/organizations/7/members", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("revoked or unknown", func(t *testing.T) {
+		router, mock := newAPIKeyRouter(t)
+		mock.ExpectQuery(`SELECT \* FROM "api_keys"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/organizations/7/events", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -1,13 +1,12 @@
 package middlewares
 
 import (
+	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/models"
-	"ebs/src/types"
 	"errors"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
@@ -15,8 +14,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
-
+// AuthMiddleware authenticates requests carrying an access token or an API key as a bearer token
 func AuthMiddleware(ctx *gin.Context) {
 	bearerToken := ctx.Request.Header.Get("Authorization")
 	if !strings.HasPrefix(bearerToken, "Bearer") || len(bearerToken) < 8 {
@@ -28,21 +26,19 @@ func AuthMiddleware(ctx *gin.Context) {
 		ctx.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
-	claims := &types.Claims{}
-	tkn, err := jwt.ParseWithClaims(reqToken, claims, func(t *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
+	if models.IsAPIKey(reqToken) {
+		apiKeyAuth(ctx, reqToken)
+		return
+	}
+	claims, err := common.VerifyAccessToken(ctx, reqToken)
 	if err != nil {
 		log.Printf("token error: %s\n", err.Error())
-		if err == jwt.ErrSignatureInvalid || err == jwt.ErrTokenMalformed {
+		switch {
+		case errors.Is(err, jwt.ErrSignatureInvalid), errors.Is(err, jwt.ErrTokenMalformed):
 			ctx.AbortWithError(http.StatusUnauthorized, errors.New("Unauthorized"))
-			return
+		default:
+			ctx.AbortWithError(http.StatusUnauthorized, err)
 		}
-		ctx.AbortWithError(http.StatusUnauthorized, err)
-		return
-	}
-	if !tkn.Valid {
-		ctx.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
@@ -73,4 +69,5 @@ func AuthMiddleware(ctx *gin.Context) {
 	ctx.Set("org", user.ActiveOrg)
 	ctx.Set("role", user.Role)
 	ctx.Set("perms", claims)
+	ctx.Set("session_id", claims.Session)
 }
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 func SecureHeaders(ctx *gin.Context) {
@@ -13,3 +14,14 @@ func SecureHeaders(ctx *gin.Context) {
 	ctx.Header("X-Content-Type-Options", "nosniff")
 	ctx.Header("Permissions-Policy", "geolocation=(),midi=(),sync-xhr=(),microphone=(),camera=(),magnetometer=(),gyroscope=(),fullscreen=(self),payment=()")
 }
+
+// RequestID stores the X-Request-ID of the request as "request_id", generating one when the client
+// did not send a usable value, and echoes it in the response
+func RequestID(ctx *gin.Context) {
+	id := ctx.GetHeader("X-Request-ID")
+	if id == "" || len(id) > 64 {
+		id = uuid.NewString()
+	}
+	ctx.Set("request_id", id)
+	ctx.Header("X-Request-ID", id)
+}
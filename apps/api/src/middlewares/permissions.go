@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OrganizationResolver returns the ID of the organization a request acts on
+type OrganizationResolver func(ctx *gin.Context) (uint, error)
+
+// OrganizationParam reads the organization ID from a route parameter
+func OrganizationParam(name string) OrganizationResolver {
+	return func(ctx *gin.Context) (uint, error) {
+		id, err := strconv.Atoi(ctx.Param(name))
+		if err != nil {
+			return 0, err
+		}
+		return uint(id), nil
+	}
+}
+
+// ActiveOrganization uses the organization the user is currently acting as
+func ActiveOrganization(ctx *gin.Context) (uint, error) {
+	orgId := ctx.GetUint("org")
+	if orgId == 0 {
+		return 0, errors.New("no active organization")
+	}
+	return orgId, nil
+}
+
+// EventOrganization resolves the organizer of the event in a route parameter
+func EventOrganization(name string) OrganizationResolver {
+	return func(ctx *gin.Context) (uint, error) {
+		id, err := strconv.Atoi(ctx.Param(name))
+		if err != nil {
+			return 0, err
+		}
+		var event models.Event
+		db := db.GetDb()
+		if err := db.
+			Select("id", "organizer_id").
+			Where(&models.Event{ID: uint(id)}).
+			First(&event).
+			Error; err != nil {
+			return 0, err
+		}
+		return event.OrganizerID, nil
+	}
+}
+
+// TicketOrganization resolves the organizer of the event of the ticket in a route parameter
+func TicketOrganization(name string) OrganizationResolver {
+	return func(ctx *gin.Context) (uint, error) {
+		id, err := strconv.Atoi(ctx.Param(name))
+		if err != nil {
+			return 0, err
+		}
+		var orgId uint
+		db := db.GetDb()
+		if err := db.
+			Model(&models.Ticket{}).
+			Select("events.organizer_id").
+			Joins("JOIN events ON events.id = tickets.event_id").
+			Where("tickets.id = ?", id).
+			Take(&orgId).
+			Error; err != nil {
+			return 0, err
+		}
+		return orgId, nil
+	}
+}
+
+// RequirePermission aborts the request unless the authenticated user's role in the resolved
+// organization grants perm. The organization and role are stored as "org_id" and "org_role".
+// Must run after AuthMiddleware.
+func RequirePermission(perm types.Permission, resolve OrganizationResolver) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		orgId, err := resolve(ctx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !CheckPermission(ctx, orgId, perm) {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// CheckPermission reports whether the authenticated user's role in the organization grants perm.
+// Use it in handlers where the organization is only known from the request body.
+// Requests made with an API key also need perm among its scopes, organization keys are only
+// granted their scopes within their own organization and while the member who created them still holds perm.
+func CheckPermission(ctx *gin.Context, orgId uint, perm types.Permission) bool {
+	userId := ctx.GetUint("id")
+	if scopes, ok := ctx.Get("api_key_scopes"); ok {
+		if granted, _ := scopes.([]types.Permission); !slices.Contains(granted, perm) {
+			return false
+		}
+		if keyOrgId, ok := ctx.Get("api_key_org"); ok {
+			if keyOrgId != orgId {
+				return false
+			}
+			userId = ctx.GetUint("api_key_creator")
+		}
+	}
+	db := db.GetDb()
+	role, err := models.GetMemberRole(db, orgId, userId)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error resolving role of user %d in organization %d: %s\n", userId, orgId, err.Error())
+		}
+		return false
+	}
+	perms, err := models.GetRolePermissions(db, role)
+	if err != nil {
+		log.Printf("Error retrieving permissions of role %s: %s\n", role, err.Error())
+		return false
+	}
+	if !slices.Contains(perms, perm) {
+		return false
+	}
+	ctx.Set("org_id", orgId)
+	ctx.Set("org_role", role)
+	return true
+}
@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPermissionRouter(t *testing.T, userId uint) (*gin.Engine, sqlmock.Sqlmock) {
+	mock := dbtest.UseMockDB(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Set("id", userId)
+	})
+	router.PATCH("/organizations/:orgId/events",
+		RequirePermission(types.PERM_EVENTS_PUBLISH, OrganizationParam("orgId")),
+		func(ctx *gin.Context) {
+			role, _ := ctx.Get("org_role")
+			ctx.JSON(http.StatusOK, gin.H{"role": role})
+		})
+	return router, mock
+}
+
+func TestRequirePermission(t *testing.T) {
+	t.Run("owner", func(t *testing.T) {
+		router, mock := newPermissionRouter(t, 1)
+		mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(7, 1))
+		mock.ExpectQuery(`SELECT "permission_name" FROM "role_permissions"`).
+			WithArgs("owner").
+			WillReturnRows(sqlmock.NewRows([]string{"permission_name"}).AddRow("events:read").AddRow("events:publish"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/organizations/7/events", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"role":"owner"}`, w.Body.String())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("member without permission", func(t *testing.T) {
+		router, mock := newPermissionRouter(t, 2)
+		mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(7, 1))
+		mock.ExpectQuery(`SELECT "team_members"\."id".* FROM "team_members" JOIN teams`).
+			WithArgs(7, 2, "active", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "team_id", "user_id", "role", "status"}).AddRow(3, 5, 2, "staff", "active"))
+		mock.ExpectQuery(`SELECT "permission_name" FROM "role_permissions"`).
+			WithArgs("staff").
+			WillReturnRows(sqlmock.NewRows([]string{"permission_name"}).AddRow("events:read").AddRow("admissions:create"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/organizations/7/events", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not a member", func(t *testing.T) {
+		router, mock := newPermissionRouter(t, 3)
+		mock.ExpectQuery(`SELECT "id","owner_id" FROM "organizations"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id"}).AddRow(7, 1))
+		mock.ExpectQuery(`FROM "team_members" JOIN teams`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/organizations/7/events", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,201 @@
+package middlewares
+
+import (
+	"bytes"
+	"ebs/src/lib"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// clock returns the current time, replaced in tests
+var clock = time.Now
+
+// RateLimitPolicy allows Limit requests per Window for each value returned by Key.
+// Requests for which Key returns an empty string are not counted.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    func(ctx *gin.Context) string
+}
+
+// LockoutPolicy locks a key out once it failed MaxFailures times within FailureWindow. The lockout starts at
+// Lockout and doubles each time the key is locked out again within a day, up to MaxLockout.
+type LockoutPolicy struct {
+	Name          string
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+	Key           func(ctx *gin.Context) string
+	// Failed tells whether the handler rejected the attempt, a 400 or 401 response when not set
+	Failed func(ctx *gin.Context) bool
+}
+
+// lockoutMemory is how long previous lockouts of a key count towards a longer one
+const lockoutMemory = 24 * time.Hour
+
+// ByIP keys requests by client IP
+func ByIP(ctx *gin.Context) string {
+	return ctx.ClientIP()
+}
+
+// ByUser keys requests by authenticated user
+func ByUser(ctx *gin.Context) string {
+	if id := ctx.GetUint("id"); id > 0 {
+		return strconv.FormatUint(uint64(id), 10)
+	}
+	return ""
+}
+
+// ByFirebaseUser keys requests by the Firebase user verified by VerifyIdToken
+func ByFirebaseUser(ctx *gin.Context) string {
+	return ctx.GetString("uid")
+}
+
+// ByBodyField keys requests by a field of their JSON body, case insensitive. The body stays readable by the handler.
+func ByBodyField(field string) func(ctx *gin.Context) string {
+	return func(ctx *gin.Context) string {
+		if ctx.Request.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, 1<<20))
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(gjson.GetBytes(body, field).String()))
+	}
+}
+
+// RateLimit rejects requests over any of the policies with 429 and sets the RateLimit-* headers of the
+// most restrictive one. Requests are let through when Redis is unavailable.
+func RateLimit(policies ...RateLimitPolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rd := lib.GetRedisClient()
+		if rd == nil {
+			return
+		}
+		now := clock()
+		remaining, limit, reset := math.MaxInt, 0, time.Duration(0)
+		for _, policy := range policies {
+			key := policy.Key(ctx)
+			if key == "" {
+				continue
+			}
+			windowStart := now.Truncate(policy.Window)
+			redisKey := fmt.Sprintf("ratelimit:%s:%s:%d", policy.Name, key, windowStart.Unix())
+			count, err := rd.Incr(ctx, redisKey).Result()
+			if err != nil {
+				log.Printf("[redis] Error applying rate limit %s: %s\n", policy.Name, err.Error())
+				continue
+			}
+			if count == 1 {
+				rd.Expire(ctx, redisKey, policy.Window)
+			}
+			left := policy.Limit - int(count)
+			if left < remaining {
+				remaining, limit, reset = left, policy.Limit, windowStart.Add(policy.Window).Sub(now)
+			}
+		}
+		if limit == 0 {
+			return
+		}
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+		ctx.Header("RateLimit-Limit", strconv.Itoa(limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+		ctx.Header("RateLimit-Reset", resetSeconds)
+		if remaining < 0 {
+			ctx.Header("Retry-After", resetSeconds)
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		}
+	}
+}
+
+// Lockout rejects requests of locked out keys with 429 and counts the responses of the handler with a
+// 400 or 401 status, or the ones the Failed func of the policy reports, as failures. Each attempt is
+// counted before the handler runs so concurrent attempts can not get past MaxFailures, attempts that
+// did not fail are refunded and a successful response clears the failures of the key.
+func Lockout(policy LockoutPolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rd := lib.GetRedisClient()
+		key := policy.Key(ctx)
+		if rd == nil || key == "" {
+			return
+		}
+		lockKey := fmt.Sprintf("lockout:%s:%s", policy.Name, key)
+		failKey := fmt.Sprintf("lockout:%s:%s:failures", policy.Name, key)
+		levelKey := fmt.Sprintf("lockout:%s:%s:level", policy.Name, key)
+		ttl, err := rd.TTL(ctx, lockKey).Result()
+		if err != nil {
+			log.Printf("[redis] Error checking lockout %s: %s\n", policy.Name, err.Error())
+			return
+		}
+		if ttl > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(ttl.Seconds()))))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts"})
+			return
+		}
+		attempt, err := rd.Incr(ctx, failKey).Result()
+		if err != nil {
+			log.Printf("[redis] Error counting attempt of %s: %s\n", policy.Name, err.Error())
+			return
+		}
+		if attempt == 1 {
+			rd.Expire(ctx, failKey, policy.FailureWindow)
+		}
+		if attempt > int64(policy.MaxFailures) {
+			rd.Decr(ctx, failKey)
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts"})
+			return
+		}
+
+		ctx.Next()
+
+		failed := policy.Failed
+		if failed == nil {
+			failed = failedStatus
+		}
+		switch {
+		case failed(ctx):
+			if attempt < int64(policy.MaxFailures) {
+				return
+			}
+			level, err := rd.Incr(ctx, levelKey).Result()
+			if err != nil {
+				log.Printf("[redis] Error counting lockouts of %s: %s\n", policy.Name, err.Error())
+				return
+			}
+			rd.Expire(ctx, levelKey, lockoutMemory)
+			rd.Set(ctx, lockKey, 1, lockoutDuration(policy, level))
+			rd.Del(ctx, failKey)
+		case ctx.Writer.Status() < http.StatusBadRequest:
+			rd.Del(ctx, failKey)
+		default:
+			rd.Decr(ctx, failKey)
+		}
+	}
+}
+
+func failedStatus(ctx *gin.Context) bool {
+	status := ctx.Writer.Status()
+	return status == http.StatusBadRequest || status == http.StatusUnauthorized
+}
+
+// lockoutDuration returns the lockout of the nth consecutive lockout of a key
+func lockoutDuration(policy LockoutPolicy, n int64) time.Duration {
+	d := policy.Lockout
+	for i := int64(1); i < n && d < policy.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, policy.MaxLockout)
+}
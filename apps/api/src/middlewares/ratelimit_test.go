@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"ebs/src/lib"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	rc, mock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+	now := time.Date(2026, 10, 19, 9, 30, 10, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/login", RateLimit(
+		RateLimitPolicy{Name: "ip", Limit: 5, Window: time.Minute, Key: ByIP},
+		RateLimitPolicy{Name: "email", Limit: 2, Window: time.Minute, Key: ByBodyField("email")},
+	), func(ctx *gin.Context) {
+		var body struct {
+			Email string `json:"email"`
+		}
+		assert.Nil(t, ctx.ShouldBindJSON(&body))
+		ctx.String(http.StatusOK, body.Email)
+	})
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"User@Example.com"}`))
+		req.RemoteAddr = "10.0.0.1:1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	mock.ExpectIncr("ratelimit:ip:10.0.0.1:1792402200").SetVal(1)
+	mock.ExpectExpire("ratelimit:ip:10.0.0.1:1792402200", time.Minute).SetVal(true)
+	mock.ExpectIncr("ratelimit:email:user@example.com:1792402200").SetVal(2)
+	w := send()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "User@Example.com", w.Body.String())
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "50", w.Header().Get("RateLimit-Reset"))
+
+	mock.ExpectIncr("ratelimit:ip:10.0.0.1:1792402200").SetVal(2)
+	mock.ExpectIncr("ratelimit:email:user@example.com:1792402200").SetVal(3)
+	w = send()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "50", w.Header().Get("Retry-After"))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestLockout(t *testing.T) {
+	rc, mock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+
+	policy := LockoutPolicy{
+		Name:          "verify",
+		MaxFailures:   2,
+		FailureWindow: 10 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    time.Hour,
+		Key:           func(ctx *gin.Context) string { return "42" },
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/verify", Lockout(policy), func(ctx *gin.Context) {
+		ctx.Status(http.StatusBadRequest)
+	})
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/verify", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	mock.ExpectTTL("lockout:verify:42").SetVal(-2 * time.Second)
+	mock.ExpectIncr("lockout:verify:42:failures").SetVal(2)
+	mock.ExpectIncr("lockout:verify:42:level").SetVal(2)
+	mock.ExpectExpire("lockout:verify:42:level", lockoutMemory).SetVal(true)
+	mock.ExpectSet("lockout:verify:42", 1, 10*time.Minute).SetVal("OK")
+	mock.ExpectDel("lockout:verify:42:failures").SetVal(1)
+	assert.Equal(t, http.StatusBadRequest, send().Code)
+
+	mock.ExpectTTL("lockout:verify:42").SetVal(10 * time.Minute)
+	w := send()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, 5*time.Minute, lockoutDuration(policy, 1))
+	assert.Equal(t, 40*time.Minute, lockoutDuration(policy, 4))
+	assert.Equal(t, time.Hour, lockoutDuration(policy, 10))
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	rc, mock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+	mock.MatchExpectationsInOrder(false)
+
+	policy := LockoutPolicy{
+		Name:          "verify",
+		MaxFailures:   2,
+		FailureWindow: 10 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    time.Hour,
+		Key:           func(ctx *gin.Context) string { return "42" },
+	}
+	var entered atomic.Int32
+	release := make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/verify", Lockout(policy), func(ctx *gin.Context) {
+		entered.Add(1)
+		<-release
+		ctx.Status(http.StatusBadRequest)
+	})
+
+	const attempts = 5
+	for i := range attempts {
+		mock.ExpectTTL("lockout:verify:42").SetVal(-2 * time.Second)
+		mock.ExpectIncr("lockout:verify:42:failures").SetVal(int64(i + 1))
+	}
+	mock.ExpectExpire("lockout:verify:42:failures", 10*time.Minute).SetVal(true)
+	for range attempts - policy.MaxFailures {
+		mock.ExpectDecr("lockout:verify:42:failures").SetVal(int64(policy.MaxFailures))
+	}
+	mock.ExpectIncr("lockout:verify:42:level").SetVal(1)
+	mock.ExpectExpire("lockout:verify:42:level", lockoutMemory).SetVal(true)
+	mock.ExpectSet("lockout:verify:42", 1, 5*time.Minute).SetVal("OK")
+	mock.ExpectDel("lockout:verify:42:failures").SetVal(1)
+
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/verify", nil)
+			router.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	// The attempts over MaxFailures are rejected while the others are still being handled
+	for range attempts - policy.MaxFailures {
+		assert.Equal(t, http.StatusTooManyRequests, <-codes)
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		assert.Equal(t, http.StatusBadRequest, code)
+	}
+	assert.Equal(t, int32(policy.MaxFailures), entered.Load())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"ebs/src/types"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole aborts the request unless the authenticated user has one of the given roles.
+// Must run after AuthMiddleware.
+func RequireRole(roles ...types.UserRole) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role, _ := ctx.Get("role")
+		userRole, _ := role.(types.UserRole)
+		if !slices.Contains(roles, userRole) {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+}
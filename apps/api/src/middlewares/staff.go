@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StaffPermissions are the only permissions granted to event scoped staff credentials
+var StaffPermissions = []types.Permission{
+	types.PERM_ADMISSIONS_READ,
+	types.PERM_ADMISSIONS_CREATE,
+}
+
+// StaffAuthMiddleware authenticates requests carrying a staff credential in the X-Staff-Token header
+// and falls back to AuthMiddleware otherwise. The event the credential is scoped to is stored as
+// "staff_event_id". Credentials are looked up on every request so a revocation applies immediately.
+func StaffAuthMiddleware(ctx *gin.Context) {
+	secret := ctx.GetHeader("X-Staff-Token")
+	if secret == "" {
+		AuthMiddleware(ctx)
+		return
+	}
+	db := db.GetDb()
+	cred, err := models.FindActiveStaffCredential(db, secret)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error retrieving staff credential: %s\n", err.Error())
+		}
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if err := db.
+		Model(&models.StaffCredential{}).
+		Where("id = ?", cred.ID).
+		UpdateColumn("last_used_at", time.Now()).
+		Error; err != nil {
+		log.Printf("Error updating staff credential [%s]: %s\n", cred.ID, err.Error())
+	}
+
+	if cred.User != nil && cred.User.TenantID != nil {
+		ctx.Set("tenant_id", cred.User.TenantID.String())
+	}
+	ctx.Set("id", cred.UserID)
+	ctx.Set("org", cred.OrganizationID)
+	ctx.Set("role", types.ROLE_STAFF)
+	ctx.Set("staff_credential_id", cred.ID)
+	ctx.Set("staff_event_id", cred.EventID)
+}
+
+// CheckEventPermission is CheckPermission for actions on a single event of the organization.
+// Staff credentials are only granted StaffPermissions on the event they are scoped to.
+func CheckEventPermission(ctx *gin.Context, orgId uint, eventId uint, perm types.Permission) bool {
+	staffEventId, ok := ctx.Get("staff_event_id")
+	if !ok {
+		return CheckPermission(ctx, orgId, perm)
+	}
+	if staffEventId != eventId || !slices.Contains(StaffPermissions, perm) {
+		return false
+	}
+	ctx.Set("org_id", orgId)
+	return true
+}
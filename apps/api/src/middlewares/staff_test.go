@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStaffRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mock := dbtest.UseMockDB(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(StaffAuthMiddleware)
+	router.POST("/events/:eventId/admission", func(ctx *gin.Context) {
+		eventId, _ := strconv.Atoi(ctx.Param("eventId"))
+		if !CheckEventPermission(ctx, 7, uint(eventId), types.PERM_ADMISSIONS_CREATE) {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"id": ctx.GetUint("id")})
+	})
+	return router, mock
+}
+
+func expectStaffCredential(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT \* FROM "staff_credentials" WHERE "staff_credentials"\."secret_hash" = \$1 AND \(revoked_at IS NULL AND expires_at > \$2\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "event_id", "user_id", "kind"}).
+			AddRow("0b8f4a7e-4f3c-4c55-9d5e-1f2a3b4c5d6e", 7, 3, 42, "code"))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(42, "staff"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "staff_credentials" SET "last_used_at"=\$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestStaffAuthMiddleware(t *testing.T) {
+	t.Run("admits on its event", func(t *testing.T) {
+		router, mock := newStaffRouter(t)
+		expectStaffCredential(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/events/3/admission", nil)
+		req.Header.Set("X-Staff-Token", "ABCDEFGHJK")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":42}`, w.Body.String())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("other event", func(t *testing.T) {
+		router, mock := newStaffRouter(t)
+		expectStaffCredential(mock)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/events/4/admission", nil)
+		req.Header.Set("X-Staff-Token", "ABCDEFGHJK")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("revoked or unknown", func(t *testing.T) {
+		router, mock := newStaffRouter(t)
+		mock.ExpectQuery(`SELECT \* FROM "staff_credentials"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/events/3/admission", nil)
+		req.Header.Set("X-Staff-Token", "ABCDEFGHJK")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
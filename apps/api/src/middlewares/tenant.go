@@ -0,0 +1,19 @@
+package middlewares
+
+import (
+	"ebs/src/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TenantContext scopes the database statements run with the context of the request to the tenant of the
+// authenticated user, see db.WithTenant. Requests of users without a tenant match no tenant.
+// Must run after AuthMiddleware.
+func TenantContext(ctx *gin.Context) {
+	tenantId := ctx.GetString("tenant_id")
+	if tenantId == "" {
+		tenantId = uuid.Nil.String()
+	}
+	ctx.Request = ctx.Request.WithContext(db.WithTenant(ctx.Request.Context(), tenantId))
+}
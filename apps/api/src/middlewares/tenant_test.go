@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"ebs/src/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tenantOf := func(tenantId string) string {
+		router := gin.New()
+		router.GET("/", func(ctx *gin.Context) {
+			if tenantId != "" {
+				ctx.Set("tenant_id", tenantId)
+			}
+		}, TenantContext, func(ctx *gin.Context) {
+			tenant, ok := db.TenantFromContext(ctx.Request.Context())
+			assert.True(t, ok)
+			ctx.String(http.StatusOK, tenant)
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	t.Run("scopes the request to the tenant of the user", func(t *testing.T) {
+		assert.Equal(t, "0b6f3c9e-8d2a-4f1b-9c7e-5a3d2e1f4b6c", tenantOf("0b6f3c9e-8d2a-4f1b-9c7e-5a3d2e1f4b6c"))
+	})
+
+	t.Run("matches no tenant without one", func(t *testing.T) {
+		assert.Equal(t, uuid.Nil.String(), tenantOf(""))
+	})
+}
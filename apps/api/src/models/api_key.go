@@ -0,0 +1,63 @@
+package models
+
+import (
+	"crypto/sha256"
+	"ebs/src/types"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key so they can be told apart from access tokens
+const APIKeyPrefix = "ebs_"
+
+// APIKey is a machine credential for integrations. Personal keys act as the user who created them,
+// organization keys are backed by a User with the service role and only act within their organization.
+// Either way a key is limited to its scopes.
+type APIKey struct {
+	ID             uuid.UUID          `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Kind           types.APIKeyKind   `gorm:"index" json:"kind"`
+	Name           string             `json:"name"`
+	Prefix         string             `json:"prefix"`
+	SecretHash     string             `gorm:"uniqueIndex" json:"-"`
+	UserID         uint               `gorm:"index" json:"user_id"`
+	OrganizationID *uint              `gorm:"index" json:"organization_id,omitempty"`
+	Scopes         []types.Permission `gorm:"serializer:json;type:jsonb" json:"scopes"`
+	CreatedBy      uint               `json:"created_by"`
+	ExpiresAt      time.Time          `json:"expires_at"`
+	RevokedAt      *time.Time         `json:"revoked_at,omitempty"`
+	RevokedBy      *uint              `json:"revoked_by,omitempty"`
+	LastUsedAt     *time.Time         `json:"last_used_at,omitempty"`
+
+	User *User `json:"-"`
+
+	types.Timestamps
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an access token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashAPIKey returns the digest an API key is stored as
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindActiveAPIKey returns the unexpired and unrevoked API key identified by secret
+func FindActiveAPIKey(tx *gorm.DB, secret string) (*APIKey, error) {
+	var key APIKey
+	if err := tx.
+		Preload("User").
+		Where(&APIKey{SecretHash: HashAPIKey(secret)}).
+		Where("revoked_at IS NULL AND expires_at > ?", time.Now()).
+		First(&key).
+		Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
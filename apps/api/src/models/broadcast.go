@@ -0,0 +1,44 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Broadcast is a message of an organizer to the ticket holders of an event. Recipients are resolved
+// when it is created and sent to in throttled batches.
+type Broadcast struct {
+	ID             uuid.UUID             `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OrganizationID uint                  `gorm:"index" json:"organization_id"`
+	EventID        uint                  `gorm:"index" json:"event_id"`
+	SentBy         uint                  `json:"sent_by"`
+	Subject        string                `json:"subject"`
+	Message        string                `json:"message"`
+	Tiers          []string              `gorm:"serializer:json;type:jsonb" json:"tiers,omitempty"`
+	Admission      types.AdmissionFilter `json:"admission,omitempty"`
+	Status         types.BroadcastStatus `gorm:"default:'queued'" json:"status"`
+	Recipients     int                   `json:"recipients"`
+	Sent           int                   `json:"sent"`
+	Failed         int                   `json:"failed"`
+	// Batches is how many batches have been claimed, each BroadcastsToSend message claims the next one
+	Batches     int        `json:"batches"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	types.Timestamps
+}
+
+// BroadcastRecipient is the delivery status of a broadcast to a ticket holder
+type BroadcastRecipient struct {
+	ID          uuid.UUID                      `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	BroadcastID uuid.UUID                      `gorm:"type:uuid;uniqueIndex:idx_broadcast_recipient" json:"broadcast_id"`
+	UserID      uint                           `gorm:"uniqueIndex:idx_broadcast_recipient" json:"user_id"`
+	Status      types.BroadcastRecipientStatus `gorm:"index;default:'pending'" json:"status"`
+	Error       *string                        `json:"error,omitempty"`
+	SentAt      *time.Time                     `json:"sent_at,omitempty"`
+
+	User *User `json:"-"`
+
+	types.Timestamps
+}
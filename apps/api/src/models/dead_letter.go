@@ -0,0 +1,22 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is a message that could not be handled by its consumer after all retries
+type DeadLetter struct {
+	ID         uuid.UUID              `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Topic      string                 `gorm:"index" json:"topic"`
+	Payload    string                 `gorm:"type:text" json:"payload"`
+	Reason     string                 `json:"reason"`
+	Attempts   int                    `json:"attempts"`
+	Status     types.DeadLetterStatus `gorm:"index;default:'pending'" json:"status"`
+	ResolvedBy *uint                  `json:"resolved_by"`
+	ResolvedAt *time.Time             `json:"resolved_at"`
+
+	types.Timestamps
+}
@@ -0,0 +1,15 @@
+package models
+
+import "ebs/src/types"
+
+// EmailBranding overrides how the emails sent on behalf of an organization look.
+// Empty fields fall back to the organization name and the default branding.
+type EmailBranding struct {
+	OrganizationID uint   `gorm:"primarykey;autoIncrement:false" json:"organization_id"`
+	SenderName     string `json:"sender_name"`
+	LogoURL        string `json:"logo_url"`
+	PrimaryColor   string `json:"primary_color"`
+	FooterText     string `json:"footer_text"`
+
+	types.Timestamps
+}
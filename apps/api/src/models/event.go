@@ -29,6 +29,8 @@ type Event struct {
 	Category    string            `gorm:"default:'uncategorized'" json:"category"`
 	Timezone    string            `gorm:"default:'UTC'" json:"timezone"`
 	CalEventID  *string           `json:"-"`
+	// Reminders are sent to ticket holders this many minutes before DateTime, DefaultEventReminders when null
+	Reminders []uint `gorm:"serializer:json;type:jsonb" json:"reminders"`
 
 	Creator      User         `gorm:"foreignKey:created_by" json:"-"`
 	Organization Organization `gorm:"foreignKey:organizer_id" json:"organization"`
@@ -38,6 +40,22 @@ type Event struct {
 	types.Timestamps
 }
 
+// DefaultEventReminders remind ticket holders 7 days, 24 hours and 2 hours before an event
+var DefaultEventReminders = []uint{7 * 24 * 60, 24 * 60, 2 * 60}
+
+// ReminderOffsets returns how long before the event its reminders are sent
+func (e *Event) ReminderOffsets() []time.Duration {
+	reminders := e.Reminders
+	if reminders == nil {
+		reminders = DefaultEventReminders
+	}
+	offsets := make([]time.Duration, 0, len(reminders))
+	for _, minutes := range reminders {
+		offsets = append(offsets, time.Duration(minutes)*time.Minute)
+	}
+	return offsets
+}
+
 func (e *Event) AfterFind(tx *gorm.DB) error {
 	if e.Timezone != "" {
 		l, err := time.LoadLocation(e.Timezone)
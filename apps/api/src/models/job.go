@@ -41,6 +41,17 @@ func (j *JobTask) AfterFind(tx *gorm.DB) error {
 	return nil
 }
 
+// CreateOutboxJobTask saves the job using the given transaction and adds its payload to the outbox,
+// to be published on the job's topic once RunsAt is reached. The relay marks the job published when it does.
+func (j *JobTask) CreateOutboxJobTask(tx *gorm.DB, aggregateType string, aggregateId any) error {
+	j.JobType = "Outbox"
+	j.Payload["JobID"] = j.ID.String()
+	if err := tx.Create(j).Error; err != nil {
+		return err
+	}
+	return EnqueueOutboxMessage(tx, aggregateType, aggregateId, j.Topic, j.Payload, j.RunsAt)
+}
+
 func (j *JobTask) CreateAndEnqueueJobTask(jobTask JobTask) (string, error) {
 	var jobID string
 	db := db.GetDb()
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MailLog is the delivery status of an email to one of its recipients, recorded when it is handed to
+// the provider. The Message-ID ties it to the bounce, complaint and delivery notifications of the provider.
+type MailLog struct {
+	ID             uuid.UUID        `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	MessageID      string           `gorm:"uniqueIndex:idx_mail_log_message" json:"message_id"`
+	Recipient      string           `gorm:"uniqueIndex:idx_mail_log_message;index" json:"recipient"`
+	OrganizationID uint             `gorm:"index" json:"organization_id,omitempty"`
+	Template       string           `gorm:"index" json:"template,omitempty"`
+	Subject        string           `json:"subject"`
+	Provider       string           `json:"provider"`
+	Status         types.MailStatus `gorm:"index" json:"status"`
+	Error          *string          `json:"error,omitempty"`
+	SentAt         *time.Time       `json:"sent_at,omitempty"`
+
+	types.Timestamps
+}
+
+// EmailSuppression is an address no email is sent to, after it hard bounced or complained
+type EmailSuppression struct {
+	ID     uint                    `gorm:"primarykey" json:"id"`
+	Email  string                  `gorm:"uniqueIndex" json:"email"`
+	Reason types.SuppressionReason `gorm:"index" json:"reason"`
+	Source string                  `json:"source"`
+	Detail string                  `json:"detail,omitempty"`
+
+	types.Timestamps
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+)
+
+// TOTPCredential is the authenticator app secret of a user. It is used as a second factor once confirmed.
+type TOTPCredential struct {
+	ID          uint       `gorm:"primarykey" json:"-"`
+	UserID      uint       `gorm:"uniqueIndex" json:"-"`
+	Secret      string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// LastUsedStep is the time step of the last accepted code, codes are single use
+	LastUsedStep int64 `json:"-"`
+
+	types.Timestamps
+}
+
+// RecoveryCode is a single use code replacing the second factor when the user lost access to it
+type RecoveryCode struct {
+	ID       uint       `gorm:"primarykey" json:"-"`
+	UserID   uint       `gorm:"index" json:"-"`
+	CodeHash string     `gorm:"uniqueIndex" json:"-"`
+	UsedAt   *time.Time `json:"used_at"`
+
+	types.Timestamps
+}
@@ -2,21 +2,72 @@ package models
 
 import (
 	"ebs/src/types"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Notification struct {
-	ID              uuid.UUID    `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
-	ReferenceSource string       `json:"ref_src"`
-	ReferenceType   string       `json:"ref_name"`
-	ReferenceValue  string       `json:"ref_value"`
-	Title           string       `json:"title"`
-	Description     *string      `json:"description"`
-	ReferenceBody   *types.JSONB `gorm:"type:jsonb" json:"ref_body"`
-	ActionType      string       `json:"action_type"`
-	ActionData      *types.JSONB `gorm:"type:jsonb" json:"action_data"`
-	Type            string       `json:"type"`
+	ID              uuid.UUID              `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ReferenceSource string                 `json:"ref_src"`
+	ReferenceType   string                 `json:"ref_name"`
+	ReferenceValue  string                 `json:"ref_value"`
+	Title           string                 `json:"title"`
+	Description     *string                `json:"description"`
+	ReferenceBody   *types.JSONB           `gorm:"type:jsonb" json:"ref_body"`
+	ActionType      string                 `json:"action_type"`
+	ActionData      *types.JSONB           `gorm:"type:jsonb" json:"action_data"`
+	Type            types.NotificationType `json:"type"`
 
 	types.Timestamps
 }
+
+// NotificationRecipient links a notification to a user who received it. Clearing a notification
+// soft deletes the link so the notification stays for its other recipients.
+type NotificationRecipient struct {
+	ID             uuid.UUID  `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	NotificationID uuid.UUID  `gorm:"type:uuid;uniqueIndex:idx_notification_recipient" json:"notification_id"`
+	UserID         uint       `gorm:"uniqueIndex:idx_notification_recipient;index" json:"-"`
+	ReadAt         *time.Time `json:"read_at"`
+
+	Notification *Notification `json:"notification,omitempty"`
+
+	types.Timestamps
+}
+
+// NotificationPreference is the channels a user receives a category of messages through. Categories
+// without a preference use DefaultNotificationPreference.
+type NotificationPreference struct {
+	ID       uint                       `gorm:"primarykey" json:"-"`
+	UserID   uint                       `gorm:"uniqueIndex:idx_notification_preference" json:"-"`
+	Category types.NotificationCategory `gorm:"uniqueIndex:idx_notification_preference" json:"category"`
+	Email    bool                       `json:"email"`
+	Push     bool                       `json:"push"`
+	SMS      bool                       `json:"sms"`
+
+	types.Timestamps
+}
+
+// DefaultNotificationPreference sends email and push notifications, text messages are opt in
+func DefaultNotificationPreference(userId uint, category types.NotificationCategory) NotificationPreference {
+	return NotificationPreference{
+		UserID:   userId,
+		Category: category,
+		Email:    true,
+		Push:     true,
+	}
+}
+
+// Allows reports whether messages of the category may be sent through the channel.
+// Account messages always go out by email.
+func (p *NotificationPreference) Allows(channel types.NotificationChannel) bool {
+	switch channel {
+	case types.CHANNEL_EMAIL:
+		return p.Email || p.Category == types.NOTIFY_ACCOUNT
+	case types.CHANNEL_PUSH:
+		return p.Push
+	case types.CHANNEL_SMS:
+		return p.SMS
+	}
+	return false
+}
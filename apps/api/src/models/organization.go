@@ -3,6 +3,7 @@ package models
 import (
 	"ebs/src/types"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gosimple/slug"
@@ -32,6 +33,8 @@ type Organization struct {
 	Identifier           *string         `gorm:"<-:create" json:"resource_id"`
 	CalendarID           *string         `json:"calId,omitempty"`
 	Timezone             string          `gorm:"default:'UTC'" json:"timezone,omitempty"`
+	// Rating is the summary of the published ratings of the organization, set by the handlers that show it
+	Rating *RatingSummary `gorm:"-" json:"rating,omitempty"`
 
 	Events []Event `gorm:"foreignKey:organizer_id" json:"-"`
 	Owner  User    `gorm:"foreignKey:owner_id" json:"-"`
@@ -47,15 +50,44 @@ func (o *Organization) AfterCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// Rating is the review of an organization by an attendee of one of its completed events
 type Rating struct {
-	ID             uint `gorm:"primaryKey" json:"-"`
-	OrganizationID uint `json:"-"`
-	ByUser         uint `json:"-"`
-	Value          uint `json:"rating_value"`
+	ID             uint               `gorm:"primaryKey" json:"id"`
+	OrganizationID uint               `gorm:"index" json:"organization_id"`
+	EventID        uint               `gorm:"uniqueIndex:idx_rating_event_user" json:"event_id"`
+	ByUser         uint               `gorm:"uniqueIndex:idx_rating_event_user" json:"-"`
+	Value          uint               `json:"rating_value"`
+	Comment        string             `json:"comment,omitempty"`
+	Reply          string             `json:"reply,omitempty"`
+	RepliedAt      *time.Time         `json:"replied_at,omitempty"`
+	Status         types.RatingStatus `gorm:"index;default:'published'" json:"status"`
 
-	Organization *Organization `json:"rating_target"`
+	Organization *Organization `json:"rating_target,omitempty"`
 	User         *User         `gorm:"foreignKey:by_user" json:"-"`
 	Identifier   *string       `gorm:"<-:create" json:"resource_id"`
 
 	types.Timestamps
 }
+
+// RatingReport flags a rating for moderation
+type RatingReport struct {
+	ID         uint                     `gorm:"primaryKey" json:"id"`
+	RatingID   uint                     `gorm:"uniqueIndex:idx_rating_report" json:"rating_id"`
+	ReportedBy uint                     `gorm:"uniqueIndex:idx_rating_report" json:"reported_by"`
+	Reason     string                   `json:"reason"`
+	Status     types.RatingReportStatus `gorm:"index;default:'open'" json:"status"`
+	ResolvedBy *uint                    `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time               `json:"resolved_at,omitempty"`
+
+	Rating *Rating `json:"rating,omitempty"`
+
+	types.Timestamps
+}
+
+// RatingSummary aggregates the published ratings of an organization
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+	// Distribution is the number of ratings of each value, from 1 to 5 stars
+	Distribution map[uint]int64 `json:"distribution"`
+}
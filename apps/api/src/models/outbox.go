@@ -0,0 +1,43 @@
+package models
+
+import (
+	"ebs/src/types"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is a message written in the same transaction as the domain change it describes.
+// The outbox relay publishes pending messages once AvailableAt is reached, in order per aggregate.
+type OutboxMessage struct {
+	ID            uint               `gorm:"primarykey" json:"id"`
+	AggregateType string             `gorm:"index:idx_outbox_aggregate" json:"aggregate_type"`
+	AggregateID   string             `gorm:"index:idx_outbox_aggregate" json:"aggregate_id"`
+	Topic         string             `json:"topic"`
+	Payload       types.JSONB        `gorm:"type:jsonb" json:"payload"`
+	Status        types.OutboxStatus `gorm:"index;default:'pending'" json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     *string            `json:"last_error"`
+	AvailableAt   time.Time          `gorm:"index" json:"available_at"`
+	PublishedAt   *time.Time         `json:"published_at"`
+
+	types.Timestamps
+}
+
+// EnqueueOutboxMessage adds a message to the outbox using the given transaction.
+// The message is published right after commit unless a later availableAt is given.
+func EnqueueOutboxMessage(tx *gorm.DB, aggregateType string, aggregateId any, topic string, payload types.JSONB, availableAt ...time.Time) error {
+	msg := OutboxMessage{
+		AggregateType: aggregateType,
+		AggregateID:   fmt.Sprint(aggregateId),
+		Topic:         topic,
+		Payload:       payload,
+		Status:        types.OUTBOX_PENDING,
+		AvailableAt:   time.Now(),
+	}
+	if len(availableAt) > 0 {
+		msg.AvailableAt = availableAt[0]
+	}
+	return tx.Create(&msg).Error
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentDiscrepancy is a difference found between a local Transaction and its Stripe payment.
+// Expected holds the value found in Stripe and Actual the local value.
+type PaymentDiscrepancy struct {
+	ID              uuid.UUID               `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OrganizationID  uint                    `gorm:"index" json:"organization_id"`
+	TransactionID   *uuid.UUID              `gorm:"type:uuid;index" json:"txn_id"`
+	PaymentIntentID *string                 `gorm:"index" json:"payment_intent_id"`
+	Kind            types.DiscrepancyKind   `gorm:"index" json:"kind"`
+	Expected        string                  `json:"expected"`
+	Actual          string                  `json:"actual"`
+	Status          types.DiscrepancyStatus `gorm:"index;default:'open'" json:"status"`
+	ResolvedBy      *uint                   `json:"resolved_by"`
+	ResolvedAt      *time.Time              `json:"resolved_at"`
+	// Fingerprint identifies the same discrepancy across reconciliation runs
+	Fingerprint *string `gorm:"uniqueIndex" json:"-"`
+
+	types.Timestamps
+}
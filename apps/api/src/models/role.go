@@ -1,5 +1,13 @@
 package models
 
+import (
+	"ebs/src/types"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
 type Role struct {
 	Name string `gorm:"primarykey" json:"name"`
 
@@ -16,3 +24,116 @@ type RolePermission struct {
 	RoleName       string `gorm:"primaryKey" json:"role"`
 	PermissionName string `gorm:"primaryKey" json:"permission"`
 }
+
+// DefaultRoles are the member roles seeded on startup along with their permissions
+var DefaultRoles = map[types.MemberRole][]types.Permission{
+	types.MEMBER_ROLE_OWNER: {
+		types.PERM_ORG_READ, types.PERM_ORG_UPDATE, types.PERM_TEAM_MANAGE,
+		types.PERM_EVENTS_READ, types.PERM_EVENTS_CREATE, types.PERM_EVENTS_UPDATE, types.PERM_EVENTS_PUBLISH, types.PERM_EVENTS_DELETE,
+		types.PERM_TICKETS_CREATE, types.PERM_TICKETS_UPDATE, types.PERM_TICKETS_PUBLISH, types.PERM_TICKETS_DELETE,
+		types.PERM_BOOKINGS_READ, types.PERM_ADMISSIONS_READ, types.PERM_ADMISSIONS_CREATE,
+		types.PERM_FINANCE_READ, types.PERM_PAYMENTS_MANAGE, types.PERM_AUDIT_READ, types.PERM_API_KEYS_MANAGE, types.PERM_WEBHOOKS_MANAGE,
+		types.PERM_BROADCASTS_SEND, types.PERM_RATINGS_REPLY,
+	},
+	types.MEMBER_ROLE_ADMIN: {
+		types.PERM_ORG_READ, types.PERM_ORG_UPDATE, types.PERM_TEAM_MANAGE,
+		types.PERM_EVENTS_READ, types.PERM_EVENTS_CREATE, types.PERM_EVENTS_UPDATE, types.PERM_EVENTS_PUBLISH, types.PERM_EVENTS_DELETE,
+		types.PERM_TICKETS_CREATE, types.PERM_TICKETS_UPDATE, types.PERM_TICKETS_PUBLISH, types.PERM_TICKETS_DELETE,
+		types.PERM_BOOKINGS_READ, types.PERM_ADMISSIONS_READ, types.PERM_ADMISSIONS_CREATE,
+		types.PERM_FINANCE_READ, types.PERM_AUDIT_READ, types.PERM_API_KEYS_MANAGE, types.PERM_WEBHOOKS_MANAGE,
+		types.PERM_BROADCASTS_SEND, types.PERM_RATINGS_REPLY,
+	},
+	types.MEMBER_ROLE_MANAGER: {
+		types.PERM_ORG_READ,
+		types.PERM_EVENTS_READ, types.PERM_EVENTS_CREATE, types.PERM_EVENTS_UPDATE, types.PERM_EVENTS_PUBLISH,
+		types.PERM_TICKETS_CREATE, types.PERM_TICKETS_UPDATE, types.PERM_TICKETS_PUBLISH,
+		types.PERM_BOOKINGS_READ, types.PERM_ADMISSIONS_READ, types.PERM_ADMISSIONS_CREATE,
+		types.PERM_BROADCASTS_SEND, types.PERM_RATINGS_REPLY,
+	},
+	types.MEMBER_ROLE_FINANCE: {
+		types.PERM_ORG_READ, types.PERM_EVENTS_READ, types.PERM_BOOKINGS_READ,
+		types.PERM_FINANCE_READ, types.PERM_PAYMENTS_MANAGE,
+	},
+	types.MEMBER_ROLE_STAFF: {
+		types.PERM_ORG_READ, types.PERM_EVENTS_READ,
+		types.PERM_ADMISSIONS_READ, types.PERM_ADMISSIONS_CREATE,
+	},
+}
+
+// IsKnownPermission reports whether perm is granted by any of the default roles
+func IsKnownPermission(perm types.Permission) bool {
+	for _, perms := range DefaultRoles {
+		if slices.Contains(perms, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedDefaultRoles creates the default roles and permissions that do not exist yet
+func SeedDefaultRoles(tx *gorm.DB) error {
+	for role, perms := range DefaultRoles {
+		if err := tx.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Role{Name: string(role)}).
+			Error; err != nil {
+			return err
+		}
+		for _, perm := range perms {
+			if err := tx.
+				Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&Permission{Name: string(perm)}).
+				Error; err != nil {
+				return err
+			}
+			if err := tx.
+				Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&RolePermission{RoleName: string(role), PermissionName: string(perm)}).
+				Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetMemberRole returns the role of a user within an organization.
+// The owner of the organization always has the owner role.
+func GetMemberRole(tx *gorm.DB, orgId uint, userId uint) (types.MemberRole, error) {
+	var org Organization
+	if err := tx.
+		Select("id", "owner_id").
+		Where(&Organization{ID: orgId}).
+		First(&org).
+		Error; err != nil {
+		return "", err
+	}
+	if org.OwnerID == userId {
+		return types.MEMBER_ROLE_OWNER, nil
+	}
+	var member TeamMember
+	if err := tx.
+		Model(&TeamMember{}).
+		Joins("JOIN teams ON teams.id = team_members.team_id").
+		Where("teams.organization_id = ?", orgId).
+		Where("team_members.user_id = ?", userId).
+		Where("team_members.status = ?", types.MEMBER_ACTIVE).
+		First(&member).
+		Error; err != nil {
+		return "", err
+	}
+	return types.MemberRole(member.Role), nil
+}
+
+// GetRolePermissions returns the permissions granted to a role
+func GetRolePermissions(tx *gorm.DB, role types.MemberRole) ([]types.Permission, error) {
+	perms := make([]types.Permission, 0)
+	if err := tx.
+		Model(&RolePermission{}).
+		Where(&RolePermission{RoleName: string(role)}).
+		Pluck("permission_name", &perms).
+		Error; err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+)
+
+const (
+	SigningKeyActive  = "active"
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey is a key pair used to sign access tokens, its ID is the kid of the tokens it signs.
+// Only the active key signs tokens, retired keys verify them until VerifyUntil.
+type SigningKey struct {
+	ID          string     `gorm:"primarykey" json:"kid"`
+	Algorithm   string     `json:"alg"`
+	PublicKey   []byte     `json:"-"`
+	PrivateKey  string     `json:"-"`
+	Status      string     `gorm:"index;default:'active'" json:"status"`
+	RetiredAt   *time.Time `json:"retired_at"`
+	VerifyUntil *time.Time `json:"verify_until"`
+
+	types.Timestamps
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"crypto/sha256"
+	"ebs/src/types"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StaffCredential lets a door volunteer admit reservations of a single event without joining the organization.
+// Each credential is backed by a User with the staff role so admissions are attributed to it.
+type StaffCredential struct {
+	ID             uuid.UUID                 `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OrganizationID uint                      `gorm:"index" json:"organization_id"`
+	EventID        uint                      `gorm:"index" json:"event_id"`
+	UserID         uint                      `json:"user_id"`
+	Name           string                    `json:"name"`
+	Kind           types.StaffCredentialKind `json:"kind"`
+	SecretHash     string                    `gorm:"uniqueIndex" json:"-"`
+	CreatedBy      uint                      `json:"created_by"`
+	ExpiresAt      time.Time                 `json:"expires_at"`
+	RevokedAt      *time.Time                `json:"revoked_at,omitempty"`
+	RevokedBy      *uint                     `json:"revoked_by,omitempty"`
+	LastUsedAt     *time.Time                `json:"last_used_at,omitempty"`
+
+	User  *User  `json:"-"`
+	Event *Event `json:"-"`
+
+	types.Timestamps
+}
+
+// HashStaffSecret returns the digest a staff credential secret is stored as
+func HashStaffSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindActiveStaffCredential returns the unexpired and unrevoked credential identified by secret
+func FindActiveStaffCredential(tx *gorm.DB, secret string) (*StaffCredential, error) {
+	var cred StaffCredential
+	if err := tx.
+		Preload("User").
+		Where(&StaffCredential{SecretHash: HashStaffSecret(secret)}).
+		Where("revoked_at IS NULL AND expires_at > ?", time.Now()).
+		First(&cred).
+		Error; err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
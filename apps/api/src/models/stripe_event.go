@@ -0,0 +1,23 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StripeEvent is a webhook event received from Stripe, unique per Stripe event ID
+type StripeEvent struct {
+	ID          uuid.UUID               `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	EventID     string                  `gorm:"uniqueIndex" json:"event_id"`
+	Type        string                  `gorm:"index" json:"type"`
+	Account     *string                 `json:"account"`
+	Payload     types.JSONB             `gorm:"type:jsonb" json:"payload"`
+	Status      types.StripeEventStatus `gorm:"index;default:'received'" json:"status"`
+	Error       *string                 `json:"error"`
+	Attempts    int                     `json:"attempts"`
+	ProcessedAt *time.Time              `json:"processed_at"`
+
+	types.Timestamps
+}
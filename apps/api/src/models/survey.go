@@ -0,0 +1,29 @@
+package models
+
+import (
+	"ebs/src/types"
+	"time"
+)
+
+// Survey collects the feedback of the attendees of an event. It is sent to those admitted once the event completes.
+type Survey struct {
+	ID             uint                   `gorm:"primarykey" json:"id"`
+	EventID        uint                   `gorm:"uniqueIndex" json:"event_id"`
+	OrganizationID uint                   `gorm:"index" json:"organization_id"`
+	Title          string                 `json:"title"`
+	Questions      []types.SurveyQuestion `gorm:"serializer:json;type:jsonb" json:"questions"`
+	SentAt         *time.Time             `json:"sent_at,omitempty"`
+
+	types.Timestamps
+}
+
+// SurveyResponse holds the answers to a survey for one reservation, keyed by question ID
+type SurveyResponse struct {
+	ID            uint        `gorm:"primarykey" json:"id"`
+	SurveyID      uint        `gorm:"uniqueIndex:idx_survey_reservation" json:"survey_id"`
+	ReservationID uint        `gorm:"uniqueIndex:idx_survey_reservation" json:"reservation_id"`
+	UserID        uint        `gorm:"index" json:"user_id"`
+	Answers       types.JSONB `gorm:"type:jsonb" json:"answers"`
+
+	types.Timestamps
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// OwnedTables only expose rows to the tenant that owns them
+var OwnedTables = []string{
+	"bookings",
+	"reservations",
+	"admissions",
+	"transactions",
+	"event_subscriptions",
+}
+
+// PublishedTables can be read by every tenant but only written by the tenant that owns the row
+var PublishedTables = []string{
+	"organizations",
+	"teams",
+	"events",
+	"tickets",
+}
+
+// OrganizationReads let a tenant scoped transaction read the rows of OwnedTables that belong to the events of
+// app.current_organization, the organization the user is working in. Subqueries on other tenant scoped
+// tables are filtered by their own policies.
+var OrganizationReads = map[string]string{
+	"bookings":            `organization_allowed((SELECT organizer_id FROM events WHERE events.id = bookings.event_id))`,
+	"reservations":        `EXISTS (SELECT 1 FROM bookings WHERE bookings.id = reservations.booking_id)`,
+	"admissions":          `EXISTS (SELECT 1 FROM reservations WHERE reservations.id = admissions.reservation_id)`,
+	"transactions":        `EXISTS (SELECT 1 FROM bookings WHERE bookings.transaction_id = transactions.id)`,
+	"event_subscriptions": `organization_allowed((SELECT organizer_id FROM events WHERE events.id = event_subscriptions.event_id))`,
+}
+
+// TenantRole is the role tenant scoped transactions switch to. The role of the API owns the tables and is
+// not subject to the policies, it is the bypass of background jobs and webhooks that are not scoped to a tenant.
+const TenantRole = "ebs_tenant"
+
+// EnableRowLevelSecurity creates the tenant isolation policies of OwnedTables and PublishedTables.
+// set_tenant switches the transaction to TenantRole, whose statements only reach the rows of
+// app.current_tenant and none when it is not set, plus the OrganizationReads of app.current_organization.
+func EnableRowLevelSecurity(tx *gorm.DB) error {
+	if err := tx.Exec(fmt.Sprintf(`
+	DO $$ BEGIN
+		CREATE ROLE %[1]s NOLOGIN;
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	GRANT %[1]s TO CURRENT_USER;
+	GRANT USAGE ON SCHEMA public TO %[1]s;
+	GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO %[1]s;
+	GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO %[1]s;
+	ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO %[1]s;
+	ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO %[1]s;
+
+	DROP FUNCTION IF EXISTS set_tenant(text);
+	CREATE OR REPLACE FUNCTION set_tenant(tenant_id text, organization_id text) RETURNS void AS $$
+	BEGIN
+		PERFORM set_config('app.current_tenant', tenant_id, true);
+		PERFORM set_config('app.current_organization', organization_id, true);
+		SET LOCAL ROLE %[1]s;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	CREATE OR REPLACE FUNCTION tenant_allowed(row_tenant uuid) RETURNS boolean AS $$
+		SELECT COALESCE(row_tenant::text = current_setting('app.current_tenant', true), false);
+	$$ LANGUAGE sql STABLE;
+
+	CREATE OR REPLACE FUNCTION organization_allowed(organizer_id bigint) RETURNS boolean AS $$
+		SELECT COALESCE(organizer_id::text = NULLIF(current_setting('app.current_organization', true), ''), false);
+	$$ LANGUAGE sql STABLE;
+
+	-- The slots left of a ticket count the reservations of every tenant, the function runs as the owner of
+	-- the table so tenant scoped transactions can call it
+	CREATE OR REPLACE FUNCTION reserved_slots(ticket bigint) RETURNS bigint AS $$
+		SELECT COUNT(id) FROM reservations
+		WHERE ticket_id = ticket AND status IN ('pending', 'completed') AND valid_until > now() AND deleted_at IS NULL;
+	$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+	`, TenantRole)).Error; err != nil {
+		return err
+	}
+	for _, table := range OwnedTables {
+		if err := enableTenantPolicy(tx, table, OrganizationReads[table]); err != nil {
+			return err
+		}
+	}
+	for _, table := range PublishedTables {
+		if err := enableTenantPolicy(tx, table, "true"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableTenantPolicy limits table to the rows of the tenant, read also lets rows matching it be read when set
+func enableTenantPolicy(tx *gorm.DB, table string, read string) error {
+	stmts := []string{
+		fmt.Sprintf(`ALTER TABLE %q ENABLE ROW LEVEL SECURITY`, table),
+		fmt.Sprintf(`ALTER TABLE %q NO FORCE ROW LEVEL SECURITY`, table),
+		fmt.Sprintf(`DROP POLICY IF EXISTS tenant_isolation ON %q`, table),
+		fmt.Sprintf(`CREATE POLICY tenant_isolation ON %q USING (tenant_allowed(tenant_id)) WITH CHECK (tenant_allowed(tenant_id))`, table),
+		fmt.Sprintf(`DROP POLICY IF EXISTS tenant_read ON %q`, table),
+	}
+	if read != "" {
+		stmts = append(stmts, fmt.Sprintf(`CREATE POLICY tenant_read ON %q FOR SELECT USING (%s)`, table, read))
+	}
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("error enabling row level security on %s: %w", table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"ebs/src/db"
+	"ebs/src/types"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TestRowLevelSecurity runs against the Postgres database in TEST_DATABASE_CONNECTION.
+// It migrates the tenant scoped tables, use a disposable database.
+func TestRowLevelSecurity(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_CONNECTION")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_CONNECTION is not set")
+	}
+	gormDB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	require.Nil(t, err)
+	db.NewDB(gormDB)
+	defer db.NewDB(nil)
+
+	require.Nil(t, gormDB.AutoMigrate(
+		&Organization{},
+		&Team{},
+		&Event{},
+		&Ticket{},
+		&Booking{},
+		&Reservation{},
+		&Admission{},
+		&Transaction{},
+		&EventSubscription{},
+	))
+	require.Nil(t, EnableRowLevelSecurity(gormDB))
+	tenantA, tenantB, tenantC := uuid.New(), uuid.New(), uuid.New()
+	const organizerId, ticketId = 900001, 900001
+	event := Event{Title: "RLS", Name: "rls", OrganizerID: organizerId, TenantID: &tenantB}
+	require.Nil(t, gormDB.Omit(clause.Associations).Create(&event).Error)
+	bookingA := Booking{Status: types.BOOKING_PENDING, TenantID: &tenantA}
+	bookingB := Booking{Status: types.BOOKING_PENDING, EventID: event.ID, TenantID: &tenantB}
+	require.Nil(t, gormDB.Omit(clause.Associations).Create(&bookingA).Error)
+	require.Nil(t, gormDB.Omit(clause.Associations).Create(&bookingB).Error)
+	validUntil := time.Now().Add(time.Hour)
+	reservationB := Reservation{TicketID: ticketId, BookingID: bookingB.ID, ValidUntil: &validUntil, TenantID: &tenantB}
+	require.Nil(t, gormDB.Omit(clause.Associations).Create(&reservationB).Error)
+	defer func() {
+		gormDB.Unscoped().Delete(&Reservation{}, reservationB.ID)
+		gormDB.Unscoped().Delete(&Booking{}, []uint{bookingA.ID, bookingB.ID})
+		gormDB.Unscoped().Delete(&Event{}, event.ID)
+	}()
+
+	// asTenant runs fc in a transaction scoped to tenant, set_tenant switches it to TenantRole
+	asTenant := func(tenant uuid.UUID, fc func(tx *gorm.DB) error) error {
+		return db.Tenant(tenant.String()).Transaction(fc)
+	}
+
+	t.Run("reads only own rows", func(t *testing.T) {
+		var bookings []Booking
+		err := asTenant(tenantA, func(tx *gorm.DB) error {
+			return tx.Where("id IN ?", []uint{bookingA.ID, bookingB.ID}).Find(&bookings).Error
+		})
+		assert.Nil(t, err)
+		assert.Len(t, bookings, 1)
+		assert.Equal(t, bookingA.ID, bookings[0].ID)
+
+		err = asTenant(tenantA, func(tx *gorm.DB) error {
+			return tx.First(&Booking{}, bookingB.ID).Error
+		})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("cannot update or delete rows of another tenant", func(t *testing.T) {
+		var updated, deleted int64
+		err := asTenant(tenantA, func(tx *gorm.DB) error {
+			res := tx.Model(&Booking{}).Where("id = ?", bookingB.ID).Update("status", types.BOOKING_CANCELED)
+			updated = res.RowsAffected
+			if res.Error != nil {
+				return res.Error
+			}
+			res = tx.Unscoped().Delete(&Booking{}, bookingB.ID)
+			deleted = res.RowsAffected
+			return res.Error
+		})
+		assert.Nil(t, err)
+		assert.Zero(t, updated)
+		assert.Zero(t, deleted)
+
+		var booking Booking
+		assert.Nil(t, gormDB.First(&booking, bookingB.ID).Error)
+		assert.Equal(t, types.BOOKING_PENDING, booking.Status)
+	})
+
+	t.Run("cannot write rows for another tenant", func(t *testing.T) {
+		err := asTenant(tenantA, func(tx *gorm.DB) error {
+			return tx.Omit(clause.Associations).Create(&Booking{Status: types.BOOKING_PENDING, TenantID: &tenantB}).Error
+		})
+		assert.NotNil(t, err)
+
+		err = asTenant(tenantA, func(tx *gorm.DB) error {
+			return tx.Model(&Booking{}).Where("id = ?", bookingA.ID).Update("tenant_id", tenantB).Error
+		})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("reads but cannot write published rows of another tenant", func(t *testing.T) {
+		var found Event
+		var updated int64
+		err := asTenant(tenantA, func(tx *gorm.DB) error {
+			if err := tx.First(&found, event.ID).Error; err != nil {
+				return err
+			}
+			res := tx.Model(&Event{}).Where("id = ?", event.ID).Update("title", "changed")
+			updated = res.RowsAffected
+			return res.Error
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, event.ID, found.ID)
+		assert.Zero(t, updated)
+	})
+
+	// asOrganization runs fc in a transaction scoped to tenant working in the organization orgId
+	asOrganization := func(tenant uuid.UUID, orgId uint, fc func(tx *gorm.DB) error) error {
+		ctx := db.WithOrganization(db.WithTenant(context.Background(), tenant.String()), orgId)
+		return db.GetDb().WithContext(ctx).Transaction(fc)
+	}
+
+	t.Run("organizations read the rows of attendees of their events", func(t *testing.T) {
+		var bookings []Booking
+		var reservations []Reservation
+		err := asOrganization(tenantC, organizerId, func(tx *gorm.DB) error {
+			if err := tx.Where("id IN ?", []uint{bookingA.ID, bookingB.ID}).Find(&bookings).Error; err != nil {
+				return err
+			}
+			return tx.Where("booking_id IN ?", []uint{bookingA.ID, bookingB.ID}).Find(&reservations).Error
+		})
+		assert.Nil(t, err)
+		assert.Len(t, bookings, 1)
+		assert.Equal(t, bookingB.ID, bookings[0].ID)
+		assert.Len(t, reservations, 1)
+		assert.Equal(t, reservationB.ID, reservations[0].ID)
+	})
+
+	t.Run("organizations cannot read the rows of events of others", func(t *testing.T) {
+		var bookings, reservations int64
+		err := asOrganization(tenantC, organizerId+1, func(tx *gorm.DB) error {
+			if err := tx.Model(&Booking{}).Where("id IN ?", []uint{bookingA.ID, bookingB.ID}).Count(&bookings).Error; err != nil {
+				return err
+			}
+			return tx.Model(&Reservation{}).Where("id = ?", reservationB.ID).Count(&reservations).Error
+		})
+		assert.Nil(t, err)
+		assert.Zero(t, bookings)
+		assert.Zero(t, reservations)
+
+		err = asOrganization(tenantC, organizerId, func(tx *gorm.DB) error {
+			return tx.Model(&Booking{}).Where("id = ?", bookingB.ID).Update("status", types.BOOKING_CANCELED).Error
+		})
+		assert.Nil(t, err)
+		var booking Booking
+		assert.Nil(t, gormDB.First(&booking, bookingB.ID).Error)
+		assert.Equal(t, types.BOOKING_PENDING, booking.Status)
+	})
+
+	t.Run("reserved slots count the reservations of every tenant", func(t *testing.T) {
+		var visible, reserved int64
+		err := asTenant(tenantA, func(tx *gorm.DB) error {
+			if err := tx.Model(&Reservation{}).Where("ticket_id = ?", ticketId).Count(&visible).Error; err != nil {
+				return err
+			}
+			return tx.Raw("SELECT reserved_slots(?)", ticketId).Scan(&reserved).Error
+		})
+		assert.Nil(t, err)
+		assert.Zero(t, visible)
+		assert.Equal(t, int64(1), reserved)
+	})
+
+	t.Run("matches no rows without a tenant", func(t *testing.T) {
+		var count int64
+		err := db.Tenant("").Model(&Booking{}).Where("id IN ?", []uint{bookingA.ID, bookingB.ID}).Count(&count).Error
+		assert.Nil(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("unscoped statements are not restricted", func(t *testing.T) {
+		var count int64
+		err := gormDB.Model(&Booking{}).Where("id IN ?", []uint{bookingA.ID, bookingB.ID}).Count(&count).Error
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+}
@@ -13,6 +13,14 @@ type TokenType string
 const (
 	TokenTypeVerification TokenType = "verification"
 	TokenTypeSession      TokenType = "session"
+	TokenTypeInvite       TokenType = "invite"
+)
+
+// Statuses of session tokens. A refresh token is rotated when it is used, using it again revokes its session.
+const (
+	TokenStatusActive  = "active"
+	TokenStatusRotated = "rotated"
+	TokenStatusRevoked = "revoked"
 )
 
 type Token struct {
@@ -1,10 +1,53 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"ebs/src/types"
+	"errors"
+	"time"
 
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrTrailLogImmutable is returned when updating or deleting audit trail entries
+var ErrTrailLogImmutable = errors.New("audit trail entries cannot be changed")
+
+// TrailLog is an entry of the append-only audit trail.
+// Type is the action, Group the kind of resource changed and Initiator the kind of actor.
 type TrailLog struct {
-	ID        uuid.UUID `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
-	Type      string
-	Initiator string
-	Group     string
+	ID             uuid.UUID         `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Type           types.AuditAction `gorm:"index" json:"type"`
+	Initiator      string            `json:"initiator"`
+	Group          string            `gorm:"index:idx_trail_logs_resource" json:"group"`
+	OrganizationID uint              `gorm:"index" json:"organization_id,omitempty"`
+	ActorID        *uint             `gorm:"index" json:"actor_id,omitempty"`
+	TenantID       *uuid.UUID        `gorm:"type:uuid" json:"-"`
+	ResourceID     string            `gorm:"index:idx_trail_logs_resource" json:"resource_id"`
+	Before         types.JSONB       `gorm:"type:jsonb" json:"before,omitempty"`
+	After          types.JSONB       `gorm:"type:jsonb" json:"after,omitempty"`
+	RequestID      string            `gorm:"index" json:"request_id,omitempty"`
+	CreatedAt      time.Time         `gorm:"index" json:"created_at"`
+}
+
+func (t *TrailLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrTrailLogImmutable
+}
+
+func (t *TrailLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrTrailLogImmutable
+}
+
+// ProtectTrailLogs makes the database reject updates and deletes of audit trail entries
+func ProtectTrailLogs(tx *gorm.DB) error {
+	return tx.Exec(`
+	CREATE OR REPLACE FUNCTION trail_logs_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit trail entries cannot be changed';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS trail_logs_append_only ON trail_logs;
+	CREATE TRIGGER trail_logs_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON trail_logs
+		FOR EACH STATEMENT EXECUTE FUNCTION trail_logs_append_only();
+	`).Error
 }
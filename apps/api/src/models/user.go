@@ -16,15 +16,17 @@ import (
 )
 
 type User struct {
-	ID                   uint            `gorm:"primarykey" json:"id"`
-	Name                 string          `json:"name,omitempty"`
-	Email                string          `gorm:"uniqueIndex" json:"email,omitempty"`
-	PhoneNumber          string          `json:"phone,omitempty"`
-	Role                 types.UserRole  `json:"role,omitempty"`
-	UID                  string          `json:"uid,omitempty"`
-	ActiveOrg            uint            `json:"active_org,omitempty"`
-	EmailVerified        bool            `json:"email_verified,omitempty"`
-	PhoneVerified        bool            `json:"phone_verified,omitempty"`
+	ID            uint           `gorm:"primarykey" json:"id"`
+	Name          string         `json:"name,omitempty"`
+	Email         string         `gorm:"uniqueIndex" json:"email,omitempty"`
+	PhoneNumber   string         `json:"phone,omitempty"`
+	Role          types.UserRole `json:"role,omitempty"`
+	UID           string         `json:"uid,omitempty"`
+	ActiveOrg     uint           `json:"active_org,omitempty"`
+	EmailVerified bool           `json:"email_verified,omitempty"`
+	PhoneVerified bool           `json:"phone_verified,omitempty"`
+	// Locale is the language of the emails sent to the user
+	Locale               string          `gorm:"default:'en'" json:"locale,omitempty"`
 	VerifiedAt           *time.Time      `json:"verified_at,omitempty"`
 	StripeAccountId      *string         `json:"-"`
 	StripeCustomerId     *string         `json:"-"`
@@ -55,6 +57,10 @@ func (u User) WebAuthnIcon() string                       { return "" }
 func (u User) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
 func (u *User) AddCredential(c webauthn.Credential)       { u.Credentials = append(u.Credentials, c) }
 func (u *User) AfterCreate(tx *gorm.DB) error {
+	// Staff and service users only back credentials and never pay
+	if u.Role == types.ROLE_STAFF || u.Role == types.ROLE_SERVICE {
+		return nil
+	}
 	go func() {
 		s := lib.GetStripeClient()
 		result := s.V1Customers.Search(context.Background(), &stripe.CustomerSearchParams{
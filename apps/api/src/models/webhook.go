@@ -0,0 +1,96 @@
+package models
+
+import (
+	"ebs/src/types"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebhookEndpoint is a URL of an organization notified of the event types it subscribes to
+type WebhookEndpoint struct {
+	ID             uuid.UUID                `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OrganizationID uint                     `gorm:"index" json:"organization_id"`
+	URL            string                   `json:"url"`
+	Description    string                   `json:"description"`
+	Events         []types.WebhookEventType `gorm:"serializer:json;type:jsonb" json:"events"`
+	// Secret signs the deliveries, encrypted with API_SECRET
+	Secret    string `json:"-"`
+	Enabled   bool   `gorm:"default:true" json:"enabled"`
+	CreatedBy uint   `json:"created_by"`
+
+	types.Timestamps
+}
+
+// WebhookDelivery is an attempt log of sending an event to an endpoint. Deliveries of the same event to
+// several endpoints share its EventID.
+type WebhookDelivery struct {
+	ID             uuid.UUID                   `gorm:"primarykey;type:uuid;default:gen_random_uuid()" json:"id"`
+	EndpointID     uuid.UUID                   `gorm:"type:uuid;index" json:"endpoint_id"`
+	OrganizationID uint                        `gorm:"index" json:"organization_id"`
+	EventID        uuid.UUID                   `gorm:"type:uuid;index" json:"event_id"`
+	EventType      types.WebhookEventType      `json:"event_type"`
+	Payload        types.JSONB                 `gorm:"type:jsonb" json:"payload"`
+	Status         types.WebhookDeliveryStatus `gorm:"index;default:'pending'" json:"status"`
+	Attempts       int                         `json:"attempts"`
+	ResponseStatus *int                        `json:"response_status,omitempty"`
+	ResponseBody   *string                     `json:"response_body,omitempty"`
+	LastError      *string                     `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time                   `gorm:"index" json:"next_attempt_at"`
+	DeliveredAt    *time.Time                  `json:"delivered_at,omitempty"`
+
+	types.Timestamps
+}
+
+// EmitWebhookEvent queues a delivery of the event to each enabled endpoint of the organization subscribed to it.
+// Pass the transaction making the change so the event is only delivered when the change is committed.
+func EmitWebhookEvent(tx *gorm.DB, orgId uint, eventType types.WebhookEventType, data types.JSONB) error {
+	var endpointIds []uuid.UUID
+	if err := tx.
+		Model(&WebhookEndpoint{}).
+		Where("organization_id = ? AND enabled = ?", orgId, true).
+		Where("events @> ?", fmt.Sprintf("[%q]", eventType)).
+		Pluck("id", &endpointIds).
+		Error; err != nil {
+		return err
+	}
+	if len(endpointIds) == 0 {
+		return nil
+	}
+	now := time.Now()
+	eventId := uuid.New()
+	payload := types.JSONB{
+		"id":             eventId.String(),
+		"type":           eventType,
+		"created":        now.Unix(),
+		"organizationId": orgId,
+		"data":           data,
+	}
+	deliveries := make([]WebhookDelivery, len(endpointIds))
+	for i, endpointId := range endpointIds {
+		deliveries[i] = WebhookDelivery{
+			EndpointID:     endpointId,
+			OrganizationID: orgId,
+			EventID:        eventId,
+			EventType:      eventType,
+			Payload:        payload,
+			Status:         types.WEBHOOK_DELIVERY_PENDING,
+			NextAttemptAt:  now,
+		}
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// EmitEventStatusChanged emits event.status_changed when an event of the organization moves to another status
+func EmitEventStatusChanged(tx *gorm.DB, orgId uint, eventId uint, from types.EventStatus, to types.EventStatus) error {
+	if from == to {
+		return nil
+	}
+	return EmitWebhookEvent(tx, orgId, types.WEBHOOK_EVENT_STATUS_CHANGED, types.JSONB{
+		"id":             eventId,
+		"previousStatus": from,
+		"status":         to,
+	})
+}
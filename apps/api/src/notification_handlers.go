@@ -0,0 +1,145 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/types"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func notificationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	g.
+		GET("/users/me/notifications", func(ctx *gin.Context) {
+			var filters types.NotificationQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			notifications, count, unread, err := common.ListNotifications(ctx.GetUint("id"), &filters)
+			if err != nil {
+				log.Printf("Error retrieving notifications of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":   notifications,
+				"count":  count,
+				"unread": unread,
+				"page":   filters.Page,
+				"limit":  filters.Limit,
+			})
+		}).
+		POST("/users/me/notifications/read", func(ctx *gin.Context) {
+			var body types.NotificationIDsRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			marked, err := common.MarkNotificationsRead(ctx.GetUint("id"), body.IDs)
+			if err != nil {
+				log.Printf("Error marking notifications of User [%d] as read: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"marked": marked})
+		}).
+		POST("/users/me/notifications/clear", func(ctx *gin.Context) {
+			var body types.NotificationIDsRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cleared, err := common.ClearNotifications(ctx.GetUint("id"), body.IDs)
+			if err != nil {
+				log.Printf("Error clearing notifications of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(notificationErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"cleared": cleared})
+		}).
+		DELETE("/users/me/notifications/:notificationId", func(ctx *gin.Context) {
+			notificationId, err := uuid.Parse(ctx.Param("notificationId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if _, err := common.ClearNotifications(ctx.GetUint("id"), []uuid.UUID{notificationId}); err != nil {
+				log.Printf("Error clearing notification [%s]: %s\n", notificationId, err.Error())
+				ctx.JSON(notificationErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		}).
+		GET("/users/me/notification-preferences", func(ctx *gin.Context) {
+			prefs, err := common.ListNotificationPreferences(ctx.GetUint("id"))
+			if err != nil {
+				log.Printf("Error retrieving notification preferences of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": prefs})
+		}).
+		PUT("/users/me/notification-preferences", func(ctx *gin.Context) {
+			var body types.UpdateNotificationPreferencesRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			prefs, err := common.UpdateNotificationPreferences(ctx.GetUint("id"), body.Preferences)
+			if err != nil {
+				log.Printf("Error updating notification preferences of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": prefs})
+		})
+	return g
+}
+
+// unsubscribeHandlers serve the one-click unsubscribe links of emails. GET is the link in the body,
+// POST is sent by mail clients supporting RFC 8058.
+func unsubscribeHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	unsubscribe := func(ctx *gin.Context) (types.NotificationCategory, bool) {
+		category, err := common.Unsubscribe(ctx.Query("token"))
+		if err != nil {
+			log.Printf("Error unsubscribing: %s\n", err.Error())
+			status := http.StatusInternalServerError
+			if errors.Is(err, common.ErrInvalidUnsubscribeToken) {
+				status = http.StatusBadRequest
+			}
+			ctx.String(status, err.Error())
+			return "", false
+		}
+		return category, true
+	}
+	g.
+		GET("/notifications/unsubscribe", func(ctx *gin.Context) {
+			category, ok := unsubscribe(ctx)
+			if !ok {
+				return
+			}
+			ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(fmt.Sprintf(
+				`<p>You will no longer receive %s emails. You can change this in your notification settings.</p>`,
+				html.EscapeString(string(category)),
+			)))
+		}).
+		POST("/notifications/unsubscribe", func(ctx *gin.Context) {
+			if _, ok := unsubscribe(ctx); ok {
+				ctx.Status(http.StatusOK)
+			}
+		})
+	return g
+}
+
+func notificationErrorStatus(err error) int {
+	if errors.Is(err, common.ErrNotificationNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"ebs/src/common"
 	"ebs/src/db"
 	"ebs/src/lib"
 	"ebs/src/middlewares"
@@ -28,7 +29,7 @@ import (
 )
 
 func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
-	g.Use(middlewares.AuthMiddleware)
+	keys := middlewares.APIKeyRoutes(g)
 	g.
 		GET("/organizations/active", func(ctx *gin.Context) {
 			userId := ctx.GetUint("id")
@@ -94,18 +95,27 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				conds.Type = filters.Type
 			}
 			role := ctx.GetString("role")
+			memberOf := false
 			if filters.Owned {
 				conds.OwnerID = userId
 			} else {
-				if role != string(types.ROLE_ADMIN) {
-					conds.OwnerID = userId
-				}
+				memberOf = role != string(types.ROLE_ADMIN)
 			}
 			db := db.GetDb()
 			err := db.Transaction(func(tx *gorm.DB) error {
-				err := tx.
+				q := tx.
 					Select("id", "name", "type", "contact_email", "stripe_account_id", "connect_onboarding_url", "status").
-					Where(conds).
+					Where(conds)
+				if memberOf {
+					// Organizations the user owns or is an active team member of
+					q = q.Where("owner_id = ? OR id IN (?)", userId, tx.
+						Model(&models.TeamMember{}).
+						Select("teams.organization_id").
+						Joins("JOIN teams ON teams.id = team_members.team_id").
+						Where("team_members.user_id = ?", userId).
+						Where("team_members.status = ?", types.MEMBER_ACTIVE))
+				}
+				err := q.
 					Order("created_at desc").
 					Find(&orgs).
 					Error
@@ -135,6 +145,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			withRatingSummary(&org)
 			ctx.JSON(http.StatusOK, gin.H{"data": org})
 		}).
 		GET("/organizations/about", func(ctx *gin.Context) {
@@ -165,9 +176,11 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			withRatingSummary(&org)
 			ctx.JSON(http.StatusOK, gin.H{"data": org})
-		}).
-		POST("/organizations/:orgId/switch", func(ctx *gin.Context) {
+		})
+	keys.
+		POST("/organizations/:orgId/switch", middlewares.RequireSession, middlewares.RequirePermission(types.PERM_ORG_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			orgIdParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(orgIdParam)
 			if err != nil {
@@ -180,7 +193,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			var org models.Organization
 			db := db.GetDb()
 			err = db.Transaction(func(tx *gorm.DB) error {
-				err := db.Where(&models.Organization{ID: orgId, OwnerID: userId}).First(&org).Error
+				err := db.Where(&models.Organization{ID: orgId}).First(&org).Error
 				if err != nil {
 					return err
 				}
@@ -208,7 +221,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			tokenCookie, err := ctx.Cookie("token")
 			email := ctx.GetString("email")
 			if err != nil {
-				tokenCookie, err = utils.GenerateJWT(email, userId, orgId)
+				tokenCookie, err = utils.GenerateJWT(email, userId, orgId, ctx.GetString("session_id"))
 			}
 			if err != nil {
 				log.Printf("Error switching to organization %d: %s\n", orgId, err.Error())
@@ -274,7 +287,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			rd.Expire(context.Background(), key, time.Hour)
 			ctx.JSON(http.StatusOK, gin.H{"access_token": tokenCookie})
 		}).
-		GET("/organizations/:orgId/reservations", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/reservations", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			idParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(idParam)
 			if err != nil {
@@ -305,7 +318,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			var org models.Organization
 			err = db.
 				Model(&models.Organization{}).
-				Where(&models.Organization{ID: orgId, OwnerID: userId}).
+				Where(&models.Organization{ID: orgId}).
 				First(&org).
 				Error
 			if err != nil {
@@ -313,14 +326,14 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 				return
 			}
-			data, err := utils.GetOrgReservations(orgId)
+			data, err := utils.GetOrgReservations(ctx.Request.Context(), orgId)
 			if err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": data})
 		}).
-		GET("/organizations/:orgId/bookings", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/bookings", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				log.Printf("Error while validating request: %s\n", err.Error())
@@ -367,7 +380,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": bookings})
 		}).
-		GET("/organizations/:orgId/events", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/events", middlewares.RequirePermission(types.PERM_EVENTS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var query struct {
 				Public bool `form:"public,omitempty"`
 			}
@@ -416,9 +429,8 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusOK, gin.H{"data": events})
 				return
 			}
-			userId := ctx.GetUint("id")
 			if err := db.
-				Where(&models.Organization{ID: orgId, OwnerID: userId}).
+				Where(&models.Organization{ID: orgId}).
 				Find(&organization).
 				Error; err != nil {
 				if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -442,7 +454,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 
 			ctx.JSON(http.StatusOK, gin.H{"data": events, "count": len(events)})
 		}).
-		GET("/organizations/:orgId/events/:eventId", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/events/:eventId", middlewares.RequirePermission(types.PERM_EVENTS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params struct {
 				OrgId   uint `uri:"orgId"`
 				EventId uint `uri:"eventId"`
@@ -454,11 +466,10 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			orgId := params.OrgId
 			eventId := params.EventId
 
-			userId := ctx.GetUint("id")
 			var organization models.Organization
 			db := db.GetDb()
 			if err := db.
-				Where(&models.Organization{ID: orgId, OwnerID: userId}).
+				Where(&models.Organization{ID: orgId}).
 				First(&organization).Error; err != nil {
 				ctx.Status(http.StatusBadRequest)
 				return
@@ -479,7 +490,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 
 			ctx.JSON(http.StatusOK, gin.H{"data": event})
 		}).
-		PUT("/organizations/:orgId/events/:eventId/cancel", func(ctx *gin.Context) {
+		PUT("/organizations/:orgId/events/:eventId/cancel", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params struct {
 				OrgId   uint `uri:"orgId"`
 				EventId uint `uri:"eventId"`
@@ -491,29 +502,41 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			orgId := params.OrgId
 			eventId := params.EventId
 
-			userId := ctx.GetUint("id")
 			var organization models.Organization
 			db := db.GetDb()
 			if err := db.Transaction(func(tx *gorm.DB) error {
 				if err := tx.
-					Where(&models.Organization{ID: orgId, OwnerID: userId}).
+					Where(&models.Organization{ID: orgId}).
 					First(&organization).Error; err != nil {
 					return err
 				}
+				var event models.Event
+				if err := tx.
+					Select("id", "status").
+					Where(&models.Event{ID: eventId, OrganizerID: orgId}).
+					First(&event).
+					Error; err != nil {
+					return err
+				}
 				subq := tx.Where(&models.Event{ID: eventId})
 
 				if err := tx.Where(subq).Update("status = ?", types.EVENT_CANCELED).Error; err != nil {
 					log.Printf("Error on canceling Event [%d]: %s\n", eventId, err.Error())
 					return err
 				}
-				return nil
+				if err := models.EmitEventStatusChanged(tx, orgId, eventId, event.Status, types.EVENT_CANCELED); err != nil {
+					return err
+				}
+				return common.RecordAudit(tx, newTrailLog(ctx, types.AUDIT_EVENT_CANCEL, orgId, eventId,
+					types.JSONB{"status": event.Status},
+					types.JSONB{"status": types.EVENT_CANCELED}))
 			}); err != nil {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
 			ctx.Status(http.StatusNoContent)
 		}).
-		GET("/organizations/:orgId/tickets", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/tickets", middlewares.RequirePermission(types.PERM_EVENTS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -551,7 +574,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": tickets})
 		}).
-		GET("/organizations/:orgId/tickets/sold", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/tickets/sold", middlewares.RequirePermission(types.PERM_FINANCE_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -593,10 +616,10 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				"to_date":   now,
 			}})
 		}).
-		GET("/organizations/:orgId/customers", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/customers", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			ctx.Status(http.StatusOK)
 		}).
-		GET("/organizations/:orgId/customers/count", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/customers/count", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -628,7 +651,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 
 			ctx.JSON(http.StatusOK, gin.H{"data": count})
 		}).
-		GET("/organizations/:orgId/customers/daily", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/customers/daily", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -664,7 +687,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": stats})
 		}).
-		GET("/organizations/:orgId/transactions/daily", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/transactions/daily", middlewares.RequirePermission(types.PERM_FINANCE_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -716,7 +739,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": stats})
 		}).
-		GET("/organizations/:orgId/dashboard", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/dashboard", middlewares.RequirePermission(types.PERM_FINANCE_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -751,7 +774,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": results})
 		}).
-		GET("/organizations/:orgId/admissions", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/admissions", middlewares.RequirePermission(types.PERM_ADMISSIONS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -835,7 +858,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": admissions})
 		}).
-		GET("/organizations/:orgId/events/:eventId/tickets", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/events/:eventId/tickets", middlewares.RequirePermission(types.PERM_EVENTS_READ, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			orgIdParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(orgIdParam)
 			if err != nil {
@@ -852,10 +875,9 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			eventId := uint(atoi)
 
-			userId := ctx.GetUint("id")
 			var organization models.Organization
 			db := db.GetDb()
-			db.Where(&models.Organization{ID: orgId, OwnerID: userId}).First(&organization)
+			db.Where(&models.Organization{ID: orgId}).First(&organization)
 			if organization.ID < 1 {
 				err := errors.New("organization not found")
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -880,7 +902,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 
 			ctx.JSON(http.StatusOK, gin.H{"data": tickets, "count": len(tickets)})
 		}).
-		POST("/organizations/:orgId/events", func(ctx *gin.Context) {
+		POST("/organizations/:orgId/events", middlewares.RequirePermission(types.PERM_EVENTS_CREATE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			id := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(id)
 			if err != nil {
@@ -892,7 +914,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			orgId := uint(atoi)
 			var organization models.Organization
 			db := db.GetDb()
-			db.Where(&models.Organization{ID: orgId, OwnerID: userId}).First(&organization)
+			db.Where(&models.Organization{ID: orgId}).First(&organization)
 			if organization.ID < 1 {
 				err := errors.New("organization not found")
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -918,14 +940,13 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusCreated, gin.H{"id": newId})
 		}).
-		POST("/organizations/:orgId/account/refresh", func(ctx *gin.Context) {
+		POST("/organizations/:orgId/account/refresh", middlewares.RequirePermission(types.PERM_PAYMENTS_MANAGE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			orgIdParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(orgIdParam)
 			if err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			userId := ctx.GetUint("id")
 			orgId := uint(atoi)
 			var accLinkURL string
 			db := db.GetDb()
@@ -934,7 +955,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				err := tx.
 					Model(&models.Organization{}).
 					Select("stripe_account_id").
-					Where(&models.Organization{ID: orgId, OwnerID: userId}).
+					Where(&models.Organization{ID: orgId}).
 					First(&org).
 					Error
 				if err != nil {
@@ -980,7 +1001,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"url": accLinkURL})
 		}).
-		POST("/organizations/:orgId/onboarding", func(ctx *gin.Context) {
+		POST("/organizations/:orgId/onboarding", middlewares.RequirePermission(types.PERM_PAYMENTS_MANAGE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			orgIdParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(orgIdParam)
 			if err != nil {
@@ -996,7 +1017,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			err = db.Transaction(func(tx *gorm.DB) error {
 				err := tx.
 					Model(&models.Organization{}).
-					Where(&models.Organization{ID: orgId, OwnerID: userId}).
+					Where(&models.Organization{ID: orgId}).
 					First(&org).
 					Error
 				if err != nil {
@@ -1053,7 +1074,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"url": accLinkURL, "account_id": org.StripeAccountID})
 		}).
-		GET("/organizations/:orgId/onboarding", func(ctx *gin.Context) {
+		GET("/organizations/:orgId/onboarding", middlewares.RequirePermission(types.PERM_PAYMENTS_MANAGE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			orgIdParam := ctx.Params.ByName("orgId")
 			atoi, err := strconv.Atoi(orgIdParam)
 			if err != nil {
@@ -1088,7 +1109,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			db := db.GetDb()
 			var org models.Organization
 			ss := db.Session(&gorm.Session{PrepareStmt: true})
-			err = ss.Where(&models.Organization{ID: orgId, OwnerID: userId}).First(&org).Error
+			err = ss.Where(&models.Organization{ID: orgId}).First(&org).Error
 			if err != nil {
 				log.Printf("Error retrieving information: %s\n", err.Error())
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -1197,7 +1218,8 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.JSON(http.StatusOK, gin.H{"account_id": stripeAccount.ID, "url": org.ConnectOnboardingURL, "completed": completed, "data": onboardingStatus})
-		}).
+		})
+	g.
 		GET("/organizations/check", func(ctx *gin.Context) {
 			orgId := ctx.GetUint("org")
 			var org models.Organization
@@ -1213,8 +1235,9 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			shared := org.Type == types.ORG_STANDARD
 			ctx.JSON(http.StatusOK, gin.H{"type": org.Type, "shared": shared})
-		}).
-		POST("/organizations/:orgId/verify/send", func(ctx *gin.Context) {
+		})
+	keys.
+		POST("/organizations/:orgId/verify/send", middlewares.RequirePermission(types.PERM_ORG_UPDATE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.Status(http.StatusBadRequest)
@@ -1231,7 +1254,7 @@ func organizationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.Status(http.StatusOK)
 		}).
-		POST("/organizations/:orgId/verify/confirm", func(ctx *gin.Context) {
+		POST("/organizations/:orgId/verify/confirm", middlewares.RequirePermission(types.PERM_ORG_UPDATE, middlewares.OrganizationParam("orgId")), func(ctx *gin.Context) {
 			var params types.SimpleOrganizationRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.Status(http.StatusBadRequest)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/lib/sms"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func phoneHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	g.
+		PUT("/users/me/phone", phoneCodeRateLimit, func(ctx *gin.Context) {
+			var body types.UpdatePhoneRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			phone, err := common.RequestPhoneVerification(ctx.GetUint("id"), body.Phone)
+			if err != nil {
+				log.Printf("Error requesting phone verification of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(phoneErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusAccepted, gin.H{"phone": phone})
+		}).
+		POST("/users/me/phone/verify", verifyPhoneLockout, func(ctx *gin.Context) {
+			var body types.VerifyPhoneRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			phone, err := common.VerifyPhone(ctx.GetUint("id"), body.Code)
+			if err != nil {
+				log.Printf("Error verifying phone of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(phoneErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"phone": phone, "phone_verified": true})
+		}).
+		DELETE("/users/me/phone", func(ctx *gin.Context) {
+			if err := common.RemovePhone(ctx.GetUint("id")); err != nil {
+				log.Printf("Error removing phone of User [%d]: %s\n", ctx.GetUint("id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		})
+	return g
+}
+
+func phoneErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sms.ErrInvalidPhoneNumber),
+		errors.Is(err, common.ErrInvalidPhoneCode),
+		errors.Is(err, common.ErrPhoneCodeExpired):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
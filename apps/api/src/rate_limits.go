@@ -0,0 +1,75 @@
+package main
+
+import (
+	"ebs/src/middlewares"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limits and lockouts of the authentication and verification routes
+var (
+	loginRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "login:ip", Limit: 20, Window: time.Minute, Key: middlewares.ByIP},
+		middlewares.RateLimitPolicy{Name: "login:account", Limit: 10, Window: 15 * time.Minute, Key: middlewares.ByFirebaseUser},
+	)
+	loginLockout = middlewares.Lockout(middlewares.LockoutPolicy{
+		Name:          "login",
+		MaxFailures:   5,
+		FailureWindow: 15 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    24 * time.Hour,
+		Key:           middlewares.ByFirebaseUser,
+		Failed:        loginFailed,
+	})
+	refreshRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "refresh:ip", Limit: 30, Window: time.Minute, Key: middlewares.ByIP},
+	)
+	mfaRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "mfa:ip", Limit: 10, Window: time.Minute, Key: middlewares.ByIP},
+		middlewares.RateLimitPolicy{Name: "mfa:account", Limit: 10, Window: 15 * time.Minute, Key: middlewares.ByFirebaseUser},
+	)
+	mfaLockout = middlewares.Lockout(middlewares.LockoutPolicy{
+		Name:          "mfa",
+		MaxFailures:   5,
+		FailureWindow: 15 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    24 * time.Hour,
+		Key:           middlewares.ByFirebaseUser,
+	})
+	requestCodeRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "request_code:user", Limit: 3, Window: 10 * time.Minute, Key: middlewares.ByUser},
+		middlewares.RateLimitPolicy{Name: "request_code:email", Limit: 3, Window: 10 * time.Minute, Key: middlewares.ByBodyField("email")},
+		middlewares.RateLimitPolicy{Name: "request_code:ip", Limit: 10, Window: time.Hour, Key: middlewares.ByIP},
+	)
+	verifyCodeRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "verify_code:ip", Limit: 30, Window: 10 * time.Minute, Key: middlewares.ByIP},
+	)
+	verifyCodeLockout = middlewares.Lockout(middlewares.LockoutPolicy{
+		Name:          "verify_code",
+		MaxFailures:   5,
+		FailureWindow: 10 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    24 * time.Hour,
+		Key:           middlewares.ByBodyField("email"),
+	})
+	phoneCodeRateLimit = middlewares.RateLimit(
+		middlewares.RateLimitPolicy{Name: "phone_code:user", Limit: 3, Window: 10 * time.Minute, Key: middlewares.ByUser},
+		middlewares.RateLimitPolicy{Name: "phone_code:ip", Limit: 10, Window: time.Hour, Key: middlewares.ByIP},
+	)
+	verifyPhoneLockout = middlewares.Lockout(middlewares.LockoutPolicy{
+		Name:          "verify_phone",
+		MaxFailures:   5,
+		FailureWindow: 10 * time.Minute,
+		Lockout:       5 * time.Minute,
+		MaxLockout:    24 * time.Hour,
+		Key:           middlewares.ByUser,
+	})
+)
+
+// loginFailed counts ID tokens presented for an account they do not belong to as failed sign ins. Tokens
+// rejected by VerifyIdToken never reach the lockout and the challenge of a second factor is not a failure.
+func loginFailed(ctx *gin.Context) bool {
+	return ctx.Writer.Status() == http.StatusUnauthorized && ctx.Writer.Header().Get("X-Authenticate-MFA") != "true"
+}
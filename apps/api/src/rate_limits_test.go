@@ -0,0 +1,75 @@
+package main
+
+import (
+	"ebs/src/lib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLockout(t *testing.T) {
+	rc, mock := redismock.NewClientMock()
+	lib.NewRedisClient(rc)
+	defer lib.NewRedisClient(nil)
+
+	var status int
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var challenge bool
+	router.POST("/login", func(ctx *gin.Context) {
+		ctx.Set("uid", "firebase-uid")
+	}, loginLockout, func(ctx *gin.Context) {
+		if challenge {
+			ctx.Header("X-Authenticate-MFA", "true")
+		}
+		ctx.Status(status)
+	})
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/login", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("second factor challenge is not a failure", func(t *testing.T) {
+		status, challenge = http.StatusUnauthorized, true
+		mock.ExpectTTL("lockout:login:firebase-uid").SetVal(-2 * time.Second)
+		mock.ExpectIncr("lockout:login:firebase-uid:failures").SetVal(1)
+		mock.ExpectExpire("lockout:login:firebase-uid:failures", 15*time.Minute).SetVal(true)
+		mock.ExpectDecr("lockout:login:firebase-uid:failures").SetVal(0)
+		assert.Equal(t, http.StatusUnauthorized, send().Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown accounts are not a failure", func(t *testing.T) {
+		status, challenge = http.StatusNotFound, false
+		mock.ExpectTTL("lockout:login:firebase-uid").SetVal(-2 * time.Second)
+		mock.ExpectIncr("lockout:login:firebase-uid:failures").SetVal(1)
+		mock.ExpectExpire("lockout:login:firebase-uid:failures", 15*time.Minute).SetVal(true)
+		mock.ExpectDecr("lockout:login:firebase-uid:failures").SetVal(0)
+		assert.Equal(t, http.StatusNotFound, send().Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("locks the account out after failed sign ins", func(t *testing.T) {
+		status, challenge = http.StatusUnauthorized, false
+		mock.ExpectTTL("lockout:login:firebase-uid").SetVal(-2 * time.Second)
+		mock.ExpectIncr("lockout:login:firebase-uid:failures").SetVal(5)
+		mock.ExpectIncr("lockout:login:firebase-uid:level").SetVal(1)
+		mock.ExpectExpire("lockout:login:firebase-uid:level", 24*time.Hour).SetVal(true)
+		mock.ExpectSet("lockout:login:firebase-uid", 1, 5*time.Minute).SetVal("OK")
+		mock.ExpectDel("lockout:login:firebase-uid:failures").SetVal(1)
+		assert.Equal(t, http.StatusUnauthorized, send().Code)
+
+		mock.ExpectTTL("lockout:login:firebase-uid").SetVal(5 * time.Minute)
+		w := send()
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "300", w.Header().Get("Retry-After"))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
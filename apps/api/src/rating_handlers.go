@@ -0,0 +1,128 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/models"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ratingHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canReply := middlewares.RequirePermission(types.PERM_RATINGS_REPLY, middlewares.OrganizationParam("orgId"))
+	g.
+		POST("/organizations/:orgId/ratings", func(ctx *gin.Context) {
+			var params types.SimpleOrganizationRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.CreateRatingRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			rating, err := common.CreateRating(params.ID, ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error rating Organization [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(ratingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": rating})
+		}).
+		GET("/organizations/:orgId/ratings", func(ctx *gin.Context) {
+			var params types.SimpleOrganizationRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var filters types.RatingQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ratings, count, err := common.ListRatings(params.ID, &filters)
+			if err != nil {
+				log.Printf("Error retrieving ratings of Organization [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  ratings,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		})
+	keys.
+		PUT("/organizations/:orgId/ratings/:ratingId/reply", canReply, func(ctx *gin.Context) {
+			var params types.RatingRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.ReplyRatingRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			before, after, err := common.ReplyToRating(orgId, params.RatingID, body.Reply)
+			if err != nil {
+				log.Printf("Error replying to rating [%d]: %s\n", params.RatingID, err.Error())
+				ctx.JSON(ratingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_RATING_REPLY, orgId, after.ID, types.JSONB{"reply": before.Reply}, types.JSONB{"reply": after.Reply})
+			ctx.JSON(http.StatusOK, gin.H{"data": after})
+		})
+	g.
+		POST("/organizations/:orgId/ratings/:ratingId/report", func(ctx *gin.Context) {
+			var params types.RatingRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.ReportRatingRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			report, err := common.ReportRating(params.OrgID, params.RatingID, ctx.GetUint("id"), body.Reason)
+			if err != nil {
+				log.Printf("Error reporting rating [%d]: %s\n", params.RatingID, err.Error())
+				ctx.JSON(ratingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": report})
+		})
+	return g
+}
+
+// withRatingSummary sets the summary of the published ratings of the organization
+func withRatingSummary(org *models.Organization) {
+	summary, err := common.RatingSummaryOf(org.ID)
+	if err != nil {
+		log.Printf("Error summarizing ratings of Organization [%d]: %s\n", org.ID, err.Error())
+		return
+	}
+	org.Rating = summary
+}
+
+func ratingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrRatingNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrNotRatingEligible):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrAlreadyRated),
+		errors.Is(err, common.ErrAlreadyReported):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -6,12 +6,11 @@ import (
 	"ebs/src/types"
 	"ebs/src/utils"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 func reservationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
@@ -27,9 +26,9 @@ func reservationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			var data []models.Booking
 			if forOrg {
 				orgId := ctx.GetUint("org")
-				data, err = utils.GetOrgReservations(orgId)
+				data, err = utils.GetOrgReservations(ctx.Request.Context(), orgId)
 			} else {
-				data, err = utils.GetOwnReservations(userId)
+				data, err = utils.GetOwnReservations(ctx.Request.Context(), userId)
 			}
 			if err != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -46,15 +45,17 @@ func reservationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			resId := uint(atoi)
 			var reservation models.Reservation
-			db := db.GetDb()
-			err = db.
-				Model(&models.Reservation{}).
-				Where(&models.Reservation{ID: resId}).
-				Preload("Booking").
-				Preload("Booking.Event").
-				Preload("Booking.Tickets").
-				Preload("Ticket").
-				First(&reservation).Error
+			db := db.GetDb().WithContext(ctx.Request.Context())
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return tx.
+					Model(&models.Reservation{}).
+					Where(&models.Reservation{ID: resId}).
+					Preload("Booking").
+					Preload("Booking.Event").
+					Preload("Booking.Tickets").
+					Preload("Ticket").
+					First(&reservation).Error
+			})
 			if err != nil {
 				err := errors.New("reservation not found")
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -68,20 +69,15 @@ func reservationHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
-			tenantId := ctx.GetString("tenant_id")
-			db := db.GetDb()
-			if err := db.Exec("select set_tenant(?)", tenantId).Error; err != nil {
-				log.Printf("Error on setting tenant %s: %s\n", tenantId, err.Error())
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
-				return
-			}
 			var adm models.Admission
-			uu, _ := uuid.Parse(tenantId)
-			if err := db.
-				Where(&models.Admission{ReservationID: params.ID, TenantID: &uu}).
-				Select("id").
-				First(&adm).
-				Error; err != nil {
+			db := db.GetDb().WithContext(ctx.Request.Context())
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				return tx.
+					Where(&models.Admission{ReservationID: params.ID}).
+					Select("id").
+					First(&adm).
+					Error
+			}); err != nil {
 				ctx.Status(http.StatusNotFound)
 				return
 			}
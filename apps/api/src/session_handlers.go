@@ -0,0 +1,57 @@
+package main
+
+import (
+	"ebs/src/common"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func sessionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	g.
+		GET("/users/me/sessions", func(ctx *gin.Context) {
+			sessions, err := common.ListSessions(ctx.GetUint("id"), ctx.GetString("session_id"))
+			if err != nil {
+				log.Printf("Error retrieving sessions: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": sessions})
+		}).
+		DELETE("/users/me/sessions", func(ctx *gin.Context) {
+			revoked, err := common.RevokeOtherSessions(ctx.GetUint("id"), ctx.GetString("session_id"))
+			if err != nil {
+				log.Printf("Error revoking sessions: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+		}).
+		DELETE("/users/me/sessions/:sessionId", func(ctx *gin.Context) {
+			sessionId := ctx.Param("sessionId")
+			if err := uuid.Validate(sessionId); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.RevokeSession(ctx.GetUint("id"), sessionId); err != nil {
+				log.Printf("Error revoking session [%s]: %s\n", sessionId, err.Error())
+				ctx.JSON(sessionErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Status(http.StatusNoContent)
+		})
+	return g
+}
+
+func sessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrInvalidRefreshToken), errors.Is(err, common.ErrRefreshTokenReused):
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func staffHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	eventOrg := middlewares.EventOrganization("id")
+	keys.
+		POST("/events/:id/staff-credentials", middlewares.RequireSession, middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, eventOrg), func(ctx *gin.Context) {
+			eventId, err := strconv.Atoi(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.CreateStaffCredentialRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cred, secret, err := common.CreateStaffCredential(uint(eventId), ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error creating staff credential for Event [%d]: %s\n", eventId, err.Error())
+				ctx.JSON(staffErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_STAFF_CREDENTIAL_CREATE, cred.OrganizationID, cred.ID, nil,
+				types.JSONB{"eventId": cred.EventID, "name": cred.Name, "kind": cred.Kind, "expiresAt": cred.ExpiresAt})
+			res := gin.H{"data": cred, "token": secret}
+			if cred.Kind == types.STAFF_CREDENTIAL_DEVICE {
+				qr, err := common.StaffProvisioningQR(cred, secret)
+				if err != nil {
+					log.Printf("Error generating provisioning QR code for staff credential [%s]: %s\n", cred.ID, err.Error())
+				} else {
+					res["qr"] = qr
+				}
+			}
+			ctx.JSON(http.StatusCreated, res)
+		}).
+		GET("/events/:id/staff-credentials", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, eventOrg), func(ctx *gin.Context) {
+			eventId, err := strconv.Atoi(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			creds, err := common.ListStaffCredentials(uint(eventId))
+			if err != nil {
+				log.Printf("Error retrieving staff credentials for Event [%d]: %s\n", eventId, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": creds})
+		}).
+		DELETE("/events/:id/staff-credentials/:credentialId", middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, eventOrg), func(ctx *gin.Context) {
+			eventId, err := strconv.Atoi(ctx.Param("id"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			credId, err := uuid.Parse(ctx.Param("credentialId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.RevokeStaffCredential(uint(eventId), credId, ctx.GetUint("id")); err != nil {
+				log.Printf("Error revoking staff credential [%s]: %s\n", credId, err.Error())
+				ctx.JSON(staffErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_STAFF_CREDENTIAL_REVOKE, ctx.GetUint("org_id"), credId,
+				types.JSONB{"eventId": eventId, "revoked": false},
+				types.JSONB{"eventId": eventId, "revoked": true})
+			ctx.Status(http.StatusNoContent)
+		})
+	return g
+}
+
+func staffErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, common.ErrStaffCredentialNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrEventClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
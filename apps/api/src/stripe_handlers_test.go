@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"ebs/src/db/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v82/webhook"
+)
+
+const testWebhookSecret = "whsec_test_fixture"
+
+func newStripeWebhookRequest(t *testing.T, fixture string, secret string) *http.Request {
+	payload, err := os.ReadFile(path.Join("testdata", "stripe", fixture))
+	assert.Nil(t, err)
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    secret,
+		Timestamp: time.Now(),
+	})
+	req, _ := http.NewRequest("POST", "/api/v1/webhook/stripe", bytes.NewReader(signed.Payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	return req
+}
+
+func newStripeWebhookRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+	mock := dbtest.UseMockDB(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	stripeWebhookRoute(router)
+	return router, mock
+}
+
+func TestStripeWebhookStoresEvent(t *testing.T) {
+	router, mock := newStripeWebhookRouter(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "stripe_events" .* ON CONFLICT \("event_id"\) DO NOTHING`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5b0b8f36-8d0a-4c62-9d0b-6c1f1e2a7a11"))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newStripeWebhookRequest(t, "payment_intent.succeeded.json", testWebhookSecret))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStripeWebhookSkipsDuplicateEvent(t *testing.T) {
+	router, mock := newStripeWebhookRouter(t)
+
+	// Nothing is returned when the event ID already exists, so no outbox message is written
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "stripe_events" .* ON CONFLICT \("event_id"\) DO NOTHING`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newStripeWebhookRequest(t, "payment_intent.succeeded.json", testWebhookSecret))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStripeWebhookRejectsInvalidSignature(t *testing.T) {
+	router, mock := newStripeWebhookRouter(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newStripeWebhookRequest(t, "payment_intent.succeeded.json", "whsec_other"))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func surveyHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canRead := middlewares.RequirePermission(types.PERM_EVENTS_READ, middlewares.EventOrganization("id"))
+	canUpdate := middlewares.RequirePermission(types.PERM_EVENTS_UPDATE, middlewares.EventOrganization("id"))
+	keys.
+		GET("/events/:id/survey", canRead, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			survey, err := common.GetEventSurvey(ctx.GetUint("org_id"), params.ID)
+			if err != nil {
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": survey})
+		}).
+		PUT("/events/:id/survey", canUpdate, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.UpdateSurveyRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			before, after, err := common.UpdateEventSurvey(orgId, params.ID, body)
+			if err != nil {
+				log.Printf("Error updating survey of Event [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			var prev types.JSONB
+			if before != nil {
+				prev = types.JSONB{"title": before.Title, "questions": before.Questions}
+			}
+			recordAudit(ctx, types.AUDIT_SURVEY_UPDATE, orgId, after.ID, prev, types.JSONB{"title": after.Title, "questions": after.Questions})
+			ctx.JSON(http.StatusOK, gin.H{"data": after})
+		}).
+		GET("/events/:id/survey/results", canRead, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			results, err := common.SurveyResultsOf(ctx.GetUint("org_id"), params.ID)
+			if err != nil {
+				log.Printf("Error retrieving survey results of Event [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": results})
+		}).
+		GET("/events/:id/survey/export", canRead, func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var buf bytes.Buffer
+			if err := common.ExportSurveyResponses(ctx.GetUint("org_id"), params.ID, &buf); err != nil {
+				log.Printf("Error exporting survey responses of Event [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d-survey.csv"`, params.ID))
+			ctx.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+		})
+	g.
+		GET("/surveys/:id", func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			survey, pending, err := common.GetSurvey(params.ID, ctx.GetUint("id"))
+			if err != nil {
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": survey, "reservations": pending})
+		}).
+		POST("/surveys/:id/responses", func(ctx *gin.Context) {
+			var params types.SimpleRequestParams
+			if err := ctx.ShouldBindUri(&params); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.SurveyResponseRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			response, err := common.RespondToSurvey(params.ID, ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error responding to Survey [%d]: %s\n", params.ID, err.Error())
+				ctx.JSON(surveyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"data": response})
+		})
+	return g
+}
+
+func surveyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrSurveyNotFound),
+		errors.Is(err, common.ErrEventNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrNotSurveyEligible):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrSurveyLocked),
+		errors.Is(err, common.ErrSurveyNotOpen),
+		errors.Is(err, common.ErrAlreadyResponded):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrInvalidSurvey),
+		errors.Is(err, common.ErrInvalidSurveyAnswers):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
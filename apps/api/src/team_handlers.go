@@ -0,0 +1,176 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func teamHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	orgParam := middlewares.OrganizationParam("orgId")
+	keys.
+		GET("/organizations/:orgId/members", middlewares.RequirePermission(types.PERM_ORG_READ, orgParam), func(ctx *gin.Context) {
+			members, err := common.ListMembers(ctx.GetUint("org_id"))
+			if err != nil {
+				log.Printf("Error retrieving members: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": members})
+		}).
+		PATCH("/organizations/:orgId/members/:userId", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			userId, err := strconv.Atoi(ctx.Param("userId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.UpdateMemberRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			previous, err := common.UpdateMemberRole(ctx.GetUint("org_id"), orgRole(ctx), uint(userId), body.Role)
+			if err != nil {
+				log.Printf("Error updating member [%d]: %s\n", userId, err.Error())
+				ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_MEMBER_UPDATE, ctx.GetUint("org_id"), userId,
+				types.JSONB{"role": previous},
+				types.JSONB{"role": body.Role})
+			ctx.Status(http.StatusNoContent)
+		}).
+		DELETE("/organizations/:orgId/members/:userId", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			userId, err := strconv.Atoi(ctx.Param("userId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.RemoveMember(ctx.GetUint("org_id"), orgRole(ctx), ctx.GetUint("id"), uint(userId)); err != nil {
+				log.Printf("Error removing member [%d]: %s\n", userId, err.Error())
+				ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_MEMBER_REMOVE, ctx.GetUint("org_id"), userId,
+				types.JSONB{"status": types.MEMBER_ACTIVE},
+				types.JSONB{"status": types.MEMBER_REMOVED})
+			ctx.Status(http.StatusNoContent)
+		}).
+		POST("/organizations/:orgId/transfer", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			var body types.TransferOwnershipRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			if err := common.TransferOwnership(orgId, ctx.GetUint("id"), body.UserID); err != nil {
+				log.Printf("Error transferring organization [%d]: %s\n", orgId, err.Error())
+				ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_OWNERSHIP_TRANSFER, orgId, orgId,
+				types.JSONB{"ownerId": ctx.GetUint("id")},
+				types.JSONB{"ownerId": body.UserID})
+			ctx.Status(http.StatusNoContent)
+		}).
+		GET("/organizations/:orgId/invites", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			invites, err := common.ListInvites(ctx.GetUint("org_id"))
+			if err != nil {
+				log.Printf("Error retrieving invites: %s\n", err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": invites})
+		}).
+		POST("/organizations/:orgId/invites", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			var body types.CreateInviteRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			invite, err := common.InviteMember(ctx.GetUint("org_id"), ctx.GetUint("id"), orgRole(ctx), body.Email, body.Role)
+			if err != nil {
+				log.Printf("Error inviting [%s]: %s\n", body.Email, err.Error())
+				ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_INVITE_CREATE, invite.OrganizationID, invite.ID, nil,
+				types.JSONB{"email": invite.Email, "role": invite.Role})
+			ctx.JSON(http.StatusCreated, gin.H{"data": invite})
+		}).
+		DELETE("/organizations/:orgId/invites/:inviteId", middlewares.RequirePermission(types.PERM_TEAM_MANAGE, orgParam), func(ctx *gin.Context) {
+			inviteId, err := uuid.Parse(ctx.Param("inviteId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := common.RevokeInvite(ctx.GetUint("org_id"), inviteId); err != nil {
+				ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_INVITE_REVOKE, ctx.GetUint("org_id"), inviteId,
+				types.JSONB{"status": types.INVITE_PENDING},
+				types.JSONB{"status": types.INVITE_REVOKED})
+			ctx.Status(http.StatusNoContent)
+		})
+	g.
+		POST("/invites/accept", func(ctx *gin.Context) {
+			respondToInvite(ctx, true)
+		}).
+		POST("/invites/decline", func(ctx *gin.Context) {
+			respondToInvite(ctx, false)
+		})
+	return g
+}
+
+func respondToInvite(ctx *gin.Context, accept bool) {
+	var body types.InviteTokenRequestBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	invite, err := common.RespondToInvite(body.Token, ctx.GetUint("id"), accept)
+	if err != nil {
+		log.Printf("Error responding to invite: %s\n", err.Error())
+		ctx.JSON(teamErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if accept {
+		recordAudit(ctx, types.AUDIT_MEMBER_JOIN, invite.OrganizationID, ctx.GetUint("id"), nil,
+			types.JSONB{"role": invite.Role, "inviteId": invite.ID})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": invite})
+}
+
+func orgRole(ctx *gin.Context) types.MemberRole {
+	role, _ := ctx.Get("org_role")
+	memberRole, _ := role.(types.MemberRole)
+	return memberRole
+}
+
+func teamErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrInviteNotFound),
+		errors.Is(err, common.ErrMemberNotFound),
+		errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrInviteExpired):
+		return http.StatusGone
+	case errors.Is(err, common.ErrAlreadyMember):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrInviteEmailMismatch),
+		errors.Is(err, common.ErrOwnerMember),
+		errors.Is(err, common.ErrNotOwner):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"ebs/src/db"
 	"ebs/src/lib"
+	"ebs/src/middlewares"
 	"ebs/src/models"
 	"ebs/src/types"
 	"ebs/src/utils"
@@ -27,6 +28,7 @@ import (
 )
 
 func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
 	g.
 		GET("/tickets", func(ctx *gin.Context) {
 			orgId := ctx.GetUint("org")
@@ -73,6 +75,20 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			var event models.Event
+			db := db.GetDb()
+			if err := db.
+				Select("id", "organizer_id").
+				Where(&models.Event{ID: body.EventID}).
+				First(&event).
+				Error; err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "event does not exist"})
+				return
+			}
+			if !middlewares.CheckPermission(ctx, event.OrganizerID, types.PERM_TICKETS_CREATE) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
 			id, err := utils.CreateNewTicket(ctx.Copy(), &body)
 			if err != nil {
 				log.Printf("error creating ticket: %s", err.Error())
@@ -96,7 +112,7 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ticketId := params.TicketID
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			var filepath string
 			filename := fmt.Sprintf("ticketcode_%d-%d", ticketId, params.ReservationID)
 			log.Printf("Download eticket for %s\n", filename)
@@ -217,15 +233,16 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.FileAttachment(signedURL, "eticket.jpeg")
-		}).
-		GET("/tickets/:id/reservations", func(ctx *gin.Context) {
+		})
+	keys.
+		GET("/tickets/:id/reservations", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.TicketOrganization("id")), func(ctx *gin.Context) {
 			var params types.TicketReservationsURIParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				log.Printf("Error in validating request: %s\n", err.Error())
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			orgId := ctx.GetUint("org")
+			orgId := ctx.GetUint("org_id")
 			ticketId := params.TicketID
 			var ticket models.Ticket
 			db := db.GetDb()
@@ -256,14 +273,14 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": reservations})
 		}).
-		GET("/tickets/:id/bookings", func(ctx *gin.Context) {
+		GET("/tickets/:id/bookings", middlewares.RequirePermission(types.PERM_BOOKINGS_READ, middlewares.TicketOrganization("id")), func(ctx *gin.Context) {
 			var params types.TicketReservationsURIParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				log.Printf("Error in validating request: %s\n", err.Error())
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			orgId := ctx.GetUint("org")
+			orgId := ctx.GetUint("org_id")
 			ticketId := params.TicketID
 			var ticket models.Ticket
 			db := db.GetDb()
@@ -294,7 +311,8 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.JSON(http.StatusOK, gin.H{"data": bookings})
-		}).
+		})
+	g.
 		GET("/tickets/:id/seats", func(ctx *gin.Context) {
 			idParam := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(idParam)
@@ -309,8 +327,9 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			ctx.JSON(http.StatusOK, gin.H{"id": ticketId, "free": free, "reserved": reserved})
-		}).
-		PATCH("/tickets/:id/publish", func(ctx *gin.Context) {
+		})
+	keys.
+		PATCH("/tickets/:id/publish", middlewares.RequirePermission(types.PERM_TICKETS_PUBLISH, middlewares.TicketOrganization("id")), func(ctx *gin.Context) {
 			id := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(id)
 			if err != nil {
@@ -336,9 +355,12 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			recordAudit(ctx, types.AUDIT_TICKET_PUBLISH, ctx.GetUint("org_id"), ticketId,
+				types.JSONB{"status": ticket.Status},
+				types.JSONB{"status": types.TICKET_OPEN})
 			ctx.JSON(http.StatusOK, gin.H{"data": ticket})
 		}).
-		PATCH("/tickets/:id/close", func(ctx *gin.Context) {
+		PATCH("/tickets/:id/close", middlewares.RequirePermission(types.PERM_TICKETS_UPDATE, middlewares.TicketOrganization("id")), func(ctx *gin.Context) {
 			id := ctx.Params.ByName("id")
 			atoi, err := strconv.Atoi(id)
 			if err != nil {
@@ -357,16 +379,19 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			recordAudit(ctx, types.AUDIT_TICKET_CLOSE, ctx.GetUint("org_id"), ticketId,
+				types.JSONB{"status": ticket.Status},
+				types.JSONB{"status": types.TICKET_CLOSED})
 			ctx.JSON(http.StatusOK, gin.H{"data": ticket})
 		}).
-		DELETE("/tickets/:id", func(ctx *gin.Context) {
+		DELETE("/tickets/:id", middlewares.RequirePermission(types.PERM_TICKETS_DELETE, middlewares.TicketOrganization("id")), func(ctx *gin.Context) {
 			var params types.SimpleRequestParams
 			if err := ctx.ShouldBindUri(&params); err != nil {
 				ctx.Status(http.StatusBadRequest)
 				return
 			}
 			ticketId := params.ID
-			_, err := utils.GetTicket(ticketId)
+			ticket, err := utils.GetTicket(ticketId)
 			if err != nil {
 				log.Printf("Error retrieving ticket [%d]: %s\n", ticketId, err.Error())
 				ctx.Status(http.StatusNotFound)
@@ -377,6 +402,9 @@ func ticketHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			recordAudit(ctx, types.AUDIT_TICKET_DELETE, ctx.GetUint("org_id"), ticketId,
+				types.JSONB{"status": ticket.Status},
+				types.JSONB{"status": types.TICKET_ARCHIVED, "deleted": true})
 			ctx.Status(http.StatusNoContent)
 		})
 	return g
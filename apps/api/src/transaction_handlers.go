@@ -16,6 +16,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stripe/stripe-go/v82"
+	"gorm.io/gorm"
 )
 
 func transactionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
@@ -58,7 +59,7 @@ func transactionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 
 			id := body.CheckoutID
 			var booking models.Booking
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			err = db.
 				Model(&models.Booking{}).
 				Preload("Event").
@@ -114,9 +115,11 @@ func transactionHandlers(g *gin.RouterGroup) *gin.RouterGroup {
 				return
 			}
 			id := uint(atoi)
-			db := db.GetDb()
+			db := db.GetDb().WithContext(ctx.Request.Context())
 			var txn models.Transaction
-			if err = db.Model(&models.Transaction{}).Where("id = ?", id).First(&txn).Error; err != nil {
+			if err = db.Transaction(func(tx *gorm.DB) error {
+				return tx.Model(&models.Transaction{}).Where("id = ?", id).First(&txn).Error
+			}); err != nil {
 				ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 				return
 			}
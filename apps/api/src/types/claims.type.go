@@ -8,6 +8,7 @@ type Claims struct {
 	Permissions  []string `json:"permissions"`
 	Organization uint
 	UID          string `json:"uid"`
+	Session      string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
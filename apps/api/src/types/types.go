@@ -132,6 +132,109 @@ type CreateOrganizationRequestBody struct {
 	Category     string           `json:"category,omitempty"`
 }
 
+type OrganizationMember struct {
+	UserID   uint             `json:"user_id"`
+	Name     string           `json:"name"`
+	Email    string           `json:"email"`
+	Role     MemberRole       `json:"role"`
+	Status   TeamMemberStatus `json:"status"`
+	JoinedAt *time.Time       `json:"joined_at"`
+}
+
+type TeamInvite struct {
+	ID             string       `json:"id"`
+	OrganizationID uint         `json:"organization_id"`
+	Email          string       `json:"email"`
+	Role           MemberRole   `json:"role"`
+	Status         InviteStatus `json:"status"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+	CreatedAt      *time.Time   `json:"created_at"`
+}
+
+type CreateInviteRequestBody struct {
+	Email string     `json:"email" binding:"required,email"`
+	Role  MemberRole `json:"role" binding:"required"`
+}
+
+type InviteTokenRequestBody struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AuthTokens are issued on sign in and on refresh. The refresh token is single use.
+type AuthTokens struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+type MFAMethod string
+
+const (
+	MFA_PASSKEY MFAMethod = "passkey"
+	MFA_TOTP    MFAMethod = "totp"
+)
+
+// TOTPEnrollment is returned once when enrolling an authenticator app, QR is a PNG data URI of URI
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QR     string `json:"qr"`
+}
+
+type MFAStatus struct {
+	Passkeys      int   `json:"passkeys"`
+	TOTP          bool  `json:"totp"`
+	RecoveryCodes int64 `json:"recovery_codes"`
+}
+
+type MFACodeRequestBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFALoginRequestBody completes a sign in with an authenticator app code or a recovery code
+type MFALoginRequestBody struct {
+	Email string `json:"email" binding:"required,email"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// JWK is a public key of a JSON Web Key Set (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type RefreshTokenRequestBody struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type UpdateMemberRequestBody struct {
+	Role MemberRole `json:"role" binding:"required"`
+}
+
+type TransferOwnershipRequestBody struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
 type ReservationTicket struct {
 	TicketID uint  `json:"ticket" binding:"required"`
 	Qty      uint8 `json:"qty" binding:"required"`
@@ -153,6 +256,11 @@ type SimpleOrganizationRequestParams struct {
 	ID uint `uri:"orgId" binding:"required"`
 }
 
+type RatingRequestParams struct {
+	OrgID    uint `uri:"orgId" binding:"required"`
+	RatingID uint `uri:"ratingId" binding:"required"`
+}
+
 type OrganizationBookingsQueryParams struct {
 	EventID *uint `form:"event_id"`
 }
@@ -234,6 +342,8 @@ const (
 	RESERVATION_COMPLETED ReservationStatus = "completed"
 	RESERVATION_PAID      ReservationStatus = "paid"
 	RESERVATION_ADMITTED  ReservationStatus = "admitted"
+	// RESERVATION_TRANSFERRED is a reservation given away by its holder
+	RESERVATION_TRANSFERRED ReservationStatus = "transferred"
 )
 
 type BookingStatus string
@@ -255,6 +365,196 @@ const (
 	TRANSACTION_EXPIRED    TransactionStatus = "expired"
 )
 
+type DeadLetterStatus string
+
+const (
+	DEAD_LETTER_PENDING   DeadLetterStatus = "pending"
+	DEAD_LETTER_REPLAYED  DeadLetterStatus = "replayed"
+	DEAD_LETTER_DISCARDED DeadLetterStatus = "discarded"
+)
+
+type OutboxStatus string
+
+const (
+	OUTBOX_PENDING   OutboxStatus = "pending"
+	OUTBOX_PUBLISHED OutboxStatus = "published"
+	OUTBOX_FAILED    OutboxStatus = "failed"
+)
+
+// WebhookEventType is a change organizations can subscribe their webhook endpoints to
+type WebhookEventType string
+
+const (
+	WEBHOOK_BOOKING_COMPLETED    WebhookEventType = "booking.completed"
+	WEBHOOK_RESERVATION_CANCELED WebhookEventType = "reservation.canceled"
+	WEBHOOK_ADMISSION_CREATED    WebhookEventType = "admission.created"
+	WEBHOOK_EVENT_STATUS_CHANGED WebhookEventType = "event.status_changed"
+	WEBHOOK_REFUND_SUCCEEDED     WebhookEventType = "refund.succeeded"
+)
+
+// WebhookEventTypes are the event types webhook endpoints may subscribe to
+var WebhookEventTypes = []WebhookEventType{
+	WEBHOOK_BOOKING_COMPLETED,
+	WEBHOOK_RESERVATION_CANCELED,
+	WEBHOOK_ADMISSION_CREATED,
+	WEBHOOK_EVENT_STATUS_CHANGED,
+	WEBHOOK_REFUND_SUCCEEDED,
+}
+
+// NotificationType is the kind of message shown in a user's notification center
+type NotificationType string
+
+const (
+	NOTIFICATION_EVENT_OPENED      NotificationType = "event.opened"
+	NOTIFICATION_EVENT_CLOSED      NotificationType = "event.closed"
+	NOTIFICATION_EVENT_COMPLETED   NotificationType = "event.completed"
+	NOTIFICATION_BOOKING_CONFIRMED NotificationType = "booking.confirmed"
+)
+
+// NotificationCategory groups messages users can choose the delivery channels of
+type NotificationCategory string
+
+const (
+	// NOTIFY_EVENTS are registration and status updates of events users subscribed to or booked
+	NOTIFY_EVENTS NotificationCategory = "events"
+	// NOTIFY_BOOKINGS are confirmations and reminders of the user's own bookings
+	NOTIFY_BOOKINGS NotificationCategory = "bookings"
+	// NOTIFY_TEAM are organization invitations and membership changes
+	NOTIFY_TEAM NotificationCategory = "team"
+	// NOTIFY_ACCOUNT are verification codes and security messages, always sent by email
+	NOTIFY_ACCOUNT NotificationCategory = "account"
+)
+
+// NotificationCategories are the categories users can set preferences for
+var NotificationCategories = []NotificationCategory{
+	NOTIFY_EVENTS,
+	NOTIFY_BOOKINGS,
+	NOTIFY_TEAM,
+	NOTIFY_ACCOUNT,
+}
+
+type NotificationChannel string
+
+const (
+	CHANNEL_EMAIL NotificationChannel = "email"
+	CHANNEL_PUSH  NotificationChannel = "push"
+	CHANNEL_SMS   NotificationChannel = "sms"
+)
+
+type BroadcastStatus string
+
+const (
+	BROADCAST_QUEUED  BroadcastStatus = "queued"
+	BROADCAST_SENDING BroadcastStatus = "sending"
+	BROADCAST_SENT    BroadcastStatus = "sent"
+)
+
+type BroadcastRecipientStatus string
+
+const (
+	BROADCAST_RECIPIENT_PENDING BroadcastRecipientStatus = "pending"
+	BROADCAST_RECIPIENT_SENDING BroadcastRecipientStatus = "sending"
+	BROADCAST_RECIPIENT_SENT    BroadcastRecipientStatus = "sent"
+	BROADCAST_RECIPIENT_FAILED  BroadcastRecipientStatus = "failed"
+)
+
+// AdmissionFilter selects ticket holders by whether they have been admitted to the event
+type AdmissionFilter string
+
+const (
+	ADMISSION_FILTER_ADMITTED     AdmissionFilter = "admitted"
+	ADMISSION_FILTER_NOT_ADMITTED AdmissionFilter = "not_admitted"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WEBHOOK_DELIVERY_PENDING   WebhookDeliveryStatus = "pending"
+	WEBHOOK_DELIVERY_SUCCEEDED WebhookDeliveryStatus = "succeeded"
+	WEBHOOK_DELIVERY_FAILED    WebhookDeliveryStatus = "failed"
+)
+
+type RatingStatus string
+
+const (
+	RATING_PUBLISHED RatingStatus = "published"
+	RATING_HIDDEN    RatingStatus = "hidden"
+)
+
+type RatingReportStatus string
+
+const (
+	RATING_REPORT_OPEN      RatingReportStatus = "open"
+	RATING_REPORT_UPHELD    RatingReportStatus = "upheld"
+	RATING_REPORT_DISMISSED RatingReportStatus = "dismissed"
+)
+
+type SurveyQuestionType string
+
+const (
+	SURVEY_QUESTION_RATING SurveyQuestionType = "rating"
+	SURVEY_QUESTION_CHOICE SurveyQuestionType = "choice"
+	SURVEY_QUESTION_TEXT   SurveyQuestionType = "text"
+)
+
+// SurveyQuestion is a question of a post-event survey. Rating questions are answered with 1 to 5 stars,
+// choice questions with one of their options and text questions with free text.
+type SurveyQuestion struct {
+	ID       string             `json:"id" binding:"required,max=40"`
+	Type     SurveyQuestionType `json:"type" binding:"required,oneof=rating choice text"`
+	Prompt   string             `json:"prompt" binding:"required,max=500"`
+	Options  []string           `json:"options,omitempty" binding:"max=20,dive,required,max=200"`
+	Required bool               `json:"required"`
+}
+
+type MailStatus string
+
+const (
+	MAIL_SENT       MailStatus = "sent"
+	MAIL_FAILED     MailStatus = "failed"
+	MAIL_SUPPRESSED MailStatus = "suppressed"
+	MAIL_DELIVERED  MailStatus = "delivered"
+	MAIL_BOUNCED    MailStatus = "bounced"
+	MAIL_COMPLAINED MailStatus = "complained"
+)
+
+type SuppressionReason string
+
+const (
+	SUPPRESSION_BOUNCE    SuppressionReason = "bounce"
+	SUPPRESSION_COMPLAINT SuppressionReason = "complaint"
+	SUPPRESSION_MANUAL    SuppressionReason = "manual"
+)
+
+type StripeEventStatus string
+
+const (
+	STRIPE_EVENT_RECEIVED   StripeEventStatus = "received"
+	STRIPE_EVENT_PROCESSING StripeEventStatus = "processing"
+	STRIPE_EVENT_PROCESSED  StripeEventStatus = "processed"
+	STRIPE_EVENT_FAILED     StripeEventStatus = "failed"
+)
+
+type DiscrepancyKind string
+
+const (
+	DISCREPANCY_MISSING_TRANSACTION DiscrepancyKind = "missing_transaction"
+	DISCREPANCY_MISSING_PAYMENT     DiscrepancyKind = "missing_payment"
+	DISCREPANCY_STATUS_MISMATCH     DiscrepancyKind = "status_mismatch"
+	DISCREPANCY_AMOUNT_MISMATCH     DiscrepancyKind = "amount_mismatch"
+	DISCREPANCY_FEE_MISMATCH        DiscrepancyKind = "fee_mismatch"
+	DISCREPANCY_REFUNDED            DiscrepancyKind = "refunded"
+	DISCREPANCY_DISPUTED            DiscrepancyKind = "disputed"
+)
+
+type DiscrepancyStatus string
+
+const (
+	DISCREPANCY_OPEN     DiscrepancyStatus = "open"
+	DISCREPANCY_FIXED    DiscrepancyStatus = "fixed"
+	DISCREPANCY_RESOLVED DiscrepancyStatus = "resolved"
+)
+
 type OrganizationType string
 
 const (
@@ -373,6 +673,34 @@ type TicketReservationsURIParams struct {
 	TicketID uint `uri:"id" binding:"required"`
 }
 
+type DeadLettersQueryFilters struct {
+	Topic  string           `form:"topic,omitempty"`
+	Status DeadLetterStatus `form:"status,omitempty"`
+	Page   int              `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int              `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type PaymentDiscrepanciesQueryFilters struct {
+	OrganizationID uint              `form:"organization_id,omitempty"`
+	Kind           DiscrepancyKind   `form:"kind,omitempty"`
+	Status         DiscrepancyStatus `form:"status,omitempty"`
+	Page           int               `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit          int               `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type DiscrepancySummary struct {
+	Kind   DiscrepancyKind   `json:"kind"`
+	Status DiscrepancyStatus `json:"status"`
+	Count  int64             `json:"count"`
+}
+
+type StripeEventsQueryFilters struct {
+	Type   string            `form:"type,omitempty"`
+	Status StripeEventStatus `form:"status,omitempty"`
+	Page   int               `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int               `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
 type OrganizationsQueryFilters struct {
 	Type  OrganizationType `form:"type,omitempty"`
 	Owned bool             `form:"owned,omitempty" binding:"omitempty"`
@@ -392,8 +720,314 @@ const (
 	ROLE_ADMIN  UserRole = "admin"
 	ROLE_OWNER  UserRole = "owner"
 	ROLE_MEMBER UserRole = "member"
+	// ROLE_STAFF identifies users backing event scoped staff credentials
+	ROLE_STAFF UserRole = "staff"
+	// ROLE_SERVICE identifies users backing organization API keys
+	ROLE_SERVICE UserRole = "service"
+)
+
+// MemberRole is the role of a user within an organization
+type MemberRole string
+
+const (
+	MEMBER_ROLE_OWNER   MemberRole = "owner"
+	MEMBER_ROLE_ADMIN   MemberRole = "admin"
+	MEMBER_ROLE_MANAGER MemberRole = "manager"
+	MEMBER_ROLE_FINANCE MemberRole = "finance"
+	MEMBER_ROLE_STAFF   MemberRole = "staff"
+)
+
+type TeamMemberStatus string
+
+const (
+	MEMBER_ACTIVE  TeamMemberStatus = "active"
+	MEMBER_REMOVED TeamMemberStatus = "removed"
+)
+
+type InviteStatus string
+
+const (
+	INVITE_PENDING  InviteStatus = "pending"
+	INVITE_ACCEPTED InviteStatus = "accepted"
+	INVITE_DECLINED InviteStatus = "declined"
+	INVITE_REVOKED  InviteStatus = "revoked"
+	INVITE_EXPIRED  InviteStatus = "expired"
 )
 
+// Permission is an action a member role is allowed to perform within its organization
+type Permission string
+
+const (
+	PERM_ORG_READ          Permission = "org:read"
+	PERM_ORG_UPDATE        Permission = "org:update"
+	PERM_TEAM_MANAGE       Permission = "team:manage"
+	PERM_EVENTS_READ       Permission = "events:read"
+	PERM_EVENTS_CREATE     Permission = "events:create"
+	PERM_EVENTS_UPDATE     Permission = "events:update"
+	PERM_EVENTS_PUBLISH    Permission = "events:publish"
+	PERM_EVENTS_DELETE     Permission = "events:delete"
+	PERM_TICKETS_CREATE    Permission = "tickets:create"
+	PERM_TICKETS_UPDATE    Permission = "tickets:update"
+	PERM_TICKETS_PUBLISH   Permission = "tickets:publish"
+	PERM_TICKETS_DELETE    Permission = "tickets:delete"
+	PERM_BOOKINGS_READ     Permission = "bookings:read"
+	PERM_ADMISSIONS_READ   Permission = "admissions:read"
+	PERM_ADMISSIONS_CREATE Permission = "admissions:create"
+	PERM_FINANCE_READ      Permission = "finance:read"
+	PERM_PAYMENTS_MANAGE   Permission = "payments:manage"
+	PERM_AUDIT_READ        Permission = "audit:read"
+	PERM_API_KEYS_MANAGE   Permission = "api_keys:manage"
+	PERM_WEBHOOKS_MANAGE   Permission = "webhooks:manage"
+	PERM_BROADCASTS_SEND   Permission = "broadcasts:send"
+	PERM_RATINGS_REPLY     Permission = "ratings:reply"
+)
+
+// AuditAction is the kind of change recorded in the audit trail
+type AuditAction string
+
+const (
+	AUDIT_EVENT_STATUS            AuditAction = "event.status"
+	AUDIT_EVENT_PUBLISH           AuditAction = "event.publish"
+	AUDIT_EVENT_CANCEL            AuditAction = "event.cancel"
+	AUDIT_EVENT_DELETE            AuditAction = "event.delete"
+	AUDIT_EVENT_REMINDERS         AuditAction = "event.reminders"
+	AUDIT_TICKET_PUBLISH          AuditAction = "ticket.publish"
+	AUDIT_TICKET_CLOSE            AuditAction = "ticket.close"
+	AUDIT_TICKET_DELETE           AuditAction = "ticket.delete"
+	AUDIT_ADMISSION_CREATE        AuditAction = "admission.create"
+	AUDIT_BOOKING_CANCEL          AuditAction = "booking.cancel"
+	AUDIT_TRANSACTION_CANCEL      AuditAction = "transaction.cancel"
+	AUDIT_REFUND_SUCCEEDED        AuditAction = "refund.succeeded"
+	AUDIT_DISCREPANCY_RESOLVE     AuditAction = "payment_discrepancy.resolve"
+	AUDIT_SETTING_CREATE          AuditAction = "setting.create"
+	AUDIT_MEMBER_UPDATE           AuditAction = "member.update"
+	AUDIT_MEMBER_REMOVE           AuditAction = "member.remove"
+	AUDIT_MEMBER_JOIN             AuditAction = "member.join"
+	AUDIT_INVITE_CREATE           AuditAction = "invite.create"
+	AUDIT_INVITE_REVOKE           AuditAction = "invite.revoke"
+	AUDIT_OWNERSHIP_TRANSFER      AuditAction = "organization.transfer"
+	AUDIT_STAFF_CREDENTIAL_CREATE AuditAction = "staff_credential.create"
+	AUDIT_STAFF_CREDENTIAL_REVOKE AuditAction = "staff_credential.revoke"
+	AUDIT_API_KEY_CREATE          AuditAction = "api_key.create"
+	AUDIT_API_KEY_REVOKE          AuditAction = "api_key.revoke"
+	AUDIT_WEBHOOK_CREATE          AuditAction = "webhook.create"
+	AUDIT_WEBHOOK_UPDATE          AuditAction = "webhook.update"
+	AUDIT_WEBHOOK_DELETE          AuditAction = "webhook.delete"
+	AUDIT_WEBHOOK_REDELIVER       AuditAction = "webhook.redeliver"
+	AUDIT_EMAIL_BRANDING_UPDATE   AuditAction = "email_branding.update"
+	AUDIT_BROADCAST_SEND          AuditAction = "broadcast.send"
+	AUDIT_RATING_REPLY            AuditAction = "rating.reply"
+	AUDIT_RATING_MODERATE         AuditAction = "rating.moderate"
+	AUDIT_SURVEY_UPDATE           AuditAction = "survey.update"
+)
+
+// Audit initiators
+const (
+	AUDIT_BY_USER    = "user"
+	AUDIT_BY_STAFF   = "staff"
+	AUDIT_BY_API_KEY = "api_key"
+	AUDIT_BY_SYSTEM  = "system"
+)
+
+type AuditTrailQueryFilters struct {
+	OrganizationID uint        `form:"organization_id,omitempty"`
+	Type           AuditAction `form:"type,omitempty"`
+	Group          string      `form:"group,omitempty"`
+	ActorID        uint        `form:"actor_id,omitempty"`
+	ResourceID     string      `form:"resource_id,omitempty"`
+	RequestID      string      `form:"request_id,omitempty"`
+	From           *time.Time  `form:"from,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+	To             *time.Time  `form:"to,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page           int         `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit          int         `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+// StaffCredentialKind is how an event scoped staff credential is handed to a scanner
+type StaffCredentialKind string
+
+const (
+	// STAFF_CREDENTIAL_CODE is a short lived code typed in by a door volunteer
+	STAFF_CREDENTIAL_CODE StaffCredentialKind = "code"
+	// STAFF_CREDENTIAL_DEVICE is a device token provisioned by scanning a QR code
+	STAFF_CREDENTIAL_DEVICE StaffCredentialKind = "device"
+)
+
+type CreateStaffCredentialRequestBody struct {
+	Name      string              `json:"name" binding:"required"`
+	Kind      StaffCredentialKind `json:"kind"`
+	ExpiresAt *time.Time          `json:"expires_at"`
+}
+
+// APIKeyKind is who an API key belongs to
+type APIKeyKind string
+
+const (
+	// API_KEY_PERSONAL acts as the user who created it, within the scopes it was granted
+	API_KEY_PERSONAL APIKeyKind = "personal"
+	// API_KEY_ORGANIZATION acts for a single organization and outlives the member who created it
+	API_KEY_ORGANIZATION APIKeyKind = "organization"
+)
+
+type CreateAPIKeyRequestBody struct {
+	Name      string       `json:"name" binding:"required"`
+	Scopes    []Permission `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+}
+
+type CreateWebhookRequestBody struct {
+	URL         string             `json:"url" binding:"required,url"`
+	Description string             `json:"description"`
+	Events      []WebhookEventType `json:"events" binding:"required,min=1"`
+}
+
+type UpdateWebhookRequestBody struct {
+	URL         *string            `json:"url" binding:"omitempty,url"`
+	Description *string            `json:"description"`
+	Events      []WebhookEventType `json:"events" binding:"omitempty,min=1"`
+	Enabled     *bool              `json:"enabled"`
+}
+
+type WebhookDeliveryQueryFilters struct {
+	Status WebhookDeliveryStatus `form:"status,omitempty"`
+	Page   int                   `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int                   `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type NotificationQueryFilters struct {
+	Unread bool `form:"unread,omitempty"`
+	Page   int  `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int  `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+// NotificationIDsRequestBody selects notifications of the user, all of them when IDs is empty
+type NotificationIDsRequestBody struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+type NotificationPreferenceBody struct {
+	Category NotificationCategory `json:"category" binding:"required"`
+	Email    bool                 `json:"email"`
+	Push     bool                 `json:"push"`
+	SMS      bool                 `json:"sms"`
+}
+
+type UpdateNotificationPreferencesRequestBody struct {
+	Preferences []NotificationPreferenceBody `json:"preferences" binding:"required,min=1,dive"`
+}
+
+// CreateBroadcastRequestBody is a message to the ticket holders of an event, optionally only those
+// holding tickets of the given tiers or with the given admission status
+type CreateBroadcastRequestBody struct {
+	Subject   string          `json:"subject" binding:"required,max=200"`
+	Message   string          `json:"message" binding:"required,max=5000"`
+	Tiers     []string        `json:"tiers" binding:"max=20,dive,required"`
+	Admission AdmissionFilter `json:"admission" binding:"omitempty,oneof=admitted not_admitted"`
+}
+
+type BroadcastQueryFilters struct {
+	Page  int `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit int `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type BroadcastRecipientQueryFilters struct {
+	Status BroadcastRecipientStatus `form:"status,omitempty" binding:"omitempty,oneof=pending sending sent failed"`
+	Page   int                      `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int                      `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type CreateRatingRequestBody struct {
+	EventID uint   `json:"event_id" binding:"required"`
+	Value   uint   `json:"rating_value" binding:"required,min=1,max=5"`
+	Comment string `json:"comment" binding:"max=2000"`
+}
+
+type ReplyRatingRequestBody struct {
+	Reply string `json:"reply" binding:"required,max=2000"`
+}
+
+type ReportRatingRequestBody struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ModerateRatingRequestBody hides a rating or publishes it again, resolving its open reports
+type ModerateRatingRequestBody struct {
+	Status RatingStatus `json:"status" binding:"required,oneof=published hidden"`
+}
+
+type RatingQueryFilters struct {
+	EventID uint `form:"event_id,omitempty"`
+	Page    int  `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit   int  `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type RatingReportQueryFilters struct {
+	Status RatingReportStatus `form:"status,omitempty" binding:"omitempty,oneof=open upheld dismissed"`
+	Page   int                `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int                `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type UpdateSurveyRequestBody struct {
+	Title     string           `json:"title" binding:"required,max=200"`
+	Questions []SurveyQuestion `json:"questions" binding:"required,min=1,max=20,dive"`
+}
+
+type SurveyResponseRequestBody struct {
+	ReservationID uint `json:"reservation_id" binding:"required"`
+	// Answers are keyed by question ID
+	Answers map[string]any `json:"answers" binding:"required"`
+}
+
+type MailLogQueryFilters struct {
+	Recipient string     `form:"recipient,omitempty"`
+	Template  string     `form:"template,omitempty"`
+	Status    MailStatus `form:"status,omitempty" binding:"omitempty,oneof=sent failed suppressed delivered bounced complained"`
+	Page      int        `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit     int        `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type EmailSuppressionQueryFilters struct {
+	Email  string            `form:"email,omitempty"`
+	Reason SuppressionReason `form:"reason,omitempty" binding:"omitempty,oneof=bounce complaint manual"`
+	Page   int               `form:"page,omitempty" binding:"omitempty,min=1"`
+	Limit  int               `form:"limit,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+type CreateEmailSuppressionRequestBody struct {
+	Email  string `json:"email" binding:"required,email"`
+	Detail string `json:"detail" binding:"max=500"`
+}
+
+// UpdateEventRemindersRequestBody sets when ticket holders are reminded of an event, in minutes before
+// it starts. An empty list turns reminders off.
+type UpdateEventRemindersRequestBody struct {
+	Reminders []uint `json:"reminders" binding:"max=5,dive,min=5,max=43200"`
+}
+
+type UpdateLocaleRequestBody struct {
+	Locale string `json:"locale" binding:"required"`
+}
+
+// UpdatePhoneRequestBody requests a verification code for a phone number, in E.164 format
+// or with a leading country code
+type UpdatePhoneRequestBody struct {
+	Phone string `json:"phone" binding:"required,max=32"`
+}
+
+type VerifyPhoneRequestBody struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type UpdateEmailBrandingRequestBody struct {
+	SenderName   string `json:"sender_name" binding:"max=100"`
+	LogoURL      string `json:"logo_url" binding:"omitempty,url"`
+	PrimaryColor string `json:"primary_color" binding:"omitempty,hexcolor"`
+	FooterText   string `json:"footer_text" binding:"max=500"`
+}
+
+type EmailPreviewQuery struct {
+	Locale string `form:"locale,omitempty"`
+}
+
 type Oauth2FlowState struct {
 	AccountID   uint   `json:"id"`
 	AccountType string `json:"account_type"`
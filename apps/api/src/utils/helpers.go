@@ -80,7 +80,7 @@ func CreateNewEvent(ctx *gin.Context, params *types.CreateEventRequestBody, orga
 	var eventId uint
 	var opens_at *time.Time
 	db := db.GetDb()
-	err = db.Transaction(func(tx *gorm.DB) error {
+	err = db.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
 		deadline, err := time.Parse(config.TIME_PARSE_FORMAT, params.Deadline)
 		if err != nil {
 			log.Printf("Error parsing deadline: %s\n", err.Error())
@@ -192,115 +192,27 @@ func CreateNewEvent(ctx *gin.Context, params *types.CreateEventRequestBody, orga
 		}()
 
 		// Set a schedule for completing the event
-		go func() {
-			topicName := WithSuffix("EventsToComplete")
-			runsAt := event.DateTime
-			runDate := runsAt.UTC().In(loc)
-			log.Printf("[DateTime] job scheduled at: %s\n", runDate)
-			jobTaskID := uuid.New()
-			payloadId := jobTaskID.String()
-			jobTask := models.JobTask{
-				Name:    WithSuffix(fmt.Sprintf("Event_%d_DateTime", eventId)),
-				JobType: "OneTimeJobStartDateTime",
-				RunsAt:  runDate,
-				HandlerParams: []any{
-					eventId,
-				},
-				PayloadID: payloadId,
-				Payload: map[string]any{
-					"payloadId":        payloadId,
-					"id":               int64(eventId),
-					"producerClientId": "EventsToCompleteProducer",
-					"topic":            topicName,
-					"table":            "events",
-				},
-				Source:     "Events",
-				SourceType: "table",
-				Topic:      topicName,
-			}
-			id, err := jobTask.CreateAndEnqueueJobTask(jobTask)
-			if err != nil {
-				log.Printf("Error creating job for Event: id=%d error=%s\n", eventId, err.Error())
-				return
-			}
-			log.Printf("Created job for Event[%d] with ID %s\n", eventId, id)
-		}()
+		if err := createEventJobTask(tx, eventId, "DateTime", "EventsToComplete", event.DateTime.UTC().In(loc)); err != nil {
+			return err
+		}
 
 		// Set a schedule for Closing the ticket reservation
-		go func() {
-			topicName := WithSuffix("EventsToClose")
-			runsAt := deadline
-			runDate := runsAt.UTC().In(loc)
-			log.Printf("[Deadline] job scheduled at: %s\n", runDate)
-			jobTaskID := uuid.New()
-			payloadId := jobTaskID.String()
-			jobTask := models.JobTask{
-				Name:    WithSuffix(fmt.Sprintf("Event_%d_Deadline", eventId)),
-				JobType: "OneTimeJobStartDateTime",
-				RunsAt:  runDate,
-				HandlerParams: []any{
-					eventId,
-				},
-				PayloadID: payloadId,
-				Payload: map[string]any{
-					"payloadId":        payloadId,
-					"id":               int64(eventId),
-					"producerClientId": "EventsToCloseProducer",
-					"topic":            topicName,
-					"table":            "events",
-				},
-				Source:     "Events",
-				SourceType: "table",
-				Topic:      topicName,
-			}
-			id, err := jobTask.CreateAndEnqueueJobTask(jobTask)
-			if err != nil {
-				log.Printf("Error creating job for Event: id=%d error=%s\n", eventId, err.Error())
-				return
+		if err := createEventJobTask(tx, eventId, "Deadline", "EventsToClose", deadline.UTC().In(loc)); err != nil {
+			return err
+		}
+
+		// Set a schedule for opening the registration
+		if !params.Publish && params.Mode == "scheduled" && opens_at != nil {
+			if err := createEventJobTask(tx, eventId, "OpensAt", "EventsToOpen", event.OpensAt.UTC().In(loc)); err != nil {
+				return err
 			}
-			log.Printf("Created job for Event[%d] with ID %s\n", eventId, id)
-		}()
+		}
 
 		return nil
 	})
 	if err != nil {
 		return 0, err
 	}
-	if !params.Publish && params.Mode == "scheduled" && opens_at != nil {
-		go func() {
-			topicName := WithSuffix("EventsToOpen")
-			runsAt := event.OpensAt
-			runDate := runsAt.UTC().In(loc)
-			log.Printf("[OpensAt] job scheduled at: %s\n", runDate)
-			jobTaskID := uuid.New()
-			payloadId := jobTaskID.String()
-			jobTask := models.JobTask{
-				Name:    WithSuffix(fmt.Sprintf("Event_%d_OpensAt", eventId)),
-				JobType: "OneTimeJobStartDateTime",
-				RunsAt:  runDate,
-				HandlerParams: []any{
-					eventId,
-				},
-				PayloadID: payloadId,
-				Payload: map[string]any{
-					"payloadId":        payloadId,
-					"id":               int64(eventId),
-					"producerClientId": "EventsToOpenProducer",
-					"topic":            topicName,
-					"table":            "events",
-				},
-				Source:     "Events",
-				SourceType: "table",
-				Topic:      topicName,
-			}
-			id, err := jobTask.CreateAndEnqueueJobTask(jobTask)
-			if err != nil {
-				log.Printf("Error creating job for Event: id=%d error=%s\n", eventId, err.Error())
-				return
-			}
-			log.Printf("Created job for Event[%d] with ID %s\n", eventId, id)
-		}()
-	}
 	if params.Publish {
 		err := PublishEvent(event.ID)
 		if err != nil {
@@ -324,7 +236,7 @@ func CreateNewTicket(ctx *gin.Context, params *types.CreateTicketRequestBody) (u
 		TenantID: &tenantId,
 	}
 
-	db := db.GetDb()
+	db := db.GetDb().WithContext(ctx.Request.Context())
 	err := db.Transaction(func(tx *gorm.DB) error {
 		var event models.Event
 		err := tx.
@@ -402,7 +314,7 @@ func CreateNewOrganization(ctx *gin.Context, params *types.CreateOrganizationReq
 		TenantID:     &tid,
 	}
 
-	db := db.GetDb()
+	db := db.GetDb().WithContext(ctx.Request.Context())
 	err := db.Transaction(func(tx *gorm.DB) error {
 		err := tx.Create(&organization).Error
 		if err != nil {
@@ -497,17 +409,28 @@ func PublishEvent(id uint) error {
 		if ticketCount == 0 {
 			return errors.New("must have at least one ticket open to publish")
 		}
+		var event models.Event
 		if err := tx.
+			Select("id", "organizer_id", "status").
+			Where(&models.Event{ID: id}).
+			First(&event).
+			Error; err != nil {
+			return err
+		}
+		res := tx.
 			Model(&models.Event{}).
 			Where("id = ? AND status IN (?)", id, []types.EventStatus{
 				types.EVENT_DRAFT,
 				types.EVENT_TICKETS_NOTIFY,
 			}).
-			Update("status", types.EVENT_REGISTRATION).
-			Error; err != nil {
-			return err
+			Update("status", types.EVENT_REGISTRATION)
+		if res.Error != nil {
+			return res.Error
 		}
-		return nil
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		return models.EmitEventStatusChanged(tx, event.OrganizerID, id, event.Status, types.EVENT_REGISTRATION)
 	})
 	return err
 }
@@ -614,7 +537,7 @@ func CreateReservation(ctx *gin.Context, params *types.CreateBookingRequestBody,
 	metadata := types.Metadata{
 		"requestId": requestId.String(),
 	}
-	db := db.GetDb()
+	db := db.GetDb().WithContext(ctx.Request.Context())
 	reservationIDs := []uint{}
 	errors := make([]string, 0)
 	now := time.Now()
@@ -632,15 +555,9 @@ func CreateReservation(ctx *gin.Context, params *types.CreateBookingRequestBody,
 			if err != nil {
 				return err
 			}
+			// The reservations of every tenant take slots, reserved_slots counts the ones the tenant can not see
 			var count int64
-			err = tx.
-				Model(&models.Reservation{}).
-				Select("COUNT(id)").
-				Where(clause.IN{Column: "status", Values: []any{types.RESERVATION_PENDING, types.RESERVATION_COMPLETED}}).
-				Where("valid_until > ?", time.Now()).
-				Where(&models.Reservation{TicketID: v.TicketID}).
-				Count(&count).
-				Error
+			err = tx.Raw("SELECT reserved_slots(?)", v.TicketID).Scan(&count).Error
 			if err != nil {
 				return err
 			}
@@ -686,47 +603,45 @@ func CreateReservation(ctx *gin.Context, params *types.CreateBookingRequestBody,
 
 			reservationIDs = append(reservationIDs, r.ID)
 			runsAt := expirationTime
-			go func() {
-				runDate := time.Date(
-					runsAt.UTC().Year(),
-					runsAt.UTC().Month(),
-					runsAt.UTC().Day(),
-					runsAt.UTC().Hour(),
-					runsAt.UTC().Minute(),
-					0,
-					0,
-					runsAt.UTC().Location(),
-				)
-				log.Printf("[ValidUntil] job scheduled at: %s\n", runDate)
-				jobTaskID := uuid.New()
-				payloadId := jobTaskID.String()
-				jobTask := models.JobTask{
-					Name:    fmt.Sprintf("Event_%d_ValidUntil", bookingId),
-					JobType: "OneTimeJobStartDateTime",
-					RunsAt:  runDate,
-					HandlerParams: []any{
-						bookingId,
-					},
-					PayloadID: payloadId,
-					Payload: map[string]any{
-						"payloadId":        payloadId,
-						"id":               bookingId,
-						"producerClientId": "PendingTransactionsProducer",
-						"topic":            "PendingTransactions",
-						"table":            "bookings",
-						"bookings":         []uint{},
-					},
-					Source:     "Booking",
-					SourceType: "table",
-					Topic:      "PendingTransactions",
-				}
-				id, err := jobTask.CreateAndEnqueueJobTask(jobTask)
-				if err != nil {
-					log.Printf("Error creating job for Booking: id=%d error=%s\n", bookingId, err.Error())
-					return
-				}
-				log.Printf("Created job for Booking[%d] with ID %s\n", bookingId, id)
-			}()
+			runDate := time.Date(
+				runsAt.UTC().Year(),
+				runsAt.UTC().Month(),
+				runsAt.UTC().Day(),
+				runsAt.UTC().Hour(),
+				runsAt.UTC().Minute(),
+				0,
+				0,
+				runsAt.UTC().Location(),
+			)
+			log.Printf("[ValidUntil] job scheduled at: %s\n", runDate)
+			jobTaskID := uuid.New()
+			payloadId := jobTaskID.String()
+			topicName := WithSuffix("PendingTransactions")
+			jobTask := models.JobTask{
+				ID:     jobTaskID,
+				Name:   fmt.Sprintf("Event_%d_ValidUntil", bookingId),
+				RunsAt: runDate,
+				HandlerParams: []any{
+					bookingId,
+				},
+				PayloadID: payloadId,
+				Payload: map[string]any{
+					"payloadId":        payloadId,
+					"id":               bookingId,
+					"producerClientId": "PendingTransactionsProducer",
+					"topic":            topicName,
+					"table":            "bookings",
+					"bookings":         []uint{},
+				},
+				Source:     "Booking",
+				SourceType: "table",
+				Topic:      topicName,
+			}
+			if err := jobTask.CreateOutboxJobTask(tx, "Booking", bookingId); err != nil {
+				log.Printf("Error creating job for Booking: id=%d error=%s\n", bookingId, err.Error())
+				return err
+			}
+			log.Printf("Created job for Booking[%d] with ID %s\n", bookingId, payloadId)
 			for range slotsToTake {
 				reservation := models.Reservation{
 					TicketID:   v.TicketID,
@@ -756,14 +671,22 @@ func CreateReservation(ctx *gin.Context, params *types.CreateBookingRequestBody,
 	return reservationIDs, nil, nil
 }
 
-func GetOrgReservations(id uint) ([]models.Booking, error) {
+// GetOrgReservations returns the bookings of the events of the organization id, the caller checks the
+// permissions of the user on it
+func GetOrgReservations(ctx context.Context, id uint) ([]models.Booking, error) {
 	var bookings []models.Booking
-	db := db.GetDb()
-	err := db.Where(&models.Booking{Event: &models.Event{OrganizerID: id}}).Preload("Event.Organization").Find(&bookings).Error
+	db := db.GetDb().WithContext(db.WithOrganization(ctx, id))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.
+			Where("event_id IN (?)", tx.Model(&models.Event{}).Select("id").Where(&models.Event{OrganizerID: id})).
+			Preload("Event.Organization").
+			Find(&bookings).
+			Error
+	})
 	return bookings, err
 }
-func GetOwnReservations(id uint) ([]models.Booking, error) {
-	db := db.GetDb()
+func GetOwnReservations(ctx context.Context, id uint) ([]models.Booking, error) {
+	db := db.GetDb().WithContext(ctx)
 	var bookings []models.Booking
 	err := db.
 		Model(&models.Booking{}).
@@ -811,7 +734,7 @@ func CreateStripeCheckout(ctx *gin.Context, params *types.CreateBookingRequestBo
 	}
 
 	var user models.User
-	db := db.GetDb()
+	db := db.GetDb().WithContext(ctx.Request.Context())
 	lineItems := []*stripe.CheckoutSessionCreateLineItemParams{}
 	err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.
@@ -928,6 +851,9 @@ func UpdateEventStatus(id uint, newStatus types.EventStatus, oldStatus types.Eve
 			log.Printf("Event status update did not complete successfully: %s\n", err.Error())
 			return err
 		}
+		if err := models.EmitEventStatusChanged(tx, event.OrganizerID, id, oldStatus, newStatus); err != nil {
+			return err
+		}
 		if err := tx.
 			Model(&models.EventSubscription{}).
 			Where(&models.EventSubscription{EventID: id, Status: "pending"}).
@@ -1110,6 +1036,39 @@ func CreateStripeAccount(org *models.Organization) (*stripe.Account, string, err
 	return acc, link.URL, nil
 }
 
+// createEventJobTask schedules a status update of an Event through the outbox of the given transaction
+func createEventJobTask(tx *gorm.DB, eventId uint, name string, topic string, runDate time.Time) error {
+	log.Printf("[%s] job scheduled at: %s\n", name, runDate)
+	jobTaskID := uuid.New()
+	payloadId := jobTaskID.String()
+	topicName := WithSuffix(topic)
+	jobTask := models.JobTask{
+		ID:     jobTaskID,
+		Name:   WithSuffix(fmt.Sprintf("Event_%d_%s", eventId, name)),
+		RunsAt: runDate,
+		HandlerParams: []any{
+			eventId,
+		},
+		PayloadID: payloadId,
+		Payload: map[string]any{
+			"payloadId":        payloadId,
+			"id":               int64(eventId),
+			"producerClientId": fmt.Sprintf("%sProducer", topic),
+			"topic":            topicName,
+			"table":            "events",
+		},
+		Source:     "Events",
+		SourceType: "table",
+		Topic:      topicName,
+	}
+	if err := jobTask.CreateOutboxJobTask(tx, "Event", eventId); err != nil {
+		log.Printf("Error creating job for Event: id=%d error=%s\n", eventId, err.Error())
+		return err
+	}
+	log.Printf("Created job for Event[%d] with ID %s\n", eventId, payloadId)
+	return nil
+}
+
 func WithSuffix(topic string) string {
 	apiEnv := os.Getenv("API_ENV")
 	if apiEnv != string(types.Production) {
@@ -1228,17 +1187,33 @@ func RevokeCredential(uid uint, name string) error {
 	return nil
 }
 
-func GenerateJWT(email string, uid uint, orgId uint) (string, error) {
+// AccessTokenTTL is how long an access token is valid, sessions are kept alive with refresh tokens
+const AccessTokenTTL = 15 * time.Minute
+
+// GenerateJWT issues an access token for the user bound to the session sessionId
+func GenerateJWT(email string, uid uint, orgId uint, sessionId string) (string, error) {
 	now := time.Now()
-	expirationTime := now.Add(24 * time.Hour)
+	expirationTime := now.Add(AccessTokenTTL)
 	permissionClaims := []string{
 		"user:read",
 		"user:update",
 	}
+	// Informational only, permissions are checked against the database on each request
+	db := db.GetDb()
+	if role, err := models.GetMemberRole(db, orgId, uid); err == nil {
+		perms, err := models.GetRolePermissions(db, role)
+		if err != nil {
+			log.Printf("Error retrieving permissions of role %s: %s\n", role, err.Error())
+		}
+		for _, perm := range perms {
+			permissionClaims = append(permissionClaims, string(perm))
+		}
+	}
 	claims := &types.Claims{
 		Permissions:  permissionClaims,
 		Username:     email,
 		Organization: orgId,
+		Session:      sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   fmt.Sprintf("%d", uid),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -1246,7 +1221,12 @@ func GenerateJWT(email string, uid uint, orgId uint) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	key, err := CurrentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 
-	return token.SignedString([]byte(config.JWT_SECRET))
+	return token.SignedString(key.Private)
 }
@@ -0,0 +1,350 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"ebs/src/config"
+	"ebs/src/db"
+	"ebs/src/models"
+	"ebs/src/types"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// SigningKeyRotation is how long a signing key signs tokens before a new one replaces it
+	SigningKeyRotation = 30 * 24 * time.Hour
+	// SigningKeyGrace is how long a retired key keeps verifying tokens. It covers the tokens it signed
+	// and the instances that have not loaded the new key yet.
+	SigningKeyGrace = 24 * time.Hour
+	// SigningKeyReload is how often the signing keys are reloaded from the database
+	SigningKeyReload = time.Hour
+)
+
+// signingKeyMissReload is the least time between reloads caused by tokens with an unknown kid
+const signingKeyMissReload = time.Minute
+
+var (
+	ErrNoSigningKey      = errors.New("no signing key available")
+	ErrUnknownSigningKey = errors.New("token is not signed by a known key")
+)
+
+// JWTKey is a signing key loaded in memory
+type JWTKey struct {
+	ID          string
+	Method      jwt.SigningMethod
+	Private     crypto.Signer
+	Public      crypto.PublicKey
+	VerifyUntil *time.Time
+}
+
+type signingKeyring struct {
+	mu       sync.RWMutex
+	current  *JWTKey
+	keys     map[string]*JWTKey
+	loadedAt time.Time
+}
+
+var keyring = &signingKeyring{keys: map[string]*JWTKey{}}
+
+// NewSigningKeys replaces the keys in memory. current signs new tokens, all of them verify tokens.
+func NewSigningKeys(current *JWTKey, others ...*JWTKey) {
+	keys := map[string]*JWTKey{}
+	for _, key := range others {
+		keys[key.ID] = key
+	}
+	if current != nil {
+		keys[current.ID] = current
+	}
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	keyring.current = current
+	keyring.keys = keys
+	keyring.loadedAt = time.Now()
+}
+
+// NewJWTKey generates a key for alg, EdDSA or RS256
+func NewJWTKey(alg string) (*JWTKey, error) {
+	var private crypto.Signer
+	switch alg {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case jwt.SigningMethodRS256.Alg():
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return &JWTKey{
+		ID:      uuid.NewString(),
+		Method:  jwt.GetSigningMethod(alg),
+		Private: private,
+		Public:  private.Public(),
+	}, nil
+}
+
+// SigningMethods returns the algorithms access tokens may be signed with
+func SigningMethods() []string {
+	return []string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}
+}
+
+// RotateSigningKeys generates a new signing key when the active one is older than maxAge, uses another
+// algorithm than JWT_SIGNING_ALG or there is none, then reloads the keys.
+// The key it replaces keeps verifying tokens for SigningKeyGrace.
+func RotateSigningKeys(maxAge time.Duration) error {
+	alg := signingAlgorithm()
+	db := db.GetDb()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Instances starting or rotating at the same time must not create several active keys
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('signing_keys'))").Error; err != nil {
+			return err
+		}
+		var active models.SigningKey
+		err := tx.
+			Where(&models.SigningKey{Status: models.SigningKeyActive}).
+			Order("created_at desc").
+			First(&active).
+			Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && active.Algorithm == alg && active.CreatedAt != nil && time.Since(*active.CreatedAt) < maxAge {
+			return nil
+		}
+		key, err := NewJWTKey(alg)
+		if err != nil {
+			return err
+		}
+		row, err := encodeSigningKey(key)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.
+			Model(&models.SigningKey{}).
+			Where(&models.SigningKey{Status: models.SigningKeyActive}).
+			Updates(map[string]any{
+				"status":       models.SigningKeyRetired,
+				"retired_at":   now,
+				"verify_until": now.Add(SigningKeyGrace),
+			}).
+			Error; err != nil {
+			return err
+		}
+		log.Printf("Rotating signing key: kid=%s alg=%s\n", key.ID, alg)
+		return tx.Create(row).Error
+	})
+	if err != nil {
+		return err
+	}
+	return LoadSigningKeys()
+}
+
+// LoadSigningKeys loads the active key and the retired keys still verifying tokens from the database
+func LoadSigningKeys() error {
+	var rows []models.SigningKey
+	db := db.GetDb()
+	if err := db.
+		Where("status = ? OR verify_until > ?", models.SigningKeyActive, time.Now()).
+		Order("created_at desc").
+		Find(&rows).
+		Error; err != nil {
+		return err
+	}
+	var current *JWTKey
+	others := make([]*JWTKey, 0, len(rows))
+	for i := range rows {
+		key, err := decodeSigningKey(&rows[i])
+		if err != nil {
+			log.Printf("Error loading signing key [%s]: %s\n", rows[i].ID, err.Error())
+			continue
+		}
+		if rows[i].Status == models.SigningKeyActive && current == nil {
+			current = key
+			continue
+		}
+		others = append(others, key)
+	}
+	if current == nil {
+		return ErrNoSigningKey
+	}
+	NewSigningKeys(current, others...)
+	return nil
+}
+
+// CurrentSigningKey returns the key new tokens are signed with
+func CurrentSigningKey() (*JWTKey, error) {
+	keyring.mu.RLock()
+	current := keyring.current
+	keyring.mu.RUnlock()
+	if current != nil {
+		return current, nil
+	}
+	if err := LoadSigningKeys(); err != nil {
+		return nil, err
+	}
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+	return keyring.current, nil
+}
+
+// VerificationKey is the jwt.Keyfunc of access tokens. It returns the public key matching the kid of the token.
+func VerificationKey(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownSigningKey
+	}
+	key, reload := keyring.lookup(kid)
+	if key == nil && reload {
+		// The key may have been rotated by another instance
+		if err := LoadSigningKeys(); err != nil {
+			log.Printf("Error reloading signing keys: %s\n", err.Error())
+		}
+		key, _ = keyring.lookup(kid)
+	}
+	if key == nil || key.Method.Alg() != t.Method.Alg() {
+		return nil, ErrUnknownSigningKey
+	}
+	if key.VerifyUntil != nil && time.Now().After(*key.VerifyUntil) {
+		return nil, ErrUnknownSigningKey
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public keys verifying access tokens as a JSON Web Key Set
+func JWKS() types.JWKSet {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+	set := types.JWKSet{Keys: make([]types.JWK, 0, len(keyring.keys))}
+	now := time.Now()
+	for _, key := range keyring.keys {
+		if key.VerifyUntil != nil && now.After(*key.VerifyUntil) {
+			continue
+		}
+		jwk := types.JWK{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch pub := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// lookup returns the key with kid, or whether the keys may be reloaded to look for it
+func (k *signingKeyring) lookup(kid string) (*JWTKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, false
+	}
+	return nil, time.Since(k.loadedAt) > signingKeyMissReload
+}
+
+func signingAlgorithm() string {
+	if config.JWT_SIGNING_ALG == "" {
+		return jwt.SigningMethodEdDSA.Alg()
+	}
+	return config.JWT_SIGNING_ALG
+}
+
+// encodeSigningKey returns the database row of key, its private key is encrypted with API_SECRET
+func encodeSigningKey(key *JWTKey) (*models.SigningKey, error) {
+	public, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(config.API_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := EncryptMessage(secret, hex.EncodeToString(private))
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Method.Alg(),
+		PublicKey:  public,
+		PrivateKey: enc,
+		Status:     models.SigningKeyActive,
+	}, nil
+}
+
+func decodeSigningKey(row *models.SigningKey) (*JWTKey, error) {
+	method := jwt.GetSigningMethod(row.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", row.Algorithm)
+	}
+	public, err := x509.ParsePKIXPublicKey(row.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &JWTKey{
+		ID:          row.ID,
+		Method:      method,
+		Public:      public,
+		VerifyUntil: row.VerifyUntil,
+	}
+	if row.Status != models.SigningKeyActive {
+		return key, nil
+	}
+	secret, err := hex.DecodeString(config.API_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := DecryptMessage(secret, row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	der, err := hex.DecodeString(*dec)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key [%s] cannot sign", row.ID)
+	}
+	key.Private = signer
+	return key, nil
+}
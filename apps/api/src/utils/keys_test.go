@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"ebs/src/db/dbtest"
+	"ebs/src/types"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeys(t *testing.T) {
+	dbtest.UseMockDB(t)
+
+	retired, err := NewJWTKey("RS256")
+	require.Nil(t, err)
+	current, err := NewJWTKey("EdDSA")
+	require.Nil(t, err)
+	verifyUntil := time.Now().Add(SigningKeyGrace)
+	retired.VerifyUntil = &verifyUntil
+	NewSigningKeys(current, retired)
+	defer NewSigningKeys(nil)
+
+	parse := func(token string) error {
+		_, err := jwt.ParseWithClaims(token, &types.Claims{}, VerificationKey, jwt.WithValidMethods(SigningMethods()))
+		return err
+	}
+	sign := func(key *JWTKey) string {
+		token := jwt.NewWithClaims(key.Method, &types.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+		})
+		token.Header["kid"] = key.ID
+		signed, err := token.SignedString(key.Private)
+		require.Nil(t, err)
+		return signed
+	}
+
+	t.Run("signs with the current key", func(t *testing.T) {
+		token, err := GenerateJWT("user@example.com", 1, 0, "8e0c1f4a-3b2d-4c5e-9f6a-7b8c9d0e1f2a")
+		assert.Nil(t, err)
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &types.Claims{})
+		assert.Nil(t, err)
+		assert.Equal(t, current.ID, parsed.Header["kid"])
+		assert.Equal(t, "EdDSA", parsed.Header["alg"])
+		assert.Nil(t, parse(token))
+	})
+
+	t.Run("verifies tokens of retired keys", func(t *testing.T) {
+		assert.Nil(t, parse(sign(retired)))
+	})
+
+	t.Run("rejects unknown keys and expired keys", func(t *testing.T) {
+		unknown, err := NewJWTKey("EdDSA")
+		require.Nil(t, err)
+		assert.ErrorIs(t, parse(sign(unknown)), ErrUnknownSigningKey)
+
+		expired := time.Now().Add(-time.Minute)
+		retired.VerifyUntil = &expired
+		defer func() { retired.VerifyUntil = &verifyUntil }()
+		assert.ErrorIs(t, parse(sign(retired)), ErrUnknownSigningKey)
+	})
+
+	t.Run("rejects tokens signed with the secret", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &types.Claims{})
+		token.Header["kid"] = current.ID
+		signed, err := token.SignedString([]byte("secret"))
+		require.Nil(t, err)
+		assert.NotNil(t, parse(signed))
+	})
+
+	t.Run("publishes the verification keys", func(t *testing.T) {
+		set := JWKS()
+		assert.Len(t, set.Keys, 2)
+		for _, jwk := range set.Keys {
+			switch jwk.Kid {
+			case current.ID:
+				assert.Equal(t, "OKP", jwk.Kty)
+				assert.Equal(t, "Ed25519", jwk.Crv)
+				assert.NotEmpty(t, jwk.X)
+			case retired.ID:
+				assert.Equal(t, "RSA", jwk.Kty)
+				assert.Equal(t, "AQAB", jwk.E)
+				assert.NotEmpty(t, jwk.N)
+			default:
+				t.Errorf("unexpected key %s", jwk.Kid)
+			}
+		}
+	})
+}
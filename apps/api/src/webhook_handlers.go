@@ -0,0 +1,156 @@
+package main
+
+import (
+	"ebs/src/common"
+	"ebs/src/middlewares"
+	"ebs/src/types"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func webhookHandlers(g *gin.RouterGroup) *gin.RouterGroup {
+	keys := middlewares.APIKeyRoutes(g)
+	canManage := middlewares.RequirePermission(types.PERM_WEBHOOKS_MANAGE, middlewares.OrganizationParam("orgId"))
+	keys.
+		GET("/organizations/:orgId/webhooks", canManage, func(ctx *gin.Context) {
+			endpoints, err := common.ListWebhooks(ctx.GetUint("org_id"))
+			if err != nil {
+				log.Printf("Error retrieving webhooks of Organization [%d]: %s\n", ctx.GetUint("org_id"), err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{"data": endpoints})
+		}).
+		POST("/organizations/:orgId/webhooks", canManage, func(ctx *gin.Context) {
+			var body types.CreateWebhookRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			endpoint, secret, err := common.CreateWebhook(orgId, ctx.GetUint("id"), body)
+			if err != nil {
+				log.Printf("Error creating webhook for Organization [%d]: %s\n", orgId, err.Error())
+				ctx.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_WEBHOOK_CREATE, orgId, endpoint.ID, nil,
+				types.JSONB{"url": endpoint.URL, "events": endpoint.Events})
+			ctx.JSON(http.StatusCreated, gin.H{"data": endpoint, "secret": secret})
+		}).
+		PATCH("/organizations/:orgId/webhooks/:webhookId", canManage, func(ctx *gin.Context) {
+			webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var body types.UpdateWebhookRequestBody
+			if err := ctx.ShouldBindJSON(&body); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			before, after, err := common.UpdateWebhook(orgId, webhookId, body)
+			if err != nil {
+				log.Printf("Error updating webhook [%s]: %s\n", webhookId, err.Error())
+				ctx.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_WEBHOOK_UPDATE, orgId, webhookId,
+				types.JSONB{"url": before.URL, "events": before.Events, "enabled": before.Enabled},
+				types.JSONB{"url": after.URL, "events": after.Events, "enabled": after.Enabled})
+			ctx.JSON(http.StatusOK, gin.H{"data": after})
+		}).
+		DELETE("/organizations/:orgId/webhooks/:webhookId", canManage, func(ctx *gin.Context) {
+			webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			if err := common.DeleteWebhook(orgId, webhookId); err != nil {
+				log.Printf("Error deleting webhook [%s]: %s\n", webhookId, err.Error())
+				ctx.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_WEBHOOK_DELETE, orgId, webhookId,
+				types.JSONB{"deleted": false},
+				types.JSONB{"deleted": true})
+			ctx.Status(http.StatusNoContent)
+		}).
+		POST("/organizations/:orgId/webhooks/:webhookId/secret", canManage, func(ctx *gin.Context) {
+			webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			secret, err := common.RotateWebhookSecret(orgId, webhookId)
+			if err != nil {
+				log.Printf("Error rotating secret of webhook [%s]: %s\n", webhookId, err.Error())
+				ctx.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_WEBHOOK_UPDATE, orgId, webhookId, nil, types.JSONB{"secretRotated": true})
+			ctx.JSON(http.StatusOK, gin.H{"secret": secret})
+		}).
+		GET("/organizations/:orgId/webhooks/:webhookId/deliveries", canManage, func(ctx *gin.Context) {
+			webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var filters types.WebhookDeliveryQueryFilters
+			if err := ctx.ShouldBindQuery(&filters); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			deliveries, count, err := common.ListWebhookDeliveries(ctx.GetUint("org_id"), webhookId, &filters)
+			if err != nil {
+				log.Printf("Error retrieving deliveries of webhook [%s]: %s\n", webhookId, err.Error())
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"data":  deliveries,
+				"count": count,
+				"page":  filters.Page,
+				"limit": filters.Limit,
+			})
+		}).
+		POST("/organizations/:orgId/webhooks/:webhookId/deliveries/:deliveryId/redeliver", canManage, func(ctx *gin.Context) {
+			webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			deliveryId, err := uuid.Parse(ctx.Param("deliveryId"))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orgId := ctx.GetUint("org_id")
+			delivery, err := common.RedeliverWebhook(orgId, webhookId, deliveryId)
+			if err != nil {
+				log.Printf("Error redelivering webhook delivery [%s]: %s\n", deliveryId, err.Error())
+				ctx.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(ctx, types.AUDIT_WEBHOOK_REDELIVER, orgId, webhookId, nil,
+				types.JSONB{"deliveryId": deliveryId, "redeliveryId": delivery.ID, "eventId": delivery.EventID})
+			ctx.JSON(http.StatusAccepted, gin.H{"data": delivery})
+		})
+	return g
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrWebhookNotFound), errors.Is(err, common.ErrWebhookDeliveryNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}